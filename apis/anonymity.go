@@ -0,0 +1,139 @@
+package apis
+
+import (
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jinzhu/copier"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DeanonymizeAContent godoc
+// @Summary 查看匿名内容的发布者，仅管理员
+// @Description 用于处理违规内容，包括已删除的内容；每次查看都会记录操作的管理员、内容和理由
+// @Tags Admin Module
+// @Accept json
+// @Produce json
+// @Router /_deanonymize [post]
+// @Param json body DeanonymizeRequest true "json"
+// @Success 200 {object} RespForSwagger{data=DeanonymizeResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+// @Failure 404 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func DeanonymizeAContent(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+	if !user.IsAdmin {
+		return Forbidden("只有管理员才能查看匿名内容的发布者")
+	}
+
+	var body DeanonymizeRequest
+	if err = ValidateBody(c, &body); err != nil {
+		return
+	}
+
+	// 查询发布者和记录审计日志在同一个事务中，记录失败时不返回发布者
+	var (
+		log    DeanonymizeLog
+		poster *User
+	)
+	err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		if log, err = Deanonymize(tx, user.ID, body.TargetType, body.TargetID, body.Reason); err != nil {
+			return
+		}
+		if log.PosterID == 0 {
+			return
+		}
+		var u User
+		if err = tx.Take(&u, log.PosterID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return
+		}
+		poster = &u
+		return
+	})
+	if err != nil {
+		return
+	}
+	Logger.Info("deanonymize",
+		zap.Int("admin_id", log.AdminID),
+		zap.String("target_type", log.TargetType),
+		zap.Int("target_id", log.TargetID),
+		zap.String("reason", log.Reason),
+	)
+
+	response := DeanonymizeResponse{
+		LogID:      log.ID,
+		TargetType: log.TargetType,
+		TargetID:   log.TargetID,
+		PosterID:   log.PosterID,
+	}
+	if poster != nil {
+		response.Poster = new(UserResponse)
+		if err = copier.CopyWithOption(response.Poster, poster, CopyOption); err != nil {
+			return
+		}
+	}
+
+	return Success(c, &response)
+}
+
+// ListDeanonymizeLogs godoc
+// @Summary 查询查看匿名内容发布者的审计日志，仅管理员
+// @Tags Admin Module
+// @Produce json
+// @Router /_deanonymize/logs [get]
+// @Param json query DeanonymizeLogListRequest true "query"
+// @Success 200 {object} RespForSwagger{data=DeanonymizeLogListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListDeanonymizeLogs(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+	if !user.IsAdmin {
+		return Forbidden("只有管理员才能查看审计日志")
+	}
+
+	var query DeanonymizeLogListRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	querySet := DB.Model(&DeanonymizeLog{})
+	if query.TargetType != "" {
+		querySet = querySet.Where("target_type = ?", query.TargetType)
+		if query.TargetID != 0 {
+			querySet = querySet.Where("target_id = ?", query.TargetID)
+		}
+	}
+	if query.AdminID != 0 {
+		querySet = querySet.Where("admin_id = ?", query.AdminID)
+	}
+
+	var (
+		logs     []DeanonymizeLog
+		response DeanonymizeLogListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		querySet, &logs, query.CursorRequest, CursorOrder{Column: "id", Desc: true},
+	); err != nil {
+		return
+	}
+
+	response.Logs = make([]DeanonymizeLogResponse, 0, len(logs))
+	if err = copier.Copy(&response.Logs, &logs); err != nil {
+		return
+	}
+
+	return Success(c, &response)
+}
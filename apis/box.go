@@ -9,6 +9,7 @@ import (
 	"gorm.io/gorm"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ListBoxes godoc
@@ -42,6 +43,7 @@ func ListBoxes(c *fiber.Ctx) (err error) {
 		key     = "boxes"
 	)
 
+	var response BoxListResponse
 	if query.Title != "" {
 		// 使用 Meilisearch 模糊搜索
 		var filter string
@@ -50,24 +52,31 @@ func ListBoxes(c *fiber.Ctx) (err error) {
 		}
 		if total, err = Search(
 			DB, &boxes, query.Title,
-			filter, []string{query.OrderBy}, "title", query.PageRequest,
+			filter, []string{query.OrderBy}, "title", query.PageRequest(),
 		); err != nil {
 			return
 		}
 	} else {
-		tx := DB.Session(&gorm.Session{NewDB: true}).Model(&Box{}).Order(query.OrderBy)
+		tx := DB.Session(&gorm.Session{NewDB: true}).Model(&Box{})
 		if query.Owner != 0 {
 			tx = tx.Where("owner_id = ?", query.Owner)
 			key = "boxes:" + strconv.Itoa(query.Owner)
 		}
-		key = key + ":" + strings.Replace(query.OrderBy, " ", "_", -1)
-		if version, total, err = PageLoad(tx, &boxes, key, query.PageRequest); err != nil {
-			return
+		if query.PageNum != 0 {
+			// 按页码分页
+			key = key + ":" + strings.Replace(query.OrderBy, " ", "_", -1)
+			if version, total, err = PageLoad(tx.Order(query.OrderBy), &boxes, key, query.PageRequest()); err != nil {
+				return
+			}
+		} else {
+			// 游标分页
+			if response.CursorResponse, err = CursorLoad(tx, &boxes, query.CursorRequest, NewCursorOrder(query.OrderBy)); err != nil {
+				return
+			}
 		}
 	}
 
 	// 构建响应
-	var response BoxListResponse
 	if err = copier.CopyWithOption(&response.MessageBoxes, &boxes, copier.Option{IgnoreEmpty: true}); err != nil {
 		return
 	}
@@ -107,8 +116,13 @@ func GetABox(c *fiber.Ctx) (err error) {
 	}
 
 	// load post content from database by box_id
+	// 其他用户只能查看公开的提问和自己提问的内容，不公开的提问即使公开了回答也不返回内容
 	var postsContent []string
-	if err = DB.Model(&Post{}).Where("box_id=?", boxID).Pluck("content", &postsContent).Error; err != nil {
+	querySet := DB.Model(&Post{}).Where("box_id=?", boxID)
+	if box.OwnerID != user.ID {
+		querySet = querySet.Where("is_public = ? OR poster_id = ?", true, user.ID)
+	}
+	if err = querySet.Pluck("content", &postsContent).Error; err != nil {
 		return
 	}
 
@@ -144,8 +158,17 @@ func CreateABox(c *fiber.Ctx) (err error) {
 	}
 
 	box := Box{
-		OwnerID: user.ID,
-		Title:   body.Title,
+		OwnerID:          user.ID,
+		Title:            body.Title,
+		Description:      body.Description,
+		QuestionTemplate: body.QuestionTemplate,
+		Status:           body.Status,
+		ClosesAt:         body.ClosesAt,
+		AnonymousPolicy:  body.AnonymousPolicy,
+		AllowGuest:       body.AllowGuest,
+	}
+	if box.ClosesAt != nil && !box.ClosesAt.After(time.Now()) {
+		return BadRequest("定时关闭的时间需要晚于当前时间")
 	}
 
 	// 创建提问箱
@@ -217,7 +240,16 @@ func ModifyABox(c *fiber.Ctx) (err error) {
 	if err = copier.CopyWithOption(&box, &body, copier.Option{IgnoreEmpty: true}); err != nil {
 		return err
 	}
-	if err = DB.Model(&box).Select("title").Updates(&box).Error; err != nil {
+	if body.ClosesAt != nil && !body.ClosesAt.After(time.Now()) {
+		return BadRequest("定时关闭的时间需要晚于当前时间")
+	}
+	// 重新打开时取消定时关闭
+	if body.Status != nil && *body.Status == BoxOpen && body.ClosesAt == nil {
+		box.ClosesAt = nil
+	}
+	if err = DB.Model(&box).
+		Select("Title", "Description", "QuestionTemplate", "Status", "ClosesAt", "AnonymousPolicy", "AllowGuest").
+		Updates(&box).Error; err != nil {
 		return
 	}
 	if err = SearchAddOrReplace(box.ToBoxSearchModel()); err != nil {
@@ -228,6 +260,7 @@ func ModifyABox(c *fiber.Ctx) (err error) {
 	go DeleteInBatch(
 		fmt.Sprintf("boxes:%d:updated_at_desc:latest", user.ID),
 		"boxes:updated_at_desc:latest",
+		CacheName(&box),
 	)
 
 	var response BoxCommonResponse
@@ -291,3 +324,46 @@ func DeleteABox(c *fiber.Ctx) (err error) {
 
 	return Success(c, &EmptyStruct{})
 }
+
+// RotateBoxShareSlug godoc
+// @Summary 重新生成提问箱的分享链接
+// @Description 只有提问箱的主人可以操作，原来的分享链接失效
+// @Tags MessageBox Module
+// @Produce json
+// @Router /messageBox/{id}/_share [put]
+// @Param id path int true "box id"
+// @Success 200 {object} RespForSwagger{data=BoxCommonResponse}
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func RotateBoxShareSlug(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var boxID int
+	if boxID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var box Box
+	if err = DB.Take(&box, boxID).Error; err != nil {
+		return
+	}
+	if box.OwnerID != user.ID {
+		return Forbidden()
+	}
+
+	box.ShareSlug = NewShareSlug()
+	if err = DB.Model(&box).Update("share_slug", box.ShareSlug).Error; err != nil {
+		return
+	}
+	go DeleteInBatch(CacheName(&box))
+
+	var response BoxCommonResponse
+	if err = copier.CopyWithOption(&response, &box, CopyOption); err != nil {
+		return
+	}
+
+	return Success(c, &response)
+}
@@ -32,26 +32,44 @@ func ListChannels(c *fiber.Ctx) (err error) {
 
 	// load posts
 	var post Post
-	if err = DB.First(&post, query.PostID).Error; err != nil {
+	if err = DB.Preload("Box").First(&post, query.PostID).Error; err != nil {
 		return
 	}
 
+	// 回复的可见性与提问相同，只能查看公开的回答时只返回提问箱主人的回复
+	querySet := DB.Where("post_id = ?", query.PostID)
+	switch post.AccessOf(user.ID) {
+	case PostAccessNone:
+		return Forbidden()
+	case PostAccessAnswer:
+		querySet = querySet.Where("owner_id = ?", post.Box.OwnerID)
+	}
+
 	// load channels from database
-	var channels []Channel
-	if err = query.QuerySet(DB).Preload("Post").Preload("Post.Box").
-		Where("post_id = ?", query.PostID).Find(&channels).Error; err != nil {
+	var (
+		channels []Channel
+		response ChannelListResponse
+		total    int64
+	)
+	// 已废弃的 total 只在第一页计算，避免每一页都查询总数
+	if query.Cursor == "" {
+		if err = querySet.Session(&gorm.Session{}).Model(&Channel{}).Count(&total).Error; err != nil {
+			return
+		}
+		response.Total = int(total)
+	}
+	if response.CursorResponse, err = CursorLoad(
+		querySet.Preload("Post").Preload("Post.Box"), &channels, query.CursorRequest, CursorOrder{Column: "id"},
+	); err != nil {
 		return
 	}
 
 	// construct response
-	var response ChannelListResponse
-	if err = copier.CopyWithOption(&response.Channels, &channels, CopyOption); err != nil {
-		return
-	}
-	for i := range response.Channels {
-		response.Channels[i].IsOwner = channels[i].OwnerID == user.ID
-		response.Channels[i].IsPostOwner = channels[i].Post.PosterID == channels[i].OwnerID
-		response.Channels[i].IsBoxOwner = channels[i].Post.Box.OwnerID == channels[i].OwnerID
+	response.Channels = make([]ChannelCommonResponse, len(channels))
+	for i := range channels {
+		if response.Channels[i], err = newChannelResponse(&channels[i], channels[i].Post, user.ID); err != nil {
+			return
+		}
 	}
 
 	return Success(c, &response)
@@ -84,15 +102,15 @@ func GetAChannel(c *fiber.Ctx) (err error) {
 	if err = DB.Preload("Post").Preload("Post.Box").First(&channel, channelID).Error; err != nil {
 		return
 	}
+	if !canViewChannel(&user, &channel) {
+		return Forbidden()
+	}
 
 	// construct response
-	var response ChannelCommonResponse
-	if err = copier.CopyWithOption(&response, &channel, CopyOption); err != nil {
+	response, err := newChannelResponse(&channel, channel.Post, user.ID)
+	if err != nil {
 		return
 	}
-	response.IsOwner = channel.OwnerID == user.ID
-	response.IsPostOwner = channel.Post.PosterID == channel.OwnerID
-	response.IsBoxOwner = channel.Post.Box.OwnerID == channel.OwnerID
 
 	return Success(c, &response)
 }
@@ -124,7 +142,7 @@ func CreateAChannel(c *fiber.Ctx) (err error) {
 	var post Post
 	var channel Channel
 	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
-		if err = DB.Preload("Box").Clauses(LockClause).First(&post, body.PostID).Error; err != nil {
+		if err = tx.Preload("Box").Clauses(LockClause).First(&post, body.PostID).Error; err != nil {
 			return
 		}
 
@@ -133,11 +151,22 @@ func CreateAChannel(c *fiber.Ctx) (err error) {
 			return Forbidden("只有提问者或者提问箱的所有者才能创建回复 thread")
 		}
 
-		// create channel
+		// create channel, 默认与提问者的提问一样匿名
 		channel = Channel{
-			PostID:  body.PostID,
-			OwnerID: user.ID,
-			Content: body.Content,
+			PostID:      body.PostID,
+			OwnerID:     user.ID,
+			Content:     body.Content,
+			IsAnonymous: post.IsAnonymous && post.PosterID == user.ID,
+		}
+		if body.IsAnonymous != nil {
+			channel.IsAnonymous = *body.IsAnonymous
+		}
+		if channel.IsAnonymous {
+			var anonyname string
+			if anonyname, err = FindOrGeneratePostAnonyname(tx, post.ID, user.ID); err != nil {
+				return
+			}
+			channel.Anonyname = &anonyname
 		}
 		if err = tx.Create(&channel).Error; err != nil {
 			return
@@ -148,19 +177,24 @@ func CreateAChannel(c *fiber.Ctx) (err error) {
 			return
 		}
 
+		// 提问箱的主人回复时标记为已回答
+		if post.Box.OwnerID == user.ID && post.AnswerStatus != AnswerAnswered {
+			post.SetAnswerStatus(AnswerAnswered, channel.CreatedAt)
+			if err = tx.Model(&post).Select("AnswerStatus", "AnsweredAt").Updates(&post).Error; err != nil {
+				return
+			}
+		}
+
 		return
 	}); err != nil {
 		return err
 	}
 
 	// construct response
-	var response ChannelCommonResponse
-	if err = copier.CopyWithOption(&response, &channel, CopyOption); err != nil {
+	response, err := newChannelResponse(&channel, &post, user.ID)
+	if err != nil {
 		return
 	}
-	response.IsOwner = true
-	response.IsPostOwner = post.PosterID == channel.OwnerID
-	response.IsBoxOwner = post.Box.OwnerID == channel.OwnerID
 
 	return Created(c, &response)
 }
@@ -198,17 +232,29 @@ func ModifyAChannel(c *fiber.Ctx) (err error) {
 	// load channel from database
 	var channel Channel
 	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
-		if err = DB.Preload("Post").Preload("Post.Box").First(&channel, channelID).Error; err != nil {
+		if err = tx.Clauses(LockClause).First(&channel, channelID).Error; err != nil {
 			return
 		}
+		var post Post
+		if err = tx.Preload("Box").First(&post, channel.PostID).Error; err != nil {
+			return
+		}
+		channel.Post = &post
 
 		// check if user is owner
 		if channel.OwnerID != user.ID {
 			return Forbidden("you are not the owner of this channel")
 		}
 
+		// record revision
+		original := channel.ToRevision()
+		channel.Content = body.Content
+		if _, err = RecordRevision(tx, original, channel.ToRevision(), user.ID); err != nil {
+			return
+		}
+
 		// update channel
-		if err = DB.Model(&channel).Updates(body).Error; err != nil {
+		if err = tx.Model(&channel).Updates(body).Error; err != nil {
 			return
 		}
 
@@ -218,13 +264,10 @@ func ModifyAChannel(c *fiber.Ctx) (err error) {
 	}
 
 	// construct response
-	var response ChannelCommonResponse
-	if err = copier.CopyWithOption(&response, &channel, CopyOption); err != nil {
+	response, err := newChannelResponse(&channel, channel.Post, user.ID)
+	if err != nil {
 		return
 	}
-	response.IsOwner = true
-	response.IsPostOwner = channel.Post.PosterID == channel.OwnerID
-	response.IsBoxOwner = channel.Post.Box.OwnerID == channel.OwnerID
 
 	return Success(c, &response)
 }
@@ -262,10 +305,31 @@ func DeleteAChannel(c *fiber.Ctx) (err error) {
 		return Forbidden("you are not the owner of this channel")
 	}
 
-	// update channel
-	if err = DB.Delete(&channel).Error; err != nil {
+	// delete channel
+	if err = DB.Transaction(func(tx *gorm.DB) error {
+		if err = tx.Delete(&channel).Error; err != nil {
+			return err
+		}
+
+		// update post.channel_count
+		return tx.Model(&Post{ID: channel.PostID}).Update("channel_count", gorm.Expr("channel_count - 1")).Error
+	}); err != nil {
 		return
 	}
 
 	return Success(c, &EmptyStruct{})
 }
+
+// newChannelResponse 构造回复的响应，需要预加载提问的 Box
+// 匿名的回复只返回回复者在这个提问中的昵称，不标记回复者是提问者还是提问箱的主人
+func newChannelResponse(channel *Channel, post *Post, userID int) (response ChannelCommonResponse, err error) {
+	if err = copier.CopyWithOption(&response, channel, CopyOption); err != nil {
+		return
+	}
+	response.IsOwner = channel.OwnerID == userID
+	if !channel.IsAnonymous {
+		response.IsPostOwner = post.PosterID == channel.OwnerID
+		response.IsBoxOwner = post.Box.OwnerID == channel.OwnerID
+	}
+	return
+}
@@ -14,6 +14,7 @@ import (
 // @Tags Chat Module
 // @Produce json
 // @Router /chats [get]
+// @Param body query ChatListRequest true "page"
 // @Success 200 {object} RespForSwagger{data=ChatListResponse}
 // @Failure 400 {object} RespForSwagger
 // @Failure 500 {object} RespForSwagger
@@ -24,18 +25,26 @@ func ListChats(c *fiber.Ctx) (err error) {
 		return
 	}
 
+	// get and validate query
+	var query ChatListRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
 	// load chats from database
-	var chats []Chat
-	if err = DB.
-		Preload("OneUser").Preload("AnotherUser").
-		Where("one_user_id = @user_id or another_user_id = @user_id", sql.Named("user_id", user.ID)).
-		Order("updated_at desc").
-		Find(&chats).Error; err != nil {
+	var (
+		chats    []Chat
+		response ChatListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Preload("OneUser").Preload("AnotherUser").
+			Where("one_user_id = @user_id or another_user_id = @user_id", sql.Named("user_id", user.ID)),
+		&chats, query.CursorRequest, CursorOrder{Column: "updated_at", Desc: true},
+	); err != nil {
 		return
 	}
 
 	// construct response
-	var response ChatListResponse
 	if err = copier.CopyWithOption(&response.Chats, &chats, CopyOption); err != nil {
 		return
 	}
@@ -85,17 +94,18 @@ func ListMessages(c *fiber.Ctx) (err error) {
 	}
 
 	// load messages by chat
-	querySet := DB.Limit(query.PageSize).Where("chat_id = ?", chat.ID)
-	if query.StartTime != nil {
-		querySet = querySet.Where("created_at < ?", query.StartTime)
-	}
-	var messages []ChatMessage
-	if err = querySet.Order("id desc").Find(&messages).Error; err != nil {
+	var (
+		messages []ChatMessage
+		response MessageListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Where("chat_id = ?", chat.ID), &messages, query.CursorRequest,
+		CursorOrder{Column: "created_at", Desc: true},
+	); err != nil {
 		return
 	}
 
 	// construct response
-	var response MessageListResponse
 	if err = copier.Copy(&response.Messages, &messages); err != nil {
 		return
 	}
@@ -166,6 +176,9 @@ func CreateMessage(c *fiber.Ctx) (err error) {
 		if err = tx.Create(&message).Error; err != nil {
 			return
 		}
+		if err = SetContentAttachments(tx, ContentTypeMessage, message.ID, user.ID, query.AttachmentIDs); err != nil {
+			return
+		}
 
 		// update chat message_count and last_message
 		if err = tx.Model(&chat).Updates(Map{
@@ -3,6 +3,8 @@ package apis
 import (
 	. "chatdan_backend/models"
 	. "chatdan_backend/utils"
+	"errors"
+	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jinzhu/copier"
 	"gorm.io/gorm"
@@ -32,32 +34,221 @@ func ListComments(c *fiber.Ctx) (err error) {
 	}
 
 	var topic Topic
-	err = DB.First(&topic, query.TopicID).Error
+	db := ReadDB(c)
+	err = db.First(&topic, query.TopicID).Error
 	if err != nil {
 		return err
 	}
 
-	tx := query.QuerySet(DB).Where("topic_id = ?", query.TopicID)
+	var (
+		comments []Comment
+		response CommentListResponse
+	)
+	querySet := db.Where("topic_id = ?", query.TopicID).Preload("Poster")
 	if query.OrderBy == "id" {
-		tx = tx.Order(query.OrderBy + " asc")
-	} else {
-		tx = tx.Order(query.OrderBy + " desc")
+		// 按照楼层顺序时保留已删除的楼层，楼层号不会因为删除而错位
+		querySet = querySet.Unscoped()
 	}
 
-	var comments []Comment
-	result := tx.Preload("Poster").Find(&comments)
-	if result.Error != nil {
-		return result.Error
+	if query.Floor != 0 {
+		if query.OrderBy != "id" || query.Cursor != "" {
+			return BadRequest("跳转楼层只支持按照 id 排序，且不能使用游标")
+		}
+		if query.Floor > topic.FloorCount {
+			return NotFound("楼层不存在")
+		}
+		if query.CursorRequest, err = floorCursorRequest(db, query.TopicID, query.Floor, query.PageSize); err != nil {
+			return err
+		}
+	}
+
+	response.CursorResponse, err = CursorLoad(querySet, &comments, query.CursorRequest, query.CursorOrder())
+	if err != nil {
+		return err
 	}
 
-	var response CommentListResponse
 	if err = copier.CopyWithOption(&response.Comments, &comments, CopyOption); err != nil {
 		return err
 	}
+	for i := range comments {
+		response.Comments[i].IsDeleted = comments[i].DeletedAt.Valid
+	}
+
+	return Success(c, &response)
+}
+
+// floorCursorRequest 构造从 floor 前半页开始的分页请求，使 floor 位于返回的一页中间
+// 楼层连续且与 ID 顺序一致，以起始楼层的前一层作为游标
+func floorCursorRequest(db *gorm.DB, topicID, floor, pageSize int) (request CursorRequest, err error) {
+	if pageSize == 0 {
+		pageSize = 10
+	}
+	request.PageSize = pageSize
+
+	startFloor := floor - pageSize/2
+	if startFloor <= 1 {
+		return
+	}
+	var previous Comment
+	if err = db.Unscoped().Select("id").
+		Where("topic_id = ? AND ranking = ?", topicID, startFloor-1).
+		First(&previous).Error; err != nil {
+		return
+	}
+	key, _ := json.Marshal(previous.ID)
+	request.Cursor = Cursor{Key: key, ID: previous.ID}.Encode()
+	return
+}
+
+// ListCommentThreads godoc
+// @Summary 查询评论树
+// @Description 分页查询帖子的顶层评论，每个评论附带 depth 层回复，每层最多 reply_page_size 条，更多回复使用 reply_cursor 加载
+// @Tags Comment Module
+// @Produce json
+// @Router /comments/_thread [get]
+// @Param json query CommentThreadRequest true "page"
+// @Success 200 {object} RespForSwagger{data=CommentTreeListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListCommentThreads(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return err
+	}
+
+	var query CommentThreadRequest
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	var topic Topic
+	db := ReadDB(c)
+	err = db.First(&topic, query.TopicID).Error
+	if err != nil {
+		return err
+	}
+
+	var (
+		comments []Comment
+		response CommentTreeListResponse
+	)
+	response.CursorResponse, err = CursorLoad(
+		db.Where("topic_id = ? AND reply_to_id IS NULL", query.TopicID).Preload("Poster"),
+		&comments, query.CursorRequest, query.CursorOrder(),
+	)
+	if err != nil {
+		return err
+	}
+
+	response.Comments, err = loadCommentTrees(db.Preload("Poster"), comments, query.CommentTreeRequest)
+	if err != nil {
+		return err
+	}
+	return Success(c, &response)
+}
+
+// ListCommentReplies godoc
+// @Summary 查询评论的回复
+// @Description 按照 ID 升序分页查询评论的直接回复，每个回复附带 depth 层回复，用于加载评论树中的更多回复
+// @Tags Comment Module
+// @Produce json
+// @Router /comment/{id}/_replies [get]
+// @Param id path int true "comment id"
+// @Param json query CommentRepliesRequest true "page"
+// @Success 200 {object} RespForSwagger{data=CommentTreeListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListCommentReplies(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var query CommentRepliesRequest
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	var comment Comment
+	db := ReadDB(c)
+	err = db.Select("id").First(&comment, id).Error
+	if err != nil {
+		return err
+	}
+
+	var (
+		comments []Comment
+		response CommentTreeListResponse
+	)
+	response.CursorResponse, err = CursorLoad(
+		db.Where("reply_to_id = ?", comment.ID).Preload("Poster"),
+		&comments, query.CursorRequest, CursorOrder{Column: "id"},
+	)
+	if err != nil {
+		return err
+	}
 
+	response.Comments, err = loadCommentTrees(db.Preload("Poster"), comments, query.CommentTreeRequest)
+	if err != nil {
+		return err
+	}
 	return Success(c, &response)
 }
 
+// loadCommentTrees 逐层加载评论的回复，构建评论树
+func loadCommentTrees(db *gorm.DB, comments []Comment, request CommentTreeRequest) (trees []CommentTreeResponse, err error) {
+	trees = make([]CommentTreeResponse, len(comments))
+	level := make([]*CommentTreeResponse, len(comments))
+	for i := range comments {
+		if err = copier.CopyWithOption(&trees[i].CommentCommonResponse, &comments[i], CopyOption); err != nil {
+			return
+		}
+		level[i] = &trees[i]
+	}
+
+	for depth := 0; depth < request.Depth && len(level) > 0; depth++ {
+		var commentIDs []int
+		for _, node := range level {
+			if node.ReplyCount > 0 {
+				commentIDs = append(commentIDs, node.ID)
+			}
+		}
+		var replies map[int][]Comment
+		if replies, err = LoadCommentReplies(db, commentIDs, request.ReplyPageSize); err != nil {
+			return
+		}
+
+		var nextLevel []*CommentTreeResponse
+		for _, node := range level {
+			children := replies[node.ID]
+			if len(children) > request.ReplyPageSize {
+				children = children[:request.ReplyPageSize]
+				last := children[len(children)-1].ID
+				key, _ := json.Marshal(last)
+				node.ReplyCursor = Cursor{Key: key, ID: last}.Encode()
+			}
+			node.Replies = make([]CommentTreeResponse, len(children))
+			for i := range children {
+				if err = copier.CopyWithOption(&node.Replies[i].CommentCommonResponse, &children[i], CopyOption); err != nil {
+					return
+				}
+				nextLevel = append(nextLevel, &node.Replies[i])
+			}
+		}
+		level = nextLevel
+	}
+	return
+}
+
 // GetAComment godoc
 // @Summary 获取一个评论
 // @Tags Comment Module
@@ -122,6 +313,25 @@ func CreateAComment(c *fiber.Ctx) (err error) {
 
 	var comment Comment
 	err = DB.Transaction(func(tx *gorm.DB) error {
+		// 回复的评论必须是本帖子的评论
+		if body.ReplyToID != nil {
+			var replyTo Comment
+			err = tx.Select("id", "topic_id").First(&replyTo, *body.ReplyToID).Error
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return BadRequest("回复的评论不存在")
+				}
+				return err
+			}
+			if replyTo.TopicID != body.TopicID {
+				return BadRequest("回复的评论不属于该帖子")
+			}
+
+			err = tx.Model(&replyTo).UpdateColumn("reply_count", gorm.Expr("reply_count + 1")).Error
+			if err != nil {
+				return err
+			}
+		}
 
 		comment = Comment{
 			Content:     body.Content,
@@ -148,11 +358,22 @@ func CreateAComment(c *fiber.Ctx) (err error) {
 			return BadRequest()
 		}
 
+		// render markdown, mentions and links
+		if err = comment.RenderContent(tx); err != nil {
+			return err
+		}
+		if err = SetContentAttachments(tx, ContentTypeComment, comment.ID, user.ID, body.AttachmentIDs); err != nil {
+			return err
+		}
+
 		// update topic
 		result = tx.Model(&topic).Update("comment_count", gorm.Expr("comment_count + 1"))
 		if result.Error != nil {
 			return result.Error
 		}
+		if err = UpdateTopicHotScore(tx, topic.ID); err != nil {
+			return err
+		}
 
 		// update user comment count
 		result = tx.Model(&user).Update("comment_count", gorm.Expr("comment_count + 1"))
@@ -220,6 +441,10 @@ func ModifyAComment(c *fiber.Ctx) (err error) {
 			return Forbidden()
 		}
 	}
+	// 附件只能由发布者修改
+	if body.AttachmentIDs != nil && user.ID != comment.PosterID {
+		return Forbidden()
+	}
 	if err = DB.Transaction(func(tx *gorm.DB) error {
 		// load comment with lock
 		if err = tx.Clauses(LockClause).First(&comment, id).Error; err != nil {
@@ -227,12 +452,34 @@ func ModifyAComment(c *fiber.Ctx) (err error) {
 		}
 
 		// copy body to comment
+		original := comment.ToRevision()
 		if err = copier.CopyWithOption(&comment, &body, CopyOption); err != nil {
 			return err
 		}
 
+		// record revision
+		edited, err := RecordRevision(tx, original, comment.ToRevision(), user.ID)
+		if err != nil {
+			return err
+		}
+		if edited {
+			now := time.Now()
+			comment.EditedAt = &now
+		}
+
 		// update comment
-		return tx.Model(&comment).Select("Content", "IsHidden").Updates(&comment).Error
+		if err = tx.Model(&comment).Select("Content", "IsHidden", "EditedAt").Updates(&comment).Error; err != nil {
+			return err
+		}
+		if body.AttachmentIDs != nil {
+			if err = SetContentAttachments(tx, ContentTypeComment, comment.ID, user.ID, *body.AttachmentIDs); err != nil {
+				return err
+			}
+		}
+		if edited {
+			return comment.RenderContent(tx)
+		}
+		return nil
 	}); err != nil {
 		return err
 	}
@@ -287,14 +534,37 @@ func DeleteAComment(c *fiber.Ctx) (err error) {
 		return Forbidden()
 	}
 
-	result = DB.Delete(&comment)
-	if result.RowsAffected == 0 {
-		return BadRequest()
-	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&comment)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return BadRequest()
+		}
 
-	result = DB.Model(&topic).Update("comment_count", gorm.Expr("comment_count - 1"))
-	if result.Error != nil {
-		return result.Error
+		if comment.ReplyToID != nil {
+			err = tx.Model(&Comment{}).Where("id = ?", *comment.ReplyToID).
+				UpdateColumn("reply_count", gorm.Expr("reply_count - 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// update topic
+		result = tx.Model(&topic).Update("comment_count", gorm.Expr("comment_count - 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if err = UpdateTopicHotScore(tx, topic.ID); err != nil {
+			return err
+		}
+
+		// update user comment count
+		return tx.Model(&User{ID: comment.PosterID}).Update("comment_count", gorm.Expr("comment_count - 1")).Error
+	})
+	if err != nil {
+		return err
 	}
 	return Success(c, &EmptyStruct{})
 }
@@ -393,6 +663,9 @@ func ListCommentsByUser(c *fiber.Ctx) (err error) {
 	}
 
 	uid, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
 
 	var query CommentListByUserRequest
 	err = ValidateQuery(c, &query)
@@ -400,25 +673,17 @@ func ListCommentsByUser(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	tx := DB.Where("poster_id = ?", uid)
-	if query.OrderBy == "id" {
-		tx = tx.Order(query.OrderBy + " asc")
-	} else {
-		tx = tx.Order(query.OrderBy + " desc")
-	}
-	if user.ID != uid {
-		tx = tx.Where("is_anonymous = false")
-	}
+	tx := ReadDB(c).Scopes(PostedBy(uid, user.ID))
 
-	tx = query.QuerySet(tx)
-
-	var comments []Comment
-	result := tx.Preload("Poster").Find(&comments)
-	if result.Error != nil {
-		return result.Error
+	var (
+		comments []Comment
+		response CommentListResponse
+	)
+	response.CursorResponse, err = CursorLoad(tx.Preload("Poster"), &comments, query.CursorRequest, query.CursorOrder())
+	if err != nil {
+		return err
 	}
 
-	var response CommentListResponse
 	if err = copier.CopyWithOption(&response.Comments, &comments, CopyOption); err != nil {
 		return err
 	}
@@ -449,7 +714,7 @@ func SearchComments(c *fiber.Ctx) (err error) {
 	}
 
 	var comments []Comment
-	_, err = Search(DB, &comments, query.Search, "", []string{"id desc"}, "", query.PageRequest)
+	_, err = Search(ReadDB(c), &comments, query.Search, "", []string{"id desc"}, "content", query.PageRequest)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,41 @@
+package apis
+
+import (
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TriggerCounterReconcile godoc
+// @Summary 重新计算所有冗余计数器并报告偏差，仅管理员
+// @Description 计数器包括话题的评论数、点赞数、收藏数、浏览数，用户的话题数、评论数、关注数，标签热度，提问箱帖子数等
+// @Tags Admin Module
+// @Produce json
+// @Router /counters/_reconcile [post]
+// @Param json query CounterReconcileRequest true "query"
+// @Success 200 {object} RespForSwagger{data=CounterReconcileReport}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func TriggerCounterReconcile(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+	if !user.IsAdmin {
+		return Forbidden("只有管理员才能重新计算计数器")
+	}
+
+	var query CounterReconcileRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	report, err := ReconcileCounters(DB, query.DryRun)
+	if err != nil {
+		return
+	}
+	LogCounterReconcileReport(report)
+
+	return Success(c, &report)
+}
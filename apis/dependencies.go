@@ -10,6 +10,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/juju/errors"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 	"strings"
 )
 
@@ -71,6 +72,43 @@ func GetCurrentUser(c *fiber.Ctx, user *User) (err error) {
 	return nil
 }
 
+// HasCredentials 请求是否携带了登录凭证，没有携带时允许访客的接口以访客身份处理
+func HasCredentials(c *fiber.Ctx) bool {
+	return c.Cookies("jwt") != "" || c.Get("Authorization") != ""
+}
+
+// GetOptionalUser 携带了登录凭证时获取当前用户，否则 user 为空，用于不需要登录的接口
+func GetOptionalUser(c *fiber.Ctx, user *User) error {
+	if !HasCredentials(c) {
+		return nil
+	}
+	return GetCurrentUser(c, user)
+}
+
+// ReadDB 只读查询使用的数据库，配置了只读副本时优先使用副本
+// 需要在 GetCurrentUser 之后调用，以保证用户能读到自己刚写入的数据
+func ReadDB(c *fiber.Ctx) *gorm.DB {
+	userID, _ := c.Locals("user_id").(int)
+	return ReplicaDB(userID)
+}
+
+// StickToPrimaryAfterWrite 用户的写请求成功后，一段时间内该用户的读请求使用主库
+func StickToPrimaryAfterWrite(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		return nil
+	}
+	if userID, ok := c.Locals("user_id").(int); ok {
+		if err := StickToPrimary(userID); err != nil {
+			Logger.Error("stick to primary error", zap.Int("user_id", userID), zap.Error(err))
+		}
+	}
+	return nil
+}
+
 func parseJwt(token string, claims *UserClaims) (err error) {
 	// split token into 3 parts
 	parts := strings.Split(token, ".")
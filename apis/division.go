@@ -200,12 +200,12 @@ func DeleteADivision(c *fiber.Ctx) (err error) {
 			return err
 		}
 
-		err = tx.Exec("UPDATE Topic SET division_id = ? WHERE division_id = ?", body.To, id).Error
+		err = tx.Model(&Topic{}).Where("division_id = ?", id).Update("division_id", body.To).Error
 		if err != nil {
 			return err
 		}
 
-		return DB.Delete(&division).Error
+		return tx.Delete(&division).Error
 	}); err != nil {
 		return err
 	}
@@ -0,0 +1,237 @@
+package apis
+
+import (
+	"chatdan_backend/config"
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 未登录用户的提问需要完成工作量证明，并且按照 IP 和提问箱限流，提问箱的主人可以按照指纹和 IP 屏蔽
+// 网关需要放行 GET /api/messageBox/{id}/_challenge 和 POST /api/post
+
+func guestChallengeScope(boxID int) string {
+	return fmt.Sprintf("box:%d", boxID)
+}
+
+// GetAGuestChallenge godoc
+// @Summary 获取未登录用户提问的工作量证明
+// @Description 不需要登录。客户端需要找到 nonce 使 sha256(challenge + nonce) 的前导零比特数不少于 difficulty，提问时提交 challenge 和 nonce
+// @Description 每个 challenge 只能使用一次，只能用于该提问箱
+// @Tags Post Module
+// @Produce json
+// @Router /messageBox/{id}/_challenge [get]
+// @Param id path int true "box id"
+// @Success 200 {object} RespForSwagger{data=GuestChallengeResponse}
+// @Failure 403 {object} RespForSwagger
+// @Failure 404 {object} RespForSwagger
+func GetAGuestChallenge(c *fiber.Ctx) (err error) {
+	var boxID int
+	if boxID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var box Box
+	if err = DB.Take(&box, boxID).Error; err != nil {
+		return
+	}
+	if _, err = box.CheckAcceptPost(nil, true); err != nil {
+		return
+	}
+
+	challenge, err := NewChallenge(guestChallengeScope(box.ID), config.Config.GuestPowDifficulty)
+	if err != nil {
+		return
+	}
+
+	return Success(c, &GuestChallengeResponse{Challenge: *challenge})
+}
+
+// checkGuestPost 检查未登录用户的提问：工作量证明、屏蔽和限流，通过后记录指纹
+func checkGuestPost(c *fiber.Ctx, box *Box, body *PostCreateRequest, post *Post) (err error) {
+	if len(body.AttachmentIDs) > 0 {
+		return BadRequest("未登录用户不能上传附件")
+	}
+	if err = VerifyChallenge(guestChallengeScope(box.ID), body.Challenge, body.Nonce); err != nil {
+		return
+	}
+
+	// 反向代理传递的客户端 IP 只在配置了可信代理时使用，见 bootstrap
+	ip := c.IP()
+	fingerprint, ipHash := GuestFingerprint(body.Fingerprint, ip), GuestIPHash(ip)
+	blocked, err := IsGuestBlocked(DB, box.ID, fingerprint, ipHash)
+	if err != nil {
+		return
+	}
+	if blocked {
+		return Forbidden("你已被提问箱的主人屏蔽")
+	}
+
+	window := config.Config.GuestRateLimitWindow
+	allowed, err := RateLimit("guest_post:ip:"+ip, config.Config.GuestIPRateLimit, window)
+	if err != nil {
+		return
+	}
+	if !allowed {
+		return TooManyRequests("提问太频繁，请稍后再试")
+	}
+	if allowed, err = RateLimit(fmt.Sprintf("guest_post:box:%d", box.ID), config.Config.GuestBoxRateLimit, window); err != nil {
+		return
+	}
+	if !allowed {
+		return TooManyRequests("该提问箱收到的提问太多，请稍后再试")
+	}
+
+	post.IsGuest = true
+	post.GuestFingerprint = fingerprint
+	post.GuestIPHash = ipHash
+	return nil
+}
+
+// BlockAGuest godoc
+// @Summary 屏蔽未登录的提问者
+// @Description 只有提问箱的主人可以操作，按照提问者的指纹和 IP 屏蔽，之后该提问者不能再向这个提问箱提问
+// @Tags MessageBox Module
+// @Produce json
+// @Router /post/{id}/_block_guest [post]
+// @Param id path int true "post id"
+// @Success 201 {object} RespForSwagger{data=BoxGuestBlockResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+func BlockAGuest(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var postID int
+	if postID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var post Post
+	if err = DB.Preload("Box").First(&post, postID).Error; err != nil {
+		return
+	}
+	if post.Box == nil || post.Box.OwnerID != user.ID {
+		return Forbidden("只有提问箱的主人可以屏蔽提问者")
+	}
+	if !post.IsGuest || post.GuestFingerprint == "" {
+		return BadRequest("只能屏蔽未登录的提问者")
+	}
+
+	block := BoxGuestBlock{BoxID: post.BoxID, Fingerprint: post.GuestFingerprint, IPHash: post.GuestIPHash, PostID: post.ID}
+	if err = DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error; err != nil {
+		return
+	}
+	if block.ID == 0 {
+		if err = DB.Where("box_id = ? AND fingerprint = ?", block.BoxID, block.Fingerprint).Take(&block).Error; err != nil {
+			return
+		}
+	}
+
+	var response BoxGuestBlockResponse
+	if err = copier.Copy(&response, &block); err != nil {
+		return
+	}
+
+	return Created(c, &response)
+}
+
+// ListGuestBlocks godoc
+// @Summary 查询提问箱屏蔽的未登录提问者
+// @Tags MessageBox Module
+// @Produce json
+// @Router /messageBox/{id}/_guest_blocks [get]
+// @Param id path int true "box id"
+// @Param query query CursorRequest false "page"
+// @Success 200 {object} RespForSwagger{data=BoxGuestBlockListResponse}
+// @Failure 403 {object} RespForSwagger
+func ListGuestBlocks(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var boxID int
+	if boxID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var query CursorRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	var box Box
+	if err = DB.Take(&box, boxID).Error; err != nil {
+		return
+	}
+	if box.OwnerID != user.ID {
+		return Forbidden()
+	}
+
+	var (
+		blocks   []BoxGuestBlock
+		response BoxGuestBlockListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Where("box_id = ?", box.ID), &blocks, query, CursorOrder{Column: "id", Desc: true},
+	); err != nil {
+		return
+	}
+	if err = copier.Copy(&response.Blocks, &blocks); err != nil {
+		return
+	}
+	if response.Blocks == nil {
+		response.Blocks = []BoxGuestBlockResponse{}
+	}
+
+	return Success(c, &response)
+}
+
+// DeleteAGuestBlock godoc
+// @Summary 取消屏蔽未登录的提问者
+// @Tags MessageBox Module
+// @Produce json
+// @Router /messageBox/{id}/_guest_blocks/{block_id} [delete]
+// @Param id path int true "box id"
+// @Param block_id path int true "block id"
+// @Success 200 {object} RespForSwagger{data=EmptyStruct}
+// @Failure 403 {object} RespForSwagger
+func DeleteAGuestBlock(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var boxID, blockID int
+	if boxID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+	if blockID, err = c.ParamsInt("block_id"); err != nil {
+		return
+	}
+
+	var box Box
+	if err = DB.Take(&box, boxID).Error; err != nil {
+		return
+	}
+	if box.OwnerID != user.ID {
+		return Forbidden()
+	}
+
+	result := DB.Where("box_id = ?", box.ID).Delete(&BoxGuestBlock{}, blockID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return Success(c, &EmptyStruct{})
+}
@@ -0,0 +1,52 @@
+package apis
+
+import (
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jinzhu/copier"
+)
+
+// ListMentions godoc
+// @Summary 查询提及当前用户的内容，按照时间倒序排序
+// @Description 只返回内容的类型和 ID，内容是否可见和发布者是否匿名由查询内容的接口决定
+// @Tags User Module
+// @Produce json
+// @Router /mentions [get]
+// @Param body query CursorRequest true "page"
+// @Success 200 {object} RespForSwagger{data=MentionListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListMentions(c *fiber.Ctx) (err error) {
+	// get current user
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	// get and validate query
+	var query CursorRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	// load mentions from database
+	var (
+		mentions []Mention
+		response MentionListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		ReadDB(c).Where("user_id = ?", user.ID),
+		&mentions, query, CursorOrder{Column: "id", Desc: true},
+	); err != nil {
+		return
+	}
+
+	// construct response
+	response.Mentions = make([]MentionResponse, 0, len(mentions))
+	if err = copier.Copy(&response.Mentions, &mentions); err != nil {
+		return
+	}
+
+	return Success(c, &response)
+}
@@ -5,10 +5,14 @@ import (
 	. "chatdan_backend/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
+	"time"
 )
 
 // ListPosts godoc
 // @Summary 查询所有帖子
+// @Description 提问箱的主人可以查看所有提问，其他用户可以查看公开的提问、自己的提问和公开了回答的提问
+// @Description 只能查看公开的回答时，提问的内容和提问者不返回
 // @Tags Post Module
 // @Produce json
 // @Router /posts [get]
@@ -36,24 +40,56 @@ func ListPosts(c *fiber.Ctx) (err error) {
 	}
 
 	// construct querySet
-	querySet := query.QuerySet(DB).Preload("Poster").Where("box_id = ?", query.BoxID)
+	querySet := DB.Where("box_id = ?", query.BoxID)
 	if user.ID != box.OwnerID {
-		querySet = querySet.Where("is_public = true or poster_id = ?", user.ID)
+		querySet = querySet.Where("is_public = ? OR poster_id = ? OR (is_answer_public = ? AND answer_status = ?)",
+			true, user.ID, true, AnswerAnswered)
+	}
+	if query.AnswerStatus != "" {
+		querySet = querySet.Where("answer_status = ?", query.AnswerStatus)
+	}
+	if query.Pinned != nil {
+		if *query.Pinned {
+			querySet = querySet.Where("pinned_at IS NOT NULL")
+		} else {
+			querySet = querySet.Where("pinned_at IS NULL")
+		}
+	}
+
+	order := CursorOrder{Column: "id"}
+	switch query.OrderBy {
+	case "unanswered_first":
+		order = CursorOrder{Column: "answer_status", Desc: true}
+	case "pinned_at":
+		querySet = querySet.Where("pinned_at IS NOT NULL")
+		order = CursorOrder{Column: "pinned_at", Desc: true}
 	}
 
 	// load posts from database
-	var posts []Post
-	if err = querySet.Find(&posts).Error; err != nil {
+	var (
+		posts    []Post
+		response PostListResponse
+		total    int64
+	)
+	// 已废弃的 total 只在第一页计算，避免每一页都查询总数
+	if query.Cursor == "" {
+		if err = querySet.Session(&gorm.Session{}).Model(&Post{}).Count(&total).Error; err != nil {
+			return
+		}
+		response.Total = int(total)
+	}
+	if response.CursorResponse, err = CursorLoad(querySet.Preload("Poster"), &posts, query.CursorRequest, order); err != nil {
 		return
 	}
 
 	// construct response
-	var response PostListResponse
 	if err = copier.CopyWithOption(&response.Posts, &posts, CopyOption); err != nil {
 		return
 	}
 	for i := range response.Posts {
+		posts[i].Box = &box
 		response.Posts[i].IsOwner = posts[i].PosterID == user.ID
+		response.Posts[i].IsQuestionHidden = posts[i].AccessOf(user.ID) != PostAccessFull
 	}
 
 	return Success(c, &response)
@@ -88,13 +124,18 @@ func GetAPost(c *fiber.Ctx) (err error) {
 	}
 
 	// check if user is authorized to view this post
-	if user.ID != post.Box.OwnerID && user.ID != post.PosterID && !post.IsPublic {
+	access := post.AccessOf(user.ID)
+	if access == PostAccessNone {
 		return Forbidden()
 	}
 
 	// load channels' content of the post
 	var channelsContent []string
-	if err = DB.Model(&Channel{}).Where("post_id = ?", post.ID).Pluck("content", &channelsContent).Error; err != nil {
+	channelQuery := DB.Model(&Channel{}).Where("post_id = ?", post.ID)
+	if access == PostAccessAnswer {
+		channelQuery = channelQuery.Where("owner_id = ?", post.Box.OwnerID)
+	}
+	if err = channelQuery.Order("id").Pluck("content", &channelsContent).Error; err != nil {
 		return err
 	}
 
@@ -105,6 +146,24 @@ func GetAPost(c *fiber.Ctx) (err error) {
 	}
 	response.Channels = channelsContent
 	response.IsOwner = user.ID == response.PosterID
+	response.IsQuestionHidden = access != PostAccessFull
+	if response.IsQuestionHidden {
+		return Success(c, &response)
+	}
+
+	// load link previews
+	previewMap, err := loadLinkPreviews(c, ContentTypePost, []int{post.ID})
+	if err != nil {
+		return
+	}
+	response.LinkPreviews = previewMap[post.ID]
+
+	// load attachments
+	attachmentMap, err := loadAttachments(c, ContentTypePost, []int{post.ID})
+	if err != nil {
+		return
+	}
+	response.Attachments = attachmentMap[post.ID]
 
 	return Success(c, &response)
 }
@@ -112,6 +171,7 @@ func GetAPost(c *fiber.Ctx) (err error) {
 // CreateAPost godoc
 // @Summary 创建帖子、提问
 // @Description 文本至少1字符，最多2000字符
+// @Description 未登录时以访客身份匿名提问，需要提问箱允许，并提交 GET /messageBox/{id}/_challenge 的工作量证明
 // @Tags Post Module
 // @Accept json
 // @Produce json
@@ -121,10 +181,13 @@ func GetAPost(c *fiber.Ctx) (err error) {
 // @Failure 500 {object} RespForSwagger
 // @Router /post [post]
 func CreateAPost(c *fiber.Ctx) (err error) {
-	// get current user
+	// get current user, 未登录时以访客身份提问
 	var user User
-	if err = GetCurrentUser(c, &user); err != nil {
-		return
+	guest := !HasCredentials(c)
+	if !guest {
+		if err = GetCurrentUser(c, &user); err != nil {
+			return
+		}
 	}
 
 	// parse and validate body
@@ -146,8 +209,34 @@ func CreateAPost(c *fiber.Ctx) (err error) {
 	}
 	post.PosterID = user.ID
 
+	// 按照提问箱的设置检查是否接受提问，并决定是否匿名
+	if post.IsAnonymous, err = box.CheckAcceptPost(body.IsAnonymous, guest); err != nil {
+		return
+	}
+	if guest {
+		if err = checkGuestPost(c, &box, &body, &post); err != nil {
+			return
+		}
+	}
+
 	// create the post to database
-	if err = DB.Create(&post).Error; err != nil {
+	if err = DB.Transaction(func(tx *gorm.DB) error {
+		if err = tx.Create(&post).Error; err != nil {
+			return err
+		}
+		if err = post.GenerateAnonyname(tx); err != nil {
+			return err
+		}
+		if err = post.RenderContent(tx); err != nil {
+			return err
+		}
+		if err = SetContentAttachments(tx, ContentTypePost, post.ID, user.ID, body.AttachmentIDs); err != nil {
+			return err
+		}
+
+		// update box.post_count
+		return tx.Model(&box).Update("post_count", gorm.Expr("post_count + 1")).Error
+	}); err != nil {
 		return
 	}
 
@@ -156,7 +245,7 @@ func CreateAPost(c *fiber.Ctx) (err error) {
 	if err = copier.CopyWithOption(&response, &post, CopyOption); err != nil {
 		return
 	}
-	response.IsOwner = true
+	response.IsOwner = !guest
 
 	return Created(c, &response)
 }
@@ -191,22 +280,40 @@ func ModifyAPost(c *fiber.Ctx) (err error) {
 		return
 	}
 
-	// load post from database
 	var post Post
-	if err = DB.First(&post, postID).Error; err != nil {
-		return
-	}
-
-	// check if user is authorized to modify this post
-	if user.ID != post.PosterID {
-		return Forbidden()
-	}
-
-	// update post
-	if err = copier.CopyWithOption(&post, &body, CopyOption); err != nil {
-		return
-	}
-	if err = DB.Model(&post).Select("Content", "Visibility").Updates(&post).Error; err != nil {
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		// load post from database
+		if err = tx.Clauses(LockClause).First(&post, postID).Error; err != nil {
+			return
+		}
+
+		// check if user is authorized to modify this post
+		if user.ID != post.PosterID {
+			return Forbidden()
+		}
+
+		// update post
+		original := post.ToRevision()
+		if err = copier.CopyWithOption(&post, &body, CopyOption); err != nil {
+			return
+		}
+		edited, err := RecordRevision(tx, original, post.ToRevision(), user.ID)
+		if err != nil {
+			return
+		}
+		if err = tx.Model(&post).Select("Content", "Visibility").Updates(&post).Error; err != nil {
+			return
+		}
+		if body.AttachmentIDs != nil {
+			if err = SetContentAttachments(tx, ContentTypePost, post.ID, user.ID, *body.AttachmentIDs); err != nil {
+				return
+			}
+		}
+		if edited {
+			return post.RenderContent(tx)
+		}
+		return nil
+	}); err != nil {
 		return
 	}
 
@@ -255,9 +362,140 @@ func DeleteAPost(c *fiber.Ctx) (err error) {
 	}
 
 	// delete post
-	if err = DB.Delete(&post).Error; err != nil {
+	if err = DB.Transaction(func(tx *gorm.DB) error {
+		if err = tx.Delete(&post).Error; err != nil {
+			return err
+		}
+
+		// update box.post_count
+		return tx.Model(&Box{ID: post.BoxID}).Update("post_count", gorm.Expr("post_count - 1")).Error
+	}); err != nil {
 		return
 	}
 
 	return Success(c, &EmptyStruct{})
 }
+
+// AnswerAPost godoc
+// @Summary 设置提问的回答状态
+// @Description 只有提问箱的主人可以设置。标记为已回答时记录回答时间；公开回答后，不公开的提问的回答所有人可见，提问的内容和提问者仍然不可见
+// @Tags Post Module
+// @Accept json
+// @Produce json
+// @Router /post/{id}/_answer [put]
+// @Param id path int true "post id"
+// @Param json body PostAnswerRequest true "answer"
+// @Success 200 {object} RespForSwagger{data=PostCommonResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func AnswerAPost(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var postID int
+	if postID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var body PostAnswerRequest
+	if err = ValidateBody(c, &body); err != nil {
+		return
+	}
+
+	var post Post
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Clauses(LockClause).Preload("Box").First(&post, postID).Error; err != nil {
+			return
+		}
+		if post.Box == nil || post.Box.OwnerID != user.ID {
+			return Forbidden("只有提问箱的主人可以回答提问")
+		}
+
+		if body.AnswerStatus != nil {
+			post.SetAnswerStatus(*body.AnswerStatus, time.Now())
+		}
+		if body.IsAnswerPublic != nil {
+			post.IsAnswerPublic = *body.IsAnswerPublic
+		}
+		return tx.Model(&post).Select("AnswerStatus", "AnsweredAt", "IsAnswerPublic").Updates(&post).Error
+	}); err != nil {
+		return
+	}
+
+	var response PostCommonResponse
+	if err = copier.Copy(&response, &post); err != nil {
+		return
+	}
+	response.IsOwner = user.ID == post.PosterID
+
+	return Success(c, &response)
+}
+
+// PinAPost godoc
+// @Summary 置顶提问
+// @Description 只有提问箱的主人可以置顶，置顶的提问可以通过 pinned 或者 order_by=pinned_at 查询
+// @Tags Post Module
+// @Produce json
+// @Router /post/{id}/_pin [put]
+// @Param id path int true "post id"
+// @Success 200 {object} RespForSwagger{data=PostCommonResponse}
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func PinAPost(c *fiber.Ctx) (err error) {
+	return pinAPost(c, true)
+}
+
+// UnpinAPost godoc
+// @Summary 取消置顶提问
+// @Tags Post Module
+// @Produce json
+// @Router /post/{id}/_pin [delete]
+// @Param id path int true "post id"
+// @Success 200 {object} RespForSwagger{data=PostCommonResponse}
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func UnpinAPost(c *fiber.Ctx) (err error) {
+	return pinAPost(c, false)
+}
+
+func pinAPost(c *fiber.Ctx, pinned bool) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var postID int
+	if postID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var post Post
+	if err = DB.Preload("Box").First(&post, postID).Error; err != nil {
+		return
+	}
+	if post.Box == nil || post.Box.OwnerID != user.ID {
+		return Forbidden("只有提问箱的主人可以置顶提问")
+	}
+
+	// 已经置顶的提问保留原来的置顶时间
+	if pinned && post.PinnedAt == nil {
+		now := time.Now()
+		post.PinnedAt = &now
+	} else if !pinned {
+		post.PinnedAt = nil
+	}
+	if err = DB.Model(&post).Select("PinnedAt").Updates(&post).Error; err != nil {
+		return
+	}
+
+	var response PostCommonResponse
+	if err = copier.Copy(&response, &post); err != nil {
+		return
+	}
+	response.IsOwner = user.ID == post.PosterID
+
+	return Success(c, &response)
+}
@@ -0,0 +1,171 @@
+package apis
+
+import (
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListTopicRevisions godoc
+// @Summary 查询话题的修改历史
+// @Description 按照版本号升序返回话题的历史版本和与上一个版本的差异，隐藏的话题只有管理员可以查看
+// @Tags Topic Module
+// @Produce json
+// @Router /topic/{id}/revisions [get]
+// @Param id path int true "topic id"
+// @Success 200 {object} RespForSwagger{data=RevisionListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListTopicRevisions(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var topic Topic
+	db := ReadDB(c)
+	err = db.Select("id", "is_hidden").First(&topic, id).Error
+	if err != nil {
+		return err
+	}
+	if topic.IsHidden && !user.IsAdmin {
+		return Forbidden()
+	}
+
+	return listRevisions(c, ContentTypeTopic, topic.ID)
+}
+
+// ListCommentRevisions godoc
+// @Summary 查询评论的修改历史
+// @Description 按照版本号升序返回评论的历史版本和与上一个版本的差异，隐藏的评论或者隐藏的话题下的评论只有管理员可以查看
+// @Tags Comment Module
+// @Produce json
+// @Router /comment/{id}/revisions [get]
+// @Param id path int true "comment id"
+// @Success 200 {object} RespForSwagger{data=RevisionListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListCommentRevisions(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return err
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return err
+	}
+
+	var comment Comment
+	db := ReadDB(c)
+	err = db.Preload("Topic").Select("id", "is_hidden", "topic_id").First(&comment, id).Error
+	if err != nil {
+		return err
+	}
+	if (comment.IsHidden || comment.Topic == nil || comment.Topic.IsHidden) && !user.IsAdmin {
+		return Forbidden()
+	}
+
+	return listRevisions(c, ContentTypeComment, comment.ID)
+}
+
+// ListPostRevisions godoc
+// @Summary 查询提问的修改历史
+// @Description 按照版本号升序返回提问的历史版本和与上一个版本的差异，权限与查看提问相同
+// @Tags Post Module
+// @Produce json
+// @Router /post/{id}/revisions [get]
+// @Param id path int true "post id"
+// @Success 200 {object} RespForSwagger{data=RevisionListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListPostRevisions(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var postID int
+	if postID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var post Post
+	if err = ReadDB(c).Preload("Box").First(&post, postID).Error; err != nil {
+		return
+	}
+	if !canViewPost(&user, &post) {
+		return Forbidden()
+	}
+
+	return listRevisions(c, ContentTypePost, post.ID)
+}
+
+// ListChannelRevisions godoc
+// @Summary 查询回复的修改历史
+// @Description 按照版本号升序返回回复的历史版本和与上一个版本的差异，权限与查看回复所在的提问相同
+// @Tags Channel Module
+// @Produce json
+// @Router /channel/{id}/revisions [get]
+// @Param id path int true "channel id"
+// @Success 200 {object} RespForSwagger{data=RevisionListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListChannelRevisions(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var channelID int
+	if channelID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var channel Channel
+	if err = ReadDB(c).Preload("Post").Preload("Post.Box").First(&channel, channelID).Error; err != nil {
+		return
+	}
+	if !canViewChannel(&user, &channel) {
+		return Forbidden()
+	}
+
+	return listRevisions(c, ContentTypeChannel, channel.ID)
+}
+
+// canViewPost 提问箱的主人、提问者可以查看提问，公开的提问所有人可以查看
+func canViewPost(user *User, post *Post) bool {
+	return post.AccessOf(user.ID) == PostAccessFull
+}
+
+// canViewChannel 回复的可见性与提问相同，公开了回答的提问所有人可以查看提问箱主人的回复
+func canViewChannel(user *User, channel *Channel) bool {
+	if channel.Post == nil {
+		return false
+	}
+	switch channel.Post.AccessOf(user.ID) {
+	case PostAccessFull:
+		return true
+	case PostAccessAnswer:
+		return channel.Post.Box != nil && channel.OwnerID == channel.Post.Box.OwnerID
+	default:
+		return false
+	}
+}
+
+func listRevisions(c *fiber.Ctx, targetType string, targetID int) (err error) {
+	revisions, err := ListRevisions(ReadDB(c), targetType, targetID)
+	if err != nil {
+		return err
+	}
+
+	response := NewRevisionListResponse(revisions)
+	return Success(c, &response)
+}
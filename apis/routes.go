@@ -6,6 +6,8 @@ import (
 )
 
 func RegisterRoutes(app *fiber.App) {
+	app.Use(LimitRequestBody)
+
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.Redirect("/api")
 	})
@@ -14,7 +16,14 @@ func RegisterRoutes(app *fiber.App) {
 	})
 	app.Get("/docs/*", swagger.HandlerDefault)
 
+	// Share, no login required
+	app.Get("/share/box/:slug", RenderSharedBoxPage)
+	app.Get("/share/box/:slug/card.png", RenderSharedBoxCard)
+	app.Get("/share/post/:slug", RenderSharedPostPage)
+	app.Get("/share/post/:slug/card.png", RenderSharedPostCard)
+
 	group := app.Group("/api")
+	group.Use(StickToPrimaryAfterWrite)
 
 	// User
 	group.Post("/user/login", Login)
@@ -35,6 +44,15 @@ func RegisterRoutes(app *fiber.App) {
 	group.Get("/users/:id/_followers", ListUserFollowers)
 	group.Get("/users/:id/_following", ListUserFollowing)
 	group.Get("/users/_search", SearchUsers)
+	group.Get("/mentions", ListMentions)
+
+	// Upload
+	group.Post("/upload", UploadAFile)
+
+	// Admin
+	group.Post("/counters/_reconcile", TriggerCounterReconcile) // admin only
+	group.Post("/_deanonymize", DeanonymizeAContent)            // admin only
+	group.Get("/_deanonymize/logs", ListDeanonymizeLogs)        // admin only
 
 	// Box
 	group.Get("/messageBoxes", ListBoxes)
@@ -42,13 +60,26 @@ func RegisterRoutes(app *fiber.App) {
 	group.Post("/messageBox", CreateABox)
 	group.Put("/messageBox/:id", ModifyABox)
 	group.Delete("/messageBox/:id", DeleteABox)
+	group.Put("/messageBox/:id/_share", RotateBoxShareSlug)                    // owner only
+	group.Get("/messageBox/:id/_challenge", GetAGuestChallenge)                // no login required
+	group.Get("/messageBox/:id/_guest_blocks", ListGuestBlocks)                // owner only
+	group.Delete("/messageBox/:id/_guest_blocks/:block_id", DeleteAGuestBlock) // owner only
 
 	// Post
 	group.Get("/posts", ListPosts)
 	group.Get("/post/:id", GetAPost)
-	group.Post("/post", CreateAPost)
+	group.Post("/post", CreateAPost) // guests can post to boxes allowing guests
 	group.Put("/post/:id", ModifyAPost)
 	group.Delete("/post/:id", DeleteAPost)
+	group.Get("/post/:id/revisions", ListPostRevisions)
+	group.Put("/post/:id/_answer", AnswerAPost)       // box owner only
+	group.Put("/post/:id/_pin", PinAPost)             // box owner only
+	group.Delete("/post/:id/_pin", UnpinAPost)        // box owner only
+	group.Post("/post/:id/_block_guest", BlockAGuest) // box owner only
+
+	// Share, no login required
+	group.Get("/share/box/:slug", GetASharedBox)
+	group.Get("/share/post/:slug", GetASharedPost)
 
 	// Channel
 	group.Get("/channels", ListChannels)
@@ -56,11 +87,25 @@ func RegisterRoutes(app *fiber.App) {
 	group.Post("/channel", CreateAChannel)
 	group.Put("/channel/:id", ModifyAChannel)
 	group.Delete("/channel/:id", DeleteAChannel)
+	group.Get("/channel/:id/revisions", ListChannelRevisions)
 
 	// Wall
 	group.Get("/wall", ListWalls)
 	group.Get("/wall/:id", GetAWall)
 	group.Post("/wall", CreateAWall)
+	group.Put("/wall/:id", ModifyAWall)
+	group.Delete("/wall/:id", DeleteAWall)
+	group.Get("/walls/_received", ListReceivedWalls)
+	group.Put("/wall/:id/_reaction", ReactToAWall)
+	group.Delete("/wall/:id/_reaction", DeleteAWallReaction)
+	group.Get("/wall/:id/comments", ListWallComments)
+	group.Post("/wall/:id/comments", CreateAWallComment)
+	group.Delete("/wallComment/:id", DeleteAWallComment)
+	group.Get("/walls/_matches", ListWallMatches)
+	group.Get("/walls/_pending", ListPendingWalls)    // admin only
+	group.Put("/wall/:id/_exclude", ExcludeAWall)     // admin only
+	group.Delete("/wall/:id/_exclude", IncludeAWall)  // admin only
+	group.Post("/walls/_publish", TriggerWallPublish) // admin only
 
 	// Division
 	group.Get("/divisions", ListDivisions)
@@ -75,14 +120,16 @@ func RegisterRoutes(app *fiber.App) {
 	group.Post("/topic", CreateATopic)
 	group.Put("/topic/:id", ModifyATopic)
 	group.Delete("/topic/:id", DeleteATopic)
+	group.Get("/topic/:id/revisions", ListTopicRevisions)
 	group.Put("/topic/:id/_like/:data", LikeOrDislikeATopic)
 	group.Put("/topic/:id/_view", ViewATopic)
 	group.Put("/topic/:id/_favor", FavorATopic)
 	group.Delete("/topic/:id/_favor", UnfavorATopic)
 	group.Get("/topics/_favor", ListFavoriteTopics)
 	group.Get("/topics/_user/:id", ListTopicsByUser)
-	group.Get("/topics/_tag/:tag", ListTopicsByTag)
+	group.Get("/topics/_tag/:tag_id", ListTopicsByTag)
 	group.Get("/topics/_search", SearchTopics)
+	group.Get("/timeline", ListTimeline)
 
 	// Comment
 	group.Get("/comments", ListComments)
@@ -90,9 +137,12 @@ func RegisterRoutes(app *fiber.App) {
 	group.Post("/comment", CreateAComment)
 	group.Put("/comment/:id", ModifyAComment)
 	group.Delete("/comment/:id", DeleteAComment)
+	group.Get("/comment/:id/revisions", ListCommentRevisions)
 	group.Put("/comment/:id/_like/:data", LikeOrDislikeAComment)
 	group.Get("/comments/_user/:id", ListCommentsByUser)
 	group.Get("/comments/_search", SearchComments)
+	group.Get("/comments/_thread", ListCommentThreads)
+	group.Get("/comment/:id/_replies", ListCommentReplies)
 
 	// Tag
 	group.Get("/tags", ListTags)
@@ -100,6 +150,13 @@ func RegisterRoutes(app *fiber.App) {
 	group.Post("/tag", CreateATag)
 	group.Put("/tag/:id", ModifyATag) // admin only
 	group.Delete("/tag/:id", DeleteATag)
+	group.Post("/tag/:id/_subscribe", SubscribeATag)
+	group.Delete("/tag/:id/_subscribe", UnsubscribeATag)
+	group.Get("/tags/_subscribed", ListSubscribedTags)
+	group.Post("/tag/:id/_merge", MergeATag) // admin only
+	group.Get("/tag/:id/_alias", ListTagAliases)
+	group.Post("/tag/:id/_alias", CreateATagAlias)             // admin only
+	group.Delete("/tag/:id/_alias/:alias_id", DeleteATagAlias) // admin only
 
 	// Chat and Message
 	group.Get("/chats", ListChats)
@@ -28,6 +28,7 @@ func init() {
 	Validate.RegisterStructValidation(ModifyRequestLevelValidation, UserModifyRequest{})
 	Validate.RegisterStructValidation(ModifyRequestLevelValidation, BoxModifyRequest{})
 	Validate.RegisterStructValidation(ModifyRequestLevelValidation, PostModifyRequest{})
+	Validate.RegisterStructValidation(ModifyRequestLevelValidation, PostAnswerRequest{})
 	Validate.RegisterStructValidation(ModifyRequestLevelValidation, DivisionModifyRequest{})
 	Validate.RegisterStructValidation(ModifyRequestLevelValidation, TopicModifyRequest{})
 	Validate.RegisterStructValidation(ModifyRequestLevelValidation, CommentModifyRequest{})
@@ -74,13 +75,29 @@ type ResetRequest struct {
 }
 
 type UserListRequest struct {
-	PageRequest
+	CursorRequest
+	PageNum int `json:"page_num" query:"page_num" validate:"omitempty,min=1"` // 按页码分页，不填使用游标分页
+	Version int `json:"version" query:"version" validate:"omitempty,min=0"`   // 按页码分页时的分页版本号
+}
+
+// PageRequest 按页码分页的参数，页码不填默认第一页
+func (u UserListRequest) PageRequest() PageRequest {
+	request := PageRequest{PageNum: u.PageNum, PageSize: u.PageSize, Version: u.Version}
+	if request.PageNum == 0 {
+		request.PageNum = 1
+	}
+	return request
+}
+
+type UserFollowListRequest struct {
+	CursorRequest
 }
 
 type UserListResponse struct {
 	Users   []UserResponse `json:"users"`
-	Version int            `json:"version"`
-	Total   int            `json:"total"` // User 总数，便于前端分页
+	Version int            `json:"version,omitempty"`
+	Total   int            `json:"total,omitempty"` // User 总数，按页码分页时返回，便于前端分页
+	CursorResponse
 }
 
 type UserModifyRequest struct {
@@ -115,9 +132,26 @@ type BoxCommonResponse struct {
 	Title     string        `json:"title"`
 	PostCount int           `json:"post_count"`
 	ViewCount int           `json:"view_count"`
+
+	// 提问箱设置
+	Description      string     `json:"description"`
+	QuestionTemplate string     `json:"question_template"`                           // 提问的提示或模板
+	Status           string     `json:"status"`                                      // open 或 closed
+	ClosesAt         *time.Time `json:"closes_at,omitempty" extensions:"x-nullable"` // 定时关闭的时间
+	AnonymousPolicy  string     `json:"anonymous_policy"`                            // allowed: 提问者选择，默认匿名；required: 只接受匿名提问；forbidden: 只接受实名提问
+	AllowGuest       bool       `json:"allow_guest"`                                 // 是否接受未登录用户的提问
+	ShareSlug        string     `json:"share_slug,omitempty"`                        // 分享链接 /share/box/{share_slug}，只返回给提问箱的主人
+
+	// 动态生成的字段
+	IsOpen bool `json:"is_open"` // 当前是否接受提问，考虑定时关闭
 }
 
 func (b *BoxCommonResponse) Postprocess(c *fiber.Ctx) (err error) {
+	b.IsOpen = (&Box{Status: b.Status, ClosesAt: b.ClosesAt}).IsOpen(time.Now())
+	if userID, _ := c.Locals("user_id").(int); userID != b.OwnerID {
+		b.ShareSlug = ""
+	}
+
 	// load owner
 	if b.OwnerID != 0 {
 		var user User
@@ -133,20 +167,38 @@ func (b *BoxCommonResponse) Postprocess(c *fiber.Ctx) (err error) {
 }
 
 type BoxCreateRequest struct {
-	Title string `json:"title" query:"title" validate:"required"`
+	Title            string     `json:"title" query:"title" validate:"required"`
+	Description      string     `json:"description" validate:"max=500"`
+	QuestionTemplate string     `json:"question_template" validate:"max=500"`
+	Status           string     `json:"status" validate:"omitempty,oneof=open closed" default:"open"`
+	ClosesAt         *time.Time `json:"closes_at" validate:"omitempty"` // 定时关闭，需要晚于当前时间
+	AnonymousPolicy  string     `json:"anonymous_policy" validate:"omitempty,oneof=allowed required forbidden" default:"allowed"`
+	AllowGuest       bool       `json:"allow_guest"` // 默认不接受未登录用户的提问
 }
 
 type BoxListRequest struct {
-	PageRequest
+	CursorRequest
+	PageNum int    `json:"page_num" query:"page_num" validate:"omitempty,min=1"` // 按页码分页，不填使用游标分页；搜索时只支持按页码分页，不填默认第一页
+	Version int    `json:"version" query:"version" validate:"omitempty,min=0"`   // 按页码分页时的分页版本号
 	Title   string `json:"title" query:"title"`
 	Owner   int    `json:"owner" query:"owner" validate:"omitempty,min=0"`
 	OrderBy string `json:"order_by" query:"order_by" validate:"oneof='id asc' 'updated_at desc'" default:"id asc"`
 }
 
+// PageRequest 按页码分页的参数，页码不填默认第一页
+func (b BoxListRequest) PageRequest() PageRequest {
+	request := PageRequest{PageNum: b.PageNum, PageSize: b.PageSize, Version: b.Version}
+	if request.PageNum == 0 {
+		request.PageNum = 1
+	}
+	return request
+}
+
 type BoxListResponse struct {
 	MessageBoxes []BoxCommonResponse `json:"messageBoxes"`
-	Version      int                 `json:"version"`
-	Total        int                 `json:"total"` // Box 总数，便于前端分页
+	Version      int                 `json:"version,omitempty"`
+	Total        int                 `json:"total,omitempty"` // Box 总数，按页码分页时返回，便于前端分页
+	CursorResponse
 }
 
 func (b *BoxListResponse) Postprocess(c *fiber.Ctx) (err error) {
@@ -161,7 +213,13 @@ func (b *BoxListResponse) Postprocess(c *fiber.Ctx) (err error) {
 		return
 	}
 
+	now := time.Now()
+	userID, _ := c.Locals("user_id").(int)
 	for i := range b.MessageBoxes {
+		b.MessageBoxes[i].IsOpen = (&Box{Status: b.MessageBoxes[i].Status, ClosesAt: b.MessageBoxes[i].ClosesAt}).IsOpen(now)
+		if userID != b.MessageBoxes[i].OwnerID {
+			b.MessageBoxes[i].ShareSlug = ""
+		}
 		for j := range users {
 			if b.MessageBoxes[i].OwnerID == users[j].ID {
 				b.MessageBoxes[i].Owner = &UserResponse{}
@@ -181,11 +239,18 @@ type BoxGetResponse struct {
 }
 
 type BoxModifyRequest struct {
-	Title *string `json:"title" query:"title"`
+	Title            *string    `json:"title" query:"title"`
+	Description      *string    `json:"description" validate:"omitempty,max=500"`
+	QuestionTemplate *string    `json:"question_template" validate:"omitempty,max=500"`
+	Status           *string    `json:"status" validate:"omitempty,oneof=open closed"` // 设置为 open 时取消定时关闭，除非同时设置 closes_at
+	ClosesAt         *time.Time `json:"closes_at" validate:"omitempty"`                // 定时关闭，需要晚于当前时间
+	AnonymousPolicy  *string    `json:"anonymous_policy" validate:"omitempty,oneof=allowed required forbidden"`
+	AllowGuest       *bool      `json:"allow_guest"`
 }
 
 func (b BoxModifyRequest) IsEmpty() bool {
-	return b.Title == nil
+	return b.Title == nil && b.Description == nil && b.QuestionTemplate == nil && b.Status == nil &&
+		b.ClosesAt == nil && b.AnonymousPolicy == nil && b.AllowGuest == nil
 }
 
 /* Post 帖子、提问 */
@@ -195,31 +260,62 @@ type PostCommonResponse struct {
 	PosterID     int           `json:"poster_id"`
 	Poster       *UserResponse `json:"poster,omitempty"`
 	Content      string        `json:"content"`
-	Visibility   string        `json:"visibility"` // public private
+	ContentHTML  string        `json:"content_html"` // 渲染后的内容
+	Visibility   string        `json:"visibility"`   // public private
 	IsOwner      bool          `json:"is_owner"`
 	IsAnonymous  bool          `json:"is_anonymous"`
-	Anonyname    string        `json:"anonyname"`
+	IsGuest      bool          `json:"is_guest"`  // 未登录用户的提问
+	Anonyname    string        `json:"anonyname"` // 匿名时提问者在这个提问中的昵称，与提问者匿名回复的昵称相同
 	ChannelCount int           `json:"channel_count"`
 	ViewCount    int           `json:"view_count"`
+
+	// 回答状态
+	AnswerStatus   string     `json:"answer_status"` // pending, answered, ignored
+	AnsweredAt     *time.Time `json:"answered_at,omitempty" extensions:"x-nullable"`
+	IsAnswerPublic bool       `json:"is_answer_public"` // 提问不公开时，是否公开提问箱主人的回答
+	PinnedAt       *time.Time `json:"pinned_at,omitempty" extensions:"x-nullable"`
+	ShareSlug      string     `json:"share_slug,omitempty"` // 分享链接 /share/post/{share_slug}，只有公开的提问或者公开了回答的提问返回
+
+	// 动态生成的字段
+	IsPinned         bool `json:"is_pinned"`
+	IsQuestionHidden bool `json:"is_question_hidden"` // 只能查看公开的回答，提问的内容和提问者不可见
 }
 
 func (p *PostCommonResponse) Postprocess(_ *fiber.Ctx) error {
-	if p.IsAnonymous {
+	if p.IsQuestionHidden {
+		p.Content = ""
+		p.ContentHTML = ""
+	}
+	if !(&Post{IsPublic: p.Visibility == Public, IsAnswerPublic: p.IsAnswerPublic, AnswerStatus: p.AnswerStatus}).IsShareable() {
+		p.ShareSlug = ""
+	}
+	p.IsPinned = p.PinnedAt != nil
+	return nil
+}
+
+func (p *PostCommonResponse) Redact() {
+	if p.IsAnonymous || p.IsQuestionHidden {
 		p.Poster = nil
 		p.PosterID = 0
 	}
-	return nil
+	if !p.IsAnonymous || p.IsQuestionHidden {
+		p.Anonyname = ""
+	}
 }
 
 type PostListRequest struct {
-	PageRequest
-	BoxID int `json:"message_box_id" query:"message_box_id" validate:"required"`
+	CursorRequest
+	BoxID        int    `json:"message_box_id" query:"message_box_id" validate:"required"`
+	AnswerStatus string `json:"answer_status" query:"answer_status" validate:"omitempty,oneof=pending answered ignored"`
+	Pinned       *bool  `json:"pinned" query:"pinned"`                                                                           // 只查询置顶或者未置顶的提问
+	OrderBy      string `json:"order_by" query:"order_by" validate:"omitempty,oneof=id unanswered_first pinned_at" default:"id"` // unanswered_first: 未回答的排在前面；pinned_at: 按照置顶时间倒序，只返回置顶的提问
 }
 
 type PostListResponse struct {
 	Posts   []PostCommonResponse `json:"posts"`
-	Version int                  `json:"version"`
-	Total   int                  `json:"total"` // Post 总数，便于前端分页
+	Version int                  `json:"version"` // Deprecated: 按页码分页时的版本号，游标分页不再使用，始终为 0
+	Total   int                  `json:"total"`   // Deprecated: 符合条件的 Post 总数，只在第一页（不带 cursor）返回，之后的页为 0，请使用 has_more 判断是否还有下一页
+	CursorResponse
 }
 
 func (p *PostListResponse) Postprocess(_ *fiber.Ctx) error {
@@ -231,34 +327,36 @@ func (p *PostListResponse) Postprocess(_ *fiber.Ctx) error {
 
 type PostGetResponse struct {
 	PostCommonResponse
-	Channels []string `json:"channels"`
+	Channels     []string              `json:"channels"`                // 只能查看公开的回答时，只包含提问箱主人的回复
+	LinkPreviews []LinkPreviewResponse `json:"link_previews,omitempty"` // 内容中链接的预览，抓取成功后返回
+	Attachments  []AttachmentResponse  `json:"attachments,omitempty"`
 }
 
 type PostCreateRequest struct {
-	BoxID       int    `json:"message_box_id" validate:"required,min=1"`
-	Content     string `json:"content" validate:"required,min=1,max=2000"` // 限制长度
-	Visibility  string `json:"visibility" validate:"omitempty,oneof=public private" default:"public"`
-	IsAnonymous *bool  `json:"is_anonymous" validate:"omitempty"`
+	BoxID         int    `json:"message_box_id" validate:"required,min=1"`
+	Content       string `json:"content" validate:"required,min=1,max=2000"` // 限制长度
+	Visibility    string `json:"visibility" validate:"omitempty,oneof=public private" default:"public"`
+	IsAnonymous   *bool  `json:"is_anonymous" validate:"omitempty"`                    // 不填时按照提问箱的匿名策略，默认匿名；未登录用户只能匿名提问
+	AttachmentIDs []int  `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // 上传的附件，未登录用户不能上传附件
+
+	// 未登录用户提问时需要完成工作量证明，见 GET /messageBox/{id}/_challenge
+	Challenge   string `json:"challenge" validate:"omitempty,max=64"`
+	Nonce       string `json:"nonce" validate:"omitempty,max=64"`
+	Fingerprint string `json:"fingerprint" validate:"omitempty,max=128"` // 浏览器指纹，用于屏蔽，不填时使用 IP
 }
 
 func (p *PostCreateRequest) IsPublic() bool {
 	return p.Visibility == Public
 }
 
-func (p *PostCreateRequest) SetDefaults() {
-	if p.IsAnonymous == nil {
-		p.IsAnonymous = new(bool)
-		*p.IsAnonymous = true
-	}
-}
-
 type PostModifyRequest struct {
-	Content    *string `json:"content" validate:"omitempty,min=1,max=2000"`
-	Visibility *string `json:"visibility" validate:"omitempty,oneof=public private"`
+	Content       *string `json:"content" validate:"omitempty,min=1,max=2000"`
+	Visibility    *string `json:"visibility" validate:"omitempty,oneof=public private"`
+	AttachmentIDs *[]int  `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // 替换附件，传空数组删除所有附件
 }
 
 func (p PostModifyRequest) IsEmpty() bool {
-	return p.Content == nil && p.Visibility == nil
+	return p.Content == nil && p.Visibility == nil && p.AttachmentIDs == nil
 }
 
 func (p *PostModifyRequest) IsPublic() *bool {
@@ -273,83 +371,286 @@ type PostModifyResponse struct {
 	PostCommonResponse
 }
 
+type PostAnswerRequest struct {
+	AnswerStatus   *string `json:"answer_status" validate:"omitempty,oneof=pending answered ignored"` // 提问箱的主人回复时自动标记为已回答
+	IsAnswerPublic *bool   `json:"is_answer_public"`                                                  // 提问不公开时，是否公开提问箱主人的回答
+}
+
+func (p PostAnswerRequest) IsEmpty() bool {
+	return p.AnswerStatus == nil && p.IsAnswerPublic == nil
+}
+
+/* Guest 未登录用户提问 */
+
+type GuestChallengeResponse struct {
+	Challenge
+}
+
+type BoxGuestBlockResponse struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	BoxID     int       `json:"message_box_id"`
+	PostID    int       `json:"post_id"` // 屏蔽时引用的提问
+}
+
+type BoxGuestBlockListResponse struct {
+	Blocks []BoxGuestBlockResponse `json:"blocks"`
+	CursorResponse
+}
+
 /* Channel 频道、回复 */
 
 type ChannelCommonResponse struct {
-	ID          int    `json:"id"`
-	PostID      int    `json:"post_id"`
-	Content     string `json:"content"`
-	IsOwner     bool   `json:"is_owner"`
-	IsPostOwner bool   `json:"is_post_owner"`
-	IsBoxOwner  bool   `json:"is_box_owner"`
+	ID          int     `json:"id"`
+	PostID      int     `json:"post_id"`
+	Content     string  `json:"content"`
+	IsOwner     bool    `json:"is_owner"`
+	IsPostOwner bool    `json:"is_post_owner"` // 匿名的回复为 false
+	IsBoxOwner  bool    `json:"is_box_owner"`  // 匿名的回复为 false
+	IsAnonymous bool    `json:"is_anonymous"`
+	Anonyname   *string `json:"anonyname,omitempty" extensions:"x-nullable"` // 匿名时回复者在这个提问中的昵称，提问者的昵称与匿名提问的昵称相同
+}
+
+// Redact 匿名的回复不标记回复者是提问者还是提问箱的主人，只能通过昵称区分
+func (ch *ChannelCommonResponse) Redact() {
+	if ch.IsAnonymous {
+		ch.IsPostOwner = false
+		ch.IsBoxOwner = false
+	} else {
+		ch.Anonyname = nil
+	}
 }
 
 type ChannelCreateRequest struct {
-	PostID  int    `json:"post_id" validate:"required"`
-	Content string `json:"content" validate:"required,min=1,max=2000"`
+	PostID      int    `json:"post_id" validate:"required"`
+	Content     string `json:"content" validate:"required,min=1,max=2000"`
+	IsAnonymous *bool  `json:"is_anonymous"` // 是否匿名，不填时提问者回复自己的匿名提问默认匿名，其他情况默认实名
 }
 
 type ChannelListRequest struct {
-	PageRequest
+	CursorRequest
 	PostID int `json:"post_id" query:"post_id" validate:"required,min=1"`
 }
 
 type ChannelListResponse struct {
 	Channels []ChannelCommonResponse `json:"channels"`
-	Version  int                     `json:"version"`
-	Total    int                     `json:"total"` // Channel 总数，便于前端分页
+	Version  int                     `json:"version"` // Deprecated: 按页码分页时的版本号，游标分页不再使用，始终为 0
+	Total    int                     `json:"total"`   // Deprecated: 符合条件的 Channel 总数，只在第一页（不带 cursor）返回，之后的页为 0，请使用 has_more 判断是否还有下一页
+	CursorResponse
 }
 
 type ChannelModifyRequest struct {
 	Content string `json:"content" validate:"required,min=1,max=2000"`
 }
 
+/* Share 分享，未登录用户可以访问，不返回提问者和回复者的信息 */
+
+type ShareBoxRequest struct {
+	CursorRequest
+}
+
+type ShareBoxResponse struct {
+	ShareSlug        string              `json:"share_slug"`
+	MessageBoxID     int                 `json:"message_box_id"` // 未登录用户提问时使用
+	Title            string              `json:"title"`
+	Description      string              `json:"description"`
+	QuestionTemplate string              `json:"question_template"`
+	AnonymousPolicy  string              `json:"anonymous_policy"`
+	AllowGuest       bool                `json:"allow_guest"`
+	IsOpen           bool                `json:"is_open"`
+	Posts            []SharePostResponse `json:"posts"` // 公开的已回答的提问，按照时间倒序
+	CursorResponse
+}
+
+type SharePostResponse struct {
+	ShareSlug        string     `json:"share_slug"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Content          string     `json:"content"`
+	ContentHTML      string     `json:"content_html"`
+	IsQuestionHidden bool       `json:"is_question_hidden"` // 提问不公开、只公开了回答时为 true，不返回提问的内容
+	AnswerStatus     string     `json:"answer_status"`
+	AnsweredAt       *time.Time `json:"answered_at,omitempty" extensions:"x-nullable"`
+	IsPinned         bool       `json:"is_pinned"`
+	Answers          []string   `json:"answers"` // 提问箱主人的回复
+	BoxShareSlug     string     `json:"box_share_slug,omitempty"`
+	BoxTitle         string     `json:"box_title,omitempty"`
+}
+
 /* 表白墙 */
 
 type WallCommonResponse struct {
-	ID          int           `json:"id"`
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
-	IsAnonymous bool          `json:"is_anonymous"`
-	PosterID    int           `json:"poster_id"`        // 匿名时为 0
-	Poster      *UserResponse `json:"poster,omitempty"` // 匿名时为 null
-	Content     string        `json:"content"`
-	Visibility  string        `json:"visibility"`
-	IsShown     bool          `json:"is_shown"` // 是否显示在表白墙页面
-}
-
-func (w *WallCommonResponse) Postprocess(_ *fiber.Ctx) error {
-	if w.IsAnonymous {
+	ID            int                    `json:"id"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+	IsAnonymous   bool                   `json:"is_anonymous"`
+	PosterID      int                    `json:"poster_id"`        // 匿名时为 0
+	Poster        *UserResponse          `json:"poster,omitempty"` // 匿名时为 null
+	Content       string                 `json:"content"`
+	ContentHTML   string                 `json:"content_html"` // 渲染后的内容
+	LinkPreviews  []LinkPreviewResponse  `json:"link_previews,omitempty"`
+	Attachments   []AttachmentResponse   `json:"attachments,omitempty"`
+	Visibility    string                 `json:"visibility"`                                   // public 所有人可见，private 只有发布者和表白的对象可见
+	IsHidden      bool                   `json:"is_hidden"`                                    // 被管理员隐藏
+	IsOwner       bool                   `json:"is_owner"`                                     // 当前用户是否为发布者
+	IsShown       bool                   `json:"is_shown"`                                     // 是否已经发布到表白墙页面
+	Sequence      int                    `json:"sequence,omitempty"`                           // 在当日表白墙中的序号，未发布或者不公开时为 0
+	IsExcluded    bool                   `json:"is_excluded"`                                  // 是否被管理员排除，不会发布
+	PublishAt     *time.Time             `json:"publish_at,omitempty" extensions:"x-nullable"` // 未发布时预计发布的时间
+	IsTarget      bool                   `json:"is_target,omitempty"`                          // 当前用户是否为表白的对象
+	TargetUser    *UserResponse          `json:"target_user,omitempty"`                        // 表白的对象，只返回给发布者
+	IsMatched     bool                   `json:"is_matched,omitempty"`                         // 发布者和对象互相表白，只返回给双方，此时匿名的发布者对对方可见
+	ReactionCount int                    `json:"reaction_count"`                               // 表情回应数
+	CommentCount  int                    `json:"comment_count"`                                // 回复数
+	Reactions     []WallReactionResponse `json:"reactions"`                                    // 每种表情的回应数，按照 WallReactionEmojis 的顺序
+
+	revealPoster bool // 互相表白时对对方显示匿名的发布者
+}
+
+// setStatus 设置发布状态和当前用户是否为发布者
+func (w *WallCommonResponse) setStatus(wall *Wall, user *User) {
+	w.IsOwner = user.ID != 0 && user.ID == wall.PosterID
+	w.IsShown = wall.IsPublished() && !wall.IsExcluded && !wall.IsHidden
+	if !wall.IsPublished() && !wall.IsExcluded {
+		publishAt := WallCutoff(WallBatchDate(wall.CreatedAt))
+		w.PublishAt = &publishAt
+	}
+}
+
+func (w *WallCommonResponse) Redact() {
+	if w.IsAnonymous && !w.revealPoster {
 		w.Poster = nil
 		w.PosterID = 0
 	}
-	w.Visibility = "public"
-	return nil
 }
 
 type WallListRequest struct {
-	PageRequest
-	Date *time.Time `json:"date" query:"date" validate:"omitempty"` // 日期（所对应的时间，只解析时间），不填默认当天（即昨天发送的表白墙）
+	CursorRequest
+	Date    *time.Time `json:"date" query:"date" validate:"omitempty"`                                                      // 发布日期（只解析日期），不填默认最近一次发布的日期
+	OrderBy string     `json:"order_by" query:"order_by" validate:"omitempty,oneof=sequence popularity" default:"sequence"` // sequence 按照序号，popularity 按照热度倒序
+}
+
+func (w WallListRequest) CursorOrder() CursorOrder {
+	if w.OrderBy == "popularity" {
+		return CursorOrder{Column: "popularity", Desc: true}
+	}
+	return CursorOrder{Column: "sequence"}
 }
 
 type WallListResponse struct {
-	Posts []WallCommonResponse `json:"posts"`
-	Total int                  `json:"total"`                     // Post 总数，便于前端分页
-	Date  time.Time            `json:"date" swaggertype:"string"` // 日期
+	Posts       []WallCommonResponse `json:"posts"`
+	Date        time.Time            `json:"date" swaggertype:"string"`            // 发布日期
+	PublishedAt *time.Time           `json:"published_at" extensions:"x-nullable"` // 生成时间，尚未生成时为 null
+	WindowEnd   time.Time            `json:"window_end" swaggertype:"string"`      // 截止时间，截止时间之前创建的表白在这一天发布
+	WallCount   int                  `json:"wall_count"`                           // 当日公开的表白数量
+	Total       int                  `json:"total"`                                // Deprecated: 当日当前用户可见的表白数量，只在第一页（不带 cursor）返回，之后的页为 0，请使用 has_more 判断是否还有下一页
+	CursorResponse
 }
 
 func (w *WallListResponse) Postprocess(c *fiber.Ctx) error {
-	for i := range w.Posts {
-		if err := w.Posts[i].Postprocess(c); err != nil {
-			return err
-		}
+	return postprocessWalls(c, w.Posts)
+}
+
+// postprocessWalls 处理表白列表，批量加载链接预览和附件
+func postprocessWalls(c *fiber.Ctx, posts []WallCommonResponse) error {
+	// batch load link previews
+	wallIDs := make([]int, len(posts))
+	for i := range posts {
+		wallIDs[i] = posts[i].ID
+	}
+	previewMap, err := loadLinkPreviews(c, ContentTypeWall, wallIDs)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].LinkPreviews = previewMap[posts[i].ID]
+	}
+
+	// batch load attachments
+	attachmentMap, err := loadAttachments(c, ContentTypeWall, wallIDs)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Attachments = attachmentMap[posts[i].ID]
 	}
 	return nil
 }
 
+type WallReactionResponse struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"` // 当前用户是否使用了这个表情
+}
+
+type WallReactionRequest struct {
+	Emoji string `json:"emoji" query:"emoji" validate:"required"` // WallReactionEmojis 中的一个
+}
+
+type WallCommentResponse struct {
+	ID           int           `json:"id"`
+	CreatedAt    time.Time     `json:"created_at"`
+	WallID       int           `json:"wall_id"`
+	Content      string        `json:"content"`
+	IsAnonymous  bool          `json:"is_anonymous"`
+	Anonyname    *string       `json:"anonyname,omitempty" extensions:"x-nullable"` // 匿名时的昵称，同一条表白中同一个用户的昵称相同
+	PosterID     int           `json:"poster_id"`                                   // 匿名时为 0
+	Poster       *UserResponse `json:"poster,omitempty"`                            // 匿名时为 null
+	IsOwner      bool          `json:"is_owner"`                                    // 当前用户是否为回复的发布者
+	IsWallPoster bool          `json:"is_wall_poster"`                              // 是否为表白的发布者
+}
+
+func (w *WallCommentResponse) Redact() {
+	if w.IsAnonymous {
+		w.Poster = nil
+		w.PosterID = 0
+	} else {
+		w.Anonyname = nil
+	}
+}
+
+type WallCommentListResponse struct {
+	Comments []WallCommentResponse `json:"comments"` // 按照时间顺序排列
+	CursorResponse
+}
+
+type WallCommentCreateRequest struct {
+	Content     string `json:"content" validate:"required,min=1,max=500"`
+	IsAnonymous *bool  `json:"is_anonymous"` // 是否匿名，不填默认匿名
+}
+
+func (w *WallCommentCreateRequest) SetDefaults() {
+	if w.IsAnonymous == nil {
+		w.IsAnonymous = new(bool)
+		*w.IsAnonymous = true
+	}
+}
+
+type WallReceivedListResponse struct {
+	Posts []WallCommonResponse `json:"posts"` // 以当前用户为对象的表白，按照时间倒序排列
+	CursorResponse
+}
+
+func (w *WallReceivedListResponse) Postprocess(c *fiber.Ctx) error {
+	return postprocessWalls(c, w.Posts)
+}
+
+type WallMatchResponse struct {
+	User          *UserResponse `json:"user"`            // 对方
+	WallID        int           `json:"wall_id"`         // 自己发布的表白
+	MatchedWallID int           `json:"matched_wall_id"` // 对方发布的表白
+	MatchedAt     time.Time     `json:"matched_at"`
+}
+
+type WallMatchListResponse struct {
+	Matches []WallMatchResponse `json:"matches"` // 按照时间倒序排列
+}
+
 type WallCreateRequest struct {
-	Content     string `json:"content" validate:"required,min=1,max=2000"`
-	IsAnonymous *bool  `json:"is_anonymous" validate:"omitempty"` // 是否匿名，不填默认匿名
+	Content       string `json:"content" validate:"required,min=1,max=2000"`
+	IsAnonymous   *bool  `json:"is_anonymous" validate:"omitempty"`                                     // 是否匿名，不填默认匿名
+	Visibility    string `json:"visibility" validate:"omitempty,oneof=public private" default:"public"` // private 只有发布者和表白的对象可见
+	TargetID      *int   `json:"target_id" validate:"omitempty,min=1"`                                  // 表白的对象，不会向其他人公开
+	AttachmentIDs []int  `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"`                  // 上传的附件
 }
 
 func (w *WallCreateRequest) SetDefaults() {
@@ -360,11 +661,37 @@ func (w *WallCreateRequest) SetDefaults() {
 }
 
 type WallModifyRequest struct {
-	Visibility *string `json:"visibility" validate:"omitempty,oneof=public private"` // 管理员修改可见性
+	Content       *string `json:"content" validate:"omitempty,min=1,max=2000"`          // owner only，发布之前
+	IsAnonymous   *bool   `json:"is_anonymous"`                                         // owner only，发布之前
+	AttachmentIDs *[]int  `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // owner only，发布之前，替换附件，传空数组删除所有附件
+	Visibility    *string `json:"visibility" validate:"omitempty,oneof=public private"` // 发布者在发布之前修改，管理员可以随时修改
+	IsHidden      *bool   `json:"is_hidden"`                                            // admin only
 }
 
 func (w WallModifyRequest) IsEmpty() bool {
-	return w.Visibility == nil
+	return w.Content == nil && w.IsAnonymous == nil && w.AttachmentIDs == nil && w.Visibility == nil && w.IsHidden == nil
+}
+
+// IsOwnerOnly 是否修改了只有发布者可以修改的内容
+func (w WallModifyRequest) IsOwnerOnly() bool {
+	return w.Content != nil || w.IsAnonymous != nil || w.AttachmentIDs != nil
+}
+
+func (w *WallModifyRequest) Fields() []string {
+	var fields []string
+	if w.Content != nil {
+		fields = append(fields, "Content")
+	}
+	if w.IsAnonymous != nil {
+		fields = append(fields, "IsAnonymous")
+	}
+	if w.Visibility != nil {
+		fields = append(fields, "Visibility")
+	}
+	if w.IsHidden != nil {
+		fields = append(fields, "IsHidden")
+	}
+	return fields
 }
 
 func (w *WallModifyRequest) IsPublic() *bool {
@@ -410,19 +737,23 @@ func (d DivisionModifyRequest) IsEmpty() bool {
 /* Topic */
 
 type TopicCommonResponse struct {
-	ID          int                    `json:"id"`
-	CreatedAt   time.Time              `json:"created_at"`
-	UpdatedAt   time.Time              `json:"updated_at"`
-	Title       string                 `json:"title"`
-	Content     string                 `json:"content"`
-	IsHidden    bool                   `json:"is_hidden"`
-	IsAnonymous bool                   `json:"is_anonymous"`
-	Anonyname   *string                `json:"anonyname,omitempty" extensions:"x-nullable"`
-	PosterID    int                    `json:"poster_id,omitempty"`
-	Poster      *UserResponse          `json:"poster,omitempty"`
-	DivisionID  int                    `json:"division_id"`
-	Tags        []TagCommonResponse    `json:"tags"`
-	LastComment *CommentCommonResponse `json:"last_comment,omitempty" extensions:"x-nullable"` // 按照时间排序最后一条评论或者按照点赞数排序最高赞的评论，创建之后为空
+	ID           int                    `json:"id"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+	Title        string                 `json:"title"`
+	Content      string                 `json:"content"`
+	ContentHTML  string                 `json:"content_html"` // 渲染后的内容，提及和标签引用渲染为带有 data-user-id 和 data-tag-id 的 span
+	IsHidden     bool                   `json:"is_hidden"`
+	IsAnonymous  bool                   `json:"is_anonymous"`
+	Anonyname    *string                `json:"anonyname,omitempty" extensions:"x-nullable"`
+	PosterID     int                    `json:"poster_id,omitempty"`
+	Poster       *UserResponse          `json:"poster,omitempty"`
+	DivisionID   int                    `json:"division_id"`
+	Tags         []TagCommonResponse    `json:"tags"`
+	LastComment  *CommentCommonResponse `json:"last_comment,omitempty" extensions:"x-nullable"` // 按照时间排序最后一条评论或者按照点赞数排序最高赞的评论，创建之后为空
+	EditedAt     *time.Time             `json:"edited_at,omitempty" extensions:"x-nullable"`    // 最后一次修改标题或内容的时间
+	LinkPreviews []LinkPreviewResponse  `json:"link_previews,omitempty"`                        // 内容中链接的预览，抓取成功后返回
+	Attachments  []AttachmentResponse   `json:"attachments,omitempty"`                          // 附件，按照添加的顺序
 
 	// 统计数据
 	ViewCount    int `json:"view_count"`     // 浏览数
@@ -430,9 +761,13 @@ type TopicCommonResponse struct {
 	DislikeCount int `json:"dislike_count"`  // 点踩数
 	CommentCount int `json:"comment_count"`  // 评论数
 	FavorCount   int `json:"favorite_count"` // 收藏数
+	FloorCount   int `json:"floor_count"`    // 楼层数，包括已删除的楼层，用于跳转楼层
+
+	HotScore float64 `json:"hot_score"` // 热度
 
 	// 动态生成的字段
 	IsOwner  bool `json:"is_owner"`
+	IsEdited bool `json:"is_edited"` // 是否修改过标题或内容，可以查看修改历史
 	Liked    bool `json:"liked"`
 	Disliked bool `json:"disliked"`
 	Favored  bool `json:"favored"`
@@ -443,6 +778,21 @@ func (t *TopicCommonResponse) Postprocess(c *fiber.Ctx) (err error) {
 	if userID == t.PosterID {
 		t.IsOwner = true
 	}
+	t.IsEdited = t.EditedAt != nil
+
+	// load link previews
+	previewMap, err := loadLinkPreviews(c, ContentTypeTopic, []int{t.ID})
+	if err != nil {
+		return
+	}
+	t.LinkPreviews = previewMap[t.ID]
+
+	// load attachments
+	attachmentMap, err := loadAttachments(c, ContentTypeTopic, []int{t.ID})
+	if err != nil {
+		return
+	}
+	t.Attachments = attachmentMap[t.ID]
 
 	// load last comment
 	var comment Comment
@@ -493,21 +843,31 @@ func (t *TopicCommonResponse) Postprocess(c *fiber.Ctx) (err error) {
 		t.Favored = true
 	}
 
-	// clear user info
+	return nil
+}
+
+func (t *TopicCommonResponse) Redact() {
 	if t.IsAnonymous {
 		t.Poster = nil
 		t.PosterID = 0
+	} else {
+		t.Anonyname = nil
 	}
-
-	return nil
 }
 
 type TopicListRequest struct {
-	DivisionID     *int       `json:"division_id" query:"division_id" validate:"omitempty,min=1"`
-	OrderBy        string     `json:"order_by" query:"order_by" validate:"omitempty,oneof=created_at updated_at" default:"created_at"` // 排序方式，created_at 按照收藏的时间排序，updated_at 按照主题帖更新的时间排序
-	CommentOrderBy string     `json:"comment_order_by" query:"comment_order_by" validate:"omitempty,oneof=id like" default:"id"`
-	PageSize       int        `json:"page_size" query:"page_size" validate:"omitempty,min=1,max=100" default:"10"`
-	StartTime      *time.Time `json:"start_time" query:"start_time" validate:"omitempty"`
+	CursorRequest
+	DivisionID     *int   `json:"division_id" query:"division_id" validate:"omitempty,min=1"`
+	OrderBy        string `json:"order_by" query:"order_by" validate:"omitempty,oneof=created_at updated_at hot" default:"created_at"` // 排序方式，created_at 按照创建（收藏）的时间倒序，updated_at 按照主题帖更新的时间倒序，hot 按照热度倒序，仅 ListTopics 支持
+	CommentOrderBy string `json:"comment_order_by" query:"comment_order_by" validate:"omitempty,oneof=id like" default:"id"`
+}
+
+// OrderColumn 排序方式对应的列，只有 ListTopics 按照热度快照分页，其他列表直接按照热度排序
+func (q TopicListRequest) OrderColumn() string {
+	if q.OrderBy == "hot" {
+		return "hot_score"
+	}
+	return q.OrderBy
 }
 
 type TopicSearchRequest struct {
@@ -517,14 +877,17 @@ type TopicSearchRequest struct {
 
 type TopicListResponse struct {
 	Topics []TopicCommonResponse `json:"topics"`
+	CursorResponse
 }
 
 func (t *TopicListResponse) Postprocess(c *fiber.Ctx) (err error) {
 	userID := c.Locals("user_id").(int)
+	db := ReadDB(c)
 	for i := range t.Topics {
 		if userID == t.Topics[i].PosterID {
 			t.Topics[i].IsOwner = true
 		}
+		t.Topics[i].IsEdited = t.Topics[i].EditedAt != nil
 	}
 
 	// batch load last comment
@@ -533,11 +896,27 @@ func (t *TopicListResponse) Postprocess(c *fiber.Ctx) (err error) {
 	for _, topic := range t.Topics {
 		topicIDs = append(topicIDs, topic.ID)
 	}
-	err = DB.Raw(
-		`select * from comment
-			where topic_id in (?) and id in (
-				select max(id) from comment group by topic_id
-			)`, topicIDs).Scan(&comments).Error
+
+	// batch load link previews
+	previewMap, err := loadLinkPreviews(c, ContentTypeTopic, topicIDs)
+	if err != nil {
+		return
+	}
+	for i := range t.Topics {
+		t.Topics[i].LinkPreviews = previewMap[t.Topics[i].ID]
+	}
+
+	// batch load attachments
+	attachmentMap, err := loadAttachments(c, ContentTypeTopic, topicIDs)
+	if err != nil {
+		return
+	}
+	for i := range t.Topics {
+		t.Topics[i].Attachments = attachmentMap[t.Topics[i].ID]
+	}
+	err = db.Where("id IN (?)",
+		db.Model(&Comment{}).Select("max(id)").Where("topic_id IN ?", topicIDs).Group("topic_id"),
+	).Find(&comments).Error
 	if err != nil {
 		return err
 	}
@@ -562,7 +941,7 @@ func (t *TopicListResponse) Postprocess(c *fiber.Ctx) (err error) {
 
 	// batch load like
 	var likes []TopicUserLikes
-	err = DB.Where("topic_id in (?) AND user_id = ?", topicIDs, userID).Find(&likes).Error
+	err = db.Where("topic_id in (?) AND user_id = ?", topicIDs, userID).Find(&likes).Error
 	if err != nil {
 		return
 	}
@@ -583,7 +962,7 @@ func (t *TopicListResponse) Postprocess(c *fiber.Ctx) (err error) {
 
 	// batch load favorite
 	var favorites []TopicUserFavorites
-	err = DB.Where("topic_id in (?) AND user_id = ?", topicIDs, userID).Find(&favorites).Error
+	err = db.Where("topic_id in (?) AND user_id = ?", topicIDs, userID).Find(&favorites).Error
 	if err != nil {
 		return
 	}
@@ -597,23 +976,16 @@ func (t *TopicListResponse) Postprocess(c *fiber.Ctx) (err error) {
 		}
 	}
 
-	// clear user info
-	for i := range t.Topics {
-		if t.Topics[i].IsAnonymous {
-			t.Topics[i].Poster = nil
-			t.Topics[i].PosterID = 0
-		}
-	}
-
 	return
 }
 
 type TopicCreateRequest struct {
-	Title       string             `json:"title" validate:"required,min=1,max=50"`
-	Content     string             `json:"content" validate:"required,min=1,max=2000"`
-	DivisionID  int                `json:"division_id" validate:"required,min=1"`
-	IsAnonymous bool               `json:"is_anonymous"` // 默认不传为 false
-	Tags        []TagCreateRequest `json:"tags" validate:"required,min=1,max=10,dive"`
+	Title         string             `json:"title" validate:"required,min=1,max=50"`
+	Content       string             `json:"content" validate:"required,min=1,max=2000"`
+	DivisionID    int                `json:"division_id" validate:"required,min=1"`
+	IsAnonymous   bool               `json:"is_anonymous"` // 默认不传为 false
+	Tags          []TagCreateRequest `json:"tags" validate:"required,min=1,max=10,dive"`
+	AttachmentIDs []int              `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // 上传的附件
 }
 
 type TopicModifyRequest struct {
@@ -622,10 +994,12 @@ type TopicModifyRequest struct {
 	DivisionID *int               `json:"division_id" validate:"omitempty,min=1"`           // admin only
 	IsHidden   *bool              `json:"is_hidden"`                                        // admin only
 	Tags       []TagCreateRequest `json:"tags" validate:"omitempty,dive,min=1,max=10,dive"` // owner or admin
+
+	AttachmentIDs *[]int `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // owner only，替换附件，传空数组删除所有附件
 }
 
 func (t TopicModifyRequest) IsEmpty() bool {
-	return t.Title == nil && t.Content == nil && t.DivisionID == nil && t.IsHidden == nil && len(t.Tags) == 0
+	return t.Title == nil && t.Content == nil && t.DivisionID == nil && t.IsHidden == nil && len(t.Tags) == 0 && t.AttachmentIDs == nil
 }
 
 func (t *TopicModifyRequest) Fields() []string {
@@ -648,25 +1022,32 @@ func (t *TopicModifyRequest) Fields() []string {
 /* Comment */
 
 type CommentCommonResponse struct {
-	ID          int           `json:"id"`
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
-	Content     string        `json:"content"`
-	IsHidden    bool          `json:"is_hidden"`
-	TopicID     int           `json:"topic_id"`
-	ReplyToID   *int          `json:"reply_to_id,omitempty" extensions:"x-nullable"`
-	IsAnonymous bool          `json:"is_anonymous"`
-	Anonyname   *string       `json:"anonyname,omitempty" extensions:"x-nullable"`
-	PosterID    int           `json:"poster_id,omitempty"`
-	Poster      *UserResponse `json:"poster,omitempty"`
-	Ranking     int           `json:"ranking"`
+	ID           int                   `json:"id"`
+	CreatedAt    time.Time             `json:"created_at"`
+	UpdatedAt    time.Time             `json:"updated_at"`
+	Content      string                `json:"content"`
+	ContentHTML  string                `json:"content_html"` // 渲染后的内容
+	IsHidden     bool                  `json:"is_hidden"`
+	TopicID      int                   `json:"topic_id"`
+	ReplyToID    *int                  `json:"reply_to_id,omitempty" extensions:"x-nullable"`
+	IsAnonymous  bool                  `json:"is_anonymous"`
+	Anonyname    *string               `json:"anonyname,omitempty" extensions:"x-nullable"`
+	PosterID     int                   `json:"poster_id,omitempty"`
+	Poster       *UserResponse         `json:"poster,omitempty"`
+	Ranking      int                   `json:"ranking"`                                     // 楼层
+	IsDeleted    bool                  `json:"is_deleted"`                                  // 楼层已删除，只在按楼层查询时返回，内容和用户信息为空
+	EditedAt     *time.Time            `json:"edited_at,omitempty" extensions:"x-nullable"` // 最后一次修改内容的时间
+	LinkPreviews []LinkPreviewResponse `json:"link_previews,omitempty"`
+	Attachments  []AttachmentResponse  `json:"attachments,omitempty"`
 
 	// 统计数据
 	LikeCount    int `json:"like_count"`    // 点赞数
 	DislikeCount int `json:"dislike_count"` // 点踩数
+	ReplyCount   int `json:"reply_count"`   // 直接回复数
 
 	// 动态生成的字段
 	IsOwner  bool `json:"is_owner"`
+	IsEdited bool `json:"is_edited"` // 是否修改过内容，可以查看修改历史
 	Liked    bool `json:"liked"`
 	Disliked bool `json:"disliked"`
 }
@@ -677,6 +1058,21 @@ func (comment *CommentCommonResponse) Postprocess(c *fiber.Ctx) (err error) {
 	if comment.PosterID == userID {
 		comment.IsOwner = true
 	}
+	comment.IsEdited = comment.EditedAt != nil
+
+	// load link previews
+	previewMap, err := loadLinkPreviews(c, ContentTypeComment, []int{comment.ID})
+	if err != nil {
+		return
+	}
+	comment.LinkPreviews = previewMap[comment.ID]
+
+	// load attachments
+	attachmentMap, err := loadAttachments(c, ContentTypeComment, []int{comment.ID})
+	if err != nil {
+		return
+	}
+	comment.Attachments = attachmentMap[comment.ID]
 
 	// load poster
 	var poster User
@@ -708,23 +1104,45 @@ func (comment *CommentCommonResponse) Postprocess(c *fiber.Ctx) (err error) {
 		}
 	}
 
-	// clear user info
-	if comment.IsAnonymous {
+	return
+}
+
+// Redact 已删除的楼层同样清除用户信息
+func (comment *CommentCommonResponse) Redact() {
+	if comment.IsAnonymous || comment.IsDeleted {
 		comment.Poster = nil
 		comment.PosterID = 0
 	}
-	return
+	if !comment.IsAnonymous || comment.IsDeleted {
+		comment.Anonyname = nil
+	}
 }
 
 type CommentListRequest struct {
-	PageRequest
+	CursorRequest
 	TopicID int    `json:"topic_id" query:"topic_id" validate:"required,min=1"`
-	OrderBy string `json:"order_by" query:"order_by" validate:"omitempty,oneof=id like" default:"id"`
+	OrderBy string `json:"order_by" query:"order_by" validate:"omitempty,oneof=id like" default:"id"` // id 按照 id 升序，即楼层顺序，包含已删除的楼层；like 按照点赞数倒序
+	Floor   int    `json:"floor" query:"floor" validate:"omitempty,min=1"`                            // 跳转到楼层，返回该楼层附近的一页，只支持按照 id 排序，不能与 cursor 同时使用
+}
+
+func (c CommentListRequest) CursorOrder() CursorOrder {
+	return commentCursorOrder(c.OrderBy)
 }
 
 type CommentListByUserRequest struct {
-	PageRequest
-	OrderBy string `json:"order_by" query:"order_by" validate:"omitempty,oneof=id like" default:"id"`
+	CursorRequest
+	OrderBy string `json:"order_by" query:"order_by" validate:"omitempty,oneof=id like" default:"id"` // id 按照 id 升序，like 按照点赞数倒序
+}
+
+func (c CommentListByUserRequest) CursorOrder() CursorOrder {
+	return commentCursorOrder(c.OrderBy)
+}
+
+func commentCursorOrder(orderBy string) CursorOrder {
+	if orderBy == "like" {
+		return CursorOrder{Column: "like_count", Desc: true}
+	}
+	return CursorOrder{Column: "id"}
 }
 
 type CommentSearchRequest struct {
@@ -734,70 +1152,273 @@ type CommentSearchRequest struct {
 
 type CommentListResponse struct {
 	Comments []CommentCommonResponse `json:"comments"`
+	CursorResponse
 }
 
 func (comments *CommentListResponse) Postprocess(c *fiber.Ctx) (err error) {
-	if len(comments.Comments) == 0 {
+	commentPointers := make([]*CommentCommonResponse, len(comments.Comments))
+	for i := range comments.Comments {
+		commentPointers[i] = &comments.Comments[i]
+	}
+	return postprocessComments(c, commentPointers)
+}
+
+// postprocessComments 批量设置评论的动态字段，清除匿名评论的用户信息
+func postprocessComments(c *fiber.Ctx, comments []*CommentCommonResponse) (err error) {
+	if len(comments) == 0 {
 		return
 	}
 	userID := c.Locals("user_id").(int)
 
 	// set owner
-	for i := range comments.Comments {
-		if comments.Comments[i].PosterID == userID {
-			comments.Comments[i].IsOwner = true
+	for i := range comments {
+		if comments[i].PosterID == userID {
+			comments[i].IsOwner = true
 		}
+		comments[i].IsEdited = comments[i].EditedAt != nil
 	}
 
 	// batch load like
 	var likes []CommentUserLikes
-	commentIDs := make([]int, len(comments.Comments))
-	for i := range comments.Comments {
-		commentIDs[i] = comments.Comments[i].ID
+	commentIDs := make([]int, len(comments))
+	for i := range comments {
+		commentIDs[i] = comments[i].ID
 	}
-	err = DB.Where("comment_id in (?) AND user_id = ?", commentIDs, userID).Find(&likes).Error
+	err = ReadDB(c).Where("comment_id in (?) AND user_id = ?", commentIDs, userID).Find(&likes).Error
 	if err != nil {
 		return
 	}
-	for i := range comments.Comments {
+
+	// batch load link previews
+	previewMap, err := loadLinkPreviews(c, ContentTypeComment, commentIDs)
+	if err != nil {
+		return
+	}
+	for i := range comments {
+		comments[i].LinkPreviews = previewMap[comments[i].ID]
+	}
+
+	// batch load attachments
+	attachmentMap, err := loadAttachments(c, ContentTypeComment, commentIDs)
+	if err != nil {
+		return
+	}
+	for i := range comments {
+		comments[i].Attachments = attachmentMap[comments[i].ID]
+	}
+	for i := range comments {
 		for j := range likes {
-			if comments.Comments[i].ID == likes[j].CommentID {
+			if comments[i].ID == likes[j].CommentID {
 				switch likes[j].LikeData {
 				case 1:
-					comments.Comments[i].Liked = true
+					comments[i].Liked = true
 				case -1:
-					comments.Comments[i].Disliked = true
+					comments[i].Disliked = true
 				}
 				break
 			}
 		}
 	}
 
-	// clear user info
-	for i := range comments.Comments {
-		if comments.Comments[i].IsAnonymous {
-			comments.Comments[i].Poster = nil
-			comments.Comments[i].PosterID = 0
+	// clear deleted floors
+	for i := range comments {
+		if comments[i].IsDeleted {
+			comments[i].Content = ""
+			comments[i].ContentHTML = ""
+			comments[i].LinkPreviews = nil
+			comments[i].Attachments = nil
+			comments[i].IsOwner = false
 		}
 	}
 
 	return
 }
 
+// CommentTreeResponse 评论及其回复组成的评论树
+type CommentTreeResponse struct {
+	CommentCommonResponse
+	Replies     []CommentTreeResponse `json:"replies"`                // 按照 ID 升序的回复，超过层数限制时为空
+	ReplyCursor string                `json:"reply_cursor,omitempty"` // 还有更多回复时，使用该游标调用 /comment/{id}/_replies 加载
+}
+
+type CommentTreeRequest struct {
+	Depth         int `json:"depth" query:"depth" validate:"omitempty,min=1,max=5" default:"3"`                      // 加载回复的层数
+	ReplyPageSize int `json:"reply_page_size" query:"reply_page_size" validate:"omitempty,min=1,max=20" default:"3"` // 每个评论加载的回复数量
+}
+
+type CommentThreadRequest struct {
+	CommentListRequest
+	CommentTreeRequest
+}
+
+type CommentRepliesRequest struct {
+	CursorRequest
+	CommentTreeRequest
+}
+
+type CommentTreeListResponse struct {
+	Comments []CommentTreeResponse `json:"comments"`
+	CursorResponse
+}
+
+func (comments *CommentTreeListResponse) Postprocess(c *fiber.Ctx) (err error) {
+	var commentPointers []*CommentCommonResponse
+	var walk func(trees []CommentTreeResponse)
+	walk = func(trees []CommentTreeResponse) {
+		for i := range trees {
+			commentPointers = append(commentPointers, &trees[i].CommentCommonResponse)
+			walk(trees[i].Replies)
+		}
+	}
+	walk(comments.Comments)
+	return postprocessComments(c, commentPointers)
+}
+
 type CommentCreateRequest struct {
-	TopicID     int    `json:"topic_id" validate:"required,min=1"`
-	ReplyToID   *int   `json:"reply_to_id"`
-	Content     string `json:"content" validate:"required,min=1,max=2000"`
-	IsAnonymous bool   `json:"is_anonymous"` // 默认实名
+	TopicID       int    `json:"topic_id" validate:"required,min=1"`
+	ReplyToID     *int   `json:"reply_to_id"`
+	Content       string `json:"content" validate:"required,min=1,max=2000"`
+	IsAnonymous   bool   `json:"is_anonymous"`                                         // 默认实名
+	AttachmentIDs []int  `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // 上传的附件
 }
 
 type CommentModifyRequest struct {
-	Content  *string `json:"content" validate:"omitempty,min=1,max=2000"`
-	IsHidden *bool   `json:"is_hidden"` // admin only
+	Content       *string `json:"content" validate:"omitempty,min=1,max=2000"`
+	IsHidden      *bool   `json:"is_hidden"`                                            // admin only
+	AttachmentIDs *[]int  `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // owner only，替换附件，传空数组删除所有附件
 }
 
 func (c CommentModifyRequest) IsEmpty() bool {
-	return c.Content == nil && c.IsHidden == nil
+	return c.Content == nil && c.IsHidden == nil && c.AttachmentIDs == nil
+}
+
+/* LinkPreview 链接预览 */
+
+type LinkPreviewResponse struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
+// loadLinkPreviews 批量加载内容中已经抓取成功的链接预览
+func loadLinkPreviews(c *fiber.Ctx, contentType string, ids []int) (responseMap map[int][]LinkPreviewResponse, err error) {
+	previewMap, err := LoadLinkPreviews(ReadDB(c), contentType, ids)
+	if err != nil {
+		return
+	}
+	responseMap = make(map[int][]LinkPreviewResponse, len(previewMap))
+	for id, previews := range previewMap {
+		var responses []LinkPreviewResponse
+		if err = copier.Copy(&responses, &previews); err != nil {
+			return
+		}
+		responseMap[id] = responses
+	}
+	return
+}
+
+/* Attachment 附件 */
+
+type AttachmentResponse struct {
+	ID           int       `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"` // 图片较大时生成的缩略图
+	MimeType     string    `json:"mime_type"`
+	Size         int       `json:"size"`   // 字节数
+	Width        int       `json:"width"`  // 图片的宽度，其他文件为 0
+	Height       int       `json:"height"` // 图片的高度，其他文件为 0
+}
+
+func NewAttachmentResponse(attachment *Attachment) AttachmentResponse {
+	response := AttachmentResponse{
+		ID:        attachment.ID,
+		CreatedAt: attachment.CreatedAt,
+		URL:       FileStorage.URL(attachment.Key),
+		MimeType:  attachment.MimeType,
+		Size:      attachment.Size,
+		Width:     attachment.Width,
+		Height:    attachment.Height,
+	}
+	if attachment.ThumbnailKey != nil {
+		response.ThumbnailURL = FileStorage.URL(*attachment.ThumbnailKey)
+	}
+	return response
+}
+
+// loadAttachments 批量加载内容引用的附件
+func loadAttachments(c *fiber.Ctx, contentType string, ids []int) (responseMap map[int][]AttachmentResponse, err error) {
+	attachmentMap, err := LoadContentAttachments(ReadDB(c), contentType, ids)
+	if err != nil {
+		return
+	}
+	responseMap = make(map[int][]AttachmentResponse, len(attachmentMap))
+	for id, attachments := range attachmentMap {
+		responses := make([]AttachmentResponse, len(attachments))
+		for i := range attachments {
+			responses[i] = NewAttachmentResponse(&attachments[i])
+		}
+		responseMap[id] = responses
+	}
+	return
+}
+
+/* Mention 提及 */
+
+type MentionResponse struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	TargetType string    `json:"target_type"` // topic, comment, post, wall
+	TargetID   int       `json:"target_id"`
+}
+
+type MentionListResponse struct {
+	Mentions []MentionResponse `json:"mentions"` // 按照时间倒序排列
+	CursorResponse
+}
+
+/* Revision 修改历史 */
+
+type RevisionResponse struct {
+	ID          int        `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"` // 修改时间，原始版本为发布时间
+	Version     int        `json:"version"`    // 版本号，原始版本为 1
+	Title       *string    `json:"title,omitempty" extensions:"x-nullable"`
+	Content     string     `json:"content"`
+	TitleDiff   []DiffLine `json:"title_diff,omitempty"`   // 与上一个版本标题的差异
+	ContentDiff []DiffLine `json:"content_diff,omitempty"` // 与上一个版本内容的差异，原始版本为空
+}
+
+type RevisionListResponse struct {
+	Revisions []RevisionResponse `json:"revisions"` // 按照版本号升序排列，未修改过时为空
+}
+
+// NewRevisionListResponse 构造修改历史，计算每个版本与上一个版本的差异
+// 修改者可能是匿名的发布者，不返回修改者，管理员需要通过 /_deanonymize 查看并记录审计日志
+func NewRevisionListResponse(revisions []Revision) (response RevisionListResponse) {
+	response.Revisions = make([]RevisionResponse, len(revisions))
+	for i, revision := range revisions {
+		response.Revisions[i] = RevisionResponse{
+			ID:        revision.ID,
+			CreatedAt: revision.CreatedAt,
+			Version:   revision.Version,
+			Title:     revision.Title,
+			Content:   revision.Content,
+		}
+		if i == 0 {
+			continue
+		}
+		previous := revisions[i-1]
+		if previous.Title != nil && revision.Title != nil && *previous.Title != *revision.Title {
+			response.Revisions[i].TitleDiff = DiffLines(*previous.Title, *revision.Title)
+		}
+		if previous.Content != revision.Content {
+			response.Revisions[i].ContentDiff = DiffLines(previous.Content, revision.Content)
+		}
+	}
+	return
 }
 
 /* Tag */
@@ -806,6 +1427,7 @@ type TagCommonResponse struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
 	Temperature int    `json:"temperature"`
+	IsLocked    bool   `json:"is_locked"`
 }
 
 func (t TagCommonResponse) GetName() string {
@@ -813,15 +1435,27 @@ func (t TagCommonResponse) GetName() string {
 }
 
 type TagListRequest struct {
-	PageRequest
+	CursorRequest
+	PageNum int    `json:"page_num" query:"page_num" validate:"omitempty,min=1"` // 按页码分页，不填使用游标分页；搜索时只支持按页码分页，不填默认第一页
+	Version int    `json:"version" query:"version" validate:"omitempty,min=0"`   // 按页码分页时的分页版本号
 	OrderBy string `json:"order_by" query:"order_by" validate:"omitempty,oneof='id asc' 'temperature desc'" default:"id asc"`
 	Search  string `json:"search" query:"search" validate:"omitempty,min=1,max=20"` // 搜索标签名
 }
 
+// PageRequest 按页码分页的参数，页码不填默认第一页
+func (t TagListRequest) PageRequest() PageRequest {
+	request := PageRequest{PageNum: t.PageNum, PageSize: t.PageSize, Version: t.Version}
+	if request.PageNum == 0 {
+		request.PageNum = 1
+	}
+	return request
+}
+
 type TagListResponse struct {
 	Tags    []TagCommonResponse `json:"tags"`
-	Version int                 `json:"version"`
-	Total   int                 `json:"total"`
+	Version int                 `json:"version,omitempty"`
+	Total   int                 `json:"total,omitempty"` // 按页码分页时返回
+	CursorResponse
 }
 
 type TagCreateRequest struct {
@@ -847,10 +1481,25 @@ func ToTagNames[T Tagger](tags []T) (names []string) {
 type TagModifyRequest struct {
 	Name        *string `json:"name" validate:"omitempty,min=1,max=20"`
 	Temperature *int    `json:"temperature" validate:"omitempty,min=1,max=100"`
+	IsLocked    *bool   `json:"is_locked"` // 锁定的标签不能添加到帖子中
 }
 
 func (t TagModifyRequest) IsEmpty() bool {
-	return t.Name == nil && t.Temperature == nil
+	return t.Name == nil && t.Temperature == nil && t.IsLocked == nil
+}
+
+type TagMergeRequest struct {
+	To int `json:"to" validate:"required,min=1"` // 合并到的标签 ID
+}
+
+type TagAliasResponse struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"` // 规范化的别名
+	TagID int    `json:"tag_id"`
+}
+
+type TagAliasCreateRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=20"`
 }
 
 /* Chat */
@@ -876,8 +1525,13 @@ func (chat *ChatCommonResponse) Postprocess(c *fiber.Ctx) error {
 	return nil
 }
 
+type ChatListRequest struct {
+	CursorRequest
+}
+
 type ChatListResponse struct {
 	Chats []ChatCommonResponse `json:"chats"` // 返回时按照 UpdatedAt 降序排列
+	CursorResponse
 }
 
 func (chats *ChatListResponse) Postprocess(c *fiber.Ctx) error {
@@ -892,25 +1546,112 @@ func (chats *ChatListResponse) Postprocess(c *fiber.Ctx) error {
 /* Message */
 
 type MessageCommonResponse struct {
-	ID         int       `json:"id"`
-	CreatedAt  time.Time `json:"created_at"`
-	Content    string    `json:"content"`
-	FromUserID int       `json:"from_user_id"`
-	ToUserID   int       `json:"to_user_id"`
-	IsOwner    bool      `json:"is_me"`
+	ID          int                  `json:"id"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Content     string               `json:"content"`
+	FromUserID  int                  `json:"from_user_id"`
+	ToUserID    int                  `json:"to_user_id"`
+	IsOwner     bool                 `json:"is_me"`
+	Attachments []AttachmentResponse `json:"attachments,omitempty"`
+}
+
+func (m *MessageCommonResponse) Postprocess(c *fiber.Ctx) (err error) {
+	attachmentMap, err := loadAttachments(c, ContentTypeMessage, []int{m.ID})
+	if err != nil {
+		return
+	}
+	m.Attachments = attachmentMap[m.ID]
+	return
 }
 
 type MessageCreateRequest struct {
-	Content  string `json:"content" validate:"required,min=1,max=2000"`
-	ToUserID int    `json:"to_user_id" validate:"required,min=1"`
+	Content       string `json:"content" validate:"required,min=1,max=2000"`
+	ToUserID      int    `json:"to_user_id" validate:"required,min=1"`
+	AttachmentIDs []int  `json:"attachment_ids" validate:"omitempty,max=9,dive,min=1"` // 上传的附件
 }
 
 type MessageListRequest struct {
-	PageSize  int        `json:"page_size" query:"page_size" validate:"omitempty,min=1,max=100" default:"10"`
-	ToUserID  int        `json:"to_user_id" query:"to_user_id" validate:"required,min=1"`
-	StartTime *time.Time `json:"start_time" query:"start_time" validate:"omitempty"` // 不填默认为当前时间
+	CursorRequest
+	ToUserID int `json:"to_user_id" query:"to_user_id" validate:"required,min=1"`
 }
 
 type MessageListResponse struct {
 	Messages []MessageCommonResponse `json:"messages"` // 按照 CreatedAt 倒序排列
+	CursorResponse
+}
+
+func (m *MessageListResponse) Postprocess(c *fiber.Ctx) (err error) {
+	messageIDs := make([]int, len(m.Messages))
+	for i := range m.Messages {
+		messageIDs[i] = m.Messages[i].ID
+	}
+	attachmentMap, err := loadAttachments(c, ContentTypeMessage, messageIDs)
+	if err != nil {
+		return
+	}
+	for i := range m.Messages {
+		m.Messages[i].Attachments = attachmentMap[m.Messages[i].ID]
+	}
+	return
+}
+
+/* Admin */
+
+type WallPendingListResponse struct {
+	Posts     []WallCommonResponse `json:"posts"`
+	Date      time.Time            `json:"date" swaggertype:"string"`       // 下一次发布的日期
+	WindowEnd time.Time            `json:"window_end" swaggertype:"string"` // 下一次发布的截止时间
+	CursorResponse
+}
+
+type WallDigestResponse struct {
+	ID          int       `json:"id"`
+	Date        string    `json:"date"`
+	PublishedAt time.Time `json:"published_at"`
+	WindowEnd   time.Time `json:"window_end"`
+	WallCount   int       `json:"wall_count"`
+}
+
+type WallPublishResponse struct {
+	Digests []WallDigestResponse `json:"digests"` // 本次生成的表白墙
+}
+
+type CounterReconcileRequest struct {
+	DryRun bool `json:"dry_run" query:"dry_run"` // 只报告偏差，不修正
+}
+
+type DeanonymizeRequest struct {
+	TargetType string `json:"target_type" validate:"required,oneof=topic comment post channel wall wall_comment"`
+	TargetID   int    `json:"target_id" validate:"required,min=1"`
+	Reason     string `json:"reason" validate:"required,min=1,max=200"` // 查看的理由，记录在审计日志中
+}
+
+type DeanonymizeResponse struct {
+	LogID      int           `json:"log_id"` // 本次查看的审计日志
+	TargetType string        `json:"target_type"`
+	TargetID   int           `json:"target_id"`
+	PosterID   int           `json:"poster_id"` // 未登录用户的提问为 0
+	Poster     *UserResponse `json:"poster"`    // 未登录用户的提问或者用户已删除时为 null
+}
+
+type DeanonymizeLogListRequest struct {
+	CursorRequest
+	TargetType string `json:"target_type" query:"target_type" validate:"omitempty,oneof=topic comment post channel wall wall_comment"`
+	TargetID   int    `json:"target_id" query:"target_id" validate:"omitempty,min=1"` // 需要同时指定 target_type
+	AdminID    int    `json:"admin_id" query:"admin_id" validate:"omitempty,min=1"`
+}
+
+type DeanonymizeLogResponse struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	AdminID    int       `json:"admin_id"`
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	PosterID   int       `json:"poster_id"`
+	Reason     string    `json:"reason"`
+}
+
+type DeanonymizeLogListResponse struct {
+	Logs []DeanonymizeLogResponse `json:"logs"` // 按照时间倒序排列
+	CursorResponse
 }
@@ -0,0 +1,374 @@
+package apis
+
+import (
+	"bytes"
+	"chatdan_backend/config"
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"html/template"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 分享页面和分享卡片不需要登录，网关需要放行 /share 和 /api/share 开头的路由
+
+const (
+	sharePagePostCount      = 20  // 分享页面展示的问答数量
+	shareSummaryMaxLength   = 100 // OpenGraph 描述的最大长度
+	shareCardCacheMaxAge    = 600 // 秒，同时是服务端缓存卡片的时间
+	sharePageCacheMaxAge    = 60
+	shareCardDefaultSubject = "向我提问吧"
+)
+
+// GetASharedBox godoc
+// @Summary 通过分享链接查看提问箱
+// @Description 不需要登录，只返回公开的已回答的提问和提问箱主人的回复，不返回提问者的信息
+// @Tags Share Module
+// @Produce json
+// @Router /share/box/{slug} [get]
+// @Param slug path string true "share slug"
+// @Param query query ShareBoxRequest false "page"
+// @Success 200 {object} RespForSwagger{data=ShareBoxResponse}
+// @Failure 404 {object} RespForSwagger
+func GetASharedBox(c *fiber.Ctx) (err error) {
+	var query ShareBoxRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	var box Box
+	if err = DB.Where("share_slug = ?", c.Params("slug")).Take(&box).Error; err != nil {
+		return
+	}
+
+	var (
+		posts    []Post
+		response ShareBoxResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		sharedPosts(box.ID), &posts, query.CursorRequest, CursorOrder{Column: "id", Desc: true},
+	); err != nil {
+		return
+	}
+	if response.Posts, err = newSharePostResponses(&box, posts); err != nil {
+		return
+	}
+	response.ShareSlug = box.ShareSlug
+	response.MessageBoxID = box.ID
+	response.Title = box.Title
+	response.Description = box.Description
+	response.QuestionTemplate = box.QuestionTemplate
+	response.AnonymousPolicy = box.AnonymousPolicy
+	response.AllowGuest = box.AllowGuest
+	response.IsOpen = box.IsOpen(time.Now())
+
+	return Success(c, &response)
+}
+
+// GetASharedPost godoc
+// @Summary 通过分享链接查看提问
+// @Description 不需要登录，只有公开的提问或者公开了回答的提问可以查看，提问不公开时不返回提问的内容
+// @Tags Share Module
+// @Produce json
+// @Router /share/post/{slug} [get]
+// @Param slug path string true "share slug"
+// @Success 200 {object} RespForSwagger{data=SharePostResponse}
+// @Failure 404 {object} RespForSwagger
+func GetASharedPost(c *fiber.Ctx) (err error) {
+	post, err := loadSharedPost(c.Params("slug"))
+	if err != nil {
+		return
+	}
+
+	responses, err := newSharePostResponses(post.Box, []Post{*post})
+	if err != nil {
+		return
+	}
+
+	return Success(c, &responses[0])
+}
+
+// RenderSharedBoxPage 提问箱的分享页面，包含 OpenGraph 信息和公开的问答
+func RenderSharedBoxPage(c *fiber.Ctx) (err error) {
+	var box Box
+	if err = DB.Where("share_slug = ?", c.Params("slug")).Take(&box).Error; err != nil {
+		return
+	}
+
+	var posts []Post
+	if err = sharedPosts(box.ID).Order("id DESC").Limit(sharePagePostCount).Find(&posts).Error; err != nil {
+		return
+	}
+	responses, err := newSharePostResponses(&box, posts)
+	if err != nil {
+		return
+	}
+
+	baseURL := shareBaseURL(c)
+	description := box.Description
+	if description == "" {
+		description = shareCardDefaultSubject
+	}
+	return renderSharePage(c, sharePage{
+		SiteName:    config.Config.AppName,
+		Title:       box.Title,
+		Description: shareSummary(description),
+		URL:         fmt.Sprintf("%s/share/box/%s", baseURL, box.ShareSlug),
+		Image:       fmt.Sprintf("%s/share/box/%s/card.png", baseURL, box.ShareSlug),
+		Posts:       responses,
+	})
+}
+
+// RenderSharedPostPage 提问的分享页面，包含 OpenGraph 信息和提问箱主人的回复
+func RenderSharedPostPage(c *fiber.Ctx) (err error) {
+	post, err := loadSharedPost(c.Params("slug"))
+	if err != nil {
+		return
+	}
+	responses, err := newSharePostResponses(post.Box, []Post{*post})
+	if err != nil {
+		return
+	}
+
+	baseURL := shareBaseURL(c)
+	return renderSharePage(c, sharePage{
+		SiteName:    config.Config.AppName,
+		Title:       post.Box.Title,
+		Description: shareSummary(highlightText(&responses[0])),
+		URL:         fmt.Sprintf("%s/share/post/%s", baseURL, post.ShareSlug),
+		Image:       fmt.Sprintf("%s/share/post/%s/card.png", baseURL, post.ShareSlug),
+		BoxURL:      fmt.Sprintf("%s/share/box/%s", baseURL, post.Box.ShareSlug),
+		Posts:       responses,
+	})
+}
+
+// RenderSharedBoxCard 提问箱的分享卡片，精选置顶的或者最新的已回答的提问
+func RenderSharedBoxCard(c *fiber.Ctx) (err error) {
+	var box Box
+	if err = DB.Where("share_slug = ?", c.Params("slug")).Take(&box).Error; err != nil {
+		return
+	}
+
+	card := ShareCard{Title: box.Title, Description: box.Description, Footer: config.Config.AppName}
+	if card.Description == "" {
+		card.Description = shareCardDefaultSubject
+	}
+
+	var post Post
+	err = sharedPosts(box.ID).Order("pinned_at IS NULL").Order("pinned_at DESC").Order("id DESC").Take(&post).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err == nil {
+		responses, err := newSharePostResponses(&box, []Post{post})
+		if err != nil {
+			return err
+		}
+		setShareCardHighlight(&card, &responses[0])
+	}
+
+	return sendShareCard(c, card)
+}
+
+// RenderSharedPostCard 提问的分享卡片
+func RenderSharedPostCard(c *fiber.Ctx) (err error) {
+	post, err := loadSharedPost(c.Params("slug"))
+	if err != nil {
+		return
+	}
+	responses, err := newSharePostResponses(post.Box, []Post{*post})
+	if err != nil {
+		return
+	}
+
+	card := ShareCard{Title: post.Box.Title, Footer: config.Config.AppName}
+	setShareCardHighlight(&card, &responses[0])
+
+	return sendShareCard(c, card)
+}
+
+// sharedPosts 分享页面展示的提问：公开的或者公开了回答的已回答的提问
+func sharedPosts(boxID int) *gorm.DB {
+	return DB.Where("box_id = ? AND answer_status = ? AND (is_public = ? OR is_answer_public = ?)",
+		boxID, AnswerAnswered, true, true)
+}
+
+// loadSharedPost 通过分享链接加载提问，不能分享的提问视为不存在
+func loadSharedPost(slug string) (post *Post, err error) {
+	post = &Post{}
+	if err = DB.Preload("Box").Where("share_slug = ?", slug).Take(post).Error; err != nil {
+		return nil, err
+	}
+	if post.Box == nil || !post.IsShareable() {
+		return nil, NotFound()
+	}
+	return post, nil
+}
+
+// newSharePostResponses 构造分享的提问，只包含提问箱主人的回复，提问不公开时不包含提问的内容
+func newSharePostResponses(box *Box, posts []Post) (responses []SharePostResponse, err error) {
+	postIDs := make([]int, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+	var channels []Channel
+	if len(postIDs) > 0 {
+		if err = DB.Where("post_id IN ? AND owner_id = ?", postIDs, box.OwnerID).Order("id").Find(&channels).Error; err != nil {
+			return
+		}
+	}
+	answerMap := make(map[int][]string, len(posts))
+	for _, channel := range channels {
+		answerMap[channel.PostID] = append(answerMap[channel.PostID], channel.Content)
+	}
+
+	responses = make([]SharePostResponse, 0, len(posts))
+	for _, post := range posts {
+		response := SharePostResponse{
+			ShareSlug:        post.ShareSlug,
+			CreatedAt:        post.CreatedAt,
+			IsQuestionHidden: !post.IsPublic,
+			AnswerStatus:     post.AnswerStatus,
+			AnsweredAt:       post.AnsweredAt,
+			IsPinned:         post.PinnedAt != nil,
+			Answers:          answerMap[post.ID],
+			BoxShareSlug:     box.ShareSlug,
+			BoxTitle:         box.Title,
+		}
+		if post.IsPublic {
+			response.Content = post.Content
+			response.ContentHTML = post.ContentHTML
+		}
+		if response.Answers == nil {
+			response.Answers = []string{}
+		}
+		responses = append(responses, response)
+	}
+	return
+}
+
+func setShareCardHighlight(card *ShareCard, post *SharePostResponse) {
+	card.Question = post.Content
+	if len(post.Answers) > 0 {
+		card.Answer = post.Answers[0]
+	}
+}
+
+// highlightText 分享提问时的描述，提问不公开时使用回复
+func highlightText(post *SharePostResponse) string {
+	if post.Content != "" {
+		return post.Content
+	}
+	if len(post.Answers) > 0 {
+		return post.Answers[0]
+	}
+	return shareCardDefaultSubject
+}
+
+func shareSummary(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= shareSummaryMaxLength {
+		return s
+	}
+	return string([]rune(s)[:shareSummaryMaxLength]) + "…"
+}
+
+// shareBaseURL 分享链接的前缀，配置了 PUBLIC_URL 时使用配置，否则使用请求的地址
+func shareBaseURL(c *fiber.Ctx) string {
+	if config.Config.PublicUrl != "" {
+		return strings.TrimSuffix(config.Config.PublicUrl, "/")
+	}
+	return c.BaseURL()
+}
+
+// sendShareCard 绘制卡片的开销较大，按照卡片的内容缓存，内容修改后自然失效
+func sendShareCard(c *fiber.Ctx, card ShareCard) (err error) {
+	key := shareCardCacheKey(card)
+	var data []byte
+	if err = Get(key, &data); err != nil {
+		if data, err = RenderShareCard(card); err != nil {
+			return err
+		}
+		if err = Set(key, data, shareCardCacheMaxAge*time.Second); err != nil {
+			return err
+		}
+	}
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", shareCardCacheMaxAge))
+	c.Type("png")
+	return c.Send(data)
+}
+
+func shareCardCacheKey(card ShareCard) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{card.Title, card.Description, card.Question, card.Answer, card.Footer}, "\x00")))
+	return "share_card:" + hex.EncodeToString(hash[:])
+}
+
+type sharePage struct {
+	SiteName    string
+	Title       string
+	Description string
+	URL         string
+	Image       string
+	BoxURL      string // 提问的分享页面链接到提问箱的分享页面
+	Posts       []SharePostResponse
+}
+
+var sharePageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - {{.SiteName}}</title>
+<meta name="description" content="{{.Description}}">
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="{{.SiteName}}">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:image" content="{{.Image}}">
+<meta property="og:image:width" content="1200">
+<meta property="og:image:height" content="630">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<meta name="twitter:image" content="{{.Image}}">
+<link rel="canonical" href="{{.URL}}">
+<style>
+body{margin:0;background:#f4f2fb;color:#1f1f2e;font-family:-apple-system,"PingFang SC","Noto Sans CJK SC",sans-serif}
+main{max-width:720px;margin:0 auto;padding:32px 16px}
+article{background:#fff;border-radius:12px;padding:16px 20px;margin:16px 0}
+.question{font-weight:600;white-space:pre-wrap}
+.hidden{color:#6b6b80}
+.answer{white-space:pre-wrap;border-left:3px solid #6d4ae0;padding-left:12px}
+</style>
+</head>
+<body>
+<main>
+<h1>{{if .BoxURL}}<a href="{{.BoxURL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h1>
+{{if not .BoxURL}}<p>{{.Description}}</p>{{end}}
+{{range .Posts}}<article>
+{{if .IsQuestionHidden}}<p class="hidden">提问未公开</p>{{else}}<p class="question">{{.Content}}</p>{{end}}
+{{range .Answers}}<p class="answer">{{.}}</p>
+{{end}}</article>
+{{else}}<p class="hidden">还没有公开的回答</p>
+{{end}}</main>
+</body>
+</html>
+`))
+
+func renderSharePage(c *fiber.Ctx, page sharePage) error {
+	var buf bytes.Buffer
+	if err := sharePageTemplate.Execute(&buf, page); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", sharePageCacheMaxAge))
+	c.Type("html", "utf-8")
+	return c.Send(buf.Bytes())
+}
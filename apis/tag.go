@@ -3,10 +3,13 @@ package apis
 import (
 	. "chatdan_backend/models"
 	. "chatdan_backend/utils"
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jinzhu/copier"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"strings"
+	"time"
 )
 
 // ListTags godoc
@@ -37,26 +40,32 @@ func ListTags(c *fiber.Ctx) (err error) {
 		total   int
 		key     = "tags"
 	)
+	var response TagListResponse
 	if query.Search != "" {
 		// using meilisearch
 		var filter string
 		if total, err = Search(
 			DB, &tags, query.Search,
-			filter, []string{query.OrderBy}, "name", query.PageRequest,
+			filter, []string{query.OrderBy}, "name", query.PageRequest(),
 		); err != nil {
 			return
 		}
-	} else {
-		// load from database
+	} else if query.PageNum != 0 {
+		// load from database, paginated by page number
 		tx := DB.Session(&gorm.Session{NewDB: true}).Model(&Tag{}).Order(query.OrderBy)
 		key = key + ":" + strings.Replace(query.OrderBy, " ", "_", -1)
-		if version, total, err = PageLoad(tx, &tags, key, query.PageRequest); err != nil {
+		if version, total, err = PageLoad(tx, &tags, key, query.PageRequest()); err != nil {
+			return
+		}
+	} else {
+		// load from database, paginated by cursor
+		tx := DB.Session(&gorm.Session{NewDB: true}).Model(&Tag{})
+		if response.CursorResponse, err = CursorLoad(tx, &tags, query.CursorRequest, NewCursorOrder(query.OrderBy)); err != nil {
 			return
 		}
 	}
 
 	// copy to response
-	var response TagListResponse
 	err = copier.Copy(&response.Tags, &tags)
 	if err != nil {
 		return
@@ -125,15 +134,35 @@ func CreateATag(c *fiber.Ctx) (err error) {
 		return
 	}
 
-	var tag = Tag{Name: request.Name}
-
-	err = CreateModel(DB, &tag)
-	if err != nil {
-		return
-	}
+	// 已有同名标签或别名时返回已有的标签
+	var tag Tag
+	if existing, err := FindTagByName(DB, request.Name); err == nil {
+		tag = *existing
+	} else if errors.Is(err, gorm.ErrRecordNotFound) {
+		tag.Name = strings.TrimSpace(request.Name)
+		err = DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag).Error
+		if err != nil {
+			return err
+		}
 
-	err = SearchAddOrReplace(tag.ToSearchModel())
-	if err != nil {
+		// 并发创建同名标签时没有插入，返回其他请求创建的标签
+		if tag.ID == 0 {
+			err = DB.Where("normalized_name = ?", tag.NormalizedName).First(&tag).Error
+			if err != nil {
+				return err
+			}
+		} else {
+			err = Set(CacheName(&tag), &tag, 10*time.Minute)
+			if err != nil {
+				return err
+			}
+
+			err = SearchAddOrReplace(tag.ToSearchModel())
+			if err != nil {
+				return err
+			}
+		}
+	} else {
 		return err
 	}
 
@@ -184,11 +213,36 @@ func ModifyATag(c *fiber.Ctx) (err error) {
 		return
 	}
 
-	err = UpdateModel(DB, &tag, request)
+	columns := Map{}
+	if request.Name != nil {
+		// 改名后不能与其他标签或别名重复，重复的标签应当合并
+		if existing, err := FindTagByName(DB, *request.Name); err == nil && existing.ID != tag.ID {
+			return BadRequest("标签名与已有的标签或别名重复，请合并标签")
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		columns["name"] = strings.TrimSpace(*request.Name)
+		columns["normalized_name"] = NormalizeTagName(*request.Name)
+	}
+	if request.Temperature != nil {
+		columns["temperature"] = *request.Temperature
+	}
+	if request.IsLocked != nil {
+		columns["is_locked"] = *request.IsLocked
+	}
+
+	err = UpdateModel(DB, &tag, columns)
 	if err != nil {
 		return
 	}
 
+	if request.Name != nil {
+		err = SearchAddOrReplace(tag.ToSearchModel())
+		if err != nil {
+			return err
+		}
+	}
+
 	var response TagCommonResponse
 	err = copier.Copy(&response, &tag)
 	if err != nil {
@@ -241,3 +295,308 @@ func DeleteATag(c *fiber.Ctx) (err error) {
 
 	return Success(c, &EmptyStruct{})
 }
+
+// SubscribeATag godoc
+// @Summary 订阅标签，订阅标签下的帖子会出现在时间线中
+// @Tags Tag Module
+// @Produce json
+// @Router /tag/{id}/_subscribe [post]
+// @Param id path int true "tag id"
+// @Success 200 {object} RespForSwagger{data=EmptyStruct}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func SubscribeATag(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return
+	}
+
+	tagID, err := c.ParamsInt("id")
+	if err != nil {
+		return
+	}
+
+	var tag = Tag{ID: tagID}
+	err = LoadModel(DB, &tag)
+	if err != nil {
+		return
+	}
+
+	var subscription = TagUserSubscriptions{
+		UserID:    user.ID,
+		TagID:     tag.ID,
+		CreatedAt: time.Now(),
+	}
+	result := DB.FirstOrCreate(&subscription)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return BadRequest("已经订阅过该标签")
+	}
+	InvalidateTimeline(user.ID)
+
+	return Success(c, &EmptyStruct{})
+}
+
+// UnsubscribeATag godoc
+// @Summary 取消订阅标签
+// @Tags Tag Module
+// @Produce json
+// @Router /tag/{id}/_subscribe [delete]
+// @Param id path int true "tag id"
+// @Success 200 {object} RespForSwagger{data=EmptyStruct}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func UnsubscribeATag(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return
+	}
+
+	tagID, err := c.ParamsInt("id")
+	if err != nil {
+		return
+	}
+
+	result := DB.Delete(&TagUserSubscriptions{UserID: user.ID, TagID: tagID})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return BadRequest("未订阅该标签")
+	}
+	InvalidateTimeline(user.ID)
+
+	return Success(c, &EmptyStruct{})
+}
+
+// ListSubscribedTags godoc
+// @Summary 查询当前用户订阅的标签，按照订阅时间倒序
+// @Tags Tag Module
+// @Produce json
+// @Router /tags/_subscribed [get]
+// @Param json query CursorRequest true "page"
+// @Success 200 {object} RespForSwagger{data=TagListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListSubscribedTags(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return
+	}
+
+	var query CursorRequest
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return
+	}
+
+	var (
+		tags     []SubscribedTag
+		response TagListResponse
+	)
+	tx := ReadDB(c).Table("tag").
+		Select("tag.*, tag_user_subscriptions.created_at AS subscribed_at").
+		Joins("JOIN tag_user_subscriptions ON tag_user_subscriptions.tag_id = tag.id").
+		Where("tag_user_subscriptions.user_id = ? AND tag.deleted_at IS NULL", user.ID)
+	if response.CursorResponse, err = CursorLoad(tx, &tags, query, SubscribedTagCursorOrder); err != nil {
+		return
+	}
+
+	err = copier.Copy(&response.Tags, &tags)
+	if err != nil {
+		return
+	}
+	return Success(c, &response)
+}
+
+// MergeATag godoc
+// @Summary 将标签合并到另一个标签，仅管理员可操作
+// @Description 帖子和订阅转移到目标标签，原标签名成为目标标签的别名，然后删除原标签
+// @Tags Tag Module
+// @Accept json
+// @Produce json
+// @Router /tag/{id}/_merge [post]
+// @Param id path int true "tag id"
+// @Param json body TagMergeRequest true "merge"
+// @Success 200 {object} RespForSwagger{data=TagCommonResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func MergeATag(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return
+	}
+
+	if !user.IsAdmin {
+		return Forbidden("非管理员无法合并标签")
+	}
+
+	tagID, err := c.ParamsInt("id")
+	if err != nil {
+		return
+	}
+
+	var request TagMergeRequest
+	err = ValidateBody(c, &request)
+	if err != nil {
+		return
+	}
+
+	var tag Tag
+	err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		tag, err = MergeTags(tx, tagID, request.To)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	// 更新缓存和搜索引擎
+	DeleteInBatch(CacheNameFromTableName("tag", tagID), CacheNameFromTableName("tag", tag.ID))
+	err = SearchDelete[TagSearchModel](tagID)
+	if err != nil {
+		return
+	}
+	err = SearchAddOrReplace(tag.ToSearchModel())
+	if err != nil {
+		return
+	}
+
+	var response TagCommonResponse
+	err = copier.Copy(&response, &tag)
+	if err != nil {
+		return
+	}
+	return Success(c, &response)
+}
+
+// ListTagAliases godoc
+// @Summary 查询标签的别名
+// @Tags Tag Module
+// @Produce json
+// @Router /tag/{id}/_alias [get]
+// @Param id path int true "tag id"
+// @Success 200 {object} RespForSwagger{data=[]TagAliasResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListTagAliases(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return
+	}
+
+	tagID, err := c.ParamsInt("id")
+	if err != nil {
+		return
+	}
+
+	var aliases []TagAlias
+	err = DB.Where("tag_id = ?", tagID).Order("id").Find(&aliases).Error
+	if err != nil {
+		return
+	}
+
+	var response = make([]TagAliasResponse, 0, len(aliases))
+	err = copier.Copy(&response, &aliases)
+	if err != nil {
+		return
+	}
+	return Success(c, &response)
+}
+
+// CreateATagAlias godoc
+// @Summary 为标签添加别名，仅管理员可操作，创建帖子时使用别名会解析为该标签
+// @Tags Tag Module
+// @Accept json
+// @Produce json
+// @Router /tag/{id}/_alias [post]
+// @Param id path int true "tag id"
+// @Param json body TagAliasCreateRequest true "alias"
+// @Success 201 {object} RespForSwagger{data=TagAliasResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func CreateATagAlias(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return
+	}
+
+	if !user.IsAdmin {
+		return Forbidden("非管理员无法添加别名")
+	}
+
+	tagID, err := c.ParamsInt("id")
+	if err != nil {
+		return
+	}
+
+	var request TagAliasCreateRequest
+	err = ValidateBody(c, &request)
+	if err != nil {
+		return
+	}
+
+	var tag = Tag{ID: tagID}
+	err = LoadModel(DB, &tag)
+	if err != nil {
+		return
+	}
+
+	alias, err := CreateTagAlias(DB, tag.ID, request.Name)
+	if err != nil {
+		return
+	}
+
+	var response TagAliasResponse
+	err = copier.Copy(&response, &alias)
+	if err != nil {
+		return
+	}
+	return Created(c, &response)
+}
+
+// DeleteATagAlias godoc
+// @Summary 删除标签的别名，仅管理员可操作
+// @Tags Tag Module
+// @Produce json
+// @Router /tag/{id}/_alias/{alias_id} [delete]
+// @Param id path int true "tag id"
+// @Param alias_id path int true "alias id"
+// @Success 200 {object} RespForSwagger{data=EmptyStruct}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func DeleteATagAlias(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return
+	}
+
+	if !user.IsAdmin {
+		return Forbidden("非管理员无法删除别名")
+	}
+
+	tagID, err := c.ParamsInt("id")
+	if err != nil {
+		return
+	}
+	aliasID, err := c.ParamsInt("alias_id")
+	if err != nil {
+		return
+	}
+
+	result := DB.Where("id = ? AND tag_id = ?", aliasID, tagID).Delete(&TagAlias{})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return NotFound("别名不存在")
+	}
+
+	return Success(c, &EmptyStruct{})
+}
@@ -3,6 +3,8 @@ package apis
 import (
 	. "chatdan_backend/models"
 	. "chatdan_backend/utils"
+	"fmt"
+	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jinzhu/copier"
 	"gorm.io/gorm"
@@ -11,7 +13,7 @@ import (
 )
 
 // ListTopics godoc
-// @Summary 查询话题，按照最近创建或最近回复排序
+// @Summary 查询话题，按照最近创建、最近回复或热度排序
 // @Tags Topic Module
 // @Produce json
 // @Router /topics [get]
@@ -30,29 +32,103 @@ func ListTopics(c *fiber.Ctx) (err error) {
 	if err != nil {
 		return err
 	}
-	nowTime := time.Now()
-	if query.StartTime == nil {
-		query.StartTime = &nowTime
-	}
 
-	var topics []Topic
-	querySet := DB.Order(query.OrderBy+" desc").Limit(query.PageSize).
-		Where("? < ?", clause.Column{Name: query.OrderBy}, query.StartTime)
-	if query.DivisionID != nil {
-		querySet = querySet.Where("division_id = ?", *query.DivisionID)
-	}
-	result := querySet.Preload("Tags").Preload("Poster").Find(&topics)
-	if result.Error != nil {
-		return result.Error
+	var (
+		topics   []Topic
+		response TopicListResponse
+	)
+	if query.OrderBy == "hot" {
+		response.CursorResponse, err = loadHotTopics(ReadDB(c), &topics, query.DivisionID, query.CursorRequest)
+		if err != nil {
+			return err
+		}
+	} else {
+		querySet := ReadDB(c).Preload("Tags").Preload("Poster")
+		if query.DivisionID != nil {
+			querySet = querySet.Where("division_id = ?", *query.DivisionID)
+		}
+		response.CursorResponse, err = CursorLoad(querySet, &topics, query.CursorRequest, CursorOrder{Column: query.OrderBy, Desc: true})
+		if err != nil {
+			return err
+		}
 	}
 
-	var response TopicListResponse
 	if err = copier.CopyWithOption(&response.Topics, &topics, CopyOption); err != nil {
 		return err
 	}
 	return Success(c, &response)
 }
 
+// loadHotTopics 按照热度分页查询话题
+// 热度随互动实时变化，直接按热度翻页会导致重复或遗漏，因此从热度排名的快照中分页，
+// 游标记录快照版本号和偏移量，同一快照内翻页结果稳定
+func loadHotTopics(db *gorm.DB, topics *[]Topic, divisionID *int, request CursorRequest) (response CursorResponse, err error) {
+	var (
+		version, offset int
+		backward        bool
+	)
+	if request.PageSize == 0 {
+		request.PageSize = 10
+	}
+	if request.Cursor != "" {
+		var cursor *Cursor
+		if cursor, err = ParseCursor(request.Cursor); err != nil {
+			return
+		}
+		if err = json.Unmarshal(cursor.Key, &version); err != nil || cursor.ID < 0 {
+			return response, BadRequest("invalid cursor")
+		}
+		offset, backward = cursor.ID, cursor.Backward
+	}
+
+	key := "topics:hot"
+	querySet := db.Model(&Topic{}).Order("hot_score desc").Order("id desc")
+	if divisionID != nil {
+		key = fmt.Sprintf("topics:hot:%d", *divisionID)
+		querySet = querySet.Where("division_id = ?", *divisionID)
+	}
+	idArray, version, total, err := SnapshotLoad(querySet, key, version, offset, request.PageSize)
+	if err != nil {
+		return
+	}
+
+	// load topics, keeping the order of snapshot
+	if len(idArray) > 0 {
+		if err = db.Preload("Tags").Preload("Poster").Find(topics, idArray).Error; err != nil {
+			return
+		}
+	}
+	topicMap := make(map[int]Topic, len(*topics))
+	for _, topic := range *topics {
+		topicMap[topic.ID] = topic
+	}
+	*topics = (*topics)[:0]
+	for _, id := range idArray {
+		if topic, ok := topicMap[id]; ok {
+			*topics = append(*topics, topic)
+		}
+	}
+
+	versionKey, _ := json.Marshal(version)
+	next := offset + len(idArray)
+	if next < total {
+		response.NextCursor = Cursor{Key: versionKey, ID: next}.Encode()
+	}
+	if offset > 0 {
+		prev := offset - request.PageSize
+		if prev < 0 {
+			prev = 0
+		}
+		response.PrevCursor = Cursor{Key: versionKey, ID: prev, Backward: true}.Encode()
+	}
+	if backward {
+		response.HasMore = offset > 0
+	} else {
+		response.HasMore = next < total
+	}
+	return
+}
+
 // GetATopic godoc
 // @Summary 获取一个话题
 // @Tags Topic Module
@@ -122,6 +198,7 @@ func CreateATopic(c *fiber.Ctx) (err error) {
 		newAnonyname := GenerateName([]string{})
 		topic.Anonyname = &newAnonyname
 	}
+	topic.HotScore = topic.CalculateHotScore()
 
 	err = DB.Transaction(func(tx *gorm.DB) error {
 		// Create topic
@@ -152,6 +229,17 @@ func CreateATopic(c *fiber.Ctx) (err error) {
 			}
 		}
 
+		// render markdown, mentions and links
+		err = topic.RenderContent(tx)
+		if err != nil {
+			return err
+		}
+
+		err = SetContentAttachments(tx, ContentTypeTopic, topic.ID, user.ID, body.AttachmentIDs)
+		if err != nil {
+			return err
+		}
+
 		result := tx.Model(&user).Update("topic_count", gorm.Expr("topic_count + 1"))
 		if result.Error != nil {
 			return result.Error
@@ -223,16 +311,50 @@ func ModifyATopic(c *fiber.Ctx) (err error) {
 		if !user.IsAdmin && user.ID != topic.PosterID {
 			return Forbidden()
 		}
+		// 附件只能由发布者修改
+		if body.AttachmentIDs != nil && user.ID != topic.PosterID {
+			return Forbidden()
+		}
 
+		original := topic.ToRevision()
 		err = copier.CopyWithOption(&topic, &body, CopyOption)
 		if err != nil {
 			return err
 		}
 
-		err = tx.Model(&topic).Select(body.Fields()).UpdateColumns(&topic).Error
+		// 记录修改历史
+		fields := body.Fields()
+		edited, err := RecordRevision(tx, original, topic.ToRevision(), user.ID)
 		if err != nil {
 			return err
 		}
+		if edited {
+			now := time.Now()
+			topic.EditedAt = &now
+			fields = append(fields, "EditedAt")
+		}
+
+		// 没有选择字段时 UpdateColumns 会保存全部字段和关联，标签由下面单独处理
+		if len(fields) > 0 {
+			err = tx.Model(&topic).Select(fields).UpdateColumns(&topic).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if edited && body.Content != nil {
+			err = topic.RenderContent(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if body.AttachmentIDs != nil {
+			err = SetContentAttachments(tx, ContentTypeTopic, topic.ID, user.ID, *body.AttachmentIDs)
+			if err != nil {
+				return err
+			}
+		}
 
 		if body.Tags != nil {
 			// clear associations
@@ -288,20 +410,43 @@ func DeleteATopic(c *fiber.Ctx) (err error) {
 		return err
 	}
 	var topic Topic
-	result := DB.First(&topic, id)
+	result := DB.Preload("Tags").First(&topic, id)
 	if result.Error != nil {
 		return NotFound()
 	}
 	if !user.IsAdmin && topic.PosterID != user.ID {
 		return Forbidden()
 	}
-	result = DB.Where("id = ?", id).Delete(&topic)
-	if result.Error != nil {
-		return result.Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Delete(&topic)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return NotFound()
+		}
+
+		// update poster topic count
+		err = tx.Model(&User{ID: topic.PosterID}).Update("topic_count", gorm.Expr("topic_count - 1")).Error
+		if err != nil {
+			return err
+		}
+
+		// update tag temperature
+		if len(topic.Tags) > 0 {
+			err = tx.Model(&topic.Tags).Update("temperature", gorm.Expr("temperature - 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// delete from meilisearch
-	err = SearchDelete[TagSearchModel](topic.ID)
+	err = SearchDelete[TopicSearchModel](topic.ID)
 	if err != nil {
 		return err
 	}
@@ -369,8 +514,11 @@ func LikeOrDislikeATopic(c *fiber.Ctx) (err error) {
 			"like_count":    likeCount,
 			"dislike_count": dislikeCount,
 		})
+		if result.Error != nil {
+			return result.Error
+		}
 
-		return result.Error
+		return UpdateTopicHotScore(tx, topic.ID)
 	})
 	if err != nil {
 		return err
@@ -404,31 +552,13 @@ func ViewATopic(c *fiber.Ctx) (err error) {
 		return err
 	}
 	var topic Topic
-	result := DB.Preload("Tags").Preload("Poster").First(&topic, id)
+	result := DB.Select("id").First(&topic, id)
 	if result.Error != nil {
 		return NotFound()
 	}
 
-	var topicUser = TopicUserViews{
-		TopicID: id,
-		UserID:  user.ID,
-		Count:   1,
-	}
-	err = DB.Transaction(func(tx *gorm.DB) (err error) {
-		err = tx.Clauses(clause.OnConflict{
-			Columns: []clause.Column{{Name: "user_id"}, {Name: "topic_id"}},
-			DoUpdates: clause.Assignments(Map{
-				"updated_at": time.Now(),
-				"count":      gorm.Expr("count + 1"),
-			}),
-		}).Create(&topicUser).Error
-		if err != nil {
-			return err
-		}
-
-		return tx.Model(&topic).UpdateColumn("view_count", gorm.Expr("view_count + 1")).Error
-	})
-	if err != nil {
+	// view count is buffered and written to database periodically
+	if err = RecordTopicView(topic.ID, user.ID); err != nil {
 		return err
 	}
 	return Success(c, &EmptyStruct{})
@@ -473,6 +603,9 @@ func FavorATopic(c *fiber.Ctx) (err error) {
 		if result.RowsAffected == 0 {
 			return BadRequest()
 		}
+		if err = UpdateTopicHotScore(DB, topic.ID); err != nil {
+			return err
+		}
 
 		result = DB.Model(&user).UpdateColumn("favorite_topics_count", gorm.Expr("favorite_topics_count + 1"))
 		if result.Error != nil {
@@ -509,41 +642,57 @@ func ListFavoriteTopics(c *fiber.Ctx) (err error) {
 	if err != nil {
 		return err
 	}
-	nowTime := time.Now()
-	if query.StartTime == nil {
-		query.StartTime = &nowTime
+
+	// created_at 按照收藏的时间排序，updated_at 按照帖子更新的时间排序，hot 按照热度排序
+	var order = CursorOrder{
+		Column:   "topic_user_favorites.created_at",
+		Field:    "FavoredAt",
+		IDColumn: "topic.id",
+		Desc:     true,
 	}
-	var topicUserFavorites []TopicUserFavorites
-	result := DB.Find(&topicUserFavorites, "user_id = ?", user.ID)
-	if result.Error != nil {
-		return NotFound()
+	if query.OrderBy != "created_at" {
+		order = CursorOrder{Column: "topic." + query.OrderColumn(), IDColumn: "topic.id", Desc: true}
 	}
 
-	var orderColumn = clause.Column{
-		Name:  query.OrderBy,
-		Table: "topic_user_favorites",
+	var (
+		favoredTopics []FavoredTopic
+		response      TopicListResponse
+	)
+	db := ReadDB(c)
+	tx := db.Model(&Topic{}).
+		Select("topic.*, topic_user_favorites.created_at as favored_at").
+		Joins("inner join topic_user_favorites on topic_user_favorites.topic_id = topic.id and topic_user_favorites.user_id = ?", user.ID)
+	if query.DivisionID != nil {
+		tx = tx.Where("topic.division_id = ?", *query.DivisionID)
 	}
-	if query.OrderBy == "updated_at" {
-		orderColumn.Table = "topic"
+	response.CursorResponse, err = CursorLoad(tx, &favoredTopics, query.CursorRequest, order)
+	if err != nil {
+		return err
 	}
 
+	// load tags and poster, keeping the order of favorites
+	topicIDs := make([]int, len(favoredTopics))
+	for i := range favoredTopics {
+		topicIDs[i] = favoredTopics[i].ID
+	}
 	var topics []Topic
-	tx := DB.
-		Where("? < ?", orderColumn, query.StartTime).
-		Order(
-			clause.OrderByColumn{
-				Column: orderColumn,
-			}).Limit(query.PageSize)
-	if query.DivisionID != nil {
-		tx = tx.Where(&Topic{DivisionID: *query.DivisionID})
+	if len(topicIDs) > 0 {
+		if err = db.Preload("Tags").Preload("Poster").Find(&topics, topicIDs).Error; err != nil {
+			return err
+		}
 	}
-	result = tx.Joins("inner join topic_user_favorites on topic_user_favorites.topic_id = topic.id and topic_user_favorites.user_id = ?", user.ID).
-		Preload("Tags").Preload("Poster").Find(&topics)
-	if result.Error != nil {
-		return result.Error
+	topicMap := make(map[int]*Topic, len(topics))
+	for i := range topics {
+		topicMap[topics[i].ID] = &topics[i]
 	}
-	var response TopicListResponse
-	if err = copier.CopyWithOption(&response.Topics, &topics, CopyOption); err != nil {
+	orderedTopics := make([]*Topic, 0, len(topics))
+	for _, topicID := range topicIDs {
+		if topic, ok := topicMap[topicID]; ok {
+			orderedTopics = append(orderedTopics, topic)
+		}
+	}
+
+	if err = copier.CopyWithOption(&response.Topics, &orderedTopics, CopyOption); err != nil {
 		return err
 	}
 
@@ -585,6 +734,9 @@ func UnfavorATopic(c *fiber.Ctx) (err error) {
 		if result.Error != nil {
 			return result.Error
 		}
+		if err = UpdateTopicHotScore(DB, topic.ID); err != nil {
+			return err
+		}
 	}
 
 	var response TopicCommonResponse
@@ -624,26 +776,19 @@ func ListTopicsByUser(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	nowTime := time.Now()
-	if query.StartTime == nil {
-		query.StartTime = &nowTime
-	}
-	var topics []Topic
-	querySet := DB.Order(query.OrderBy+" desc").Limit(query.PageSize).
-		Where("? < ?", clause.Column{Name: query.OrderBy}, query.StartTime).
-		Where("poster_id = ?", uid)
+	var (
+		topics   []Topic
+		response TopicListResponse
+	)
+	querySet := ReadDB(c).Scopes(PostedBy(uid, user.ID))
 	if query.DivisionID != nil {
 		querySet = querySet.Where("division_id = ?", *query.DivisionID)
 	}
-	if uid != user.ID {
-		querySet = querySet.Where("is_anonymous = false")
-	}
-	result := querySet.Preload("Tags").Preload("Poster").Find(&topics)
-	if result.Error != nil {
-		return result.Error
+	response.CursorResponse, err = CursorLoad(querySet.Preload("Tags").Preload("Poster"), &topics, query.CursorRequest, CursorOrder{Column: query.OrderColumn(), Desc: true})
+	if err != nil {
+		return err
 	}
 
-	var response TopicListResponse
 	if err = copier.CopyWithOption(&response.Topics, &topics, CopyOption); err != nil {
 		return err
 	}
@@ -672,15 +817,30 @@ func ListTopicsByTag(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	tx := DB.Model(&Topic{}).Joins("inner join topic_tags on topic_tags.topic_id = topic.id")
+	var query TopicListRequest
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
 
-	var topics []Topic
-	result := tx.Where("tag_id = ?", tagID).Preload("Tags").Preload("Poster").Find(&topics)
-	if result.Error != nil {
-		return result.Error
+	tx := ReadDB(c).Model(&Topic{}).Joins("inner join topic_tags on topic_tags.topic_id = topic.id").
+		Where("topic_tags.tag_id = ?", tagID)
+	if query.DivisionID != nil {
+		tx = tx.Where("topic.division_id = ?", *query.DivisionID)
+	}
+
+	var (
+		topics   []Topic
+		response TopicListResponse
+	)
+	response.CursorResponse, err = CursorLoad(
+		tx.Preload("Tags").Preload("Poster"), &topics, query.CursorRequest,
+		CursorOrder{Column: "topic." + query.OrderColumn(), IDColumn: "topic.id", Desc: true},
+	)
+	if err != nil {
+		return err
 	}
 
-	var response TopicListResponse
 	if err = copier.CopyWithOption(&response.Topics, &topics, CopyOption); err != nil {
 		return err
 	}
@@ -688,6 +848,43 @@ func ListTopicsByTag(c *fiber.Ctx) (err error) {
 	return Success(c, &response)
 }
 
+// ListTimeline godoc
+// @Summary 查询当前用户的时间线
+// @Description 关注用户发布的话题和订阅标签下的话题，按照创建时间倒序，不包含隐藏话题和关注用户的匿名话题
+// @Tags Topic Module
+// @Produce json
+// @Router /timeline [get]
+// @Param json query CursorRequest true "page"
+// @Success 200 {object} RespForSwagger{data=TopicListResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func ListTimeline(c *fiber.Ctx) (err error) {
+	var user User
+	err = GetCurrentUser(c, &user)
+	if err != nil {
+		return err
+	}
+	var query CursorRequest
+	err = ValidateQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	var (
+		topics   []Topic
+		response TopicListResponse
+	)
+	response.CursorResponse, err = TimelineLoad(ReadDB(c).Preload("Tags").Preload("Poster"), user.ID, &topics, query)
+	if err != nil {
+		return err
+	}
+
+	if err = copier.CopyWithOption(&response.Topics, &topics, CopyOption); err != nil {
+		return err
+	}
+	return Success(c, &response)
+}
+
 // SearchTopics godoc
 // @Summary 搜索话题
 // @Tags Topic Module
@@ -711,7 +908,8 @@ func SearchTopics(c *fiber.Ctx) (err error) {
 	}
 
 	var topics []Topic
-	_, err = Search(DB.Preload("Tags"), &topics, query.Search, "", []string{"id desc"}, "title", query.PageRequest)
+	db := ReadDB(c)
+	_, err = Search(db.Preload("Tags"), &topics, query.Search, "", []string{"id desc"}, "title", query.PageRequest)
 	if err != nil {
 		return
 	}
@@ -722,7 +920,7 @@ func SearchTopics(c *fiber.Ctx) (err error) {
 		posterIDs[i] = topic.PosterID
 	}
 	var posters []User
-	result := DB.Where("id in (?)", posterIDs).Find(&posters)
+	result := db.Where("id in (?)", posterIDs).Find(&posters)
 	if result.Error != nil {
 		return result.Error
 	}
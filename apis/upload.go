@@ -0,0 +1,89 @@
+package apis
+
+import (
+	"chatdan_backend/config"
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+)
+
+// UploadBodyLimit 上传文件的请求体大小限制，包括 multipart 的额外开销，不小于 fiber 默认的限制
+func UploadBodyLimit() int {
+	limit := config.Config.UploadMaxSize + 1<<20
+	if limit < fiber.DefaultBodyLimit {
+		limit = fiber.DefaultBodyLimit
+	}
+	return limit
+}
+
+// LimitRequestBody 只有上传文件的接口允许超过 fiber 默认大小的请求体
+// fasthttp 只支持全局的请求体大小限制，全局的限制为 UploadBodyLimit，其他接口的限制在这里检查
+func LimitRequestBody(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodPost && c.Path() == "/api/upload" {
+		return c.Next()
+	}
+	if len(c.Request().Body()) > fiber.DefaultBodyLimit {
+		return fiber.ErrRequestEntityTooLarge
+	}
+	return c.Next()
+}
+
+// UploadAFile godoc
+// @Summary 上传附件
+// @Description 支持 jpeg、png、gif、webp 图片和 pdf 文件，类型按照文件内容判断，图片的 EXIF 等元数据会被删除
+// @Description 相同内容的文件只存储一次，再次上传时返回已有的附件，状态码与新上传的文件相同，不暴露文件是否已被其他人上传。返回的附件 ID 可以在发布话题、评论、表白墙、提问和私信时引用
+// @Tags Upload Module
+// @Accept multipart/form-data
+// @Produce json
+// @Router /upload [post]
+// @Param file formData file true "file"
+// @Success 201 {object} RespForSwagger{data=AttachmentResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger
+func UploadAFile(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return BadRequest("请上传文件")
+	}
+	if fileHeader.Size <= 0 {
+		return BadRequest("文件为空")
+	}
+	if fileHeader.Size > int64(config.Config.UploadMaxSize) {
+		return BadRequest(fmt.Sprintf("文件大小不能超过 %d MB", config.Config.UploadMaxSize>>20))
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(file, int64(config.Config.UploadMaxSize)+1))
+	if err != nil {
+		return
+	}
+	if len(data) > config.Config.UploadMaxSize {
+		return BadRequest(fmt.Sprintf("文件大小不能超过 %d MB", config.Config.UploadMaxSize>>20))
+	}
+
+	contentType := DetectContentType(data)
+	if _, ok := UploadContentTypes[contentType]; !ok {
+		return BadRequest("不支持的文件类型")
+	}
+
+	attachment, _, err := SaveAttachment(DB, user.ID, data, contentType)
+	if err != nil {
+		return
+	}
+
+	response := NewAttachmentResponse(&attachment)
+	return Created(c, &response)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/jinzhu/copier"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -37,20 +38,23 @@ func ListUsers(c *fiber.Ctx) (err error) {
 
 	// load users from database
 	var (
-		users          []User
-		version, total int
+		users    []User
+		response UserListResponse
 	)
-	if version, total, err = PageLoad(DB, &users, "users", query.PageRequest); err != nil {
-		return
+	if query.PageNum != 0 {
+		if response.Version, response.Total, err = PageLoad(DB.Model(&User{}).Order("id asc"), &users, "users", query.PageRequest()); err != nil {
+			return
+		}
+	} else {
+		if response.CursorResponse, err = CursorLoad(DB.Model(&User{}), &users, query.CursorRequest, CursorOrder{Column: "id"}); err != nil {
+			return
+		}
 	}
 
 	// construct response
-	var response UserListResponse
-	if err = copier.Copy(&response, &users); err != nil {
+	if err = copier.Copy(&response.Users, &users); err != nil {
 		return
 	}
-	response.Version = version
-	response.Total = total
 
 	return Success(c, &response)
 }
@@ -402,6 +406,7 @@ func FollowAUser(c *fiber.Ctx) (err error) {
 	}); err != nil {
 		return
 	}
+	InvalidateTimeline(currentUser.ID)
 
 	// construct response
 	var response EmptyStruct
@@ -465,6 +470,7 @@ func UnfollowAUser(c *fiber.Ctx) (err error) {
 	}); err != nil {
 		return err
 	}
+	InvalidateTimeline(currentUser.ID)
 
 	// construct response
 	var response EmptyStruct
@@ -477,7 +483,7 @@ func UnfollowAUser(c *fiber.Ctx) (err error) {
 // @Produce json
 // @Router /users/{id}/_followers [get]
 // @Param id path int true "user id"
-// @Param page query UserListRequest true "page"
+// @Param page query UserFollowListRequest true "page"
 // @Success 200 {object} RespForSwagger{data=UserListResponse}
 // @Failure 400 {object} RespForSwagger
 // @Failure 500 {object} RespForSwagger
@@ -489,7 +495,7 @@ func ListUserFollowers(c *fiber.Ctx) (err error) {
 	}
 
 	// get and validate request query
-	var query UserListRequest
+	var query UserFollowListRequest
 	if err = ValidateQuery(c, &query); err != nil {
 		return
 	}
@@ -500,17 +506,20 @@ func ListUserFollowers(c *fiber.Ctx) (err error) {
 		return
 	}
 
-	// get followers
-	var followers []User
-	if err = query.QuerySet(DB).Model(&user).
-		Joins("inner join user_follows on user_follows.follower_id = user.id and user_follows.user_id = ?", user.ID).
-		Order("user_follows.created_at desc").
-		Find(&followers).Error; err != nil {
+	// get followers, ordered by follow time
+	var (
+		followers []FollowedUser
+		response  UserListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Model(&User{}).Select("?.*, user_follows.created_at AS followed_at", clause.Table{Name: "user"}).
+			Joins("inner join user_follows on user_follows.follower_id = ? and user_follows.user_id = ?", clause.Column{Table: "user", Name: "id"}, user.ID),
+		&followers, query.CursorRequest, FollowedUserCursorOrder,
+	); err != nil {
 		return
 	}
 
 	// construct response
-	var response UserListResponse
 	if err = copier.Copy(&response.Users, &followers); err != nil {
 		return
 	}
@@ -524,7 +533,7 @@ func ListUserFollowers(c *fiber.Ctx) (err error) {
 // @Produce json
 // @Router /users/{id}/_following [get]
 // @Param id path int true "user id"
-// @Param page query UserListRequest true "page"
+// @Param page query UserFollowListRequest true "page"
 // @Success 200 {object} RespForSwagger{data=UserListResponse}
 // @Failure 400 {object} RespForSwagger
 // @Failure 500 {object} RespForSwagger
@@ -536,7 +545,7 @@ func ListUserFollowing(c *fiber.Ctx) (err error) {
 	}
 
 	// get and validate request query
-	var query UserListRequest
+	var query UserFollowListRequest
 	if err = ValidateQuery(c, &query); err != nil {
 		return
 	}
@@ -547,17 +556,20 @@ func ListUserFollowing(c *fiber.Ctx) (err error) {
 		return
 	}
 
-	// get following
-	var following []User
-	if err = query.QuerySet(DB).Model(&user).
-		Joins("inner join user_follows on user_follows.user_id = user.id and user_follows.follower_id = ?", user.ID).
-		Order("user_follows.created_at desc").
-		Find(&following).Error; err != nil {
+	// get following, ordered by follow time
+	var (
+		following []FollowedUser
+		response  UserListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Model(&User{}).Select("?.*, user_follows.created_at AS followed_at", clause.Table{Name: "user"}).
+			Joins("inner join user_follows on user_follows.user_id = ? and user_follows.follower_id = ?", clause.Column{Table: "user", Name: "id"}, user.ID),
+		&following, query.CursorRequest, FollowedUserCursorOrder,
+	); err != nil {
 		return
 	}
 
 	// construct response
-	var response UserListResponse
 	if err = copier.Copy(&response.Users, &following); err != nil {
 		return
 	}
@@ -3,14 +3,19 @@ package apis
 import (
 	. "chatdan_backend/models"
 	. "chatdan_backend/utils"
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jinzhu/copier"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
 	"time"
 )
 
 // ListWalls
-// @Summary 获取今日表白墙
-// @Description 获取今日表白墙，即昨日发送的表白墙
+// @Summary 获取每日表白墙
+// @Description 获取某一天发布的表白墙，默认按照序号排序，也可以按照热度排序，不填日期默认最近一次发布的表白墙
+// @Description 每天的截止时间（WALL_PUBLISH_TIME，WALL_TIMEZONE 时区）之前创建的表白在当天发布
 // @Tags Wall Module
 // @Router /wall [get]
 // @Produce json
@@ -19,39 +24,63 @@ import (
 // @Failure 400 {object} RespForSwagger
 // @Failure 500 {object} RespForSwagger "服务器错误"
 func ListWalls(c *fiber.Ctx) (err error) {
+	// get current user, optional
+	var user User
+	if err = GetOptionalUser(c, &user); err != nil {
+		return err
+	}
+
 	// get and validate query
 	var query WallListRequest
 	if err = ValidateQuery(c, &query); err != nil {
 		return err
 	}
 
-	nowTime := time.Now()
-	nowDate := time.Date(nowTime.Year(), nowTime.Month(), nowTime.Day(), 0, 0, 0, 0, time.Local)
-
-	// construct querySet and load walls from database（昨日发送的表白墙）
-	queryDate := time.Now().AddDate(0, 0, -1)
+	latestDate := WallPublishDate(time.Now())
+	date := latestDate
 	if query.Date != nil {
-		queryDate = (*query.Date).AddDate(0, 0, -1)
+		date = time.Date(query.Date.Year(), query.Date.Month(), query.Date.Day(), 0, 0, 0, 0, WallLocation())
 	}
-	if queryDate.After(nowDate) {
+	if date.After(latestDate) {
 		return BadRequest("不允许查询未来的表白墙")
 	}
 
-	var walls []Wall
-	if err = query.QuerySet(DB).Where(
-		"created_at between ? and ?",
-		time.Date(queryDate.Year(), queryDate.Month(), queryDate.Day(), 0, 0, 0, 0, time.Local),
-		time.Date(queryDate.Year(), queryDate.Month(), queryDate.Day(), 23, 59, 59, 999, time.Local),
-	).Preload("Poster").Find(&walls).Error; err != nil {
+	response := WallListResponse{Posts: []WallCommonResponse{}, Date: date, WindowEnd: WallCutoff(date)}
+
+	// 尚未生成时返回空的表白墙
+	var digest WallDigest
+	if err = DB.Where("date = ?", date.Format(WallDateLayout)).Take(&digest).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Success(c, &response)
+		}
+		return err
+	}
+	response.PublishedAt = &digest.PublishedAt
+	response.WallCount = digest.WallCount
+
+	// load walls of the digest, private and hidden walls are only visible to the poster
+	var (
+		walls []Wall
+		total int64
+	)
+	querySet := DB.Where("digest_id = ?", digest.ID).Scopes(WallVisibleTo(&user))
+	// 已废弃的 total 只在第一页计算，避免每一页都查询总数
+	if query.Cursor == "" {
+		if err = querySet.Session(&gorm.Session{}).Model(&Wall{}).Count(&total).Error; err != nil {
+			return err
+		}
+		response.Total = int(total)
+	}
+	if response.CursorResponse, err = CursorLoad(
+		querySet.Preload("Poster"), &walls, query.CursorRequest, query.CursorOrder(),
+	); err != nil {
 		return err
 	}
 
 	// construct response
-	var response WallListResponse
-	if err = copier.Copy(&response.Posts, &walls); err != nil {
+	if response.Posts, err = newWallResponses(walls, &user); err != nil {
 		return err
 	}
-	response.Date = queryDate
 
 	return Success(c, &response)
 }
@@ -66,6 +95,12 @@ func ListWalls(c *fiber.Ctx) (err error) {
 // @Failure 400 {object} RespForSwagger
 // @Failure 500 {object} RespForSwagger "服务器错误"
 func GetAWall(c *fiber.Ctx) (err error) {
+	// get current user, optional
+	var user User
+	if err = GetOptionalUser(c, &user); err != nil {
+		return
+	}
+
 	// get wall id
 	var wallID int
 	if wallID, err = c.ParamsInt("id"); err != nil {
@@ -77,24 +112,43 @@ func GetAWall(c *fiber.Ctx) (err error) {
 	if err = DB.First(&wall, wallID).Error; err != nil {
 		return
 	}
+	if !wall.VisibleTo(&user) {
+		return NotFound()
+	}
 
 	// construct response
-	var response WallCommonResponse
-	if err = copier.Copy(&response, &wall); err != nil {
+	response, err := newWallResponse(&wall, &user)
+	if err != nil {
 		return
 	}
 
-	return Success(c, &response)
+	// load link previews and attachments
+	previewMap, err := loadLinkPreviews(c, ContentTypeWall, []int{wall.ID})
+	if err != nil {
+		return
+	}
+	response.LinkPreviews = previewMap[wall.ID]
+	attachmentMap, err := loadAttachments(c, ContentTypeWall, []int{wall.ID})
+	if err != nil {
+		return
+	}
+	response.Attachments = attachmentMap[wall.ID]
+
+	return Success(c, response)
 }
 
 // CreateAWall
 // @Summary 创建表白墙
+// @Description 可以指定表白的对象，对象会在收到的表白中看到这条表白；双方互相表白并且都已经发布时，双方都可以看到对方的匿名表白的发布者
+// @Description 每个用户 24 小时内指定对象的表白数量有限制（WALL_TARGET_DAILY_LIMIT）
+// @Description 表白的对象只对发布者和对象本人可见
 // @Tags Wall Module
 // @Router /wall [post]
 // @Produce json
 // @Param json body WallCreateRequest true "json"
 // @Success 201 {object} RespForSwagger{data=WallCommonResponse}
 // @Failure 400 {object} RespForSwagger
+// @Failure 429 {object} RespForSwagger
 // @Failure 500 {object} RespForSwagger "服务器错误"
 func CreateAWall(c *fiber.Ctx) (err error) {
 	// get current user
@@ -108,6 +162,18 @@ func CreateAWall(c *fiber.Ctx) (err error) {
 	if err = ValidateBody(c, &body); err != nil {
 		return
 	}
+	if body.TargetID != nil {
+		if *body.TargetID == user.ID {
+			return BadRequest("不能向自己表白")
+		}
+		var target User
+		if err = DB.Take(&target, *body.TargetID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return BadRequest("表白的对象不存在")
+			}
+			return
+		}
+	}
 
 	// create wall
 	var wall Wall
@@ -115,19 +181,806 @@ func CreateAWall(c *fiber.Ctx) (err error) {
 		return
 	}
 	wall.PosterID = user.ID
-	if err = DB.Create(&wall).Error; err != nil {
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		if wall.TargetID != nil {
+			if err = CheckWallTargetLimit(tx, user.ID); err != nil {
+				return
+			}
+		}
+		if err = tx.Create(&wall).Error; err != nil {
+			return
+		}
+		if err = wall.RenderContent(tx); err != nil {
+			return
+		}
+		return SetContentAttachments(tx, ContentTypeWall, wall.ID, user.ID, body.AttachmentIDs)
+	}); err != nil {
 		return
 	}
 
 	// construct response
-	var response WallCommonResponse
-	if err = copier.Copy(&response, &wall); err != nil {
+	response, err := newWallResponse(&wall, &user) // 在下一次截止时间发布
+	if err != nil {
+		return
+	}
+
+	attachmentMap, err := loadAttachments(c, ContentTypeWall, []int{wall.ID})
+	if err != nil {
+		return
+	}
+	response.Attachments = attachmentMap[wall.ID]
+
+	return Created(c, response)
+}
+
+// ModifyAWall
+// @Summary 修改表白
+// @Description 发布者可以在发布之前修改内容、匿名、附件和可见性；管理员可以随时修改可见性和隐藏表白
+// @Tags Wall Module
+// @Router /wall/{id} [put]
+// @Accept json
+// @Produce json
+// @Param id path int true "wall id"
+// @Param json body WallModifyRequest true "json"
+// @Success 200 {object} RespForSwagger{data=WallCommonResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger "服务器错误"
+func ModifyAWall(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var wallID int
+	if wallID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var body WallModifyRequest
+	if err = ValidateBody(c, &body); err != nil {
+		return
+	}
+	if body.IsEmpty() {
+		return BadRequest()
+	}
+	if body.IsHidden != nil && !user.IsAdmin {
+		return Forbidden("只有管理员才能隐藏表白")
+	}
+
+	var wall Wall
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Clauses(LockClause).First(&wall, wallID).Error; err != nil {
+			return
+		}
+		if !wall.VisibleTo(&user) {
+			return NotFound()
+		}
+
+		isPoster := user.ID == wall.PosterID
+		if body.IsOwnerOnly() && !isPoster {
+			return Forbidden("只有发布者才能修改表白")
+		}
+		if body.Visibility != nil && !isPoster && !user.IsAdmin {
+			return Forbidden()
+		}
+		// 管理员可以在发布之后修改可见性和隐藏，发布者只能在发布之前修改
+		if wall.IsPublished() && (body.IsOwnerOnly() || (body.Visibility != nil && !user.IsAdmin)) {
+			return BadRequest("表白已经发布，不能修改")
+		}
+
+		if err = copier.CopyWithOption(&wall, &body, CopyOption); err != nil {
+			return
+		}
+		if fields := body.Fields(); len(fields) > 0 {
+			if err = tx.Model(&wall).Select(fields).Updates(&wall).Error; err != nil {
+				return
+			}
+		}
+		if body.Content != nil {
+			if err = wall.RenderContent(tx); err != nil {
+				return
+			}
+		}
+		if body.AttachmentIDs != nil {
+			return SetContentAttachments(tx, ContentTypeWall, wall.ID, user.ID, *body.AttachmentIDs)
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+
+	response, err := newWallResponse(&wall, &user)
+	if err != nil {
+		return
+	}
+
+	previewMap, err := loadLinkPreviews(c, ContentTypeWall, []int{wall.ID})
+	if err != nil {
+		return
+	}
+	response.LinkPreviews = previewMap[wall.ID]
+	attachmentMap, err := loadAttachments(c, ContentTypeWall, []int{wall.ID})
+	if err != nil {
+		return
+	}
+	response.Attachments = attachmentMap[wall.ID]
+
+	return Success(c, response)
+}
+
+// DeleteAWall
+// @Summary 删除表白
+// @Description 发布者或者管理员可以删除，已经发布的表白删除后不再显示在表白墙中
+// @Tags Wall Module
+// @Router /wall/{id} [delete]
+// @Produce json
+// @Param id path int true "wall id"
+// @Success 200 {object} RespForSwagger{data=EmptyStruct}
+// @Failure 403 {object} RespForSwagger
+// @Failure 404 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger "服务器错误"
+func DeleteAWall(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var wallID int
+	if wallID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var wall Wall
+	if err = DB.First(&wall, wallID).Error; err != nil {
+		return
+	}
+	if !wall.VisibleTo(&user) {
+		return NotFound()
+	}
+	if user.ID != wall.PosterID && !user.IsAdmin {
+		return Forbidden()
+	}
+
+	if err = DB.Delete(&wall).Error; err != nil {
+		return
+	}
+
+	return Success(c, &EmptyStruct{})
+}
+
+// ListPendingWalls
+// @Summary 获取尚未发布的表白，仅管理员
+// @Description 包括已经被排除的表白，按照创建顺序排序
+// @Tags Wall Module
+// @Router /walls/_pending [get]
+// @Produce json
+// @Param json query CursorRequest true "query"
+// @Success 200 {object} RespForSwagger{data=WallPendingListResponse}
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger "服务器错误"
+func ListPendingWalls(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+	if !user.IsAdmin {
+		return Forbidden("只有管理员才能查看未发布的表白")
+	}
+
+	var query CursorRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	var (
+		walls    []Wall
+		response WallPendingListResponse
+	)
+	response.Date = WallBatchDate(time.Now())
+	response.WindowEnd = WallCutoff(response.Date)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Where("digest_id IS NULL"), &walls, query, CursorOrder{Column: "id"},
+	); err != nil {
+		return
+	}
+
+	if response.Posts, err = newWallResponses(walls, &user); err != nil {
+		return
+	}
+
+	return Success(c, &response)
+}
+
+// ExcludeAWall
+// @Summary 排除表白，仅管理员
+// @Description 只能在发布之前排除，被排除的表白不会发布
+// @Tags Wall Module
+// @Router /wall/{id}/_exclude [put]
+// @Produce json
+// @Param id path int true "wall id"
+// @Success 200 {object} RespForSwagger{data=WallCommonResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+func ExcludeAWall(c *fiber.Ctx) error {
+	return setWallExcluded(c, true)
+}
+
+// IncludeAWall
+// @Summary 取消排除表白，仅管理员
+// @Description 只能在发布之前取消，之后在下一次截止时间发布
+// @Tags Wall Module
+// @Router /wall/{id}/_exclude [delete]
+// @Produce json
+// @Param id path int true "wall id"
+// @Success 200 {object} RespForSwagger{data=WallCommonResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 403 {object} RespForSwagger
+func IncludeAWall(c *fiber.Ctx) error {
+	return setWallExcluded(c, false)
+}
+
+func setWallExcluded(c *fiber.Ctx, excluded bool) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+	if !user.IsAdmin {
+		return Forbidden("只有管理员才能排除表白")
+	}
+
+	var wallID int
+	if wallID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var wall Wall
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		if err = tx.Clauses(LockClause).First(&wall, wallID).Error; err != nil {
+			return
+		}
+		if wall.IsPublished() {
+			return BadRequest("表白已经发布")
+		}
+		wall.IsExcluded = excluded
+		return tx.Model(&wall).Update("is_excluded", excluded).Error
+	}); err != nil {
+		return
+	}
+
+	response, err := newWallResponse(&wall, &user)
+	if err != nil {
+		return
+	}
+
+	return Success(c, response)
+}
+
+// TriggerWallPublish
+// @Summary 立即生成所有到达截止时间但尚未生成的表白墙，仅管理员
+// @Description 定时任务会自动生成，用于定时任务关闭或者出错时补发
+// @Tags Admin Module
+// @Router /walls/_publish [post]
+// @Produce json
+// @Success 200 {object} RespForSwagger{data=WallPublishResponse}
+// @Failure 403 {object} RespForSwagger
+// @Failure 500 {object} RespForSwagger "服务器错误"
+func TriggerWallPublish(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+	if !user.IsAdmin {
+		return Forbidden("只有管理员才能发布表白墙")
+	}
+
+	digests, err := PublishWallDigests(DB, time.Now())
+	if err != nil {
+		return
+	}
+
+	response := WallPublishResponse{Digests: []WallDigestResponse{}}
+	if err = copier.Copy(&response.Digests, &digests); err != nil {
+		return
+	}
+
+	return Success(c, &response)
+}
+
+// ListReceivedWalls
+// @Summary 获取收到的表白
+// @Description 以当前用户为对象的表白，包括尚未发布的表白，按照时间倒序排序
+// @Tags Wall Module
+// @Router /walls/_received [get]
+// @Produce json
+// @Param json query CursorRequest true "query"
+// @Success 200 {object} RespForSwagger{data=WallReceivedListResponse}
+// @Failure 500 {object} RespForSwagger "服务器错误"
+func ListReceivedWalls(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var query CursorRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	var (
+		walls    []Wall
+		response WallReceivedListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Where("target_id = ? AND is_hidden = ?", user.ID, false).Preload("Poster"),
+		&walls, query, CursorOrder{Column: "id", Desc: true},
+	); err != nil {
+		return
+	}
+	if response.Posts, err = newWallResponses(walls, &user); err != nil {
+		return
+	}
+
+	return Success(c, &response)
+}
+
+// ListWallMatches
+// @Summary 获取互相表白的用户
+// @Description 双方都发布了以对方为对象的表白时互相表白，只有已经发布到表白墙的表白才算，删除、排除或者被隐藏的表白不算
+// @Tags Wall Module
+// @Router /walls/_matches [get]
+// @Produce json
+// @Success 200 {object} RespForSwagger{data=WallMatchListResponse}
+// @Failure 500 {object} RespForSwagger "服务器错误"
+func ListWallMatches(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	matches, err := FindWallMatches(DB, user.ID)
+	if err != nil {
+		return
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].MatchedAt.After(matches[j].MatchedAt)
+	})
+
+	userIDs := make([]int, len(matches))
+	for i := range matches {
+		userIDs[i] = matches[i].UserID
+	}
+	users, err := loadWallUsers(userIDs)
+	if err != nil {
+		return
+	}
+
+	response := WallMatchListResponse{Matches: make([]WallMatchResponse, 0, len(matches))}
+	if err = copier.Copy(&response.Matches, &matches); err != nil {
 		return
 	}
+	for i := range matches {
+		response.Matches[i].User = users[matches[i].UserID]
+	}
 
-	yesterday := time.Now().AddDate(0, 0, -1)
-	endOfYesterday := time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 23, 59, 59, 999, time.Local)
-	response.IsShown = wall.CreatedAt.Before(endOfYesterday) // 创建时间在昨天结束之前的才会显示
+	return Success(c, &response)
+}
+
+func newWallResponse(wall *Wall, user *User) (*WallCommonResponse, error) {
+	responses, err := newWallResponses([]Wall{*wall}, user)
+	if err != nil {
+		return nil, err
+	}
+	return &responses[0], nil
+}
 
-	return Created(c, &response)
+// newWallResponses 按照当前用户构造表白的响应
+// 表白的对象只返回给发布者，对象本人只知道自己是对象；互相表白时，对方可以看到匿名表白的发布者
+func newWallResponses(walls []Wall, user *User) (responses []WallCommonResponse, err error) {
+	responses = []WallCommonResponse{}
+	if err = copier.Copy(&responses, &walls); err != nil {
+		return
+	}
+
+	wallIDs := make([]int, len(walls))
+	for i := range walls {
+		wallIDs[i] = walls[i].ID
+	}
+	reactionMap, err := loadWallReactions(wallIDs, user.ID)
+	if err != nil {
+		return
+	}
+	for i := range responses {
+		responses[i].Reactions = reactionMap[walls[i].ID]
+	}
+
+	var userIDs []int
+	for i := range walls {
+		responses[i].setStatus(&walls[i], user)
+		if walls[i].TargetID == nil || user.ID == 0 {
+			continue
+		}
+		if walls[i].PosterID == user.ID {
+			userIDs = append(userIDs, *walls[i].TargetID)
+		} else if walls[i].IsTarget(user.ID) {
+			userIDs = append(userIDs, walls[i].PosterID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+
+	matches, err := FindWallMatches(DB, user.ID, userIDs...)
+	if err != nil {
+		return
+	}
+	matched := make(map[int]bool, len(matches))
+	for _, match := range matches {
+		matched[match.UserID] = true
+	}
+	users, err := loadWallUsers(userIDs)
+	if err != nil {
+		return
+	}
+
+	for i := range walls {
+		wall := &walls[i]
+		if wall.TargetID == nil {
+			continue
+		}
+		if wall.PosterID == user.ID {
+			responses[i].TargetUser = users[*wall.TargetID]
+			responses[i].IsMatched = matched[*wall.TargetID]
+		} else if wall.IsTarget(user.ID) {
+			responses[i].IsTarget = true
+			if matched[wall.PosterID] {
+				responses[i].IsMatched = true
+				responses[i].revealPoster = true
+				responses[i].PosterID = wall.PosterID
+				responses[i].Poster = users[wall.PosterID]
+			}
+		}
+	}
+	return
+}
+
+func loadWallUsers(userIDs []int) (map[int]*UserResponse, error) {
+	users := make(map[int]*UserResponse, len(userIDs))
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+
+	var models []User
+	if err := DB.Where("id IN ?", userIDs).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	for i := range models {
+		var response UserResponse
+		if err := copier.Copy(&response, &models[i]); err != nil {
+			return nil, err
+		}
+		users[models[i].ID] = &response
+	}
+	return users, nil
+}
+
+// loadWallReactions 批量加载表白每种表情的回应数，以及当前用户是否回应
+func loadWallReactions(wallIDs []int, userID int) (map[int][]WallReactionResponse, error) {
+	reactionMap := make(map[int][]WallReactionResponse, len(wallIDs))
+	for _, wallID := range wallIDs {
+		reactions := make([]WallReactionResponse, len(WallReactionEmojis))
+		for i, emoji := range WallReactionEmojis {
+			reactions[i].Emoji = emoji
+		}
+		reactionMap[wallID] = reactions
+	}
+	if len(wallIDs) == 0 {
+		return reactionMap, nil
+	}
+
+	var counts []struct {
+		WallID int
+		Emoji  string
+		Count  int
+	}
+	if err := DB.Model(&WallReaction{}).
+		Select("wall_id, emoji, count(*) AS count").
+		Where("wall_id IN ?", wallIDs).
+		Group("wall_id, emoji").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	var reacted []WallReaction
+	if userID != 0 {
+		if err := DB.Where("wall_id IN ? AND user_id = ?", wallIDs, userID).Find(&reacted).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	find := func(wallID int, emoji string) *WallReactionResponse {
+		for i, reaction := range reactionMap[wallID] {
+			if reaction.Emoji == emoji {
+				return &reactionMap[wallID][i]
+			}
+		}
+		return nil
+	}
+	for _, count := range counts {
+		if reaction := find(count.WallID, count.Emoji); reaction != nil {
+			reaction.Count = count.Count
+		}
+	}
+	for _, reaction := range reacted {
+		if response := find(reaction.WallID, reaction.Emoji); response != nil {
+			response.Reacted = true
+		}
+	}
+	return reactionMap, nil
+}
+
+// loadInteractiveWall 加载可以回应和回复的表白，只能回应和回复已经发布、未被隐藏的表白
+func loadInteractiveWall(tx *gorm.DB, wallID int, user *User) (wall Wall, err error) {
+	if err = tx.First(&wall, wallID).Error; err != nil {
+		return
+	}
+	if !wall.VisibleTo(user) {
+		return wall, NotFound()
+	}
+	if !wall.IsPublished() || wall.IsHidden {
+		return wall, BadRequest("表白尚未发布")
+	}
+	return
+}
+
+// ReactToAWall
+// @Summary 表情回应表白
+// @Description 表情只能是 WallReactionEmojis 中的一个，每个用户可以使用多个不同的表情，重复回应同一个表情不会重复计数
+// @Tags Wall Module
+// @Router /wall/{id}/_reaction [put]
+// @Accept json
+// @Produce json
+// @Param id path int true "wall id"
+// @Param json body WallReactionRequest true "json"
+// @Success 200 {object} RespForSwagger{data=WallCommonResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 404 {object} RespForSwagger
+func ReactToAWall(c *fiber.Ctx) (err error) {
+	var body WallReactionRequest
+	if err = ValidateBody(c, &body); err != nil {
+		return
+	}
+	return setWallReaction(c, body.Emoji, true)
+}
+
+// DeleteAWallReaction
+// @Summary 取消表情回应
+// @Tags Wall Module
+// @Router /wall/{id}/_reaction [delete]
+// @Produce json
+// @Param id path int true "wall id"
+// @Param json query WallReactionRequest true "query"
+// @Success 200 {object} RespForSwagger{data=WallCommonResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 404 {object} RespForSwagger
+func DeleteAWallReaction(c *fiber.Ctx) (err error) {
+	var query WallReactionRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+	return setWallReaction(c, query.Emoji, false)
+}
+
+func setWallReaction(c *fiber.Ctx, emoji string, reacted bool) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+	if !IsWallReactionEmoji(emoji) {
+		return BadRequest("不支持的表情")
+	}
+
+	var wallID int
+	if wallID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var wall Wall
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		if wall, err = loadInteractiveWall(tx.Clauses(LockClause), wallID, &user); err != nil {
+			return
+		}
+
+		reaction := WallReaction{WallID: wall.ID, UserID: user.ID, Emoji: emoji}
+		if reacted {
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction).Error
+		} else {
+			err = tx.Where(&reaction).Delete(&WallReaction{}).Error
+		}
+		if err != nil {
+			return
+		}
+
+		if err = UpdateWallCounters(tx, wall.ID); err != nil {
+			return
+		}
+		return tx.Preload("Poster").First(&wall, wall.ID).Error
+	}); err != nil {
+		return
+	}
+
+	response, err := newWallResponse(&wall, &user)
+	if err != nil {
+		return
+	}
+
+	return Success(c, response)
+}
+
+// ListWallComments
+// @Summary 获取表白的回复
+// @Description 按照时间顺序排序
+// @Tags Wall Module
+// @Router /wall/{id}/comments [get]
+// @Produce json
+// @Param id path int true "wall id"
+// @Param json query CursorRequest true "query"
+// @Success 200 {object} RespForSwagger{data=WallCommentListResponse}
+// @Failure 404 {object} RespForSwagger
+func ListWallComments(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetOptionalUser(c, &user); err != nil {
+		return
+	}
+
+	var wallID int
+	if wallID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var query CursorRequest
+	if err = ValidateQuery(c, &query); err != nil {
+		return
+	}
+
+	var wall Wall
+	if err = DB.First(&wall, wallID).Error; err != nil {
+		return
+	}
+	if !wall.VisibleTo(&user) {
+		return NotFound()
+	}
+
+	var (
+		comments []WallComment
+		response WallCommentListResponse
+	)
+	if response.CursorResponse, err = CursorLoad(
+		DB.Where("wall_id = ?", wall.ID).Preload("Poster"),
+		&comments, query, CursorOrder{Column: "id"},
+	); err != nil {
+		return
+	}
+	if response.Comments, err = newWallCommentResponses(comments, &wall, &user); err != nil {
+		return
+	}
+
+	return Success(c, &response)
+}
+
+// CreateAWallComment
+// @Summary 回复表白
+// @Description 默认匿名，匿名时在同一条表白中使用同一个匿名昵称
+// @Tags Wall Module
+// @Router /wall/{id}/comments [post]
+// @Accept json
+// @Produce json
+// @Param id path int true "wall id"
+// @Param json body WallCommentCreateRequest true "json"
+// @Success 201 {object} RespForSwagger{data=WallCommentResponse}
+// @Failure 400 {object} RespForSwagger
+// @Failure 404 {object} RespForSwagger
+func CreateAWallComment(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var wallID int
+	if wallID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	var body WallCommentCreateRequest
+	if err = ValidateBody(c, &body); err != nil {
+		return
+	}
+
+	var (
+		wall    Wall
+		comment WallComment
+	)
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		if wall, err = loadInteractiveWall(tx.Clauses(LockClause), wallID, &user); err != nil {
+			return
+		}
+
+		comment = WallComment{
+			Content:     body.Content,
+			IsAnonymous: *body.IsAnonymous,
+			WallID:      wall.ID,
+			PosterID:    user.ID,
+		}
+		if comment.IsAnonymous {
+			var anonyname string
+			if anonyname, err = FindOrGenerateWallAnonyname(tx, wall.ID, user.ID); err != nil {
+				return
+			}
+			comment.Anonyname = &anonyname
+		}
+		if err = tx.Create(&comment).Error; err != nil {
+			return
+		}
+		return UpdateWallCounters(tx, wall.ID)
+	}); err != nil {
+		return
+	}
+
+	responses, err := newWallCommentResponses([]WallComment{comment}, &wall, &user)
+	if err != nil {
+		return
+	}
+
+	return Created(c, &responses[0])
+}
+
+// DeleteAWallComment
+// @Summary 删除表白的回复
+// @Description 回复的发布者或者管理员可以删除
+// @Tags Wall Module
+// @Router /wallComment/{id} [delete]
+// @Produce json
+// @Param id path int true "wall comment id"
+// @Success 200 {object} RespForSwagger{data=EmptyStruct}
+// @Failure 403 {object} RespForSwagger
+// @Failure 404 {object} RespForSwagger
+func DeleteAWallComment(c *fiber.Ctx) (err error) {
+	var user User
+	if err = GetCurrentUser(c, &user); err != nil {
+		return
+	}
+
+	var commentID int
+	if commentID, err = c.ParamsInt("id"); err != nil {
+		return
+	}
+
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		var comment WallComment
+		if err = tx.First(&comment, commentID).Error; err != nil {
+			return
+		}
+		if comment.PosterID != user.ID && !user.IsAdmin {
+			return Forbidden()
+		}
+		if err = tx.Delete(&comment).Error; err != nil {
+			return
+		}
+		return UpdateWallCounters(tx, comment.WallID)
+	}); err != nil {
+		return
+	}
+
+	return Success(c, &EmptyStruct{})
+}
+
+func newWallCommentResponses(comments []WallComment, wall *Wall, user *User) (responses []WallCommentResponse, err error) {
+	responses = []WallCommentResponse{}
+	if err = copier.Copy(&responses, &comments); err != nil {
+		return
+	}
+	for i := range comments {
+		responses[i].IsOwner = user.ID != 0 && comments[i].PosterID == user.ID
+		// 实名回复匿名的表白时不标记，避免暴露表白的发布者
+		responses[i].IsWallPoster = comments[i].PosterID == wall.PosterID && (comments[i].IsAnonymous || !wall.IsAnonymous)
+	}
+	return
 }
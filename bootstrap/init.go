@@ -12,13 +12,20 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/pprof"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
 func InitFiberApp() *fiber.App {
 	config.InitConfig()
-	models.InitDB()
+	// 迁移数据时需要清除缓存
 	utils.InitCache()
+	models.InitDB()
+	utils.InitStorage()
+	models.StartCounterReconciler()
+	models.StartTopicViewFlusher()
+	models.StartLinkPreviewFetcher()
+	models.StartWallPublisher()
 
 	app := fiber.New(fiber.Config{
 		AppName:               config.Config.AppName,
@@ -26,11 +33,22 @@ func InitFiberApp() *fiber.App {
 		JSONEncoder:           json.Marshal,
 		JSONDecoder:           json.Unmarshal,
 		DisableStartupMessage: true,
+		BodyLimit:             apis.UploadBodyLimit(), // 其他接口的限制由 apis.LimitRequestBody 检查
+
+		// 只信任来自反向代理的客户端 IP，没有配置可信代理时 c.IP() 返回连接的地址
+		ProxyHeader:             config.Config.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          config.Config.TrustedProxies,
 	})
 
 	registerMiddlewares(app)
 	apis.RegisterRoutes(app)
 
+	// 本地存储的文件由本服务提供访问
+	if config.Config.StorageType == "local" && strings.HasPrefix(config.Config.StoragePublicUrl, "/") {
+		app.Static(config.Config.StoragePublicUrl, config.Config.StorageLocalDir, fiber.Static{MaxAge: 86400 * 30})
+	}
+
 	return app
 }
 
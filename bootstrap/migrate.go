@@ -0,0 +1,77 @@
+package bootstrap
+
+import (
+	"chatdan_backend/config"
+	"chatdan_backend/models"
+	"chatdan_backend/utils"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = `usage: app migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down [n]    revert the last n applied migrations, default 1
+  status      show applied and pending migrations`
+
+// RunMigrate 执行 migrate 子命令
+func RunMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	config.InitConfig()
+	// 迁移数据时需要清除缓存
+	utils.InitCache()
+	models.OpenDB()
+
+	switch args[0] {
+	case "up":
+		applied, err := models.MigrateUp(models.DB)
+		for _, id := range applied {
+			fmt.Println("applied", id)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+		}
+		reverted, err := models.MigrateDown(models.DB, steps)
+		for _, id := range reverted {
+			fmt.Println("reverted", id)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+	case "status":
+		statuses, err := models.GetMigrationStatus(models.DB)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.AppliedAt == nil {
+				fmt.Printf("%-40s pending\n", status.ID)
+			} else {
+				fmt.Printf("%-40s applied at %s\n", status.ID, status.AppliedAt.Format(time.RFC3339))
+			}
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -2,23 +2,52 @@ package config
 
 import (
 	"github.com/caarlos0/env/v8"
+	"time"
 )
 
 var Config struct {
-	Debug             bool   `env:"DEBUG" envDefault:"false"`
-	Mode              string `env:"MODE" envDefault:"dev"`
-	DbType            string `env:"DB_TYPE" envDefault:"sqlite"`
-	DbUrl             string `env:"DB_URL"`
-	RedisUrl          string `env:"REDIS_URL"`
-	AppName           string `env:"APP_NAME" envDefault:"ChatDan"`
-	Hostname          string `env:"HOSTNAME" envDefault:"localhost"`
-	Standalone        bool   `env:"STANDALONE" envDefault:"false"` // if true, go without gateway
-	GatewayType       string `env:"GATEWAY_TYPE" envDefault:"apisix"`
-	ApisixUrl         string `env:"APISIX_URL"`
-	ApisixAdminKey    string `env:"APISIX_ADMIN_KEY"`
-	MeilisearchUrl    string `env:"MEILISEARCH_URL"`
-	MeilisearchApiKey string `env:"MEILISEARCH_API_KEY"`
-	MeilisearchReload bool   `env:"MEILISEARCH_RELOAD" envDefault:"false"`
+	Debug                    bool          `env:"DEBUG" envDefault:"false"`
+	Mode                     string        `env:"MODE" envDefault:"dev"`
+	DbType                   string        `env:"DB_TYPE" envDefault:"sqlite"`
+	DbUrl                    string        `env:"DB_URL"`
+	DbReplicaUrls            []string      `env:"DB_REPLICA_URLS" envSeparator:","`            // read-only replicas, same db type as primary
+	DbReplicaSticky          time.Duration `env:"DB_REPLICA_STICKY" envDefault:"10s"`          // reads of a user go to primary for this duration after the user writes
+	MigrationMode            string        `env:"MIGRATION_MODE" envDefault:"auto"`            // auto: run pending migrations on boot; check: refuse to start if any migration is pending; skip: do nothing
+	CounterReconcileInterval time.Duration `env:"COUNTER_RECONCILE_INTERVAL" envDefault:"24h"` // recompute denormalized counters periodically, 0 to disable
+	TopicViewFlushInterval   time.Duration `env:"TOPIC_VIEW_FLUSH_INTERVAL" envDefault:"10s"`  // buffered topic views are written to database periodically
+	TopicViewDedupeWindow    time.Duration `env:"TOPIC_VIEW_DEDUPE_WINDOW" envDefault:"30m"`   // views of a topic by the same user within this window count once, capped at 10 minutes without redis
+	LinkPreviewFetchInterval time.Duration `env:"LINK_PREVIEW_FETCH_INTERVAL" envDefault:"5s"` // fetch pending link previews periodically, 0 to disable
+	RedisUrl                 string        `env:"REDIS_URL"`
+	StorageType              string        `env:"STORAGE_TYPE" envDefault:"local"`          // local or s3
+	StorageLocalDir          string        `env:"STORAGE_LOCAL_DIR" envDefault:"uploads"`   // directory of local storage
+	StoragePublicUrl         string        `env:"STORAGE_PUBLIC_URL" envDefault:"/uploads"` // url prefix of uploaded files
+	S3Endpoint               string        `env:"S3_ENDPOINT"`                              // s3 compatible endpoint, path-style, e.g. http://minio:9000
+	S3Region                 string        `env:"S3_REGION" envDefault:"us-east-1"`
+	S3Bucket                 string        `env:"S3_BUCKET"`
+	S3AccessKey              string        `env:"S3_ACCESS_KEY"`
+	S3SecretKey              string        `env:"S3_SECRET_KEY"`
+	UploadMaxSize            int           `env:"UPLOAD_MAX_SIZE" envDefault:"10485760"` // max size of an uploaded file in bytes
+	PublicUrl                string        `env:"PUBLIC_URL"`                            // public url of this service used in share links, e.g. https://chatdan.example.com, defaults to the request host
+	ShareCardFont            string        `env:"SHARE_CARD_FONT"`                       // ttf, otf or ttc font file used in share cards, glyphs missing from it fall back to the bundled Go fonts and GB2312 subset of WenQuanYi Micro Hei
+	GuestPowDifficulty       int           `env:"GUEST_POW_DIFFICULTY" envDefault:"18"`  // leading zero bits of the proof of work required for guest posts
+	GuestIPRateLimit         int           `env:"GUEST_IP_RATE_LIMIT" envDefault:"5"`    // max guest posts from an ip within the window
+	GuestBoxRateLimit        int           `env:"GUEST_BOX_RATE_LIMIT" envDefault:"30"`  // max guest posts to a box within the window
+	GuestRateLimitWindow     time.Duration `env:"GUEST_RATE_LIMIT_WINDOW" envDefault:"10m"`
+	ProxyHeader              string        `env:"PROXY_HEADER"`                             // header carrying the client ip set by the reverse proxy, e.g. X-Real-IP
+	TrustedProxies           []string      `env:"TRUSTED_PROXIES" envSeparator:","`         // ips or cidrs of the reverse proxies, PROXY_HEADER is ignored for requests from other addresses
+	WallTimezone             string        `env:"WALL_TIMEZONE" envDefault:"Asia/Shanghai"` // timezone of the daily wall
+	WallPublishTime          string        `env:"WALL_PUBLISH_TIME" envDefault:"00:00"`     // HH:MM, walls created before this time of a day are published on that day
+	WallPublishInterval      time.Duration `env:"WALL_PUBLISH_INTERVAL" envDefault:"1m"`    // check whether a daily wall is due periodically, 0 to disable
+	WallTargetDailyLimit     int           `env:"WALL_TARGET_DAILY_LIMIT" envDefault:"3"`   // max walls with a target a user can post within 24 hours
+	AppName                  string        `env:"APP_NAME" envDefault:"ChatDan"`
+	Hostname                 string        `env:"HOSTNAME" envDefault:"localhost"`
+	Standalone               bool          `env:"STANDALONE" envDefault:"false"` // if true, go without gateway
+	GatewayType              string        `env:"GATEWAY_TYPE" envDefault:"apisix"`
+	ApisixUrl                string        `env:"APISIX_URL"`
+	ApisixAdminKey           string        `env:"APISIX_ADMIN_KEY"`
+	MeilisearchUrl           string        `env:"MEILISEARCH_URL"`
+	MeilisearchApiKey        string        `env:"MEILISEARCH_API_KEY"`
+	MeilisearchReload        bool          `env:"MEILISEARCH_RELOAD" envDefault:"false"`
 }
 
 func InitConfig() {
@@ -4,3 +4,8 @@ import _ "embed"
 
 //go:embed names.json
 var NamesFile []byte
+
+// CJKFontFile 文泉驿微米黑的 GB2312 子集，用于绘制分享卡片中的中文
+//
+//go:embed wqy-microhei-gb2312.ttf
+var CJKFontFile []byte
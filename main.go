@@ -3,6 +3,7 @@ package main
 import (
 	"chatdan_backend/bootstrap"
 	_ "chatdan_backend/docs"
+	"chatdan_backend/models"
 	"chatdan_backend/utils"
 	"go.uber.org/zap"
 	"log"
@@ -46,6 +47,9 @@ import (
 // @tag.name Chat Module
 // @tag.description 聊天模块
 
+// @tag.name Admin Module
+// @tag.description 管理模块
+
 // @contact.name   JingYiJun
 // @contact.url    https://www.jingyijun.xyz
 // @contact.email  jingyijun3104@outlook.com
@@ -63,6 +67,13 @@ import (
 //go:generate go install github.com/swaggo/swag/cmd/swag@latest
 //go:generate swag init
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := bootstrap.RunMigrate(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	app := bootstrap.InitFiberApp()
 
 	go func() {
@@ -83,5 +94,8 @@ func main() {
 		utils.Logger.Error("app shutdown error", zap.Error(err))
 	}
 
+	// write buffered data to database
+	models.StopTopicViewFlusher()
+
 	_ = utils.Logger.Sync()
 }
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"chatdan_backend/utils"
+	"gorm.io/gorm"
+	"time"
+)
+
+// DeanonymizeLog 管理员查看匿名内容发布者的记录
+type DeanonymizeLog struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	AdminID    int       `json:"admin_id" gorm:"not null;index"`
+	TargetType string    `json:"target_type" gorm:"size:16;not null;index:idx_deanonymize_log_target,priority:1"`
+	TargetID   int       `json:"target_id" gorm:"not null;index:idx_deanonymize_log_target,priority:2"`
+	PosterID   int       `json:"poster_id" gorm:"not null"` // 匿名内容的发布者，未登录用户的提问为 0
+	Reason     string    `json:"reason" gorm:"not null"`
+}
+
+func (DeanonymizeLog) TableName() string {
+	return "deanonymize_log"
+}
+
+func (l DeanonymizeLog) GetID() int {
+	return l.ID
+}
+
+// anonymousTarget 可以匿名的内容对应的模型和发布者的列
+type anonymousTarget struct {
+	model        any
+	posterColumn string
+}
+
+var anonymousTargets = map[string]anonymousTarget{
+	ContentTypeTopic:       {&Topic{}, "poster_id"},
+	ContentTypeComment:     {&Comment{}, "poster_id"},
+	ContentTypePost:        {&Post{}, "poster_id"},
+	ContentTypeChannel:     {&Channel{}, "owner_id"},
+	ContentTypeWall:        {&Wall{}, "poster_id"},
+	ContentTypeWallComment: {&WallComment{}, "poster_id"},
+}
+
+// Deanonymize 查询匿名内容的发布者并记录，包括已删除的内容
+// 内容不存在时返回 gorm.ErrRecordNotFound，内容不是匿名的时候返回 400
+func Deanonymize(tx *gorm.DB, adminID int, targetType string, targetID int, reason string) (log DeanonymizeLog, err error) {
+	target, ok := anonymousTargets[targetType]
+	if !ok {
+		return log, utils.BadRequest("不支持的内容类型")
+	}
+
+	var result struct {
+		PosterID    int
+		IsAnonymous bool
+	}
+	if err = tx.Unscoped().Model(target.model).
+		Select(target.posterColumn+" AS poster_id", "is_anonymous").
+		Where("id = ?", targetID).Take(&result).Error; err != nil {
+		return
+	}
+	if !result.IsAnonymous {
+		return log, utils.BadRequest("内容不是匿名的")
+	}
+
+	log = DeanonymizeLog{
+		AdminID:    adminID,
+		TargetType: targetType,
+		TargetID:   targetID,
+		PosterID:   result.PosterID,
+		Reason:     reason,
+	}
+	err = tx.Create(&log).Error
+	return
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"chatdan_backend/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const MaxContentAttachments = 9 // 每条内容最多引用的附件数
+
+// Attachment 上传的文件，按照内容哈希去重，相同的文件只存储一次
+type Attachment struct {
+	ID           int       `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Hash         string    `json:"-" gorm:"size:64;not null;uniqueIndex"` // 删除元数据后内容的 sha256
+	Key          string    `json:"-" gorm:"size:256;not null"`            // 存储中的路径
+	ThumbnailKey *string   `json:"-" gorm:"size:256"`                     // 缩略图在存储中的路径，没有缩略图时为空
+	MimeType     string    `json:"mime_type" gorm:"size:64;not null"`
+	Size         int       `json:"size" gorm:"not null"`
+	Width        int       `json:"width" gorm:"not null;default:0"` // 图片的宽高，其他文件为 0
+	Height       int       `json:"height" gorm:"not null;default:0"`
+	UploaderID   int       `json:"-" gorm:"not null;index"` // 第一次上传的用户
+}
+
+func (Attachment) TableName() string {
+	return "attachment"
+}
+
+// UserAttachment 用户上传过的附件，用户只能引用自己上传过的附件
+type UserAttachment struct {
+	UserID       int       `gorm:"primaryKey"`
+	AttachmentID int       `gorm:"primaryKey"`
+	CreatedAt    time.Time `gorm:"not null"`
+}
+
+func (UserAttachment) TableName() string {
+	return "user_attachment"
+}
+
+// ContentAttachment 内容引用的附件，Position 为附件在内容中的顺序
+type ContentAttachment struct {
+	TargetType   string `gorm:"size:16;primaryKey"`
+	TargetID     int    `gorm:"primaryKey"`
+	AttachmentID int    `gorm:"primaryKey;index"`
+	Position     int    `gorm:"not null;default:0"`
+}
+
+// SaveAttachment 存储上传的文件并记录上传者，已经存在相同内容的文件时只记录上传者
+// 调用前需要检查文件类型，图片的元数据在此删除
+func SaveAttachment(tx *gorm.DB, userID int, data []byte, contentType string) (attachment Attachment, created bool, err error) {
+	data = utils.StripImageMetadata(data, contentType)
+	hash := sha256.Sum256(data)
+	attachment.Hash = hex.EncodeToString(hash[:])
+
+	err = tx.Where("hash = ?", attachment.Hash).Take(&attachment).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return
+	}
+	if err == gorm.ErrRecordNotFound {
+		if err = storeAttachment(&attachment, data, contentType); err != nil {
+			return
+		}
+		attachment.UploaderID = userID
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&attachment)
+		if result.Error != nil {
+			return attachment, false, result.Error
+		}
+		// 同时上传相同的文件，使用先创建的记录，存储的内容相同
+		if result.RowsAffected == 0 {
+			if err = tx.Where("hash = ?", attachment.Hash).Take(&attachment).Error; err != nil {
+				return
+			}
+		} else {
+			created = true
+		}
+	}
+
+	err = tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserAttachment{UserID: userID, AttachmentID: attachment.ID}).Error
+	return
+}
+
+// storeAttachment 写入文件和缩略图，路径由哈希决定，重复写入的内容相同
+func storeAttachment(attachment *Attachment, data []byte, contentType string) (err error) {
+	width, height, err := utils.ImageConfig(data, contentType)
+	if err == utils.ErrImageTooLarge {
+		return utils.BadRequest("图片尺寸过大")
+	} else if err != nil {
+		return utils.BadRequest("无法解析图片")
+	}
+	thumbnail, err := utils.GenerateThumbnail(data, contentType)
+	if err != nil {
+		return utils.BadRequest("无法解析图片")
+	}
+
+	hash := attachment.Hash
+	attachment.Key = fmt.Sprintf("%s/%s/%s%s", hash[:2], hash[2:4], hash, utils.UploadContentTypes[contentType])
+	attachment.MimeType = contentType
+	attachment.Size = len(data)
+	attachment.Width = width
+	attachment.Height = height
+	if err = utils.FileStorage.Put(attachment.Key, data, contentType); err != nil {
+		return
+	}
+	if thumbnail != nil {
+		thumbnailKey := fmt.Sprintf("thumbnails/%s/%s/%s.jpg", hash[:2], hash[2:4], hash)
+		if err = utils.FileStorage.Put(thumbnailKey, thumbnail, "image/jpeg"); err != nil {
+			return
+		}
+		attachment.ThumbnailKey = &thumbnailKey
+	}
+	return nil
+}
+
+// SetContentAttachments 替换内容引用的附件，附件必须是 userID 上传过的
+// 需要在创建或者修改内容的事务中调用
+func SetContentAttachments(tx *gorm.DB, targetType string, targetID int, userID int, attachmentIDs []int) (err error) {
+	attachmentIDs = uniqueIDs(attachmentIDs)
+	if len(attachmentIDs) > MaxContentAttachments {
+		return utils.BadRequest(fmt.Sprintf("最多添加 %d 个附件", MaxContentAttachments))
+	}
+	if len(attachmentIDs) > 0 {
+		var count int64
+		err = tx.Model(&UserAttachment{}).
+			Where("user_id = ? AND attachment_id IN ?", userID, attachmentIDs).
+			Count(&count).Error
+		if err != nil {
+			return
+		}
+		if int(count) != len(attachmentIDs) {
+			return utils.BadRequest("附件不存在")
+		}
+	}
+
+	err = tx.Where("target_type = ? AND target_id = ?", targetType, targetID).Delete(&ContentAttachment{}).Error
+	if err != nil || len(attachmentIDs) == 0 {
+		return
+	}
+	contentAttachments := make([]ContentAttachment, len(attachmentIDs))
+	for i, attachmentID := range attachmentIDs {
+		contentAttachments[i] = ContentAttachment{TargetType: targetType, TargetID: targetID, AttachmentID: attachmentID, Position: i}
+	}
+	return tx.Create(&contentAttachments).Error
+}
+
+// LoadContentAttachments 批量查询内容引用的附件，按照 Position 排序
+func LoadContentAttachments(tx *gorm.DB, targetType string, targetIDs []int) (attachmentMap map[int][]Attachment, err error) {
+	attachmentMap = make(map[int][]Attachment)
+	if len(targetIDs) == 0 {
+		return
+	}
+
+	var results []struct {
+		Attachment
+		TargetID int
+	}
+	err = tx.Model(&Attachment{}).
+		Select("attachment.*, content_attachment.target_id").
+		Joins("JOIN content_attachment ON content_attachment.attachment_id = attachment.id").
+		Where("content_attachment.target_type = ? AND content_attachment.target_id IN ?", targetType, targetIDs).
+		Order("content_attachment.position").
+		Scan(&results).Error
+	if err != nil {
+		return
+	}
+	for _, result := range results {
+		attachmentMap[result.TargetID] = append(attachmentMap[result.TargetID], result.Attachment)
+	}
+	return
+}
+
+// uniqueIDs 去除重复的 ID，保持原有的顺序
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
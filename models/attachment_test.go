@@ -0,0 +1,237 @@
+package models
+
+import (
+	"bytes"
+	"chatdan_backend/utils"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestJPEG 生成带有 EXIF 段的 JPEG 图片
+func newTestJPEG(t *testing.T, width, height int, shade uint8) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: shade, G: uint8(x), B: uint8(y), A: 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	exif := []byte("Exif\x00\x00GPS 31.2304N 121.4737E")
+	segment := append([]byte{0xFF, 0xE1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}, exif...)
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+// newTestWebP 构造带有 EXIF 和 XMP 块的 WebP 容器，图像数据不需要能够解码
+func newTestWebP() []byte {
+	chunk := func(fourCC string, payload []byte) []byte {
+		data := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(payload)))
+		data = append(data, payload...)
+		if len(payload)%2 == 1 {
+			data = append(data, 0)
+		}
+		return data
+	}
+	var body []byte
+	body = append(body, "WEBP"...)
+	body = append(body, chunk("VP8X", []byte{0x0C, 0, 0, 0, 15, 0, 0, 15, 0, 0})...)
+	body = append(body, chunk("VP8L", []byte{0x2F, 0x0F, 0xC0, 0x03, 0x00})...)
+	body = append(body, chunk("EXIF", []byte("GPS 31.2304N 121.4737E"))...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta>GPS</x:xmpmeta>"))...)
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+func TestAttachment(t *testing.T) {
+	db := newTestDB(t, "test_attachment")
+
+	dir := t.TempDir()
+	oldStorage := utils.FileStorage
+	utils.FileStorage = utils.NewLocalStorage(dir, "/uploads")
+	defer func() {
+		utils.FileStorage = oldStorage
+	}()
+
+	// 删除 EXIF，生成缩略图
+	data := newTestJPEG(t, 640, 480, 10)
+	if !bytes.Contains(data, []byte("GPS")) {
+		t.Fatal("test image should contain exif")
+	}
+	attachment, created, err := SaveAttachment(db, 1, data, utils.DetectContentType(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || attachment.ID == 0 || attachment.MimeType != "image/jpeg" || attachment.Width != 640 || attachment.Height != 480 {
+		t.Fatalf("unexpected attachment %+v", attachment)
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(attachment.Key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("GPS")) || len(stored) != attachment.Size {
+		t.Errorf("exif should be stripped, size %d, stored %d", attachment.Size, len(stored))
+	}
+	if attachment.ThumbnailKey == nil {
+		t.Fatal("thumbnail should be generated")
+	}
+	thumbnail, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(*attachment.ThumbnailKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != utils.ThumbnailMaxSize || config.Height != 240 {
+		t.Errorf("thumbnail size %dx%d", config.Width, config.Height)
+	}
+
+	// 相同内容的文件只存储一次，记录新的上传者
+	duplicated, created, err := SaveAttachment(db, 2, data, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || duplicated.ID != attachment.ID {
+		t.Errorf("duplicated upload should reuse attachment %d, got %d", attachment.ID, duplicated.ID)
+	}
+
+	// 小图片不生成缩略图
+	small, _, err := SaveAttachment(db, 1, newTestJPEG(t, 64, 32, 20), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.ThumbnailKey != nil {
+		t.Error("small image should not have thumbnail")
+	}
+
+	// WebP 删除 EXIF 和 XMP 块，清除 VP8X 中的标志位
+	webP := newTestWebP()
+	webPAttachment, _, err := SaveAttachment(db, 1, webP, utils.DetectContentType(webP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webPAttachment.MimeType != "image/webp" {
+		t.Fatalf("unexpected webp attachment %+v", webPAttachment)
+	}
+	stored, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(webPAttachment.Key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("GPS")) || stored[20]&0x0C != 0 ||
+		int(binary.LittleEndian.Uint32(stored[4:8])) != len(stored)-8 {
+		t.Errorf("webp metadata should be stripped, got %q", stored)
+	}
+
+	// 只能引用自己上传过的附件，按照引用的顺序返回
+	if err = SetContentAttachments(db, ContentTypeTopic, 1, 1, []int{small.ID, attachment.ID, small.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetContentAttachments(db, ContentTypeTopic, 2, 2, []int{small.ID}); err == nil {
+		t.Error("user 2 should not reference attachment uploaded by user 1")
+	}
+	if err = SetContentAttachments(db, ContentTypeTopic, 2, 2, []int{attachment.ID}); err != nil {
+		t.Fatal(err)
+	}
+	attachmentMap, err := LoadContentAttachments(db, ContentTypeTopic, []int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachmentMap[1]) != 2 || attachmentMap[1][0].ID != small.ID || attachmentMap[1][1].ID != attachment.ID {
+		t.Errorf("unexpected attachments of topic 1: %+v", attachmentMap[1])
+	}
+	if len(attachmentMap[2]) != 1 || len(attachmentMap[3]) != 0 {
+		t.Errorf("unexpected attachments: %+v", attachmentMap)
+	}
+
+	// 替换为空时删除所有引用
+	if err = SetContentAttachments(db, ContentTypeTopic, 1, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	attachmentMap, err = LoadContentAttachments(db, ContentTypeTopic, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachmentMap[1]) != 0 {
+		t.Errorf("attachments should be cleared, got %+v", attachmentMap[1])
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		objects = map[string][]byte{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=access/") ||
+			!strings.Contains(authorization, "/us-east-1/s3/aws4_request") ||
+			!strings.Contains(authorization, "SignedHeaders=host;x-amz-content-sha256;x-amz-date") ||
+			r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = body
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(body)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	storage := &utils.S3Storage{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Client:    server.Client(),
+	}
+	if err := storage.Put("ab/cd/file.png", []byte("content"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects["/bucket/ab/cd/file.png"]; !ok {
+		t.Fatalf("object not stored, got %v", objects)
+	}
+	reader, err := storage.Get("ab/cd/file.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(reader)
+	_ = reader.Close()
+	if string(content) != "content" {
+		t.Errorf("unexpected content %q", content)
+	}
+	if storage.URL("ab/cd/file.png") != server.URL+"/bucket/ab/cd/file.png" {
+		t.Errorf("unexpected url %s", storage.URL("ab/cd/file.png"))
+	}
+	if err = storage.Delete("ab/cd/file.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.Get("ab/cd/file.png"); err == nil {
+		t.Error("deleted object should not be found")
+	}
+}
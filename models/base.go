@@ -7,3 +7,14 @@ type Map = map[string]any
 var CopyOption = copier.Option{IgnoreEmpty: true, DeepCopy: true}
 
 type EmptyStruct struct{}
+
+// 内容的类型，与表名相同，用于修改历史、提及、链接预览和附件关联到具体的内容
+const (
+	ContentTypeTopic       = "topic"
+	ContentTypeComment     = "comment"
+	ContentTypePost        = "post"
+	ContentTypeChannel     = "channel"
+	ContentTypeWall        = "wall"
+	ContentTypeWallComment = "wall_comment"
+	ContentTypeMessage     = "chat_message"
+)
@@ -0,0 +1,296 @@
+// Package baseline 引入数据库迁移之前的表结构快照，只用于初始化迁移 20230601000000_init，不要修改
+// 之后新增的字段和索引在各自的迁移中添加，否则已有的数据库在执行初始化迁移时就会提前创建这些字段和索引
+package baseline
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type User struct {
+	ID             int
+	Username       string    `gorm:"index,size:100"`
+	Email          *string   `gorm:"index"`
+	HashedPassword string    `gorm:"size:256"`
+	LoginTime      time.Time `gorm:"autoUpdateTime"`
+	RegisterTime   time.Time `gorm:"autoCreateTime"`
+	DeletedAt      gorm.DeletedAt
+	Banned         bool
+	IsAdmin        bool
+	Avatar         *string `gorm:"size:256"`
+	Introduction   *string `gorm:"size:256"`
+
+	UserJwtSecret  *UserJwtSecret `gorm:"foreignKey:UserID"`
+	ViewedTopics   []*Topic       `gorm:"many2many:topic_user_views"`
+	FavoriteTopics []*Topic       `gorm:"many2many:topic_user_favorites"`
+	Followers      []*User        `gorm:"many2many:user_followers"`
+
+	TopicCount          int `gorm:"not null;default:0"`
+	CommentCount        int `gorm:"not null;default:0"`
+	FavoriteTopicsCount int `gorm:"not null;default:0"`
+	FollowersCount      int `gorm:"not null;default:0"`
+	FollowingUsersCount int `gorm:"not null;default:0"`
+}
+
+func (User) TableName() string {
+	return "user"
+}
+
+type UserFollows struct {
+	UserID     int `gorm:"primaryKey"`
+	FollowerID int `gorm:"primaryKey"`
+	CreatedAt  time.Time
+}
+
+type UserJwtSecret struct {
+	UserID int    `gorm:"primaryKey"`
+	Secret string `gorm:"size:256"`
+}
+
+func (UserJwtSecret) TableName() string {
+	return "user_jwt_secret"
+}
+
+type Box struct {
+	ID        int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt
+	Title     string
+
+	OwnerID int
+	Owner   *User `gorm:"foreignKey:OwnerID"`
+	Posts   []Post
+
+	PostCount int
+	ViewCount int
+}
+
+func (Box) TableName() string {
+	return "box"
+}
+
+type Post struct {
+	ID          int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt
+	Content     string
+	IsPublic    bool
+	IsAnonymous bool
+
+	PosterID int
+	Poster   *User `gorm:"foreignKey:PosterID"`
+	BoxID    int
+	Box      *Box `gorm:"foreignKey:BoxID"`
+	Channel  []Channel
+
+	ChannelCount int
+	ViewCount    int
+}
+
+type Channel struct {
+	ID        int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt
+	Content   string
+
+	OwnerID int
+	Owner   *User `gorm:"foreignKey:OwnerID"`
+	PostID  int
+	Post    *Post `gorm:"foreignKey:PostID"`
+}
+
+type Wall struct {
+	ID          int
+	CreatedAt   time.Time      `gorm:"index"`
+	UpdatedAt   time.Time      `gorm:"index"`
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Content     string
+	Visibility  string
+	IsAnonymous bool
+
+	PosterID int
+	Poster   *User `gorm:"foreignKey:PosterID"`
+}
+
+type Division struct {
+	ID             int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	Name           string         `gorm:"not null;unique"`
+	Description    *string
+	PinnedTopicIDs []int `gorm:"serializer:json;not null;default:\"[]\""`
+}
+
+type Topic struct {
+	ID          int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Title       string         `gorm:"not null"`
+	Content     string         `gorm:"not null"`
+	IsAnonymous bool           `gorm:"not null;default:false"`
+	Anonyname   *string
+	IsHidden    bool `gorm:"not null;default:false"`
+
+	PosterID       int       `gorm:"not null"`
+	Poster         *User     `gorm:"foreignKey:PosterID"`
+	DivisionID     int       `gorm:"not null"`
+	Division       *Division `gorm:"foreignKey:DivisionID"`
+	Tags           []*Tag    `gorm:"many2many:topic_tags"`
+	ViewedUsers    []*User   `gorm:"many2many:topic_user_views"`
+	FavoredUsers   []*User   `gorm:"many2many:topic_user_favorites"`
+	LikedUsers     []*User   `gorm:"many2many:topic_user_likes"`
+	AnonymousUsers []*User   `gorm:"many2many:topic_anonyname_mapping"`
+
+	ViewCount    int `gorm:"not null;default:0"`
+	LikeCount    int `gorm:"not null;default:0"`
+	DislikeCount int `gorm:"not null;default:0"`
+	CommentCount int `gorm:"not null;default:0"`
+	FavorCount   int `gorm:"not null;default:0"`
+}
+
+func (Topic) TableName() string {
+	return "topic"
+}
+
+type Comment struct {
+	ID          int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Content     string         `gorm:"not null"`
+	IsAnonymous bool           `gorm:"not null;default:false"`
+	Anonyname   *string
+	Ranking     int `gorm:"not null;default:0"`
+	ReplyToID   *int
+	IsHidden    bool `gorm:"not null;default:false"`
+
+	PosterID   int     `gorm:"not null"`
+	Poster     *User   `gorm:"foreignKey:PosterID"`
+	TopicID    int     `gorm:"not null"`
+	Topic      *Topic  `gorm:"foreignKey:TopicID"`
+	LikedUsers []*User `gorm:"many2many:comment_user_likes"`
+
+	LikeCount    int `gorm:"not null;default:0"`
+	DislikeCount int `gorm:"not null;default:0"`
+}
+
+func (Comment) TableName() string {
+	return "comment"
+}
+
+type Tag struct {
+	ID          int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Name        string         `gorm:"not null;unique"`
+	Temperature int            `gorm:"not null;default:0;index"`
+
+	Topics []*Topic `gorm:"many2many:topic_tags"`
+}
+
+func (Tag) TableName() string {
+	return "tag"
+}
+
+type TopicUserLikes struct {
+	UserID    int `gorm:"primaryKey"`
+	TopicID   int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	LikeData  int `gorm:"not null;default:0"`
+}
+
+type TopicUserFavorites struct {
+	UserID    int `gorm:"primaryKey"`
+	TopicID   int `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+type TopicUserViews struct {
+	UserID    int `gorm:"primaryKey"`
+	TopicID   int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Count     int `gorm:"not null;default:0"`
+}
+
+type TopicAnonynameMapping struct {
+	TopicID   int    `gorm:"primaryKey"`
+	UserID    int    `gorm:"primaryKey"`
+	Anonyname string `gorm:"not null"`
+}
+
+type CommentUserLikes struct {
+	UserID    int `gorm:"primaryKey"`
+	CommentID int `gorm:"primaryKey"`
+	CreatedAt time.Time
+	LikeData  int `gorm:"not null;default:0"`
+}
+
+type Chat struct {
+	ID        int
+	CreatedAt time.Time `gorm:"index"`
+	UpdatedAt time.Time `gorm:"index"`
+
+	OneUserID          int   `gorm:"index;index:idx_chat_one_another,priority:1"`
+	OneUser            *User `gorm:"foreignKey:OneUserID"`
+	AnotherUserID      int   `gorm:"index;index:idx_chat_one_another,priority:2"`
+	AnotherUser        *User `gorm:"foreignKey:AnotherUserID"`
+	LastMessageID      int
+	LastMessageContent string
+	Messages           []ChatMessage
+
+	MessageCount int
+}
+
+type ChatMessage struct {
+	ID        int
+	CreatedAt time.Time `gorm:"index"`
+	Content   string
+
+	ChatID     int   `gorm:"index"`
+	Chat       *Chat `gorm:"foreignKey:ChatID"`
+	FromUserID int
+	FromUser   *User `gorm:"foreignKey:FromUserID"`
+	ToUserID   int
+	ToUser     *User `gorm:"foreignKey:ToUserID"`
+}
+
+// Migrate 按照快照创建表结构，已有的表只会补充缺少的字段和索引
+func Migrate(tx *gorm.DB) (err error) {
+	for _, joinTable := range []struct {
+		model     any
+		field     string
+		joinTable any
+	}{
+		{User{}, "Followers", &UserFollows{}},
+		{Topic{}, "LikedUsers", &TopicUserLikes{}},
+		{Topic{}, "FavoredUsers", &TopicUserFavorites{}},
+		{Topic{}, "ViewedUsers", &TopicUserViews{}},
+		{Topic{}, "AnonymousUsers", &TopicAnonynameMapping{}},
+		{Comment{}, "LikedUsers", &CommentUserLikes{}},
+	} {
+		if err = tx.SetupJoinTable(joinTable.model, joinTable.field, joinTable.joinTable); err != nil {
+			return
+		}
+	}
+	return tx.AutoMigrate(
+		User{},
+		UserJwtSecret{},
+		Box{},
+		Post{},
+		Channel{},
+		Wall{},
+		Division{},
+		Topic{},
+		Comment{},
+		Tag{},
+		Chat{},
+		ChatMessage{},
+	)
+}
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"chatdan_backend/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/thanhpk/randstr"
 	"gorm.io/gorm"
 	"time"
 )
@@ -15,6 +19,17 @@ type Box struct {
 	DeletedAt gorm.DeletedAt `json:"deleted_at"`
 	Title     string         `json:"title"`
 
+	// 提问箱设置
+	Description      string     `json:"description" gorm:"size:500;not null;default:''"`
+	QuestionTemplate string     `json:"question_template" gorm:"size:500;not null;default:''"` // 提问的提示或模板，展示给提问者
+	Status           string     `json:"status" gorm:"size:16;not null;default:open"`           // open 或 closed
+	ClosesAt         *time.Time `json:"closes_at"`                                             // 定时关闭的时间，到达后视为关闭
+	AnonymousPolicy  string     `json:"anonymous_policy" gorm:"size:16;not null;default:allowed"`
+	AllowGuest       bool       `json:"allow_guest" gorm:"not null;default:false"` // 是否接受未登录用户的提问
+
+	// 分享链接，未登录用户可以通过它查看提问箱公开的问答
+	ShareSlug string `json:"share_slug" gorm:"size:32;uniqueIndex"`
+
 	// 关联数据
 	OwnerID int    `json:"owner_id"`
 	Owner   *User  `json:"owner" gorm:"foreignKey:OwnerID"`
@@ -33,6 +48,118 @@ func (b Box) GetID() int {
 	return b.ID
 }
 
+func (b *Box) BeforeCreate(_ *gorm.DB) error {
+	if b.ShareSlug == "" {
+		b.ShareSlug = NewShareSlug()
+	}
+	return nil
+}
+
+// ShareSlugLength 分享链接的长度，base62 字符，不可猜测
+const ShareSlugLength = 16
+
+func NewShareSlug() string {
+	return randstr.Base62(ShareSlugLength)
+}
+
+const (
+	BoxOpen   = "open"
+	BoxClosed = "closed"
+)
+
+// 提问箱的匿名策略
+const (
+	AnonymousAllowed   = "allowed"   // 提问者选择是否匿名，默认匿名
+	AnonymousRequired  = "required"  // 只接受匿名提问
+	AnonymousForbidden = "forbidden" // 只接受实名提问
+)
+
+// IsOpen 提问箱是否接受提问，到达定时关闭的时间后视为关闭
+func (b *Box) IsOpen(now time.Time) bool {
+	return b.Status != BoxClosed && (b.ClosesAt == nil || now.Before(*b.ClosesAt))
+}
+
+// ResolveAnonymous 按照匿名策略决定提问是否匿名，isAnonymous 为空时使用策略的默认值
+func (b *Box) ResolveAnonymous(isAnonymous *bool) (bool, error) {
+	switch b.AnonymousPolicy {
+	case AnonymousRequired:
+		if isAnonymous != nil && !*isAnonymous {
+			return false, utils.BadRequest("该提问箱只接受匿名提问")
+		}
+		return true, nil
+	case AnonymousForbidden:
+		if isAnonymous != nil && *isAnonymous {
+			return false, utils.BadRequest("该提问箱不接受匿名提问")
+		}
+		return false, nil
+	default:
+		return isAnonymous == nil || *isAnonymous, nil
+	}
+}
+
+// CheckAcceptPost 检查提问箱是否接受提问，返回提问是否匿名
+// guest 为未登录的提问者，只有 AllowGuest 的提问箱接受，并且只能匿名提问
+func (b *Box) CheckAcceptPost(isAnonymous *bool, guest bool) (bool, error) {
+	if !b.IsOpen(time.Now()) {
+		return false, utils.Forbidden("提问箱已关闭")
+	}
+	if guest {
+		if !b.AllowGuest {
+			return false, utils.Forbidden("该提问箱不接受未登录用户的提问")
+		}
+		if isAnonymous != nil && !*isAnonymous {
+			return false, utils.BadRequest("未登录用户只能匿名提问")
+		}
+		anonymous := true
+		isAnonymous = &anonymous
+	}
+	return b.ResolveAnonymous(isAnonymous)
+}
+
+// BoxGuestBlock 提问箱的主人屏蔽的未登录提问者，指纹或者 IP 任意一个相同都视为同一个提问者
+// 只更换指纹或者只更换 IP 都不能绕过屏蔽
+type BoxGuestBlock struct {
+	ID          int       `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	BoxID       int       `json:"message_box_id" gorm:"not null;uniqueIndex:idx_box_guest_block,priority:1;index:idx_box_guest_block_ip,priority:1"`
+	Fingerprint string    `json:"-" gorm:"size:64;not null;uniqueIndex:idx_box_guest_block,priority:2"`
+	IPHash      string    `json:"-" gorm:"size:64;not null;default:'';index:idx_box_guest_block_ip,priority:2"`
+	PostID      int       `json:"post_id"` // 屏蔽时引用的提问
+}
+
+func (BoxGuestBlock) TableName() string {
+	return "box_guest_block"
+}
+
+func (b BoxGuestBlock) GetID() int {
+	return b.ID
+}
+
+// GuestFingerprint 未登录提问者的指纹摘要，客户端没有提供指纹时使用 IP
+// 只保存摘要，提问箱的主人通过提问屏蔽，不能看到指纹
+func GuestFingerprint(clientFingerprint, ip string) string {
+	if clientFingerprint == "" {
+		return GuestIPHash(ip)
+	}
+	sum := sha256.Sum256([]byte("client:" + clientFingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+// GuestIPHash 未登录提问者的 IP 摘要
+func GuestIPHash(ip string) string {
+	sum := sha256.Sum256([]byte("ip:" + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsGuestBlocked 未登录提问者的指纹或者 IP 是否被提问箱屏蔽
+func IsGuestBlocked(tx *gorm.DB, boxID int, fingerprint, ipHash string) (bool, error) {
+	var blocked int64
+	err := tx.Model(&BoxGuestBlock{}).
+		Where("box_id = ? AND (fingerprint = ? OR ip_hash = ?)", boxID, fingerprint, ipHash).
+		Count(&blocked).Error
+	return blocked > 0, err
+}
+
 type BoxSearchModel struct {
 	ID        int    `json:"id"`
 	CreatedAt int    `json:"created_at"`
@@ -104,8 +231,24 @@ type Post struct {
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"deleted_at"`
 	Content     string         `json:"content"`
+	ContentHTML string         `json:"content_html"` // 渲染后的内容
 	IsPublic    bool           `json:"is_public"`    // true if the post is public
 	IsAnonymous bool           `json:"is_anonymous"` // true if the post is anonymous
+	Anonyname   *string        `json:"anonyname"`    // 匿名时提问者在这个提问中的昵称，与追问使用的昵称相同
+
+	// 回答状态，由提问箱的主人设置
+	AnswerStatus   string     `json:"answer_status" gorm:"size:16;not null;default:pending;index"`
+	AnsweredAt     *time.Time `json:"answered_at"`
+	IsAnswerPublic bool       `json:"is_answer_public" gorm:"not null;default:false"` // 提问不公开时，是否公开提问箱主人的回答
+	PinnedAt       *time.Time `json:"pinned_at" gorm:"index"`                         // 置顶的时间，未置顶时为空
+
+	// 分享链接，只有公开的提问或者公开了回答的提问可以通过它查看
+	ShareSlug string `json:"share_slug" gorm:"size:32;uniqueIndex"`
+
+	// 未登录用户的提问，PosterID 为 0
+	IsGuest          bool   `json:"is_guest" gorm:"not null;default:false"`
+	GuestFingerprint string `json:"-" gorm:"size:64;not null;default:''"` // 未登录提问者的指纹摘要，用于屏蔽
+	GuestIPHash      string `json:"-" gorm:"size:64;not null;default:''"` // 未登录提问者的 IP 摘要，用于屏蔽
 
 	// 关联数据
 	PosterID int       `json:"poster_id"`
@@ -119,6 +262,26 @@ type Post struct {
 	ViewCount    int `json:"view_count"`    // 浏览数
 }
 
+func (Post) TableName() string {
+	return "post"
+}
+
+func (p Post) GetID() int {
+	return p.ID
+}
+
+func (p *Post) BeforeCreate(_ *gorm.DB) error {
+	if p.ShareSlug == "" {
+		p.ShareSlug = NewShareSlug()
+	}
+	return nil
+}
+
+// IsShareable 提问是否可以通过分享链接查看，即未登录用户是否可以查看提问或者回答
+func (p *Post) IsShareable() bool {
+	return p.IsPublic || (p.IsAnswerPublic && p.AnswerStatus == AnswerAnswered)
+}
+
 func (p *Post) Visibility() string {
 	if p.IsPublic {
 		return Public
@@ -131,6 +294,48 @@ const (
 	Public         = "public"
 )
 
+// 提问的回答状态
+// 按照字典序倒序为 pending、ignored、answered，按照 answer_status 倒序排序时未回答的提问排在前面
+const (
+	AnswerPending  = "pending"
+	AnswerAnswered = "answered"
+	AnswerIgnored  = "ignored"
+)
+
+// PostAccess 用户对提问及其回复的访问权限
+type PostAccess int
+
+const (
+	PostAccessNone   PostAccess = iota // 不可见
+	PostAccessAnswer                   // 只能查看提问箱主人公开的回答，提问的内容和提问者不可见
+	PostAccessFull                     // 可以查看提问和所有回复
+)
+
+// AccessOf 返回用户对提问及其回复的访问权限，需要预加载 Box
+// 提问箱的主人和提问者可以查看所有内容，公开的提问所有人可以查看
+// 不公开的提问在回答并公开回答后，所有人可以查看提问箱主人的回答
+func (p *Post) AccessOf(userID int) PostAccess {
+	if p.IsPublic || (p.PosterID != 0 && userID == p.PosterID) || (p.Box != nil && userID == p.Box.OwnerID) {
+		return PostAccessFull
+	}
+	if p.IsAnswerPublic && p.AnswerStatus == AnswerAnswered {
+		return PostAccessAnswer
+	}
+	return PostAccessNone
+}
+
+// SetAnswerStatus 设置回答状态，第一次标记为已回答时记录回答时间
+func (p *Post) SetAnswerStatus(status string, now time.Time) {
+	if status == AnswerAnswered {
+		if p.AnswerStatus != AnswerAnswered || p.AnsweredAt == nil {
+			p.AnsweredAt = &now
+		}
+	} else {
+		p.AnsweredAt = nil
+	}
+	p.AnswerStatus = status
+}
+
 // Channel 回复、追问、追答
 // 一个提问包含一个回复 Thread，Thread 里的元素是追问追答的 Channel，即 Thread = []Channel
 type Channel struct {
@@ -141,9 +346,51 @@ type Channel struct {
 	DeletedAt gorm.DeletedAt `json:"deleted_at"`
 	Content   string         `json:"content"`
 
+	// 匿名的回复使用回复者在这个提问中的昵称
+	IsAnonymous bool    `json:"is_anonymous" gorm:"not null;default:false"`
+	Anonyname   *string `json:"anonyname"`
+
 	// 关联数据
 	OwnerID int   `json:"owner_id"`
 	Owner   *User `json:"owner" gorm:"foreignKey:OwnerID"`
 	PostID  int   `json:"post_id"`
 	Post    *Post `json:"post" gorm:"foreignKey:PostID"`
 }
+
+func (Channel) TableName() string {
+	return "channel"
+}
+
+func (c Channel) GetID() int {
+	return c.ID
+}
+
+// PostAnonynameMapping 提问中每个参与者的匿名昵称，提问和回复使用同一个昵称
+// 未登录的提问者 UserID 为 0
+type PostAnonynameMapping struct {
+	PostID    int    `json:"post_id" gorm:"primaryKey"`
+	UserID    int    `json:"user_id" gorm:"primaryKey"`
+	Anonyname string `json:"anonyname" gorm:"not null"`
+}
+
+func (PostAnonynameMapping) TableName() string {
+	return "post_anonyname_mapping"
+}
+
+// FindOrGeneratePostAnonyname 用户在提问及其回复中使用的匿名昵称
+func FindOrGeneratePostAnonyname(tx *gorm.DB, postID, userID int) (string, error) {
+	return findOrGenerateAnonyname(tx, &PostAnonynameMapping{}, "post_id", postID, userID)
+}
+
+// GenerateAnonyname 匿名的提问创建之后生成提问者的昵称
+func (p *Post) GenerateAnonyname(tx *gorm.DB) error {
+	if !p.IsAnonymous {
+		return nil
+	}
+	anonyname, err := FindOrGeneratePostAnonyname(tx, p.ID, p.PosterID)
+	if err != nil {
+		return err
+	}
+	p.Anonyname = &anonyname
+	return tx.Model(p).UpdateColumn("anonyname", anonyname).Error
+}
@@ -22,6 +22,14 @@ type Chat struct {
 	MessageCount int `json:"message_count"`
 }
 
+func (Chat) TableName() string {
+	return "chat"
+}
+
+func (c Chat) GetID() int {
+	return c.ID
+}
+
 type ChatMessage struct {
 	ID        int       `json:"id"`
 	CreatedAt time.Time `json:"created_at" gorm:"index"`
@@ -35,3 +43,11 @@ type ChatMessage struct {
 	ToUserID   int   `json:"to_user_id"`
 	ToUser     *User `json:"-" gorm:"foreignKey:ToUserID"`
 }
+
+func (ChatMessage) TableName() string {
+	return "chat_message"
+}
+
+func (m ChatMessage) GetID() int {
+	return m.ID
+}
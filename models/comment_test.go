@@ -0,0 +1,56 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestCommentFloor(t *testing.T) {
+	db := newTestDB(t, "test_comment_floor")
+	var err error
+
+	topic := Topic{Title: "floor", Content: "floor", DivisionID: 1}
+	if err = db.Create(&topic).Error; err != nil {
+		t.Fatal(err)
+	}
+	create := func() Comment {
+		comment := Comment{Content: "floor", TopicID: topic.ID, PosterID: 1}
+		if err := db.Create(&comment).Error; err != nil {
+			t.Fatal(err)
+		}
+		return comment
+	}
+
+	// 楼层从 1 开始连续分配
+	first, second := create(), create()
+	if first.Ranking != 1 || second.Ranking != 2 {
+		t.Fatalf("expected floors 1 and 2, got %d and %d", first.Ranking, second.Ranking)
+	}
+
+	// 事务回滚时楼层不会空缺
+	rollback := errors.New("rollback")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&Comment{Content: "floor", TopicID: topic.ID, PosterID: 1}).Error; err != nil {
+			return err
+		}
+		return rollback
+	})
+	if err != rollback {
+		t.Fatal(err)
+	}
+
+	// 删除评论后楼层不变
+	if err = db.Delete(&second).Error; err != nil {
+		t.Fatal(err)
+	}
+	if third := create(); third.Ranking != 3 {
+		t.Fatalf("expected floor 3, got %d", third.Ranking)
+	}
+	if err = db.First(&topic, topic.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if topic.FloorCount != 3 {
+		t.Fatalf("expected floor count 3, got %d", topic.FloorCount)
+	}
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"chatdan_backend/config"
+	"chatdan_backend/utils"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	counterReconcileBatchSize = 1000 // 每批对账的数据量
+	counterDriftSampleSize    = 100  // 每个计数器在报告中保留的偏差样例数量
+)
+
+// counterDefinition 冗余计数器的定义，计数器的值等于来源表中关联数据的数量
+type counterDefinition struct {
+	Table      string // 计数器所在的表
+	Column     string // 计数器列
+	Source     string // 来源表
+	ForeignKey string // 来源表中关联计数器所在表的列
+	Where      string // 来源表的过滤条件
+	Sum        string // 不为空时对来源表的该列求和，而不是计数
+}
+
+func (d counterDefinition) Name() string {
+	return d.Table + "." + d.Column
+}
+
+var counterDefinitions = []counterDefinition{
+	{Table: "topic", Column: "comment_count", Source: "comment", ForeignKey: "topic_id", Where: "deleted_at IS NULL"},
+	{Table: "topic", Column: "like_count", Source: "topic_user_likes", ForeignKey: "topic_id", Where: "like_data = 1"},
+	{Table: "topic", Column: "dislike_count", Source: "topic_user_likes", ForeignKey: "topic_id", Where: "like_data = -1"},
+	{Table: "topic", Column: "favor_count", Source: "topic_user_favorites", ForeignKey: "topic_id"},
+	{Table: "topic", Column: "view_count", Source: "topic_user_views", ForeignKey: "topic_id", Sum: "count"},
+	{Table: "comment", Column: "like_count", Source: "comment_user_likes", ForeignKey: "comment_id", Where: "like_data = 1"},
+	{Table: "comment", Column: "dislike_count", Source: "comment_user_likes", ForeignKey: "comment_id", Where: "like_data = -1"},
+	{Table: "comment", Column: "reply_count", Source: "comment", ForeignKey: "reply_to_id", Where: "deleted_at IS NULL"},
+	{Table: "user", Column: "topic_count", Source: "topic", ForeignKey: "poster_id", Where: "deleted_at IS NULL"},
+	{Table: "user", Column: "comment_count", Source: "comment", ForeignKey: "poster_id", Where: "deleted_at IS NULL"},
+	{Table: "user", Column: "favorite_topics_count", Source: "topic_user_favorites", ForeignKey: "user_id"},
+	{Table: "user", Column: "followers_count", Source: "user_follows", ForeignKey: "user_id"},
+	{Table: "user", Column: "following_users_count", Source: "user_follows", ForeignKey: "follower_id"},
+	{Table: "tag", Column: "temperature", Source: "topic_tags", ForeignKey: "tag_id", Where: "topic_id IN (SELECT id FROM topic WHERE deleted_at IS NULL)"},
+	{Table: "box", Column: "post_count", Source: "post", ForeignKey: "box_id", Where: "deleted_at IS NULL"},
+	{Table: "post", Column: "channel_count", Source: "channel", ForeignKey: "post_id", Where: "deleted_at IS NULL"},
+	{Table: "chat", Column: "message_count", Source: "chat_message", ForeignKey: "chat_id"},
+	{Table: "wall", Column: "reaction_count", Source: "wall_reaction", ForeignKey: "wall_id"},
+	{Table: "wall", Column: "comment_count", Source: "wall_comment", ForeignKey: "wall_id", Where: "deleted_at IS NULL"},
+}
+
+// CounterDrift 计数器的偏差
+type CounterDrift struct {
+	ID     int `json:"id"`
+	Stored int `json:"stored"` // 计数器中的值
+	Actual int `json:"actual"` // 从来源表重新计算的值
+}
+
+// CounterReport 单个计数器的对账结果
+type CounterReport struct {
+	Counter string         `json:"counter"`
+	Checked int            `json:"checked"` // 检查的数据量
+	Drifted int            `json:"drifted"` // 存在偏差的数据量
+	Fixed   int            `json:"fixed"`   // 修正的数据量，期间被并发修改的数据不会修正
+	Samples []CounterDrift `json:"samples"` // 偏差样例，最多 counterDriftSampleSize 个
+}
+
+// CounterReconcileReport 计数器对账报告
+type CounterReconcileReport struct {
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	DryRun     bool            `json:"dry_run"`
+	Counters   []CounterReport `json:"counters"`
+}
+
+// ReconcileCounters 从来源表重新计算所有冗余计数器，修正存在偏差的计数器
+// dryRun 为 true 时只报告偏差，不修改数据
+func ReconcileCounters(db *gorm.DB, dryRun bool) (report CounterReconcileReport, err error) {
+	report.StartedAt = time.Now()
+	report.DryRun = dryRun
+	fixedTopics := make(map[int]bool)
+	fixedWalls := make(map[int]bool)
+	for _, definition := range counterDefinitions {
+		var (
+			counterReport CounterReport
+			fixedIDs      []int
+		)
+		if counterReport, fixedIDs, err = reconcileCounter(db, definition, dryRun); err != nil {
+			return
+		}
+		report.Counters = append(report.Counters, counterReport)
+		if definition.Table == "topic" {
+			for _, id := range fixedIDs {
+				fixedTopics[id] = true
+			}
+		} else if definition.Table == "wall" {
+			for _, id := range fixedIDs {
+				fixedWalls[id] = true
+			}
+		}
+	}
+
+	// 话题的计数器修正后重新计算热度
+	topicIDs := make([]int, 0, len(fixedTopics))
+	for id := range fixedTopics {
+		topicIDs = append(topicIDs, id)
+	}
+	for start := 0; start < len(topicIDs); start += counterReconcileBatchSize {
+		end := start + counterReconcileBatchSize
+		if end > len(topicIDs) {
+			end = len(topicIDs)
+		}
+		if err = UpdateTopicHotScore(db, topicIDs[start:end]...); err != nil {
+			return
+		}
+	}
+
+	// 表白的计数器修正后重新计算热度
+	for id := range fixedWalls {
+		if err = UpdateWallCounters(db, id); err != nil {
+			return
+		}
+	}
+	report.FinishedAt = time.Now()
+	return
+}
+
+func reconcileCounter(db *gorm.DB, definition counterDefinition, dryRun bool) (report CounterReport, fixedIDs []int, err error) {
+	report.Counter = definition.Name()
+	report.Samples = []CounterDrift{}
+
+	aggregate := "count(*)"
+	if definition.Sum != "" {
+		aggregate = fmt.Sprintf("coalesce(sum(%s), 0)", definition.Sum)
+	}
+
+	type counterValue struct {
+		ID    int
+		Value int
+	}
+
+	// 按 ID 分批读取计数器
+	lastID := 0
+	for {
+		var stored []counterValue
+		if err = db.Table(definition.Table).
+			Select("id, "+definition.Column+" AS value").
+			Where("id > ?", lastID).
+			Order("id").Limit(counterReconcileBatchSize).
+			Scan(&stored).Error; err != nil {
+			return
+		}
+		if len(stored) == 0 {
+			return
+		}
+		lastID = stored[len(stored)-1].ID
+
+		// 从来源表计算这一批的实际值
+		idArray := make([]int, len(stored))
+		for i := range stored {
+			idArray[i] = stored[i].ID
+		}
+		var actual []counterValue
+		querySet := db.Table(definition.Source).
+			Select(definition.ForeignKey+" AS id, "+aggregate+" AS value").
+			Where(definition.ForeignKey+" IN ?", idArray)
+		if definition.Where != "" {
+			querySet = querySet.Where(definition.Where)
+		}
+		if err = querySet.Group(definition.ForeignKey).Scan(&actual).Error; err != nil {
+			return
+		}
+		actualMap := make(map[int]int, len(actual))
+		for _, value := range actual {
+			actualMap[value.ID] = value.Value
+		}
+
+		for _, value := range stored {
+			report.Checked++
+			if actualMap[value.ID] == value.Value {
+				continue
+			}
+
+			report.Drifted++
+			if len(report.Samples) < counterDriftSampleSize {
+				report.Samples = append(report.Samples, CounterDrift{ID: value.ID, Stored: value.Value, Actual: actualMap[value.ID]})
+			}
+			if dryRun {
+				continue
+			}
+
+			// 只在计数器没有被并发修改时修正，避免覆盖对账期间的更新
+			result := db.Table(definition.Table).
+				Where("id = ? AND "+definition.Column+" = ?", value.ID, value.Value).
+				UpdateColumn(definition.Column, actualMap[value.ID])
+			if result.Error != nil {
+				return report, fixedIDs, result.Error
+			}
+			if result.RowsAffected > 0 {
+				report.Fixed++
+				fixedIDs = append(fixedIDs, value.ID)
+				utils.Delete(CacheNameFromTableName(definition.Table, value.ID))
+			}
+		}
+	}
+}
+
+// StartCounterReconciler 按照 COUNTER_RECONCILE_INTERVAL 定时对账，为 0 时不启动
+func StartCounterReconciler() {
+	interval := config.Config.CounterReconcileInterval
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report, err := ReconcileCounters(DB, false)
+			if err != nil {
+				utils.Logger.Error("reconcile counters error", zap.Error(err))
+				continue
+			}
+			LogCounterReconcileReport(report)
+		}
+	}()
+}
+
+// LogCounterReconcileReport 记录存在偏差的计数器
+func LogCounterReconcileReport(report CounterReconcileReport) {
+	for _, counter := range report.Counters {
+		if counter.Drifted == 0 {
+			continue
+		}
+		utils.Logger.Warn("counter drift",
+			zap.String("counter", counter.Counter),
+			zap.Int("checked", counter.Checked),
+			zap.Int("drifted", counter.Drifted),
+			zap.Int("fixed", counter.Fixed),
+			zap.Bool("dry_run", report.DryRun),
+		)
+	}
+	utils.Logger.Info("counters reconciled", zap.Duration("duration", report.FinishedAt.Sub(report.StartedAt)))
+}
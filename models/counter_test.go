@@ -0,0 +1,84 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestReconcileCounters(t *testing.T) {
+	db := newTestDB(t, "test_counter")
+	var err error
+
+	// 计数器与实际数据不一致
+	user := User{Username: "counter", TopicCount: 5, CommentCount: 0}
+	if err = db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	tag := Tag{Name: "counter", Temperature: 3}
+	topics := []Topic{
+		{Title: "a", Content: "a", PosterID: user.ID, DivisionID: 1, Tags: []*Tag{&tag}},
+		{Title: "b", Content: "b", PosterID: user.ID, DivisionID: 1, Tags: []*Tag{&tag}},
+	}
+	if err = db.Create(&topics).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&Comment{Content: "a", PosterID: user.ID, TopicID: topics[0].ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Delete(&topics[1]).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]CounterDrift{
+		"user.topic_count":    {ID: user.ID, Stored: 5, Actual: 1},
+		"user.comment_count":  {ID: user.ID, Stored: 0, Actual: 1},
+		"topic.comment_count": {ID: topics[0].ID, Stored: 0, Actual: 1},
+		"tag.temperature":     {ID: tag.ID, Stored: 3, Actual: 1},
+	}
+	check := func(report CounterReconcileReport, fixed bool) {
+		for _, counter := range report.Counters {
+			drift, ok := expected[counter.Counter]
+			if !ok {
+				if counter.Drifted != 0 {
+					t.Errorf("unexpected drift in %s: %v", counter.Counter, counter.Samples)
+				}
+				continue
+			}
+			if counter.Drifted != 1 || counter.Samples[0] != drift {
+				t.Errorf("expected drift %v in %s, got %v", drift, counter.Counter, counter.Samples)
+			}
+			if fixed != (counter.Fixed == 1) {
+				t.Errorf("unexpected fixed count %d in %s", counter.Fixed, counter.Counter)
+			}
+		}
+	}
+
+	// 只报告偏差
+	report, err := ReconcileCounters(db, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(report, false)
+
+	// 修正偏差
+	if report, err = ReconcileCounters(db, false); err != nil {
+		t.Fatal(err)
+	}
+	check(report, true)
+
+	if err = db.First(&user, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.TopicCount != 1 || user.CommentCount != 1 {
+		t.Fatalf("counters not fixed: %+v", user)
+	}
+
+	// 修正后没有偏差
+	if report, err = ReconcileCounters(db, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, counter := range report.Counters {
+		if counter.Drifted != 0 {
+			t.Errorf("unexpected drift in %s after fix: %v", counter.Counter, counter.Samples)
+		}
+	}
+}
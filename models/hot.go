@@ -0,0 +1,58 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"math"
+	"time"
+)
+
+const (
+	hotScoreEpoch    = 1685548800 // 2023-06-01 00:00:00 UTC，计算热度时时间的起点
+	hotScoreTimeSpan = 45000      // 话题每晚发布 45000 秒（12.5 小时），热度增加 1，相当于互动数乘以 10
+)
+
+// CalculateHotScore 计算话题的热度
+// 互动数取对数后加上发布时间，新话题不需要很多互动就能排在旧话题前面。
+// 热度只在互动数变化时改变，不需要随时间重新计算，可以存储在数据库中用于排序
+func (topic *Topic) CalculateHotScore() float64 {
+	activity := float64(topic.LikeCount-topic.DislikeCount) +
+		2*float64(topic.CommentCount) +
+		3*float64(topic.FavorCount) +
+		float64(topic.ViewCount)/10
+
+	sign := 0.0
+	if activity > 0 {
+		sign = 1
+	} else if activity < 0 {
+		sign = -1
+	}
+	order := math.Log10(math.Max(math.Abs(activity), 1))
+
+	createdAt := topic.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	return sign*order + float64(createdAt.Unix()-hotScoreEpoch)/hotScoreTimeSpan
+}
+
+// UpdateTopicHotScore 在话题的互动数变化后重新计算热度
+func UpdateTopicHotScore(tx *gorm.DB, topicIDs ...int) (err error) {
+	if len(topicIDs) == 0 {
+		return
+	}
+
+	var topics []Topic
+	if err = tx.Unscoped().
+		Select("id", "created_at", "like_count", "dislike_count", "comment_count", "favor_count", "view_count").
+		Where("id IN ?", topicIDs).Find(&topics).Error; err != nil {
+		return
+	}
+
+	for i := range topics {
+		if err = tx.Model(&Topic{}).Where("id = ?", topics[i].ID).
+			UpdateColumn("hot_score", topics[i].CalculateHotScore()).Error; err != nil {
+			return
+		}
+	}
+	return
+}
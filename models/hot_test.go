@@ -0,0 +1,30 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateHotScore(t *testing.T) {
+	now := time.Now()
+	quiet := Topic{CreatedAt: now}
+	active := Topic{CreatedAt: now, LikeCount: 10, CommentCount: 5}
+	disliked := Topic{CreatedAt: now, DislikeCount: 10}
+	older := Topic{CreatedAt: now.Add(-48 * time.Hour), LikeCount: 10, CommentCount: 5}
+
+	if active.CalculateHotScore() <= quiet.CalculateHotScore() {
+		t.Errorf("active topic should be hotter than quiet topic")
+	}
+	if disliked.CalculateHotScore() >= quiet.CalculateHotScore() {
+		t.Errorf("disliked topic should be colder than quiet topic")
+	}
+	if older.CalculateHotScore() >= active.CalculateHotScore() {
+		t.Errorf("older topic should be colder than new topic with the same activity")
+	}
+
+	// 时间每增加 hotScoreTimeSpan 秒，热度增加 1
+	later := Topic{CreatedAt: now.Add(hotScoreTimeSpan * time.Second)}
+	if diff := later.CalculateHotScore() - quiet.CalculateHotScore(); diff < 0.999 || diff > 1.001 {
+		t.Errorf("expected score difference 1, got %f", diff)
+	}
+}
@@ -3,12 +3,16 @@ package models
 import (
 	"chatdan_backend/config"
 	"chatdan_backend/utils"
+	"fmt"
+	"go.uber.org/zap"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
+	"strings"
 	"time"
 )
 
@@ -32,6 +36,37 @@ var gormConfig = &gorm.Config{
 }
 
 func InitDB() {
+	OpenDB()
+
+	switch config.Config.MigrationMode {
+	case "auto":
+		applied, err := MigrateUp(DB)
+		if err != nil {
+			panic(err)
+		}
+		if len(applied) > 0 {
+			utils.Logger.Info("database migrated", zap.Strings("migrations", applied))
+		}
+	case "check":
+		pending, err := PendingMigrations(DB)
+		if err != nil {
+			panic(err)
+		}
+		if len(pending) > 0 {
+			panic(fmt.Sprintf("pending migrations: %s, run `migrate up` first", strings.Join(pending, ", ")))
+		}
+	case "skip":
+	default:
+		panic("unknown migration mode")
+	}
+
+	utils.Logger.Info("database connected")
+
+	InitSearch()
+}
+
+// OpenDB 连接数据库，不执行迁移
+func OpenDB() {
 	var err error
 	switch config.Config.DbType {
 	case "mysql":
@@ -39,6 +74,11 @@ func InitDB() {
 			panic("mysql db url required")
 		}
 		DB, err = gorm.Open(mysql.Open(config.Config.DbUrl), gormConfig)
+	case "postgres":
+		if config.Config.DbUrl == "" {
+			panic("postgres db url required")
+		}
+		DB, err = gorm.Open(postgres.Open(config.Config.DbUrl), gormConfig)
 	case "sqlite":
 		if config.Config.DbUrl == "" {
 			config.Config.DbUrl = "data.db"
@@ -57,50 +97,34 @@ func InitDB() {
 		DB = DB.Debug()
 	}
 
-	if err = DB.SetupJoinTable(User{}, "Followers", &UserFollows{}); err != nil {
-		panic(err)
-	}
-	if err = DB.SetupJoinTable(Topic{}, "LikedUsers", &TopicUserLikes{}); err != nil {
+	if err = setupJoinTables(DB); err != nil {
 		panic(err)
 	}
-	if err = DB.SetupJoinTable(Topic{}, "FavoredUsers", &TopicUserFavorites{}); err != nil {
-		panic(err)
+
+	openReplicas()
+}
+
+func setupJoinTables(db *gorm.DB) (err error) {
+	if err = db.SetupJoinTable(User{}, "Followers", &UserFollows{}); err != nil {
+		return
 	}
-	if err = DB.SetupJoinTable(Topic{}, "ViewedUsers", &TopicUserViews{}); err != nil {
-		panic(err)
+	if err = db.SetupJoinTable(Topic{}, "LikedUsers", &TopicUserLikes{}); err != nil {
+		return
 	}
-	if err = DB.SetupJoinTable(Topic{}, "AnonymousUsers", &TopicAnonynameMapping{}); err != nil {
-		panic(err)
+	if err = db.SetupJoinTable(Topic{}, "FavoredUsers", &TopicUserFavorites{}); err != nil {
+		return
 	}
-	if err = DB.SetupJoinTable(Comment{}, "LikedUsers", &CommentUserLikes{}); err != nil {
-		panic(err)
+	if err = db.SetupJoinTable(Topic{}, "ViewedUsers", &TopicUserViews{}); err != nil {
+		return
 	}
-
-	err = DB.AutoMigrate(
-		User{},
-		Box{},
-		Post{},
-		Channel{},
-		Wall{},
-		Division{},
-		Topic{},
-		Comment{},
-		Tag{},
-		Chat{},
-		ChatMessage{},
-	)
-	if err != nil {
-		panic(err)
+	if err = db.SetupJoinTable(Topic{}, "AnonymousUsers", &TopicAnonynameMapping{}); err != nil {
+		return
 	}
-
-	if config.Config.Standalone {
-		err = DB.AutoMigrate(UserJwtSecret{})
+	if err = db.SetupJoinTable(Comment{}, "LikedUsers", &CommentUserLikes{}); err != nil {
+		return
 	}
-	if err != nil {
-		panic(err)
+	if err = db.SetupJoinTable(Tag{}, "SubscribedUsers", &TagUserSubscriptions{}); err != nil {
+		return
 	}
-
-	utils.Logger.Info("database connected")
-
-	InitSearch()
+	return
 }
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// Migration 数据库迁移
+// 每个迁移在一个事务中执行，执行成功后记录在 migration_history 表中
+// MySQL 的 DDL 语句会隐式提交事务，Down 需要能够处理 Up 只执行了一部分的情况
+type Migration struct {
+	ID   string // 迁移 ID，按照字典序执行，格式为 "20230601000000_init"
+	Up   func(tx *gorm.DB) error
+	Down func(tx *gorm.DB) error
+}
+
+// MigrationHistory 已执行的迁移
+type MigrationHistory struct {
+	ID        string    `json:"id" gorm:"primaryKey;size:255"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	ID        string
+	AppliedAt *time.Time // 未执行时为 nil
+}
+
+// migrations 已注册的迁移，按照 ID 排序
+var migrations []Migration
+
+// RegisterMigration 注册迁移，在 init 函数中调用
+func RegisterMigration(migration Migration) {
+	for _, m := range migrations {
+		if m.ID == migration.ID {
+			panic(fmt.Sprintf("migration %s registered twice", migration.ID))
+		}
+	}
+	migrations = append(migrations, migration)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].ID < migrations[j].ID
+	})
+}
+
+func loadMigrationHistory(db *gorm.DB) (applied map[string]time.Time, err error) {
+	if err = db.AutoMigrate(&MigrationHistory{}); err != nil {
+		return
+	}
+
+	var histories []MigrationHistory
+	if err = db.Find(&histories).Error; err != nil {
+		return
+	}
+
+	applied = make(map[string]time.Time, len(histories))
+	for _, history := range histories {
+		applied[history.ID] = history.AppliedAt
+	}
+	return
+}
+
+// GetMigrationStatus 获取所有迁移的执行状态
+func GetMigrationStatus(db *gorm.DB) (statuses []MigrationStatus, err error) {
+	applied, err := loadMigrationHistory(db)
+	if err != nil {
+		return
+	}
+
+	statuses = make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{ID: migration.ID}
+		if appliedAt, ok := applied[migration.ID]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return
+}
+
+// PendingMigrations 获取未执行的迁移 ID
+func PendingMigrations(db *gorm.DB) (pending []string, err error) {
+	statuses, err := GetMigrationStatus(db)
+	if err != nil {
+		return
+	}
+
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.ID)
+		}
+	}
+	return
+}
+
+// MigrateUp 按顺序执行所有未执行的迁移，返回执行的迁移 ID
+func MigrateUp(db *gorm.DB) (applied []string, err error) {
+	history, err := loadMigrationHistory(db)
+	if err != nil {
+		return
+	}
+
+	for _, migration := range migrations {
+		if _, ok := history[migration.ID]; ok {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&MigrationHistory{ID: migration.ID, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, errors.Annotatef(err, "migration %s up", migration.ID)
+		}
+		applied = append(applied, migration.ID)
+	}
+	return
+}
+
+// MigrateDown 按倒序回滚最近执行的 steps 个迁移，返回回滚的迁移 ID
+func MigrateDown(db *gorm.DB, steps int) (reverted []string, err error) {
+	history, err := loadMigrationHistory(db)
+	if err != nil {
+		return
+	}
+
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := migrations[i]
+		if _, ok := history[migration.ID]; !ok {
+			continue
+		}
+		if migration.Down == nil {
+			return reverted, errors.Errorf("migration %s is irreversible", migration.ID)
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&MigrationHistory{ID: migration.ID}).Error
+		})
+		if err != nil {
+			return reverted, errors.Annotatef(err, "migration %s down", migration.ID)
+		}
+		reverted = append(reverted, migration.ID)
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"chatdan_backend/models/baseline"
+	"chatdan_backend/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+)
+
+func TestMigrateUpDown(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:test_migrate?mode=memory&cache=shared"), &gorm.Config{
+		NamingStrategy: gormConfig.NamingStrategy,
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(migrations) {
+		t.Fatalf("expected %d pending migrations, got %v", len(migrations), pending)
+	}
+
+	applied, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) || !db.Migrator().HasTable(&Topic{}) {
+		t.Fatalf("expected all migrations applied, got %v", applied)
+	}
+
+	// 重复执行不会再次应用
+	if applied, err = MigrateUp(db); err != nil || len(applied) != 0 {
+		t.Fatalf("expected no migrations applied, got %v, %v", applied, err)
+	}
+
+	statuses, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Fatalf("migration %s not applied", status.ID)
+		}
+	}
+
+	// 全部回滚
+	reverted, err := MigrateDown(db, len(migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(migrations) || reverted[0] != migrations[len(migrations)-1].ID {
+		t.Fatalf("unexpected reverted migrations %v", reverted)
+	}
+	if db.Migrator().HasTable(&Topic{}) || db.Migrator().HasTable("topic_tags") {
+		t.Fatal("expected tables dropped")
+	}
+	if pending, err = PendingMigrations(db); err != nil || len(pending) != len(migrations) {
+		t.Fatalf("expected all migrations pending, got %v, %v", pending, err)
+	}
+}
+
+// TestMigrateFromBaseline 已有数据的旧数据库升级到最新的表结构
+func TestMigrateFromBaseline(t *testing.T) {
+	utils.InitCache()
+	db, err := gorm.Open(sqlite.Open("file:test_migrate_baseline?mode=memory&cache=shared"), &gorm.Config{
+		NamingStrategy: gormConfig.NamingStrategy,
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = setupJoinTables(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// 引入迁移之前的表结构和数据
+	if err = baseline.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	users := []baseline.User{{Username: "alice"}, {Username: "bob"}}
+	boxes := []baseline.Box{{Title: "box 1", OwnerID: 1}, {Title: "box 2", OwnerID: 2}}
+	posts := []baseline.Post{{Content: "post 1", BoxID: 1, PosterID: 2}, {Content: "post 2", BoxID: 1, PosterID: 2}}
+	walls := []baseline.Wall{{Content: "wall 1", PosterID: 1}, {Content: "wall 2", PosterID: 2}}
+	tags := []baseline.Tag{{Name: "Go"}, {Name: "go"}}
+	topic := baseline.Topic{Title: "topic", Content: "topic", PosterID: 1, DivisionID: 1, Tags: []*baseline.Tag{&tags[0], &tags[1]}}
+	replyTo := 1
+	comments := []baseline.Comment{
+		{Content: "comment 1", PosterID: 1, TopicID: 1},
+		{Content: "comment 2", PosterID: 2, TopicID: 1},
+		{Content: "reply", PosterID: 1, TopicID: 1, ReplyToID: &replyTo},
+	}
+	for _, value := range []any{&users, &boxes, &posts, &walls, &baseline.Division{Name: "division"}, &tags, &topic, &comments} {
+		if err = db.Create(value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个模型的字段和索引都由迁移创建
+	for _, model := range []any{
+		&User{}, &UserJwtSecret{}, &UserFollows{}, &Box{}, &Post{}, &Channel{}, &BoxGuestBlock{}, &PostAnonynameMapping{},
+		&Wall{}, &WallDigest{}, &WallReaction{}, &WallComment{}, &WallAnonynameMapping{},
+		&Division{}, &Topic{}, &Comment{}, &Tag{}, &TagAlias{}, &TagUserSubscriptions{},
+		&TopicUserLikes{}, &TopicUserFavorites{}, &TopicUserViews{}, &TopicAnonynameMapping{}, &CommentUserLikes{},
+		&Chat{}, &ChatMessage{}, &Revision{}, &Mention{}, &LinkPreview{}, &ContentLinkPreview{},
+		&Attachment{}, &UserAttachment{}, &ContentAttachment{}, &DeanonymizeLog{},
+	} {
+		stmt := &gorm.Statement{DB: db}
+		if err = stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !field.IgnoreMigration && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("column %s.%s not migrated", stmt.Schema.Table, field.DBName)
+			}
+		}
+		for _, index := range stmt.Schema.ParseIndexes() {
+			if !db.Migrator().HasIndex(model, index.Name) {
+				t.Errorf("index %s.%s not migrated", stmt.Schema.Table, index.Name)
+			}
+		}
+	}
+
+	// 迁移填充已有的数据
+	var slugs []string
+	if err = db.Model(&Box{}).Pluck("share_slug", &slugs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(slugs) != 2 || slugs[0] == "" || slugs[0] == slugs[1] {
+		t.Fatalf("unexpected box share slugs %v", slugs)
+	}
+	var rankings []int
+	if err = db.Model(&Comment{}).Order("id").Pluck("ranking", &rankings).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rankings) != 3 || rankings[0] != 1 || rankings[1] != 2 || rankings[2] != 3 {
+		t.Fatalf("unexpected comment rankings %v", rankings)
+	}
+	var comment Comment
+	if err = db.Take(&comment, 1).Error; err != nil || comment.ReplyCount != 1 {
+		t.Fatalf("unexpected reply count %d, %v", comment.ReplyCount, err)
+	}
+	var tagCount int64
+	if err = db.Model(&Tag{}).Where("normalized_name = ?", "go").Count(&tagCount).Error; err != nil || tagCount != 1 {
+		t.Fatalf("expected duplicated tags merged, got %d, %v", tagCount, err)
+	}
+	var wall Wall
+	if err = db.Take(&wall, 1).Error; err != nil || wall.Visibility != Public {
+		t.Fatalf("unexpected wall visibility %q, %v", wall.Visibility, err)
+	}
+}
@@ -0,0 +1,799 @@
+package models
+
+import (
+	"chatdan_backend/models/baseline"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 新的迁移追加在这个文件的末尾，已发布的迁移不要修改
+func init() {
+	// 初始化表结构，已有的数据库执行时不会修改数据
+	// 使用引入迁移之前的表结构快照，之后的字段和索引在各自的迁移中添加
+	RegisterMigration(Migration{
+		ID: "20230601000000_init",
+		Up: func(tx *gorm.DB) error {
+			return baseline.Migrate(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(
+				"comment_user_likes",
+				"topic_anonyname_mapping",
+				"topic_user_likes",
+				"topic_user_favorites",
+				"topic_user_views",
+				"topic_tags",
+				"user_followers",
+				"user_follows",
+				baseline.ChatMessage{},
+				baseline.Chat{},
+				baseline.Tag{},
+				baseline.Comment{},
+				baseline.Topic{},
+				baseline.Division{},
+				baseline.Wall{},
+				baseline.Channel{},
+				baseline.Post{},
+				baseline.Box{},
+				baseline.UserJwtSecret{},
+				baseline.User{},
+			)
+		},
+	})
+
+	// 话题热度，用于 order_by=hot 排序
+	RegisterMigration(Migration{
+		ID: "20230701000000_topic_hot_score",
+		Up: func(tx *gorm.DB) (err error) {
+			if !tx.Migrator().HasColumn(&Topic{}, "HotScore") {
+				if err = tx.Migrator().AddColumn(&Topic{}, "HotScore"); err != nil {
+					return
+				}
+			}
+			if !tx.Migrator().HasIndex(&Topic{}, "HotScore") {
+				if err = tx.Migrator().CreateIndex(&Topic{}, "HotScore"); err != nil {
+					return
+				}
+			}
+
+			// 计算已有话题的热度
+			var topics []Topic
+			return tx.Unscoped().Select("id").FindInBatches(&topics, 1000, func(_ *gorm.DB, _ int) error {
+				topicIDs := make([]int, len(topics))
+				for i := range topics {
+					topicIDs[i] = topics[i].ID
+				}
+				return UpdateTopicHotScore(tx, topicIDs...)
+			}).Error
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if tx.Migrator().HasIndex(&Topic{}, "HotScore") {
+				if err = tx.Migrator().DropIndex(&Topic{}, "HotScore"); err != nil {
+					return
+				}
+			}
+			return tx.Migrator().DropColumn(&Topic{}, "HotScore")
+		},
+	})
+
+	// 用户订阅标签，用于时间线
+	RegisterMigration(Migration{
+		ID: "20230710000000_tag_user_subscriptions",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, TagUserSubscriptions{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(TagUserSubscriptions{})
+		},
+	})
+
+	// 标签名规范化、别名和锁定，规范化后重名的标签合并到最早创建的标签
+	RegisterMigration(Migration{
+		ID: "20230715000000_tag_management",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, column := range []string{"NormalizedName", "IsLocked"} {
+				if !tx.Migrator().HasColumn(&Tag{}, column) {
+					if err = tx.Migrator().AddColumn(&Tag{}, column); err != nil {
+						return
+					}
+				}
+			}
+			if !tx.Migrator().HasIndex(&Tag{}, "NormalizedName") {
+				if err = tx.Migrator().CreateIndex(&Tag{}, "NormalizedName"); err != nil {
+					return
+				}
+			}
+			if err = createTables(tx, TagAlias{}); err != nil {
+				return
+			}
+
+			// 计算已有标签的规范化名称
+			var tags []Tag
+			if err = tx.Unscoped().Select("id", "name").FindInBatches(&tags, 1000, func(_ *gorm.DB, _ int) error {
+				for _, tag := range tags {
+					if err := tx.Unscoped().Model(&tag).
+						UpdateColumn("normalized_name", NormalizeTagName(tag.Name)).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			}).Error; err != nil {
+				return
+			}
+
+			// 合并重名的标签
+			var duplicatedNames []string
+			if err = tx.Model(&Tag{}).Group("normalized_name").Having("count(*) > 1").
+				Pluck("normalized_name", &duplicatedNames).Error; err != nil {
+				return
+			}
+			for _, name := range duplicatedNames {
+				var tagIDs []int
+				if err = tx.Model(&Tag{}).Where("normalized_name = ?", name).Order("id").
+					Pluck("id", &tagIDs).Error; err != nil {
+					return
+				}
+				for _, tagID := range tagIDs[1:] {
+					if _, err = MergeTags(tx, tagID, tagIDs[0]); err != nil {
+						return
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropTable(TagAlias{}); err != nil {
+				return
+			}
+			if tx.Migrator().HasIndex(&Tag{}, "NormalizedName") {
+				if err = tx.Migrator().DropIndex(&Tag{}, "NormalizedName"); err != nil {
+					return
+				}
+			}
+			for _, column := range []string{"NormalizedName", "IsLocked"} {
+				if err = tx.Migrator().DropColumn(&Tag{}, column); err != nil {
+					return
+				}
+			}
+			return nil
+		},
+	})
+
+	// 评论的回复数，用于楼中楼
+	RegisterMigration(Migration{
+		ID: "20230720000000_comment_reply_count",
+		Up: func(tx *gorm.DB) (err error) {
+			if !tx.Migrator().HasColumn(&Comment{}, "ReplyCount") {
+				if err = tx.Migrator().AddColumn(&Comment{}, "ReplyCount"); err != nil {
+					return
+				}
+			}
+			if !tx.Migrator().HasIndex(&Comment{}, "ReplyToID") {
+				if err = tx.Migrator().CreateIndex(&Comment{}, "ReplyToID"); err != nil {
+					return
+				}
+			}
+
+			// 计算已有评论的回复数
+			for _, definition := range counterDefinitions {
+				if definition.Name() == "comment.reply_count" {
+					_, _, err = reconcileCounter(tx, definition, false)
+				}
+			}
+			return
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if tx.Migrator().HasIndex(&Comment{}, "ReplyToID") {
+				if err = tx.Migrator().DropIndex(&Comment{}, "ReplyToID"); err != nil {
+					return
+				}
+			}
+			return tx.Migrator().DropColumn(&Comment{}, "ReplyCount")
+		},
+	})
+
+	// 评论楼层，按照 ID 顺序为已有评论分配楼层，包括已删除的评论
+	RegisterMigration(Migration{
+		ID: "20230725000000_comment_floor",
+		Up: func(tx *gorm.DB) (err error) {
+			if !tx.Migrator().HasColumn(&Topic{}, "FloorCount") {
+				if err = tx.Migrator().AddColumn(&Topic{}, "FloorCount"); err != nil {
+					return
+				}
+			}
+
+			var topics []Topic
+			if err = tx.Unscoped().Select("id").FindInBatches(&topics, 100, func(_ *gorm.DB, _ int) error {
+				for _, topic := range topics {
+					var comments []Comment
+					if err := tx.Unscoped().Select("id", "ranking").Where("topic_id = ?", topic.ID).
+						Order("id").Find(&comments).Error; err != nil {
+						return err
+					}
+					for i, comment := range comments {
+						if comment.Ranking == i+1 {
+							continue
+						}
+						if err := tx.Unscoped().Model(&comment).UpdateColumn("ranking", i+1).Error; err != nil {
+							return err
+						}
+					}
+					if err := tx.Unscoped().Model(&topic).UpdateColumn("floor_count", len(comments)).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			}).Error; err != nil {
+				return
+			}
+
+			if !tx.Migrator().HasIndex(&Comment{}, "idx_comment_topic_ranking") {
+				return tx.Migrator().CreateIndex(&Comment{}, "idx_comment_topic_ranking")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if tx.Migrator().HasIndex(&Comment{}, "idx_comment_topic_ranking") {
+				if err = tx.Migrator().DropIndex(&Comment{}, "idx_comment_topic_ranking"); err != nil {
+					return
+				}
+			}
+			return tx.Migrator().DropColumn(&Topic{}, "FloorCount")
+		},
+	})
+
+	// 话题、评论、提问和回复的修改历史
+	RegisterMigration(Migration{
+		ID: "20230801000000_revision",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, model := range []any{&Topic{}, &Comment{}} {
+				if !tx.Migrator().HasColumn(model, "EditedAt") {
+					if err = tx.Migrator().AddColumn(model, "EditedAt"); err != nil {
+						return
+					}
+				}
+			}
+			return createTables(tx, Revision{})
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropTable(Revision{}); err != nil {
+				return
+			}
+			if err = tx.Migrator().DropColumn(&Comment{}, "EditedAt"); err != nil {
+				return
+			}
+			return tx.Migrator().DropColumn(&Topic{}, "EditedAt")
+		},
+	})
+
+	// Markdown 渲染、提及和链接预览，渲染已有的内容
+	RegisterMigration(Migration{
+		ID: "20230805000000_rich_content",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, model := range []any{&Topic{}, &Comment{}, &Post{}, &Wall{}} {
+				if !tx.Migrator().HasColumn(model, "ContentHTML") {
+					if err = tx.Migrator().AddColumn(model, "ContentHTML"); err != nil {
+						return
+					}
+				}
+			}
+			if err = createTables(tx, Mention{}, LinkPreview{}, ContentLinkPreview{}); err != nil {
+				return
+			}
+
+			var contents []struct {
+				ID       int
+				TopicID  int
+				PosterID int
+				Content  string
+			}
+			for _, table := range []struct{ Name, TopicID string }{
+				{ContentTypeTopic, "id"},
+				{ContentTypeComment, "topic_id"},
+				{ContentTypePost, "0"},
+				{ContentTypeWall, "0"},
+			} {
+				if err = tx.Table(table.Name).Select("id", table.TopicID+" AS topic_id", "poster_id", "content").
+					Where("content_html IS NULL OR content_html = ''").
+					FindInBatches(&contents, 100, func(_ *gorm.DB, _ int) error {
+						for _, content := range contents {
+							if _, err := (RichContent{
+								Type:     table.Name,
+								ID:       content.ID,
+								TopicID:  content.TopicID,
+								PosterID: content.PosterID,
+								Content:  content.Content,
+							}).Save(tx); err != nil {
+								return err
+							}
+						}
+						return nil
+					}).Error; err != nil {
+					return
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropTable(Mention{}, LinkPreview{}, ContentLinkPreview{}); err != nil {
+				return
+			}
+			for _, model := range []any{&Topic{}, &Comment{}, &Post{}, &Wall{}} {
+				if err = tx.Migrator().DropColumn(model, "ContentHTML"); err != nil {
+					return
+				}
+			}
+			return nil
+		},
+	})
+
+	RegisterMigration(Migration{
+		ID: "20230810000000_attachment",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, Attachment{}, UserAttachment{}, ContentAttachment{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(Attachment{}, UserAttachment{}, ContentAttachment{})
+		},
+	})
+
+	boxSettingFields := []string{"Description", "QuestionTemplate", "Status", "ClosesAt", "AnonymousPolicy", "AllowGuest"}
+	RegisterMigration(Migration{
+		ID: "20230815000000_box_settings",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, field := range boxSettingFields {
+				if !tx.Migrator().HasColumn(&Box{}, field) {
+					if err = tx.Migrator().AddColumn(&Box{}, field); err != nil {
+						return
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			for _, field := range boxSettingFields {
+				if err = tx.Migrator().DropColumn(&Box{}, field); err != nil {
+					return
+				}
+			}
+			return nil
+		},
+	})
+
+	postAnswerFields := []string{"AnswerStatus", "AnsweredAt", "IsAnswerPublic", "PinnedAt"}
+	RegisterMigration(Migration{
+		ID: "20230820000000_post_answer",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, field := range postAnswerFields {
+				if !tx.Migrator().HasColumn(&Post{}, field) {
+					if err = tx.Migrator().AddColumn(&Post{}, field); err != nil {
+						return
+					}
+				}
+			}
+			for _, index := range []string{"AnswerStatus", "PinnedAt"} {
+				if !tx.Migrator().HasIndex(&Post{}, index) {
+					if err = tx.Migrator().CreateIndex(&Post{}, index); err != nil {
+						return
+					}
+				}
+			}
+
+			// 提问箱主人回复过的提问视为已回答，回答时间为第一条回复的时间
+			ownerChannels := tx.Model(&Channel{}).
+				Where("channel.post_id = post.id AND channel.owner_id = (SELECT box.owner_id FROM box WHERE box.id = post.box_id)")
+			return tx.Model(&Post{}).
+				Where("answer_status = ? AND EXISTS (?)", AnswerPending, ownerChannels.Session(&gorm.Session{}).Select("1")).
+				Updates(map[string]any{
+					"answer_status": AnswerAnswered,
+					"answered_at":   gorm.Expr("(?)", ownerChannels.Session(&gorm.Session{}).Select("MIN(channel.created_at)")),
+				}).Error
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			for _, field := range postAnswerFields {
+				if err = tx.Migrator().DropColumn(&Post{}, field); err != nil {
+					return
+				}
+			}
+			return nil
+		},
+	})
+
+	// 为已有的提问箱和提问生成分享链接，生成后再创建唯一索引
+	RegisterMigration(Migration{
+		ID: "20230825000000_share_slug",
+		Up: func(tx *gorm.DB) (err error) {
+			if err = addShareSlug[Box](tx); err != nil {
+				return
+			}
+			return addShareSlug[Post](tx)
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropColumn(&Box{}, "ShareSlug"); err != nil {
+				return
+			}
+			return tx.Migrator().DropColumn(&Post{}, "ShareSlug")
+		},
+	})
+
+	guestPostFields := []string{"IsGuest", "GuestFingerprint"}
+	RegisterMigration(Migration{
+		ID: "20230830000000_guest_post",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, field := range guestPostFields {
+				if !tx.Migrator().HasColumn(&Post{}, field) {
+					if err = tx.Migrator().AddColumn(&Post{}, field); err != nil {
+						return
+					}
+				}
+			}
+			return createTables(tx, BoxGuestBlock{})
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropTable(BoxGuestBlock{}); err != nil {
+				return
+			}
+			for _, field := range guestPostFields {
+				if err = tx.Migrator().DropColumn(&Post{}, field); err != nil {
+					return
+				}
+			}
+			return nil
+		},
+	})
+
+	wallDigestFields := []string{"DigestID", "Sequence", "IsExcluded"}
+	RegisterMigration(Migration{
+		ID: "20230905000000_wall_digest",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, field := range wallDigestFields {
+				if !tx.Migrator().HasColumn(&Wall{}, field) {
+					if err = tx.Migrator().AddColumn(&Wall{}, field); err != nil {
+						return
+					}
+				}
+			}
+			if !tx.Migrator().HasIndex(&Wall{}, "DigestID") {
+				if err = tx.Migrator().CreateIndex(&Wall{}, "DigestID"); err != nil {
+					return
+				}
+			}
+			return createTables(tx, WallDigest{})
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropTable(WallDigest{}); err != nil {
+				return
+			}
+			if tx.Migrator().HasIndex(&Wall{}, "DigestID") {
+				if err = tx.Migrator().DropIndex(&Wall{}, "DigestID"); err != nil {
+					return
+				}
+			}
+			for _, field := range wallDigestFields {
+				if err = tx.Migrator().DropColumn(&Wall{}, field); err != nil {
+					return
+				}
+			}
+			return nil
+		},
+	})
+
+	RegisterMigration(Migration{
+		ID: "20230910000000_wall_moderation",
+		Up: func(tx *gorm.DB) (err error) {
+			if !tx.Migrator().HasColumn(&Wall{}, "IsHidden") {
+				if err = tx.Migrator().AddColumn(&Wall{}, "IsHidden"); err != nil {
+					return
+				}
+			}
+			// 之前创建的表白没有保存可见性，都是公开的
+			return tx.Unscoped().Model(&Wall{}).Where("visibility IS NULL OR visibility = ?", "").Update("visibility", Public).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&Wall{}, "IsHidden")
+		},
+	})
+
+	RegisterMigration(Migration{
+		ID: "20230915000000_wall_target",
+		Up: func(tx *gorm.DB) (err error) {
+			if !tx.Migrator().HasColumn(&Wall{}, "TargetID") {
+				if err = tx.Migrator().AddColumn(&Wall{}, "TargetID"); err != nil {
+					return
+				}
+			}
+			if !tx.Migrator().HasIndex(&Wall{}, "TargetID") {
+				return tx.Migrator().CreateIndex(&Wall{}, "TargetID")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if tx.Migrator().HasIndex(&Wall{}, "TargetID") {
+				if err = tx.Migrator().DropIndex(&Wall{}, "TargetID"); err != nil {
+					return
+				}
+			}
+			return tx.Migrator().DropColumn(&Wall{}, "TargetID")
+		},
+	})
+
+	wallCounterFields := []string{"ReactionCount", "CommentCount", "Popularity"}
+	channelAnonymousFields := []string{"IsAnonymous", "Anonyname"}
+	RegisterMigration(Migration{
+		ID: "20230920000000_wall_interaction",
+		Up: func(tx *gorm.DB) (err error) {
+			for _, field := range wallCounterFields {
+				if !tx.Migrator().HasColumn(&Wall{}, field) {
+					if err = tx.Migrator().AddColumn(&Wall{}, field); err != nil {
+						return
+					}
+				}
+			}
+			return createTables(tx, WallReaction{}, WallComment{}, WallAnonynameMapping{})
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropTable(WallAnonynameMapping{}, WallComment{}, WallReaction{}); err != nil {
+				return
+			}
+			for _, field := range wallCounterFields {
+				if err = tx.Migrator().DropColumn(&Wall{}, field); err != nil {
+					return
+				}
+			}
+			return nil
+		},
+	})
+
+	RegisterMigration(Migration{
+		ID: "20230925000000_post_anonyname",
+		Up: func(tx *gorm.DB) (err error) {
+			if !tx.Migrator().HasColumn(&Post{}, "Anonyname") {
+				if err = tx.Migrator().AddColumn(&Post{}, "Anonyname"); err != nil {
+					return
+				}
+			}
+			for _, field := range channelAnonymousFields {
+				if !tx.Migrator().HasColumn(&Channel{}, field) {
+					if err = tx.Migrator().AddColumn(&Channel{}, field); err != nil {
+						return
+					}
+				}
+			}
+			if err = createTables(tx, PostAnonynameMapping{}); err != nil {
+				return
+			}
+
+			// 之前的匿名提问生成昵称，提问者的回复同样匿名
+			var posts []Post
+			if err = tx.Unscoped().Select("id", "poster_id", "is_anonymous").
+				Where("is_anonymous = ? AND anonyname IS NULL", true).Find(&posts).Error; err != nil {
+				return
+			}
+			for i := range posts {
+				if err = posts[i].GenerateAnonyname(tx); err != nil {
+					return
+				}
+				if posts[i].PosterID == 0 {
+					continue
+				}
+				if err = tx.Unscoped().Model(&Channel{}).
+					Where("post_id = ? AND owner_id = ?", posts[i].ID, posts[i].PosterID).
+					UpdateColumns(map[string]any{"is_anonymous": true, "anonyname": *posts[i].Anonyname}).Error; err != nil {
+					return
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if err = tx.Migrator().DropTable(PostAnonynameMapping{}); err != nil {
+				return
+			}
+			for _, field := range channelAnonymousFields {
+				if err = tx.Migrator().DropColumn(&Channel{}, field); err != nil {
+					return
+				}
+			}
+			return tx.Migrator().DropColumn(&Post{}, "Anonyname")
+		},
+	})
+
+	RegisterMigration(Migration{
+		ID: "20230930000000_deanonymize_log",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, DeanonymizeLog{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(DeanonymizeLog{})
+		},
+	})
+
+	// 规范化的标签名改为唯一索引，防止并发创建大小写或全角半角不同的同名标签
+	RegisterMigration(Migration{
+		ID: "20231005000000_tag_normalized_name_unique",
+		Up: func(tx *gorm.DB) (err error) {
+			// 修改话题时创建的标签没有计算规范化名称
+			var tags []Tag
+			if err = tx.Unscoped().Select("id", "name").Where("normalized_name = ?", "").Find(&tags).Error; err != nil {
+				return
+			}
+			for _, tag := range tags {
+				if err = tx.Unscoped().Model(&tag).
+					UpdateColumn("normalized_name", NormalizeTagName(tag.Name)).Error; err != nil {
+					return
+				}
+			}
+
+			if err = mergeDuplicatedTags(tx); err != nil {
+				return
+			}
+			if tx.Migrator().HasIndex(&Tag{}, "NormalizedName") {
+				if err = tx.Migrator().DropIndex(&Tag{}, "NormalizedName"); err != nil {
+					return
+				}
+			}
+			return tx.Migrator().CreateIndex(&tagNormalizedNameUnique{}, "idx_tag_normalized_name")
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			// 合并的标签无法拆分，只恢复普通索引
+			if err = tx.Migrator().DropIndex(&tagNormalizedNameUnique{}, "idx_tag_normalized_name"); err != nil {
+				return
+			}
+			return tx.Migrator().CreateIndex(&Tag{}, "NormalizedName")
+		},
+	})
+
+	// 未登录提问者同时按照 IP 屏蔽，已有的屏蔽记录只有指纹
+	RegisterMigration(Migration{
+		ID: "20231010000000_guest_block_ip",
+		Up: func(tx *gorm.DB) (err error) {
+			if !tx.Migrator().HasColumn(&Post{}, "GuestIPHash") {
+				if err = tx.Migrator().AddColumn(&Post{}, "GuestIPHash"); err != nil {
+					return
+				}
+			}
+			if !tx.Migrator().HasColumn(&BoxGuestBlock{}, "IPHash") {
+				if err = tx.Migrator().AddColumn(&BoxGuestBlock{}, "IPHash"); err != nil {
+					return
+				}
+			}
+			if !tx.Migrator().HasIndex(&BoxGuestBlock{}, "idx_box_guest_block_ip") {
+				return tx.Migrator().CreateIndex(&BoxGuestBlock{}, "idx_box_guest_block_ip")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) (err error) {
+			if tx.Migrator().HasIndex(&BoxGuestBlock{}, "idx_box_guest_block_ip") {
+				if err = tx.Migrator().DropIndex(&BoxGuestBlock{}, "idx_box_guest_block_ip"); err != nil {
+					return
+				}
+			}
+			if err = tx.Migrator().DropColumn(&BoxGuestBlock{}, "IPHash"); err != nil {
+				return
+			}
+			return tx.Migrator().DropColumn(&Post{}, "GuestIPHash")
+		},
+	})
+
+	// 匿名的话题和评论之前在搜索引擎中保存了发布者，重新索引
+	RegisterMigration(Migration{
+		ID: "20231015000000_search_anonymous_poster",
+		Up: func(tx *gorm.DB) error {
+			return reindexAnonymousSearchModels(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			// 不需要恢复搜索引擎中的发布者
+			return nil
+		},
+	})
+}
+
+// createTables 创建新的表
+// 不使用 AutoMigrate，AutoMigrate 会同时迁移关联的模型，提前添加之后的迁移中才有的字段和索引
+func createTables(tx *gorm.DB, models ...any) (err error) {
+	for _, model := range models {
+		if tx.Migrator().HasTable(model) {
+			continue
+		}
+		if err = tx.Migrator().CreateTable(model); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// addColumnWithoutUnique 添加字段，唯一索引在填充已有的数据之后再单独创建
+// 单独一个字段的唯一索引会在字段定义中加上 UNIQUE，SQLite 不支持添加 UNIQUE 字段
+func addColumnWithoutUnique(tx *gorm.DB, model any, name string) error {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	field := stmt.Schema.LookUpField(name)
+	if field == nil {
+		return fmt.Errorf("failed to look up field with name: %s", name)
+	}
+	column := *field
+	column.Unique = false
+	return tx.Exec("ALTER TABLE ? ADD ? ?",
+		clause.Table{Name: stmt.Schema.Table}, clause.Column{Name: column.DBName}, tx.Migrator().FullDataTypeOf(&column)).Error
+}
+
+func addShareSlug[T any](tx *gorm.DB) (err error) {
+	var model T
+	if !tx.Migrator().HasColumn(&model, "ShareSlug") {
+		if err = addColumnWithoutUnique(tx, &model, "ShareSlug"); err != nil {
+			return
+		}
+	}
+
+	var ids []int
+	if err = tx.Unscoped().Model(&model).Where("share_slug IS NULL OR share_slug = ?", "").Pluck("id", &ids).Error; err != nil {
+		return
+	}
+	for _, id := range ids {
+		if err = tx.Unscoped().Model(&model).Where("id = ?", id).Update("share_slug", NewShareSlug()).Error; err != nil {
+			return
+		}
+	}
+
+	if !tx.Migrator().HasIndex(&model, "ShareSlug") {
+		return tx.Migrator().CreateIndex(&model, "ShareSlug")
+	}
+	return nil
+}
+
+// tagNormalizedNameUnique 规范化标签名的唯一索引，与 Tag 上的普通索引同名
+// 标签管理的迁移使用 Tag 创建普通索引，Tag 上不能声明唯一索引
+type tagNormalizedNameUnique struct {
+	NormalizedName string `gorm:"size:64;uniqueIndex:idx_tag_normalized_name"`
+}
+
+func (tagNormalizedNameUnique) TableName() string {
+	return "tag"
+}
+
+// mergeDuplicatedTags 合并规范化名称相同的标签到最早创建的标签，已删除的重复标签清除关联后彻底删除
+func mergeDuplicatedTags(tx *gorm.DB) (err error) {
+	var duplicatedNames []string
+	if err = tx.Unscoped().Model(&Tag{}).Group("normalized_name").Having("count(*) > 1").
+		Pluck("normalized_name", &duplicatedNames).Error; err != nil {
+		return
+	}
+	for _, name := range duplicatedNames {
+		var tags []Tag
+		if err = tx.Unscoped().Where("normalized_name = ?", name).Order("id").Find(&tags).Error; err != nil {
+			return
+		}
+		to := tags[0]
+		for _, tag := range tags {
+			if !tag.DeletedAt.Valid {
+				to = tag
+				break
+			}
+		}
+		for _, tag := range tags {
+			if tag.ID == to.ID {
+				continue
+			}
+			if !tag.DeletedAt.Valid && !to.DeletedAt.Valid {
+				if _, err = MergeTags(tx, tag.ID, to.ID); err != nil {
+					return
+				}
+				continue
+			}
+			for _, table := range []string{"topic_tags", "tag_user_subscriptions"} {
+				if err = tx.Table(table).Where("tag_id = ?", tag.ID).Delete(nil).Error; err != nil {
+					return
+				}
+			}
+			if err = tx.Model(&TagAlias{}).Where("tag_id = ?", tag.ID).Update("tag_id", to.ID).Error; err != nil {
+				return
+			}
+			if err = tx.Unscoped().Delete(&tag).Error; err != nil {
+				return
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"chatdan_backend/config"
+	"chatdan_backend/utils"
+	"context"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	replicaCheckInterval = 10 * time.Second // 只读副本健康检查间隔
+	replicaCheckTimeout  = 2 * time.Second  // 只读副本健康检查超时时间
+)
+
+// replica 只读副本，健康检查失败时不再分配读请求
+type replica struct {
+	index   int
+	db      *gorm.DB
+	healthy atomic.Bool
+}
+
+var (
+	replicas     []*replica
+	replicaIndex atomic.Uint64 // 轮询分配只读副本
+)
+
+func openReplicas() {
+	replicas = nil
+	for i, url := range config.Config.DbReplicaUrls {
+		var dialector gorm.Dialector
+		switch config.Config.DbType {
+		case "mysql":
+			dialector = mysql.Open(url)
+		case "postgres":
+			dialector = postgres.Open(url)
+		case "sqlite":
+			dialector = sqlite.Open(url)
+		default:
+			panic("db replicas not supported for db type " + config.Config.DbType)
+		}
+
+		db, err := gorm.Open(dialector, gormConfig)
+		if err != nil {
+			panic(err)
+		}
+		if config.Config.Debug {
+			db = db.Debug()
+		}
+		if err = setupJoinTables(db); err != nil {
+			panic(err)
+		}
+
+		r := &replica{index: i, db: db}
+		r.check()
+		replicas = append(replicas, r)
+	}
+
+	if len(replicas) > 0 {
+		utils.Logger.Info("database replicas connected", zap.Int("count", len(replicas)))
+		go checkReplicas()
+	}
+}
+
+func checkReplicas() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, r := range replicas {
+			r.check()
+		}
+	}
+}
+
+func (r *replica) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+
+	sqlDB, err := r.db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+
+	healthy := err == nil
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			utils.Logger.Info("database replica healthy", zap.Int("replica", r.index))
+		} else {
+			utils.Logger.Error("database replica unhealthy, fallback to primary", zap.Int("replica", r.index), zap.Error(err))
+		}
+	}
+}
+
+func replicaStickyKey(userID int) string {
+	return "replica_sticky:" + strconv.Itoa(userID)
+}
+
+// ReplicaDB 返回用于只读查询的数据库
+// 用户在 DB_REPLICA_STICKY 时间内有过写入时使用主库，保证用户能读到自己的写入；
+// 没有可用的只读副本时也使用主库。写入和事务始终使用 DB
+func ReplicaDB(userID int) *gorm.DB {
+	if len(replicas) == 0 {
+		return DB
+	}
+
+	if userID != 0 {
+		var sticky bool
+		if err := utils.Get(replicaStickyKey(userID), &sticky); err == nil && sticky {
+			return DB
+		}
+	}
+
+	start := replicaIndex.Add(1)
+	for i := range replicas {
+		r := replicas[(start+uint64(i))%uint64(len(replicas))]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return DB
+}
+
+// StickToPrimary 记录用户的写入，之后一段时间内该用户的读请求使用主库
+func StickToPrimary(userID int) error {
+	if len(replicas) == 0 || userID == 0 {
+		return nil
+	}
+	return utils.Set(replicaStickyKey(userID), true, config.Config.DbReplicaSticky)
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"chatdan_backend/config"
+	"chatdan_backend/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestReplicaDB(t *testing.T) {
+	utils.InitCache()
+	primary, replicaDB := &gorm.DB{}, &gorm.DB{}
+	oldDB, oldReplicas, oldSticky := DB, replicas, config.Config.DbReplicaSticky
+	defer func() {
+		DB, replicas, config.Config.DbReplicaSticky = oldDB, oldReplicas, oldSticky
+	}()
+	DB, replicas, config.Config.DbReplicaSticky = primary, nil, time.Minute
+
+	// 没有只读副本
+	if ReplicaDB(1) != primary {
+		t.Fatal("expected primary without replicas")
+	}
+
+	r := &replica{db: replicaDB}
+	r.healthy.Store(true)
+	replicas = []*replica{r}
+	if ReplicaDB(1) != replicaDB || ReplicaDB(0) != replicaDB {
+		t.Fatal("expected replica for reads")
+	}
+
+	// 写入后读自己的写入
+	if err := StickToPrimary(1); err != nil {
+		t.Fatal(err)
+	}
+	if ReplicaDB(1) != primary {
+		t.Fatal("expected primary after write")
+	}
+	if ReplicaDB(2) != replicaDB {
+		t.Fatal("expected replica for other users")
+	}
+
+	// 副本不可用时使用主库
+	r.healthy.Store(false)
+	if ReplicaDB(2) != primary {
+		t.Fatal("expected primary when replica unhealthy")
+	}
+}
+
+func TestReplicaCheck(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:test_replica?mode=memory&cache=shared"), gormConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &replica{db: db}
+	r.check()
+	if !r.healthy.Load() {
+		t.Fatal("expected replica healthy")
+	}
+
+	sqlDB, _ := db.DB()
+	_ = sqlDB.Close()
+	r.check()
+	if r.healthy.Load() {
+		t.Fatal("expected replica unhealthy after close")
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Revision 内容的历史版本
+// 第一次修改时记录原始版本和修改后的版本，之后每次修改记录修改后的版本，最新的版本与当前内容相同
+type Revision struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	TargetType string    `json:"target_type" gorm:"size:16;not null;uniqueIndex:idx_revision_target_version,priority:1"` // topic, comment, post, channel
+	TargetID   int       `json:"target_id" gorm:"not null;uniqueIndex:idx_revision_target_version,priority:2"`
+	Version    int       `json:"version" gorm:"not null;uniqueIndex:idx_revision_target_version,priority:3"` // 版本号，原始版本为 1
+	Title      *string   `json:"title"`                                                                      // 标题，只有话题有标题
+	Content    string    `json:"content" gorm:"not null"`
+	EditorID   int       `json:"editor_id" gorm:"not null"` // 修改的用户，原始版本为发布者
+}
+
+func (Revision) TableName() string {
+	return "revision"
+}
+
+// ToRevision 话题当前内容的版本，用于记录修改前的原始版本
+func (t *Topic) ToRevision() Revision {
+	title := t.Title
+	return Revision{TargetType: ContentTypeTopic, TargetID: t.ID, Title: &title, Content: t.Content, EditorID: t.PosterID, CreatedAt: t.CreatedAt}
+}
+
+// ToRevision 评论当前内容的版本，用于记录修改前的原始版本
+func (c *Comment) ToRevision() Revision {
+	return Revision{TargetType: ContentTypeComment, TargetID: c.ID, Content: c.Content, EditorID: c.PosterID, CreatedAt: c.CreatedAt}
+}
+
+// ToRevision 提问当前内容的版本，用于记录修改前的原始版本
+func (p *Post) ToRevision() Revision {
+	return Revision{TargetType: ContentTypePost, TargetID: p.ID, Content: p.Content, EditorID: p.PosterID, CreatedAt: p.CreatedAt}
+}
+
+// ToRevision 回复当前内容的版本，用于记录修改前的原始版本
+func (c *Channel) ToRevision() Revision {
+	return Revision{TargetType: ContentTypeChannel, TargetID: c.ID, Content: c.Content, EditorID: c.OwnerID, CreatedAt: c.CreatedAt}
+}
+
+// RecordRevision 记录一次修改，original 为修改前的内容，revised 为修改后的内容
+// 内容没有变化时不记录，返回 edited = false
+// 需要在修改内容的事务中调用，并且已经锁定了被修改的数据
+func RecordRevision(tx *gorm.DB, original, revised Revision, editorID int) (edited bool, err error) {
+	if original.Content == revised.Content &&
+		(original.Title == nil) == (revised.Title == nil) &&
+		(original.Title == nil || *original.Title == *revised.Title) {
+		return false, nil
+	}
+
+	var latest int
+	if err = tx.Model(&Revision{}).Select("coalesce(max(version), 0)").
+		Where("target_type = ? AND target_id = ?", original.TargetType, original.TargetID).
+		Row().Scan(&latest); err != nil {
+		return
+	}
+
+	// 第一次修改时记录原始版本
+	if latest == 0 {
+		original.Version = 1
+		if err = tx.Create(&original).Error; err != nil {
+			return
+		}
+		latest = 1
+	}
+
+	revised.ID = 0
+	revised.Version = latest + 1
+	revised.EditorID = editorID
+	revised.CreatedAt = time.Now()
+	return true, tx.Create(&revised).Error
+}
+
+// ListRevisions 按照版本号升序查询内容的历史版本
+func ListRevisions(tx *gorm.DB, targetType string, targetID int) (revisions []Revision, err error) {
+	err = tx.Where("target_type = ? AND target_id = ?", targetType, targetID).Order("version").Find(&revisions).Error
+	return
+}
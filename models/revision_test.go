@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestRecordRevision(t *testing.T) {
+	db := newTestDB(t, "test_revision")
+
+	comment := Comment{ID: 1, Content: "original", PosterID: 1}
+
+	// 内容没有变化时不记录
+	edited, err := RecordRevision(db, comment.ToRevision(), comment.ToRevision(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited {
+		t.Fatal("expected unchanged content not to be recorded")
+	}
+
+	// 第一次修改记录原始版本和修改后的版本，之后每次修改记录一个版本
+	for i, content := range []string{"first edit", "second edit"} {
+		original := comment.ToRevision()
+		comment.Content = content
+		if edited, err = RecordRevision(db, original, comment.ToRevision(), 2); err != nil {
+			t.Fatal(err)
+		}
+		if !edited {
+			t.Fatalf("expected edit %d to be recorded", i+1)
+		}
+	}
+
+	revisions, err := ListRevisions(db, ContentTypeComment, comment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Revision{
+		{Version: 1, Content: "original", EditorID: 1},
+		{Version: 2, Content: "first edit", EditorID: 2},
+		{Version: 3, Content: "second edit", EditorID: 2},
+	}
+	if len(revisions) != len(expected) {
+		t.Fatalf("expected %d revisions, got %d", len(expected), len(revisions))
+	}
+	for i, revision := range revisions {
+		if revision.Version != expected[i].Version || revision.Content != expected[i].Content ||
+			revision.EditorID != expected[i].EditorID || revision.Title != nil {
+			t.Errorf("expected revision %+v, got %+v", expected[i], revision)
+		}
+	}
+
+	// 其他类型的同一 ID 不受影响
+	if revisions, err = ListRevisions(db, ContentTypeTopic, comment.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 0 {
+		t.Fatalf("expected no topic revisions, got %d", len(revisions))
+	}
+}
@@ -0,0 +1,419 @@
+package models
+
+import (
+	"chatdan_backend/config"
+	"chatdan_backend/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Mention 内容中提及的用户
+// 通过匿名昵称提及时同样记录，但是渲染的内容中不包含用户 ID
+type Mention struct {
+	ID          int       `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	TargetType  string    `json:"target_type" gorm:"size:16;not null;uniqueIndex:idx_mention_target_user,priority:1"` // topic, comment, post, wall
+	TargetID    int       `json:"target_id" gorm:"not null;uniqueIndex:idx_mention_target_user,priority:2"`
+	UserID      int       `json:"user_id" gorm:"not null;uniqueIndex:idx_mention_target_user,priority:3;index"` // 被提及的用户
+	IsAnonymous bool      `json:"is_anonymous" gorm:"not null;default:false"`                                   // 是否通过匿名昵称提及
+}
+
+func (Mention) TableName() string {
+	return "mention"
+}
+
+func (m Mention) GetID() int {
+	return m.ID
+}
+
+const (
+	LinkPreviewPending = "pending"
+	LinkPreviewSuccess = "success"
+	LinkPreviewFailed  = "failed"
+)
+
+const (
+	linkPreviewMaxAttempts = 3
+	linkPreviewMaxBodySize = 1 << 20
+	linkPreviewBatchSize   = 20
+)
+
+// LinkPreview 链接预览，创建内容时记录，由后台任务抓取
+type LinkPreview struct {
+	ID          int        `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	URL         string     `json:"url" gorm:"size:2048;not null"`
+	URLHash     string     `json:"-" gorm:"size:64;not null;uniqueIndex"` // URL 的 sha256，用于去重
+	Status      string     `json:"status" gorm:"size:16;not null;default:pending;index"`
+	Attempts    int        `json:"-" gorm:"not null;default:0"` // 抓取失败的次数，达到 linkPreviewMaxAttempts 后不再抓取
+	Title       string     `json:"title" gorm:"size:1024;not null;default:''"`
+	Description string     `json:"description" gorm:"size:1024;not null;default:''"`
+	Image       string     `json:"image" gorm:"size:2048;not null;default:''"`
+	SiteName    string     `json:"site_name" gorm:"size:1024;not null;default:''"`
+	FetchedAt   *time.Time `json:"fetched_at"`
+}
+
+func (LinkPreview) TableName() string {
+	return "link_preview"
+}
+
+// ContentLinkPreview 内容中的链接，Position 为链接在内容中出现的顺序
+type ContentLinkPreview struct {
+	TargetType    string `gorm:"size:16;primaryKey"`
+	TargetID      int    `gorm:"primaryKey"`
+	LinkPreviewID int    `gorm:"primaryKey"`
+	Position      int    `gorm:"not null;default:0"`
+}
+
+// RichContent 需要渲染的内容
+type RichContent struct {
+	Type     string // ContentTypeTopic, ContentTypeComment, ContentTypePost, ContentTypeWall
+	ID       int
+	TopicID  int // 话题和评论所在的话题，用于解析本话题中的匿名昵称，其他内容为 0
+	PosterID int // 发布者，提及自己时不记录
+	Content  string
+}
+
+// Save 渲染 Markdown 内容并写入 content_html 列，同时记录提及的用户和链接
+// 需要在创建或者修改内容的事务中调用
+func (r RichContent) Save(tx *gorm.DB) (html string, err error) {
+	document := utils.ParseMarkdown(r.Content)
+	references := document.References()
+
+	resolver := utils.MarkdownResolver{}
+	if resolver.Mentions, err = resolveMentions(tx, r.TopicID, references.Mentions); err != nil {
+		return
+	}
+	if resolver.Tags, err = resolveTagReferences(tx, references.Tags); err != nil {
+		return
+	}
+	if html, err = document.Render(resolver); err != nil {
+		return
+	}
+
+	if err = r.saveMentions(tx, references.Mentions, resolver.Mentions); err != nil {
+		return
+	}
+	if err = r.saveLinks(tx, references.Links); err != nil {
+		return
+	}
+	err = tx.Table(r.Type).Where("id = ?", r.ID).UpdateColumn("content_html", html).Error
+	return
+}
+
+// RenderContent 渲染话题内容，在话题和匿名昵称创建之后调用
+func (t *Topic) RenderContent(tx *gorm.DB) (err error) {
+	t.ContentHTML, err = RichContent{Type: ContentTypeTopic, ID: t.ID, TopicID: t.ID, PosterID: t.PosterID, Content: t.Content}.Save(tx)
+	return
+}
+
+// RenderContent 渲染评论内容，在评论创建之后调用
+func (c *Comment) RenderContent(tx *gorm.DB) (err error) {
+	c.ContentHTML, err = RichContent{Type: ContentTypeComment, ID: c.ID, TopicID: c.TopicID, PosterID: c.PosterID, Content: c.Content}.Save(tx)
+	return
+}
+
+// RenderContent 渲染提问内容，在提问创建之后调用
+func (p *Post) RenderContent(tx *gorm.DB) (err error) {
+	p.ContentHTML, err = RichContent{Type: ContentTypePost, ID: p.ID, PosterID: p.PosterID, Content: p.Content}.Save(tx)
+	return
+}
+
+// RenderContent 渲染表白墙内容，在表白墙创建之后调用
+func (w *Wall) RenderContent(tx *gorm.DB) (err error) {
+	w.ContentHTML, err = RichContent{Type: ContentTypeWall, ID: w.ID, PosterID: w.PosterID, Content: w.Content}.Save(tx)
+	return
+}
+
+// resolveMentions 先按照本话题中的匿名昵称解析，再按照用户名解析，重名的用户不解析
+func resolveMentions(tx *gorm.DB, topicID int, names []string) (targets map[string]utils.MentionTarget, err error) {
+	targets = make(map[string]utils.MentionTarget, len(names))
+	if len(names) == 0 {
+		return
+	}
+
+	if topicID != 0 {
+		var mappings []TopicAnonynameMapping
+		if err = tx.Where("topic_id = ? AND anonyname IN ?", topicID, names).Find(&mappings).Error; err != nil {
+			return
+		}
+		for _, mapping := range mappings {
+			targets[mapping.Anonyname] = utils.MentionTarget{UserID: mapping.UserID, Anonymous: true}
+		}
+	}
+
+	usernames := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, found := targets[name]; !found {
+			usernames = append(usernames, name)
+		}
+	}
+	if len(usernames) == 0 {
+		return
+	}
+	var users []User
+	if err = tx.Select("id", "username").Where("username IN ?", usernames).Find(&users).Error; err != nil {
+		return
+	}
+	duplicated := make(map[string]bool)
+	for _, user := range users {
+		if _, found := targets[user.Username]; found {
+			duplicated[user.Username] = true
+		}
+		targets[user.Username] = utils.MentionTarget{UserID: user.ID}
+	}
+	for name := range duplicated {
+		delete(targets, name)
+	}
+	return
+}
+
+// resolveTagReferences 按照标签名或别名解析，大小写和全角半角不敏感
+func resolveTagReferences(tx *gorm.DB, names []string) (tagIDs map[string]int, err error) {
+	tagIDs = make(map[string]int, len(names))
+	if len(names) == 0 {
+		return
+	}
+	normalizedNames := make([]string, len(names))
+	for i, name := range names {
+		normalizedNames[i] = NormalizeTagName(name)
+	}
+	tagMap, err := findTagsByNormalizedNames(tx, normalizedNames)
+	if err != nil {
+		return
+	}
+	for i, name := range names {
+		if tag, found := tagMap[normalizedNames[i]]; found {
+			tagIDs[name] = tag.ID
+		}
+	}
+	return
+}
+
+func (r RichContent) saveMentions(tx *gorm.DB, names []string, targets map[string]utils.MentionTarget) (err error) {
+	if err = tx.Where("target_type = ? AND target_id = ?", r.Type, r.ID).Delete(&Mention{}).Error; err != nil {
+		return
+	}
+
+	mentions := make([]Mention, 0, len(names))
+	seen := map[int]bool{r.PosterID: true}
+	for _, name := range names {
+		target, found := targets[name]
+		if !found || seen[target.UserID] {
+			continue
+		}
+		seen[target.UserID] = true
+		mentions = append(mentions, Mention{
+			TargetType:  r.Type,
+			TargetID:    r.ID,
+			UserID:      target.UserID,
+			IsAnonymous: target.Anonymous,
+		})
+	}
+	if len(mentions) == 0 {
+		return
+	}
+	return tx.Create(&mentions).Error
+}
+
+func (r RichContent) saveLinks(tx *gorm.DB, links []string) (err error) {
+	if err = tx.Where("target_type = ? AND target_id = ?", r.Type, r.ID).Delete(&ContentLinkPreview{}).Error; err != nil {
+		return
+	}
+	if len(links) == 0 {
+		return
+	}
+
+	previews := make([]LinkPreview, len(links))
+	hashes := make([]string, len(links))
+	for i, link := range links {
+		hash := sha256.Sum256([]byte(link))
+		hashes[i] = hex.EncodeToString(hash[:])
+		previews[i] = LinkPreview{URL: link, URLHash: hashes[i], Status: LinkPreviewPending}
+	}
+	if err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&previews).Error; err != nil {
+		return
+	}
+
+	// 已经存在的链接不会插入，需要重新查询 ID
+	var existing []LinkPreview
+	if err = tx.Select("id", "url_hash").Where("url_hash IN ?", hashes).Find(&existing).Error; err != nil {
+		return
+	}
+	idMap := make(map[string]int, len(existing))
+	for _, preview := range existing {
+		idMap[preview.URLHash] = preview.ID
+	}
+	contentLinks := make([]ContentLinkPreview, 0, len(links))
+	for i, hash := range hashes {
+		if id, found := idMap[hash]; found {
+			contentLinks = append(contentLinks, ContentLinkPreview{TargetType: r.Type, TargetID: r.ID, LinkPreviewID: id, Position: i})
+		}
+	}
+	return tx.Create(&contentLinks).Error
+}
+
+// LoadLinkPreviews 批量查询内容中已经抓取成功的链接预览，按照链接在内容中出现的顺序返回
+func LoadLinkPreviews(tx *gorm.DB, targetType string, targetIDs []int) (previewMap map[int][]LinkPreview, err error) {
+	previewMap = make(map[int][]LinkPreview)
+	if len(targetIDs) == 0 {
+		return
+	}
+
+	var results []struct {
+		LinkPreview
+		TargetID int
+	}
+	err = tx.Model(&LinkPreview{}).
+		Select("link_preview.*, content_link_preview.target_id").
+		Joins("JOIN content_link_preview ON content_link_preview.link_preview_id = link_preview.id").
+		Where("content_link_preview.target_type = ? AND content_link_preview.target_id IN ?", targetType, targetIDs).
+		Where("link_preview.status = ?", LinkPreviewSuccess).
+		Order("content_link_preview.position").
+		Scan(&results).Error
+	if err != nil {
+		return
+	}
+	for _, result := range results {
+		previewMap[result.TargetID] = append(previewMap[result.TargetID], result.LinkPreview)
+	}
+	return
+}
+
+/* 链接预览抓取 */
+
+var errLinkPreviewForbiddenAddress = errors.New("link preview: forbidden address")
+
+// NewLinkPreviewClient 抓取链接预览的 HTTP 客户端，不允许访问内网地址
+func NewLinkPreviewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return errLinkPreviewForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// FetchLinkPreviews 抓取等待中的链接预览，返回处理的数量
+// 抓取失败时记录次数，达到 linkPreviewMaxAttempts 次后标记为失败
+func FetchLinkPreviews(tx *gorm.DB, client *http.Client, limit int) (count int, err error) {
+	var previews []LinkPreview
+	if err = tx.Where("status = ?", LinkPreviewPending).Order("id").Limit(limit).Find(&previews).Error; err != nil {
+		return
+	}
+
+	for i := range previews {
+		preview := &previews[i]
+		meta, fetchErr := fetchLinkPreview(client, preview.URL)
+		now := time.Now()
+		updates := Map{"fetched_at": now}
+		if fetchErr != nil {
+			utils.Logger.Debug("fetch link preview error", zap.String("url", preview.URL), zap.Error(fetchErr))
+			updates["attempts"] = preview.Attempts + 1
+			if preview.Attempts+1 >= linkPreviewMaxAttempts {
+				updates["status"] = LinkPreviewFailed
+			}
+		} else {
+			updates["status"] = LinkPreviewSuccess
+			updates["title"] = meta.Title
+			updates["description"] = meta.Description
+			updates["image"] = meta.Image
+			updates["site_name"] = meta.SiteName
+		}
+		if err = tx.Model(preview).Updates(updates).Error; err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+func fetchLinkPreview(client *http.Client, link string) (meta utils.LinkPreviewMeta, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", config.Config.AppName+" LinkPreview")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return meta, fmt.Errorf("link preview: unexpected status %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return meta, fmt.Errorf("link preview: unsupported content type %q", mediaType)
+	}
+
+	return utils.ParseLinkPreview(io.LimitReader(resp.Body, linkPreviewMaxBodySize), resp.Request.URL), nil
+}
+
+// StartLinkPreviewFetcher 按照 LINK_PREVIEW_FETCH_INTERVAL 定期抓取链接预览
+func StartLinkPreviewFetcher() {
+	interval := config.Config.LinkPreviewFetchInterval
+	if interval <= 0 {
+		return
+	}
+
+	client := NewLinkPreviewClient()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			// 一次抓取满一批时继续抓取下一批
+			for {
+				count, err := FetchLinkPreviews(DB, client, linkPreviewBatchSize)
+				if err != nil {
+					utils.Logger.Error("fetch link previews error", zap.Error(err))
+					break
+				}
+				if count < linkPreviewBatchSize {
+					break
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRichContent(t *testing.T) {
+	db := newTestDB(t, "test_rich")
+	var err error
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/article":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<html><head><title>Fallback</title>
+<meta property="og:title" content="Article Title">
+<meta name="description" content="Article description">
+<meta property="og:image" content="/cover.png">
+</head><body><meta property="og:title" content="Ignored"></body></html>`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	poster := User{Username: "rich_poster"}
+	mentioned := User{Username: "rich_mentioned"}
+	anonymous := User{Username: "rich_anonymous"}
+	for _, user := range []*User{&poster, &mentioned, &anonymous} {
+		if err = db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重名的用户不解析
+	for i := 0; i < 2; i++ {
+		if err = db.Create(&User{Username: "rich_duplicated"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag := Tag{Name: "RichTag"}
+	if err = db.Create(&tag).Error; err != nil {
+		t.Fatal(err)
+	}
+	topic := Topic{Title: "t", Content: "t", DivisionID: 1, PosterID: poster.ID}
+	if err = db.Create(&topic).Error; err != nil {
+		t.Fatal(err)
+	}
+	anonyname, err := NewAnonyname(db, topic.ID, anonymous.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comment := Comment{TopicID: topic.ID, PosterID: poster.ID, Content: fmt.Sprintf(
+		"@rich_mentioned @%s @rich_duplicated @rich_poster #ｒｉｃｈｔａｇ\n\n<%s/article> [image](%s/image) [missing](%s/missing)",
+		anonyname, server.URL, server.URL, server.URL,
+	)}
+	if err = db.Create(&comment).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = comment.RenderContent(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		fmt.Sprintf(`<span class="mention" data-user-id="%d">@rich_mentioned</span>`, mentioned.ID),
+		`<span class="mention mention-anonymous">@` + anonyname + `</span>`,
+		"@rich_duplicated ",
+		fmt.Sprintf(`<span class="tag" data-tag-id="%d">#ｒｉｃｈｔａｇ</span>`, tag.ID),
+	} {
+		if !strings.Contains(comment.ContentHTML, expected) {
+			t.Errorf("expected %q in %q", expected, comment.ContentHTML)
+		}
+	}
+	var stored Comment
+	if err = db.First(&stored, comment.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ContentHTML != comment.ContentHTML {
+		t.Errorf("expected content_html saved, got %q", stored.ContentHTML)
+	}
+
+	// 提及自己不记录，匿名提及记录但是标记为匿名
+	var mentions []Mention
+	if err = db.Where("target_type = ? AND target_id = ?", ContentTypeComment, comment.ID).Order("user_id").Find(&mentions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(mentions) != 2 || mentions[0].UserID != mentioned.ID || mentions[0].IsAnonymous ||
+		mentions[1].UserID != anonymous.ID || !mentions[1].IsAnonymous {
+		t.Fatalf("expected mentions of %d and anonymous %d, got %+v", mentioned.ID, anonymous.ID, mentions)
+	}
+
+	// 内网地址不允许抓取
+	if _, err = fetchLinkPreview(NewLinkPreviewClient(), server.URL+"/article"); err == nil {
+		t.Fatal("expected loopback address to be forbidden")
+	}
+
+	// 抓取链接预览，非网页和不存在的链接重试之后标记为失败
+	for i := 0; i < linkPreviewMaxAttempts; i++ {
+		if _, err = FetchLinkPreviews(db, server.Client(), linkPreviewBatchSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var previews []LinkPreview
+	if err = db.Order("id").Find(&previews).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(previews) != 3 {
+		t.Fatalf("expected 3 link previews, got %d", len(previews))
+	}
+	article := previews[0]
+	if article.Status != LinkPreviewSuccess || article.Title != "Article Title" ||
+		article.Description != "Article description" || article.Image != server.URL+"/cover.png" {
+		t.Errorf("unexpected article preview %+v", article)
+	}
+	for _, preview := range previews[1:] {
+		if preview.Status != LinkPreviewFailed || preview.Attempts != linkPreviewMaxAttempts {
+			t.Errorf("expected failed preview, got %+v", preview)
+		}
+	}
+
+	previewMap, err := LoadLinkPreviews(db, ContentTypeComment, []int{comment.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previewMap[comment.ID]) != 1 || previewMap[comment.ID][0].ID != article.ID {
+		t.Fatalf("expected only successful preview, got %+v", previewMap)
+	}
+
+	// 修改后重新记录提及和链接
+	comment.Content = "nothing"
+	if err = comment.RenderContent(db); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&Mention{}).Where("target_type = ? AND target_id = ?", ContentTypeComment, comment.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("expected mentions removed, got %d", count)
+	}
+	if previewMap, err = LoadLinkPreviews(db, ContentTypeComment, []int{comment.ID}); err != nil || len(previewMap) != 0 {
+		t.Errorf("expected link previews removed, got %+v, %v", previewMap, err)
+	}
+}
@@ -19,10 +19,7 @@ func InitSearch() {
 	if config.Config.MeilisearchUrl == "" {
 		return
 	}
-	meilisearchClient = meilisearch.NewClient(meilisearch.ClientConfig{
-		Host:   config.Config.MeilisearchUrl,
-		APIKey: config.Config.MeilisearchApiKey,
-	})
+	connectSearch()
 	utils.Logger.Info("Meilisearch initialized")
 
 	// create or update indexes
@@ -120,12 +117,12 @@ func InitSearch() {
 		if config.Config.MeilisearchReload {
 			// reload model concurrently
 			reloadWaitGroup.Add(1)
-			go func() {
+			go func(model SearchModel) {
 				defer reloadWaitGroup.Done()
-				if err = model.ReloadModel(); err != nil {
-					utils.Logger.Panic("Cannot reload model "+indexName, zap.Error(err))
+				if err := model.ReloadModel(); err != nil {
+					utils.Logger.Panic("Cannot reload model "+model.IndexName(), zap.Error(err))
 				}
-			}()
+			}(model)
 		}
 	}
 
@@ -135,6 +132,45 @@ func InitSearch() {
 	}
 }
 
+// connectSearch 连接搜索引擎，迁移在 InitSearch 之前执行，需要时也会调用
+func connectSearch() {
+	if meilisearchClient != nil {
+		return
+	}
+	meilisearchClient = meilisearch.NewClient(meilisearch.ClientConfig{
+		Host:   config.Config.MeilisearchUrl,
+		APIKey: config.Config.MeilisearchApiKey,
+	})
+}
+
+// reindexAnonymousSearchModels 重新索引匿名的话题和评论，去掉之前保存在搜索引擎中的发布者
+func reindexAnonymousSearchModels(tx *gorm.DB) (err error) {
+	if config.Config.MeilisearchUrl == "" {
+		return
+	}
+	connectSearch()
+
+	var topics []Topic
+	if err = tx.Where("is_anonymous = ?", true).FindInBatches(&topics, 100, func(tx *gorm.DB, batch int) error {
+		var searchModels []TopicSearchModel
+		for _, topic := range topics {
+			searchModels = append(searchModels, topic.ToSearchModel())
+		}
+		return SearchAddOrReplaceInBatch(searchModels)
+	}).Error; err != nil {
+		return
+	}
+
+	var comments []Comment
+	return tx.Where("is_anonymous = ?", true).FindInBatches(&comments, 100, func(tx *gorm.DB, batch int) error {
+		var searchModels []CommentSearchModel
+		for _, comment := range comments {
+			searchModels = append(searchModels, comment.ToSearchModel())
+		}
+		return SearchAddOrReplaceInBatch(searchModels)
+	}).Error
+}
+
 type SearchModel interface {
 	IDModel
 	IndexName() string
@@ -2,9 +2,11 @@ package models
 
 import (
 	"chatdan_backend/utils"
+	"fmt"
 	"github.com/juju/errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"strings"
 	"time"
 )
 
@@ -33,6 +35,8 @@ type Topic struct {
 	IsAnonymous bool           `json:"is_anonymous" gorm:"not null;default:false"` // 是否匿名
 	Anonyname   *string        `json:"anonyname"`                                  // 匿名时的昵称
 	IsHidden    bool           `json:"is_hidden" gorm:"not null;default:false"`    // 是否隐藏，隐藏的帖子不会出现在列表中
+	EditedAt    *time.Time     `json:"edited_at"`                                  // 最后一次修改标题或内容的时间，未修改时为空
+	ContentHTML string         `json:"content_html"`                               // 渲染后的内容
 
 	// 关联数据
 	PosterID       int       `json:"poster_id" gorm:"not null"`                                  // 发帖人ID
@@ -51,6 +55,9 @@ type Topic struct {
 	DislikeCount int `json:"dislike_count" gorm:"not null;default:0"`  // 点踩数
 	CommentCount int `json:"comment_count" gorm:"not null;default:0"`  // 评论数
 	FavorCount   int `json:"favorite_count" gorm:"not null;default:0"` // 收藏数
+	FloorCount   int `json:"floor_count" gorm:"not null;default:0"`    // 已分配的楼层数，包括已删除的评论
+
+	HotScore float64 `json:"hot_score" gorm:"not null;default:0;index"` // 热度，根据互动数和发布时间计算，互动数变化时更新
 }
 
 func (t Topic) GetID() int {
@@ -66,46 +73,77 @@ func (t *Topic) FindOrCreateTags(tx *gorm.DB, tagNames []string) (err error) {
 		return
 	}
 
-	// batch find and create if not exists
-	var tags []*Tag
-	err = tx.Where("name IN ?", tagNames).Find(&tags).Error
+	// 按照规范化的标签名去重
+	var (
+		names, normalizedNames []string
+		seen                   = make(map[string]bool)
+	)
+	for _, tagName := range tagNames {
+		normalizedName := NormalizeTagName(tagName)
+		if normalizedName == "" || seen[normalizedName] {
+			continue
+		}
+		seen[normalizedName] = true
+		names = append(names, strings.TrimSpace(tagName))
+		normalizedNames = append(normalizedNames, normalizedName)
+	}
+
+	// batch find, aliases resolve to their tags
+	tagMap, err := findTagsByNormalizedNames(tx, normalizedNames)
 	if err != nil {
 		return
 	}
 
-	var newTagNames []string
-	for _, tagName := range tagNames {
-		var found bool
-		for _, tag := range tags {
-			if tag.Name == tagName {
-				found = true
-				break
-			}
-		}
+	var (
+		tags     []*Tag
+		newTags  []*Tag
+		foundIDs = make(map[int]bool)
+	)
+	for i, normalizedName := range normalizedNames {
+		tag, found := tagMap[normalizedName]
 		if !found {
-			newTagNames = append(newTagNames, tagName)
+			newTags = append(newTags, &Tag{Name: names[i]})
+			continue
+		}
+		if tag.IsLocked {
+			return utils.BadRequest(fmt.Sprintf("标签 %s 已被锁定", tag.Name))
+		}
+		// 不同的别名可能对应同一个标签
+		if !foundIDs[tag.ID] {
+			foundIDs[tag.ID] = true
+			tags = append(tags, tag)
 		}
 	}
 	t.Tags = tags
 
-	if len(newTagNames) == 0 {
+	if len(newTags) == 0 {
 		return
 	}
 
-	var newTags []*Tag
-	for _, tagName := range newTagNames {
-		newTags = append(newTags, &Tag{
-			Name: tagName,
-		})
-	}
+	// create if not exists
 	err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&newTags).Error
 	if err != nil {
 		return
 	}
 
+	// 并发创建时可能没有插入，按照规范化的标签名重新查询，其他请求创建的标签也可能已被锁定
+	var createdTags []*Tag
+	for _, tag := range newTags {
+		if tag.ID == 0 {
+			if err = tx.Where("normalized_name = ?", tag.NormalizedName).First(tag).Error; err != nil {
+				return
+			}
+			if tag.IsLocked {
+				return utils.BadRequest(fmt.Sprintf("标签 %s 已被锁定", tag.Name))
+			}
+		} else {
+			createdTags = append(createdTags, tag)
+		}
+	}
+
 	// save to search engine
 	var tagSearchModels []TagSearchModel
-	for _, tag := range newTags {
+	for _, tag := range createdTags {
 		tagSearchModels = append(tagSearchModels, tag.ToSearchModel())
 	}
 	err = SearchAddOrReplaceInBatch(tagSearchModels)
@@ -117,16 +155,31 @@ func (t *Topic) FindOrCreateTags(tx *gorm.DB, tagNames []string) (err error) {
 	return nil
 }
 
+// PostedBy 查询 posterID 发布的话题或评论，当前用户 userID 查询其他用户时不包括匿名的内容
+func PostedBy(posterID, userID int) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Where("poster_id = ?", posterID)
+		if posterID != userID {
+			tx = tx.Where("is_anonymous = ?", false)
+		}
+		return tx
+	}
+}
+
+// ToSearchModel 匿名的话题不在搜索引擎中保存发布者
 func (t Topic) ToSearchModel() TopicSearchModel {
-	return TopicSearchModel{
+	model := TopicSearchModel{
 		ID:         t.ID,
 		Title:      t.Title,
 		Content:    t.Content,
 		CreatedAt:  int(t.CreatedAt.UnixMicro()),
 		UpdatedAt:  int(t.UpdatedAt.UnixMicro()),
-		PosterID:   t.PosterID,
 		DivisionID: t.DivisionID,
 	}
+	if !t.IsAnonymous {
+		model.PosterID = t.PosterID
+	}
+	return model
 }
 
 type TopicSearchModel struct {
@@ -187,23 +240,26 @@ type Comment struct {
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
-	Content     string         `json:"content" gorm:"not null"`                    // 评论内容
-	IsAnonymous bool           `json:"is_anonymous" gorm:"not null;default:false"` // 是否匿名
-	Anonyname   *string        `json:"anonyname"`                                  // 匿名时的昵称，可选
-	Ranking     int            `json:"ranking" gorm:"not null;default:0"`          // 评论的楼层
-	ReplyToID   *int           `json:"reply_to_id"`                                // 回复的评论ID，必须是本帖子的评论
-	IsHidden    bool           `json:"is_hidden" gorm:"not null;default:false"`    // 是否被隐藏，被隐藏的评论不会显示在帖子中
+	Content     string         `json:"content" gorm:"not null"`                                                            // 评论内容
+	IsAnonymous bool           `json:"is_anonymous" gorm:"not null;default:false"`                                         // 是否匿名
+	Anonyname   *string        `json:"anonyname"`                                                                          // 匿名时的昵称，可选
+	Ranking     int            `json:"ranking" gorm:"not null;default:0;uniqueIndex:idx_comment_topic_ranking,priority:2"` // 评论的楼层，从 1 开始，删除评论后楼层不变
+	ReplyToID   *int           `json:"reply_to_id" gorm:"index"`                                                           // 回复的评论ID，必须是本帖子的评论
+	IsHidden    bool           `json:"is_hidden" gorm:"not null;default:false"`                                            // 是否被隐藏，被隐藏的评论不会显示在帖子中
+	EditedAt    *time.Time     `json:"edited_at"`                                                                          // 最后一次修改内容的时间，未修改时为空
+	ContentHTML string         `json:"content_html"`                                                                       // 渲染后的内容
 
 	// 关联数据
 	PosterID   int     `json:"poster_id" gorm:"not null"`
 	Poster     *User   `json:"poster" gorm:"foreignKey:PosterID"`
-	TopicID    int     `json:"topic_id" gorm:"not null"`
+	TopicID    int     `json:"topic_id" gorm:"not null;uniqueIndex:idx_comment_topic_ranking,priority:1"`
 	Topic      *Topic  `json:"topic" gorm:"foreignKey:TopicID"`
 	LikedUsers []*User `json:"liked_user" gorm:"many2many:comment_user_likes"` // 点赞或点踩评论的用户
 
 	// 统计数据
 	LikeCount    int `json:"like_count" gorm:"not null;default:0"`    // 点赞数
 	DislikeCount int `json:"dislike_count" gorm:"not null;default:0"` // 点踩数
+	ReplyCount   int `json:"reply_count" gorm:"not null;default:0"`   // 直接回复数
 }
 
 func (c Comment) GetID() int {
@@ -214,15 +270,59 @@ func (Comment) TableName() string {
 	return "comment"
 }
 
+// BeforeCreate 为评论分配楼层
+// 在创建评论的事务中增加话题的 floor_count，话题被锁定直到事务结束，并发创建评论时楼层不会重复；
+// 事务回滚时 floor_count 一起回滚，楼层不会有空缺
+func (c *Comment) BeforeCreate(tx *gorm.DB) (err error) {
+	if c.Ranking != 0 {
+		return
+	}
+	db := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&Topic{}).Where("id = ?", c.TopicID).
+		Session(&gorm.Session{})
+	if err = db.UpdateColumn("floor_count", gorm.Expr("floor_count + 1")).Error; err != nil {
+		return
+	}
+	return db.Select("floor_count").Row().Scan(&c.Ranking)
+}
+
+// LoadCommentReplies 加载每个评论按照 ID 升序的前 limit 条直接回复
+// 每个评论多加载一条，用于判断是否还有更多回复；tx 可以设置 Preload
+func LoadCommentReplies(tx *gorm.DB, commentIDs []int, limit int) (replies map[int][]Comment, err error) {
+	replies = make(map[int][]Comment, len(commentIDs))
+	if len(commentIDs) == 0 {
+		return
+	}
+
+	// 使用窗口函数限制每个评论的回复数量，避免回复很多的评论加载全部回复
+	db := tx.Session(&gorm.Session{NewDB: true})
+	ranked := db.Model(&Comment{}).
+		Select("*, ROW_NUMBER() OVER (PARTITION BY reply_to_id ORDER BY id) AS reply_rank").
+		Where("reply_to_id IN ?", commentIDs)
+	var comments []Comment
+	if err = tx.Table("(?) AS comment", ranked).
+		Where("reply_rank <= ?", limit+1).
+		Order("id").Find(&comments).Error; err != nil {
+		return
+	}
+	for _, comment := range comments {
+		replies[*comment.ReplyToID] = append(replies[*comment.ReplyToID], comment)
+	}
+	return
+}
+
+// ToSearchModel 匿名的评论不在搜索引擎中保存发布者
 func (c *Comment) ToSearchModel() CommentSearchModel {
-	return CommentSearchModel{
+	model := CommentSearchModel{
 		ID:        c.ID,
 		CreatedAt: int(c.CreatedAt.UnixMicro()),
 		UpdatedAt: int(c.UpdatedAt.UnixMicro()),
 		TopicID:   c.TopicID,
 		Content:   c.Content,
-		PosterID:  c.PosterID,
 	}
+	if !c.IsAnonymous {
+		model.PosterID = c.PosterID
+	}
+	return model
 }
 
 // CommentSearchModel 评论搜索模型
@@ -278,15 +378,18 @@ var _ SearchModel = CommentSearchModel{}
 
 // Tag 标签
 type Tag struct {
-	ID          int            `json:"id"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
-	Name        string         `json:"name" gorm:"not null;unique"`                 // 标签名，大小写不敏感
-	Temperature int            `json:"temperature" gorm:"not null;default:0;index"` // 热度，表示有多少帖子使用了这个标签，越高表示越热门，用于排序
+	ID             int            `json:"id"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	Name           string         `json:"name" gorm:"not null;unique"`                              // 标签名，显示用
+	NormalizedName string         `json:"normalized_name" gorm:"size:64;not null;default:'';index"` // 规范化的标签名，大小写和全角半角不敏感，用于查找标签，唯一索引由迁移创建
+	Temperature    int            `json:"temperature" gorm:"not null;default:0;index"`              // 热度，表示有多少帖子使用了这个标签，越高表示越热门，用于排序
+	IsLocked       bool           `json:"is_locked" gorm:"not null;default:false"`                  // 是否锁定，锁定的标签不能添加到帖子中，可以用于禁止创建某个标签
 
 	// 关联数据
-	Topics []*Topic `json:"topics" gorm:"many2many:topic_tags"`
+	Topics          []*Topic `json:"topics" gorm:"many2many:topic_tags"`
+	SubscribedUsers []*User  `json:"subscribed_users" gorm:"many2many:tag_user_subscriptions"` // 订阅标签的用户
 }
 
 func (t Tag) GetID() int {
@@ -368,6 +471,12 @@ type TopicUserFavorites struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// FavoredTopic 收藏的帖子，附带收藏时间，用于按收藏时间分页
+type FavoredTopic struct {
+	Topic
+	FavoredAt time.Time `json:"favored_at"`
+}
+
 // TopicUserViews 用户浏览过的帖子
 // 默认按照更新时间倒序返回
 type TopicUserViews struct {
@@ -394,41 +503,47 @@ func NewAnonyname(tx *gorm.DB, topicID, userID int) (string, error) {
 }
 
 func FindOrGenerateAnonyname(tx *gorm.DB, topicID, userID int) (string, error) {
-	var anonyname string
-	err := tx.
-		Model(&TopicAnonynameMapping{}).
+	return findOrGenerateAnonyname(tx, &TopicAnonynameMapping{}, "topic_id", topicID, userID)
+}
+
+// findOrGenerateAnonyname 查找用户在某个范围（如话题）内的匿名昵称，没有时生成一个在该范围内不重复的昵称
+// model 为匿名昵称映射表，包含 scopeColumn、user_id 和 anonyname 列
+func findOrGenerateAnonyname(tx *gorm.DB, model any, scopeColumn string, scopeID, userID int) (anonyname string, err error) {
+	err = tx.
+		Model(model).
 		Select("anonyname").
-		Where("topic_id = ?", topicID).
+		Where(scopeColumn+" = ?", scopeID).
 		Where("user_id = ?", userID).
 		Take(&anonyname).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
 
+	var names []string
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Model(model).
+		Where(scopeColumn+" = ?", scopeID).
+		Order("anonyname asc").
+		Pluck("anonyname", &names).Error
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			var names []string
-			err = tx.
-				Clauses(clause.Locking{Strength: "UPDATE"}).
-				Model(&TopicAnonynameMapping{}).
-				Where("topic_id = ?", topicID).
-				Order("anonyname asc").
-				Pluck("anonyname", &names).Error
-			if err != nil {
-				return "", err
-			}
-
-			anonyname = utils.GenerateName(names)
-			err = tx.Create(&TopicAnonynameMapping{
-				TopicID:   topicID,
-				UserID:    userID,
-				Anonyname: anonyname,
-			}).Error
-			if err != nil {
-				return anonyname, err
-			}
-		} else {
-			return "", err
-		}
+		return "", err
 	}
-	return anonyname, nil
+
+	anonyname = utils.GenerateName(names)
+	err = tx.Model(model).Create(map[string]any{
+		scopeColumn: scopeID,
+		"user_id":   userID,
+		"anonyname": anonyname,
+	}).Error
+	return
+}
+
+// TagUserSubscriptions 用户订阅标签，订阅标签下的帖子会出现在用户的时间线中
+type TagUserSubscriptions struct {
+	UserID    int       `json:"user_id" gorm:"primaryKey"`
+	TagID     int       `json:"tag_id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type CommentUserLikes struct {
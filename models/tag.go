@@ -0,0 +1,169 @@
+package models
+
+import (
+	"chatdan_backend/utils"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// TagAlias 标签别名，创建帖子时使用别名会解析为对应的标签
+// 合并标签后，被合并的标签名成为目标标签的别名
+type TagAlias struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name" gorm:"size:64;not null;uniqueIndex"` // 规范化的别名
+	TagID     int       `json:"tag_id" gorm:"not null;index"`
+	Tag       *Tag      `json:"tag" gorm:"foreignKey:TagID"`
+}
+
+// SubscribedTag 订阅的标签，附带订阅时间，用于按订阅时间分页
+type SubscribedTag struct {
+	Tag
+	SubscribedAt time.Time `json:"subscribed_at"`
+}
+
+var SubscribedTagCursorOrder = CursorOrder{
+	Column:   "tag_user_subscriptions.created_at",
+	Field:    "SubscribedAt",
+	IDColumn: "tag.id",
+	Desc:     true,
+}
+
+// NormalizeTagName 规范化标签名：全角字符转换为半角，半角片假名转换为全角，英文字母转换为小写，合并连续的空白字符
+func NormalizeTagName(name string) string {
+	// 半角片假名的浊点转换后是组合字符，需要再组合
+	name = strings.ToLower(norm.NFC.String(width.Fold.String(name)))
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func (t *Tag) BeforeCreate(_ *gorm.DB) error {
+	t.NormalizedName = NormalizeTagName(t.Name)
+	return nil
+}
+
+// findTagsByNormalizedNames 按照规范化的标签名或别名查找标签，返回规范化的名称到标签的映射
+func findTagsByNormalizedNames(tx *gorm.DB, normalizedNames []string) (tagMap map[string]*Tag, err error) {
+	tagMap = make(map[string]*Tag, len(normalizedNames))
+	if len(normalizedNames) == 0 {
+		return
+	}
+
+	var tags []*Tag
+	if err = tx.Where("normalized_name IN ?", normalizedNames).Find(&tags).Error; err != nil {
+		return
+	}
+	for _, tag := range tags {
+		tagMap[tag.NormalizedName] = tag
+	}
+
+	var aliases []TagAlias
+	if err = tx.Preload("Tag").Where("name IN ?", normalizedNames).Find(&aliases).Error; err != nil {
+		return
+	}
+	for _, alias := range aliases {
+		if _, found := tagMap[alias.Name]; !found && alias.Tag != nil {
+			tagMap[alias.Name] = alias.Tag
+		}
+	}
+	return
+}
+
+// FindTagByName 按照标签名或别名查找标签，大小写和全角半角不敏感
+func FindTagByName(tx *gorm.DB, name string) (tag *Tag, err error) {
+	normalizedName := NormalizeTagName(name)
+	tagMap, err := findTagsByNormalizedNames(tx, []string{normalizedName})
+	if err != nil {
+		return
+	}
+	tag, found := tagMap[normalizedName]
+	if !found {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return
+}
+
+// CreateTagAlias 为标签创建别名，别名不能与已有的标签或别名重复
+func CreateTagAlias(tx *gorm.DB, tagID int, name string) (alias TagAlias, err error) {
+	alias = TagAlias{Name: NormalizeTagName(name), TagID: tagID}
+	if alias.Name == "" {
+		return alias, utils.BadRequest("别名不能为空")
+	}
+	if _, err = FindTagByName(tx, alias.Name); err == nil {
+		return alias, utils.BadRequest("别名与已有的标签或别名重复")
+	} else if err != gorm.ErrRecordNotFound {
+		return
+	}
+	err = tx.Create(&alias).Error
+	return
+}
+
+// MergeTags 将标签 from 合并到标签 to：帖子和订阅转移到 to，from 的名称和别名成为 to 的别名，然后彻底删除 from
+// from 的名称已经成为别名，保留软删除的记录会占用规范化名称的唯一索引
+// 需要在事务中调用，调用方负责更新缓存和搜索引擎
+func MergeTags(tx *gorm.DB, fromID, toID int) (to Tag, err error) {
+	if fromID == toID {
+		return to, utils.BadRequest("不能合并到自身")
+	}
+
+	// 按照 ID 顺序加锁
+	var tags []Tag
+	if err = tx.Clauses(LockClause).Where("id IN ?", []int{fromID, toID}).Order("id").Find(&tags).Error; err != nil {
+		return
+	}
+	if len(tags) != 2 {
+		return to, gorm.ErrRecordNotFound
+	}
+	from := tags[0]
+	to = tags[1]
+	if from.ID != fromID {
+		from, to = to, from
+	}
+
+	// 同时带有两个标签的帖子，删除 from 的关联，剩下的关联转移到 to
+	for _, table := range []struct{ Name, Column string }{
+		{"topic_tags", "topic_id"},
+		{"tag_user_subscriptions", "user_id"},
+	} {
+		var duplicated []int
+		if err = tx.Table(table.Name).Where("tag_id = ?", to.ID).Pluck(table.Column, &duplicated).Error; err != nil {
+			return
+		}
+		if len(duplicated) > 0 {
+			if err = tx.Table(table.Name).Where("tag_id = ? AND "+table.Column+" IN ?", from.ID, duplicated).
+				Delete(nil).Error; err != nil {
+				return
+			}
+		}
+		if err = tx.Table(table.Name).Where("tag_id = ?", from.ID).Update("tag_id", to.ID).Error; err != nil {
+			return
+		}
+	}
+
+	// 重新计算热度
+	var temperature int64
+	if err = tx.Table("topic_tags").
+		Where("tag_id = ? AND topic_id IN (?)", to.ID, tx.Model(&Topic{}).Select("id")).
+		Count(&temperature).Error; err != nil {
+		return
+	}
+	to.Temperature = int(temperature)
+	if err = tx.Model(&to).UpdateColumn("temperature", to.Temperature).Error; err != nil {
+		return
+	}
+
+	// from 的别名和名称转移到 to
+	if err = tx.Model(&TagAlias{}).Where("tag_id = ?", from.ID).Update("tag_id", to.ID).Error; err != nil {
+		return
+	}
+	if from.NormalizedName != to.NormalizedName {
+		if err = tx.Create(&TagAlias{Name: from.NormalizedName, TagID: to.ID}).Error; err != nil {
+			return
+		}
+	}
+
+	err = tx.Unscoped().Delete(&from).Error
+	return
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestNormalizeTagName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Golang":     "golang",
+		"ＧｏＬａｎｇ":     "golang",
+		" 复旦　 大学 ":   "复旦 大学",
+		"ﾀｸﾞ":        "タグ",
+		"C++":        "c++",
+		"Ｃ＋＋ Primer": "c++ primer",
+	} {
+		if got := NormalizeTagName(name); got != expected {
+			t.Errorf("NormalizeTagName(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestTagManagement(t *testing.T) {
+	db := newTestDB(t, "test_tag")
+	var err error
+
+	// 大小写和全角半角不同的标签名解析为同一个标签
+	var topic Topic
+	if err = topic.FindOrCreateTags(db, []string{"Golang", "ｇｏｌａｎｇ", "Rust"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(topic.Tags) != 2 || topic.Tags[0].Name != "Golang" || topic.Tags[1].Name != "Rust" {
+		t.Fatalf("expected tags Golang and Rust, got %+v", topic.Tags)
+	}
+	golang, rust := *topic.Tags[0], *topic.Tags[1]
+	if err = db.Create(&Tag{Name: "GOLANG"}).Error; err == nil {
+		t.Fatal("expected unique normalized name")
+	}
+
+	// 别名解析为对应的标签
+	if _, err = CreateTagAlias(db, golang.ID, "Go"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CreateTagAlias(db, rust.ID, "GOLANG"); err == nil {
+		t.Fatal("expected error for alias conflicting with existing tag")
+	}
+	if err = topic.FindOrCreateTags(db, []string{"GO"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(topic.Tags) != 1 || topic.Tags[0].ID != golang.ID {
+		t.Fatalf("expected alias to resolve to tag %d, got %+v", golang.ID, topic.Tags)
+	}
+
+	// 锁定的标签不能使用
+	if err = db.Model(&rust).Update("is_locked", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = topic.FindOrCreateTags(db, []string{"rust"}); err == nil {
+		t.Fatal("expected error for locked tag")
+	}
+	if err = db.Model(&rust).Update("is_locked", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 合并标签：同时带有两个标签的帖子只保留一个关联，热度重新计算
+	topics := []Topic{
+		{Title: "a", Content: "a", DivisionID: 1, Tags: []*Tag{&golang}},
+		{Title: "b", Content: "b", DivisionID: 1, Tags: []*Tag{&rust}},
+		{Title: "c", Content: "c", DivisionID: 1, Tags: []*Tag{&golang, &rust}},
+	}
+	if err = db.Create(&topics).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&TagUserSubscriptions{UserID: 1, TagID: rust.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var merged Tag
+	if err = db.Transaction(func(tx *gorm.DB) (err error) {
+		merged, err = MergeTags(tx, rust.ID, golang.ID)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if merged.ID != golang.ID || merged.Temperature != 3 {
+		t.Fatalf("expected tag %d with temperature 3, got %+v", golang.ID, merged)
+	}
+	var count int64
+	if err = db.Table("topic_tags").Where("tag_id = ?", golang.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 topics in merged tag, got %d", count)
+	}
+	if err = db.Model(&TagUserSubscriptions{}).Where("tag_id = ?", golang.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected subscription moved to merged tag, got %d", count)
+	}
+
+	// 被合并的标签名成为别名
+	tag, err := FindTagByName(db, "ＲＵＳＴ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.ID != golang.ID {
+		t.Fatalf("expected merged tag name to resolve to %d, got %d", golang.ID, tag.ID)
+	}
+	if err = db.Unscoped().Model(&Tag{}).Where("id = ?", rust.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatal("expected merged tag to be deleted")
+	}
+	if _, err = MergeTags(db, golang.ID, golang.ID); err == nil {
+		t.Fatal("expected error merging tag into itself")
+	}
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"chatdan_backend/utils"
+	"fmt"
+	"github.com/goccy/go-json"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	timelineCacheSize         = 500              // 缓存的时间线长度，超出部分从数据库查询
+	timelineCacheExpiration   = 10 * time.Minute // 缓存的时间线过期时间
+	timelineRefreshInterval   = 30 * time.Second // 缓存的时间线超过该时间后读取时合并新发布的话题
+	timelineHeavyReaderReads  = 20               // 时间窗口内读取时间线超过该次数的用户使用缓存的时间线
+	timelineHeavyReaderWindow = time.Hour
+)
+
+// timelineEntry 缓存的时间线中的一条话题，保存排序值用于游标分页
+type timelineEntry struct {
+	ID        int       `json:"i"`
+	CreatedAt time.Time `json:"c"`
+}
+
+// timelineCache 缓存的时间线，按照创建时间倒序
+type timelineCache struct {
+	Entries   []timelineEntry `json:"entries"`
+	Complete  bool            `json:"complete"` // 为 true 时 Entries 包含时间线的全部话题
+	RefreshAt time.Time       `json:"refresh_at"`
+}
+
+// timelineReads 用户在时间窗口内读取时间线的次数
+type timelineReads struct {
+	Count int       `json:"count"`
+	Since time.Time `json:"since"`
+}
+
+var timelineOrder = CursorOrder{Column: "created_at", Desc: true}
+
+func timelineCacheKey(userID int) string {
+	return fmt.Sprintf("timeline:%d", userID)
+}
+
+// TimelineQuery 用户时间线包含的话题：关注用户发布的话题和订阅标签下的话题，不包含隐藏的话题
+// 关注用户发布的匿名话题不包含在内，否则会暴露匿名话题的发布者
+func TimelineQuery(tx *gorm.DB, userID int) *gorm.DB {
+	db := tx.Session(&gorm.Session{NewDB: true})
+	followedUsers := db.Model(&UserFollows{}).Select("user_id").Where("follower_id = ?", userID)
+	subscribedTags := db.Model(&TagUserSubscriptions{}).Select("tag_id").Where("user_id = ?", userID)
+	taggedTopics := db.Table("topic_tags").Select("topic_id").Where("tag_id IN (?)", subscribedTags)
+
+	return tx.Model(&Topic{}).
+		Where("is_hidden = ?", false).
+		Where(db.Where("poster_id IN (?) AND is_anonymous = ?", followedUsers, false).
+			Or("id IN (?)", taggedTopics))
+}
+
+// TimelineLoad 游标分页查询用户的时间线，按照话题创建时间倒序
+// 大多数用户每次读取时从数据库查询；频繁读取的用户从缓存的时间线中分页，
+// 缓存之后新发布的话题在读取时合并到缓存中。两种方式的游标相同，可以互相切换
+// tx 可以设置 Preload，不要设置查询条件、排序和分页
+func TimelineLoad(tx *gorm.DB, userID int, topics *[]Topic, request utils.CursorRequest) (response utils.CursorResponse, err error) {
+	if request.PageSize == 0 {
+		request.PageSize = 10
+	}
+	// 同一个 tx 用于多次查询
+	tx = tx.Session(&gorm.Session{})
+
+	var cursor *utils.Cursor
+	if request.Cursor != "" {
+		if cursor, err = utils.ParseCursor(request.Cursor); err != nil {
+			return
+		}
+	}
+
+	// 缓存的时间线只用于向后翻页
+	if isTimelineHeavyReader(userID) && (cursor == nil || !cursor.Backward) {
+		var ok bool
+		if response, ok, err = timelineCacheLoad(tx, userID, topics, cursor, request.PageSize); err != nil || ok {
+			return
+		}
+	}
+
+	return CursorLoad(TimelineQuery(tx, userID), topics, request, timelineOrder)
+}
+
+// InvalidateTimeline 关注、取消关注用户或者订阅、取消订阅标签后清除缓存的时间线
+func InvalidateTimeline(userID int) {
+	utils.Delete(timelineCacheKey(userID))
+}
+
+// isTimelineHeavyReader 记录一次读取，返回用户是否频繁读取时间线
+func isTimelineHeavyReader(userID int) bool {
+	key := fmt.Sprintf("timeline_reads:%d", userID)
+	var reads timelineReads
+	if err := utils.Get(key, &reads); err != nil || time.Since(reads.Since) > timelineHeavyReaderWindow {
+		reads = timelineReads{Since: time.Now()}
+	}
+	reads.Count++
+	_ = utils.Set(key, reads, timelineHeavyReaderWindow)
+	return reads.Count > timelineHeavyReaderReads
+}
+
+// timelineCacheLoad 从缓存的时间线中分页，缓存不足一页且不完整时返回 ok = false，由调用方从数据库查询
+func timelineCacheLoad(tx *gorm.DB, userID int, topics *[]Topic, cursor *utils.Cursor, pageSize int) (response utils.CursorResponse, ok bool, err error) {
+	timeline, err := loadTimelineCache(tx, userID)
+	if err != nil {
+		return
+	}
+
+	// 跳过游标及之前的话题
+	start := 0
+	if cursor != nil {
+		var key time.Time
+		if err = json.Unmarshal(cursor.Key, &key); err != nil {
+			return response, false, utils.BadRequest("invalid cursor")
+		}
+		for start < len(timeline.Entries) {
+			entry := timeline.Entries[start]
+			if entry.CreatedAt.Before(key) || entry.CreatedAt.Equal(key) && entry.ID < cursor.ID {
+				break
+			}
+			start++
+		}
+	}
+
+	// 缓存中的话题可能已经被删除或隐藏，分批加载直到凑满一页
+	// 多加载一条用于判断是否还有数据
+	result := make([]Topic, 0, pageSize+1)
+	for i := start; i < len(timeline.Entries) && len(result) <= pageSize; i += pageSize + 1 {
+		end := i + pageSize + 1
+		if end > len(timeline.Entries) {
+			end = len(timeline.Entries)
+		}
+		idArray := make([]int, 0, end-i)
+		for _, entry := range timeline.Entries[i:end] {
+			idArray = append(idArray, entry.ID)
+		}
+
+		var batch []Topic
+		if err = tx.Where("id IN ? AND is_hidden = ?", idArray, false).Find(&batch).Error; err != nil {
+			return
+		}
+		batchMap := make(map[int]Topic, len(batch))
+		for _, topic := range batch {
+			batchMap[topic.ID] = topic
+		}
+		for _, id := range idArray {
+			if topic, found := batchMap[id]; found && len(result) <= pageSize {
+				result = append(result, topic)
+			}
+		}
+	}
+	if len(result) <= pageSize && !timeline.Complete {
+		return response, false, nil
+	}
+
+	response.HasMore = len(result) > pageSize
+	if response.HasMore {
+		result = result[:pageSize]
+	}
+	*topics = result
+	if len(result) == 0 {
+		return response, true, nil
+	}
+
+	newCursor := func(topic *Topic, backward bool) string {
+		key, _ := json.Marshal(topic.CreatedAt)
+		return utils.Cursor{Key: key, ID: topic.ID, Backward: backward}.Encode()
+	}
+	if response.HasMore {
+		response.NextCursor = newCursor(&result[len(result)-1], false)
+	}
+	if cursor != nil {
+		response.PrevCursor = newCursor(&result[0], true)
+	}
+	return response, true, nil
+}
+
+// loadTimelineCache 加载缓存的时间线，不存在时从数据库生成，超过 timelineRefreshInterval 时合并新发布的话题
+func loadTimelineCache(tx *gorm.DB, userID int) (timeline timelineCache, err error) {
+	db := tx.Session(&gorm.Session{NewDB: true})
+	key := timelineCacheKey(userID)
+	if err = utils.Get(key, &timeline); err != nil {
+		if err != utils.ErrCacheMiss {
+			return
+		}
+
+		// 生成时间线
+		if err = TimelineQuery(db, userID).Select("id", "created_at").
+			Order("created_at desc").Order("id desc").
+			Limit(timelineCacheSize + 1).Scan(&timeline.Entries).Error; err != nil {
+			return
+		}
+		timeline.Complete = len(timeline.Entries) <= timelineCacheSize
+		if !timeline.Complete {
+			timeline.Entries = timeline.Entries[:timelineCacheSize]
+		}
+		timeline.RefreshAt = time.Now()
+		return timeline, utils.Set(key, timeline, timelineCacheExpiration)
+	}
+
+	if time.Since(timeline.RefreshAt) < timelineRefreshInterval {
+		return
+	}
+
+	// 合并缓存之后新发布的话题
+	querySet := TimelineQuery(db, userID).Select("id", "created_at")
+	if len(timeline.Entries) > 0 {
+		head := timeline.Entries[0]
+		querySet = querySet.Where("created_at > ? OR (created_at = ? AND id > ?)", head.CreatedAt, head.CreatedAt, head.ID)
+	}
+	var entries []timelineEntry
+	if err = querySet.Order("created_at desc").Order("id desc").
+		Limit(timelineCacheSize + 1).Scan(&entries).Error; err != nil {
+		return
+	}
+	if len(entries) > timelineCacheSize {
+		// 新话题过多，缓存中的话题与新话题之间可能有遗漏，只保留新话题
+		timeline.Entries = entries[:timelineCacheSize]
+		timeline.Complete = false
+	} else {
+		timeline.Entries = append(entries, timeline.Entries...)
+		if len(timeline.Entries) > timelineCacheSize {
+			timeline.Entries = timeline.Entries[:timelineCacheSize]
+			timeline.Complete = false
+		}
+	}
+	timeline.RefreshAt = time.Now()
+	return timeline, utils.Set(key, timeline, timelineCacheExpiration)
+}
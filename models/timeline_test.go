@@ -0,0 +1,108 @@
+package models
+
+import (
+	"chatdan_backend/utils"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimelineLoad(t *testing.T) {
+	db := newTestDB(t, "test_timeline")
+	var err error
+
+	reader := User{Username: "timeline_reader"}
+	followed := User{Username: "timeline_followed"}
+	stranger := User{Username: "timeline_stranger"}
+	for _, user := range []*User{&reader, &followed, &stranger} {
+		if err = db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag := Tag{Name: "timeline"}
+	if err = db.Create(&tag).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&UserFollows{UserID: followed.ID, FollowerID: reader.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&TagUserSubscriptions{UserID: reader.ID, TagID: tag.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 关注用户的话题和订阅标签下的话题，关注用户在订阅标签下的话题只出现一次
+	now := time.Now()
+	var expected []int
+	for i := 0; i < 25; i++ {
+		topic := Topic{Title: "t", Content: "t", DivisionID: 1, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		switch i % 5 {
+		case 0:
+			topic.PosterID = followed.ID
+		case 1:
+			topic.PosterID = stranger.ID
+			topic.Tags = []*Tag{&tag}
+		case 2:
+			topic.PosterID = followed.ID
+			topic.Tags = []*Tag{&tag}
+		case 3:
+			// 关注用户的匿名话题不出现
+			topic.PosterID = followed.ID
+			topic.IsAnonymous = true
+		case 4:
+			// 隐藏的话题不出现
+			topic.PosterID = followed.ID
+			topic.IsHidden = true
+		}
+		if err = db.Create(&topic).Error; err != nil {
+			t.Fatal(err)
+		}
+		if i%5 < 3 {
+			expected = append([]int{topic.ID}, expected...)
+		}
+	}
+	// 其他用户未打标签的话题不出现
+	if err = db.Create(&Topic{Title: "t", Content: "t", DivisionID: 1, PosterID: stranger.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	loadAll := func() (idArray []int) {
+		request := utils.CursorRequest{PageSize: 4}
+		for {
+			var topics []Topic
+			response, err := TimelineLoad(db, reader.ID, &topics, request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, topic := range topics {
+				idArray = append(idArray, topic.ID)
+			}
+			if !response.HasMore {
+				return
+			}
+			request.Cursor = response.NextCursor
+		}
+	}
+
+	// 从数据库查询
+	InvalidateTimeline(reader.ID)
+	utils.Delete(fmt.Sprintf("timeline_reads:%d", reader.ID))
+	if got := loadAll(); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	// 频繁读取后从缓存的时间线查询，结果相同
+	for i := 0; i < timelineHeavyReaderReads; i++ {
+		isTimelineHeavyReader(reader.ID)
+	}
+	if got := loadAll(); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v from cache, got %v", expected, got)
+	}
+
+	// 缓存之后隐藏的话题不出现
+	if err = db.Model(&Topic{}).Where("id = ?", expected[0]).Update("is_hidden", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := loadAll(); fmt.Sprint(got) != fmt.Sprint(expected[1:]) {
+		t.Fatalf("expected %v from cache, got %v", expected[1:], got)
+	}
+}
@@ -113,6 +113,19 @@ type UserFollows struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+// FollowedUser 关注关系中的用户，附带关注时间，用于按关注时间分页
+type FollowedUser struct {
+	User
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+var FollowedUserCursorOrder = CursorOrder{
+	Column:   "user_follows.created_at",
+	Field:    "FollowedAt",
+	IDColumn: "user.id",
+	Desc:     true,
+}
+
 type UserJwtSecret struct {
 	UserID int    `json:"id" gorm:"primaryKey"`
 	Secret string `json:"secret" gorm:"size:256"`
@@ -2,9 +2,20 @@ package models
 
 import (
 	"chatdan_backend/utils"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/juju/errors"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,46 +40,67 @@ func CacheNameFromTableName(tableName string, id int) (name string) {
 	return tableName + ":" + strconv.Itoa(id)
 }
 
+const (
+	pageSnapshotChunkSize  = 1000             // 快照每个分片的 ID 数量
+	pageSnapshotExpiration = 10 * time.Minute // 快照的过期时间
+	pageSnapshotRefreshAge = 5 * time.Minute  // 快照超过这个时间后，在后台生成新的快照
+)
+
+// PageSnapshot 分页快照的元数据
+// 快照中的 ID 数组按照 pageSnapshotChunkSize 分片，压缩后分别存储在 key:version:chunk_index 中，
+// 翻页时只读取需要的分片，避免单个缓存值过大
+type PageSnapshot struct {
+	Version   int `json:"version"`    // 版本号，生成快照时的时间戳（微秒）
+	Total     int `json:"total"`      // ID 总数
+	ChunkSize int `json:"chunk_size"` // 每个分片的 ID 数量
+}
+
+func (s PageSnapshot) chunkKey(key string, index int) string {
+	return key + ":" + strconv.Itoa(s.Version) + ":" + strconv.Itoa(index)
+}
+
 // PageLoad 分页查询
 // tx 数据库包含表和查询条件
 func PageLoad[T IDTabler](tx *gorm.DB, models *[]T, key string, request utils.PageRequest) (version, total int, err error) {
-	var idArray []int
-
-	// 设置版本号
-	if request.Version != 0 {
-		version = request.Version
-	} else {
-		// 读取最新版本号的缓存
-		if version, idArray, err = loadLatestVersion(tx, key); err != nil {
-			return
-		}
-		goto PROCESS
+	idArray, version, total, err := SnapshotLoad(tx, key, request.Version, (request.PageNum-1)*request.PageSize, request.PageSize)
+	if err != nil || len(idArray) == 0 {
+		return
 	}
 
-	// 从版本号缓存中读取 idArray
-	if err = utils.Get(key+":"+strconv.Itoa(version), &idArray); err != nil {
-		if err != utils.ErrCacheMiss {
-			return
-		}
+	err = LoadModelByIDArray(tx.Session(&gorm.Session{NewDB: true}), models, idArray)
 
-		// 缓存中没有，加载最新版本号的缓存
-		if version, idArray, err = loadLatestVersion(tx, key); err != nil {
+	return
+}
+
+// SnapshotLoad 从快照中读取第 offset 个开始的 size 个 ID，快照不存在时使用最新版本
+// tx 数据库包含表、查询条件和排序，requestVersion 为 0 时使用最新版本
+func SnapshotLoad(tx *gorm.DB, key string, requestVersion, offset, size int) (idArray []int, version, total int, err error) {
+	var snapshot *PageSnapshot
+
+	// 读取指定版本号的快照，不存在时使用最新版本
+	if requestVersion != 0 {
+		var value PageSnapshot
+		if err = utils.Get(key+":"+strconv.Itoa(requestVersion), &value); err != nil {
+			if err != utils.ErrCacheMiss {
+				return
+			}
+		} else {
+			snapshot = &value
+		}
+	}
+	if snapshot == nil {
+		if snapshot, err = loadLatestVersion(tx, key); err != nil {
 			return
 		}
 	}
 
-PROCESS:
-	singleTx := tx.Session(&gorm.Session{NewDB: true})
-
 	// 设置总数
-	total = len(idArray)
+	version, total = snapshot.Version, snapshot.Total
 	if total == 0 {
 		return
 	}
 
 	// 设置分页
-	size := request.PageSize
-	offset := (request.PageNum - 1) * request.PageSize
 	if offset >= total {
 		return
 	}
@@ -76,58 +108,286 @@ PROCESS:
 		size = total - offset
 	}
 
-	err = LoadModelByIDArray(singleTx, models, idArray[offset:offset+size])
+	// 读取分页所在的分片
+	idArray, err = loadSnapshotIDArray(key, snapshot, offset, size)
+	if err == utils.ErrCacheMiss {
+		// 分片已被淘汰，重新生成快照
+		if snapshot, err = SetLatestVersion(tx, key); err != nil {
+			return
+		}
+		version, total = snapshot.Version, snapshot.Total
+		if offset >= total {
+			return
+		}
+		if offset+size > total {
+			size = total - offset
+		}
+		idArray, err = loadSnapshotIDArray(key, snapshot, offset, size)
+	}
 
 	return
 }
 
-type PageVersionValue struct {
-	Version int   `json:"version"`
-	IDArray []int `json:"id_array"`
+func loadSnapshotIDArray(key string, snapshot *PageSnapshot, offset, size int) (idArray []int, err error) {
+	idArray = make([]int, 0, size)
+	for index := offset / snapshot.ChunkSize; index <= (offset+size-1)/snapshot.ChunkSize; index++ {
+		var data []byte
+		if err = utils.Get(snapshot.chunkKey(key, index), &data); err != nil {
+			return
+		}
+		var chunk []int
+		if chunk, err = decodeIDArray(data); err != nil {
+			return
+		}
+
+		// 截取分片中属于当前页的部分
+		start, end := offset-index*snapshot.ChunkSize, offset+size-index*snapshot.ChunkSize
+		if start < 0 {
+			start = 0
+		}
+		if end > len(chunk) {
+			end = len(chunk)
+		}
+		if start < end {
+			idArray = append(idArray, chunk[start:end]...)
+		}
+	}
+	return
 }
 
-func loadLatestVersion(tx *gorm.DB, key string) (version int, idArray []int, err error) {
+// pageSnapshotRefreshing 正在后台生成快照的 key
+var pageSnapshotRefreshing sync.Map
+
+func loadLatestVersion(tx *gorm.DB, key string) (snapshot *PageSnapshot, err error) {
 	// 读取最新版本号的缓存
-	var value PageVersionValue
+	var value PageSnapshot
 	if err = utils.Get(key+":latest", &value); err != nil {
 		if err != utils.ErrCacheMiss {
 			return
 		}
 
 		return SetLatestVersion(tx, key)
-	} else {
-		idArray = value.IDArray
-		version = value.Version
 	}
-	return
+
+	// 快照较旧时在后台刷新，本次请求仍然使用旧快照
+	if time.Since(time.UnixMicro(int64(value.Version))) > pageSnapshotRefreshAge {
+		if _, refreshing := pageSnapshotRefreshing.LoadOrStore(key, struct{}{}); !refreshing {
+			go func() {
+				defer pageSnapshotRefreshing.Delete(key)
+				if _, err := SetLatestVersion(tx, key); err != nil {
+					utils.Logger.Error("refresh page snapshot error", zap.String("key", key), zap.Error(err))
+				}
+			}()
+		}
+	}
+
+	return &value, nil
 }
 
-func SetLatestVersion(tx *gorm.DB, key string) (version int, idArray []int, err error) {
+// SetLatestVersion 从数据库中生成新的快照，并设置为最新版本
+// 逐行读取 ID，每满一个分片就写入缓存，内存占用与表的大小无关
+func SetLatestVersion(tx *gorm.DB, key string) (snapshot *PageSnapshot, err error) {
 	// 生成当前版本号
-	version = int(time.Now().UnixMicro())
+	snapshot = &PageSnapshot{
+		Version:   int(time.Now().UnixMicro()),
+		ChunkSize: pageSnapshotChunkSize,
+	}
 
-	// 缓存中没有，从数据库中加载 idArray
-	if err = tx.Pluck("id", &idArray).Error; err != nil {
+	rows, err := tx.Session(&gorm.Session{}).Select("id").Rows()
+	if err != nil {
 		return
 	}
-	if len(idArray) == 0 {
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	// 分片写入缓存
+	chunk := make([]int, 0, pageSnapshotChunkSize)
+	flush := func() error {
+		index := snapshot.Total / pageSnapshotChunkSize
+		snapshot.Total += len(chunk)
+		err := utils.Set(snapshot.chunkKey(key, index), encodeIDArray(chunk), pageSnapshotExpiration)
+		chunk = chunk[:0]
+		return err
+	}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+		chunk = append(chunk, id)
+		if len(chunk) == pageSnapshotChunkSize {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
 		return
 	}
+	if len(chunk) > 0 {
+		if err = flush(); err != nil {
+			return
+		}
+	}
 
 	// 设置版本号缓存
-	if err = utils.Set(key+":"+strconv.Itoa(version), idArray, 10*time.Minute); err != nil {
+	if err = utils.Set(key+":"+strconv.Itoa(snapshot.Version), snapshot, pageSnapshotExpiration); err != nil {
 		return
 	}
 
 	// 设置最新版本号缓存
 	// 这里设置的过期时间要比版本号缓存的过期时间要短，防止其他请求读到错误的最新版本号
-	if err = utils.Set(key+":latest", PageVersionValue{
-		Version: version,
-		IDArray: idArray,
-	}, 9*time.Minute); err != nil {
+	if err = utils.Set(key+":latest", snapshot, pageSnapshotExpiration-time.Minute); err != nil {
+		return
+	}
+
+	return
+}
+
+// encodeIDArray 压缩 ID 数组，存储相邻 ID 的差值的 varint 编码
+// 按 ID 排序时差值很小，每个 ID 通常只需要 1 字节
+func encodeIDArray(idArray []int) []byte {
+	data := make([]byte, 0, len(idArray)*2)
+	var last int64
+	for _, id := range idArray {
+		data = binary.AppendVarint(data, int64(id)-last)
+		last = int64(id)
+	}
+	return data
+}
+
+func decodeIDArray(data []byte) (idArray []int, err error) {
+	var last int64
+	for len(data) > 0 {
+		delta, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, errors.New("invalid page snapshot chunk")
+		}
+		last += delta
+		idArray = append(idArray, int(last))
+		data = data[n:]
+	}
+	return
+}
+
+// CursorOrder 游标分页的排序方式，排序值相同时按照 IDColumn 排序，保证顺序稳定
+type CursorOrder struct {
+	Column   string // 排序列，可以带表名，如 topic.updated_at
+	Field    string // 模型中排序列对应的字段名或列名，默认为 Column 去掉表名
+	IDColumn string // 排序值相同时使用的列，默认为 id
+	Desc     bool   // 是否倒序
+}
+
+// NewCursorOrder 从 "updated_at desc" 形式的字符串构造排序方式
+func NewCursorOrder(orderBy string) CursorOrder {
+	fields := strings.Fields(orderBy)
+	order := CursorOrder{Column: fields[0]}
+	if len(fields) > 1 && strings.ToLower(fields[1]) == "desc" {
+		order.Desc = true
+	}
+	return order
+}
+
+var cursorSchemaCache sync.Map
+
+// CursorLoad 游标分页查询
+// tx 数据库包含表和查询条件，不要在 tx 中设置排序和分页
+// 以游标对应数据的排序值和 ID 作为边界查询，翻页过程中插入新数据不会导致数据重复或遗漏
+func CursorLoad[T IDModel](tx *gorm.DB, models *[]T, request utils.CursorRequest, order CursorOrder) (response utils.CursorResponse, err error) {
+	if order.IDColumn == "" {
+		order.IDColumn = "id"
+	}
+	if order.Field == "" {
+		order.Field = order.Column[strings.LastIndex(order.Column, ".")+1:]
+	}
+	if request.PageSize == 0 {
+		request.PageSize = 10
+	}
+
+	// 解析模型，用于读取和还原排序值
+	var _model T
+	modelSchema, err := schema.Parse(&_model, &cursorSchemaCache, tx.NamingStrategy)
+	if err != nil {
+		return
+	}
+	field := modelSchema.LookUpField(order.Field)
+	if field == nil {
+		return response, errors.Errorf("cursor field %s not found in %s", order.Field, modelSchema.Name)
+	}
+
+	// 列名需要按照数据库方言转义，如 PostgreSQL 中的 user 表
+	column, idColumn := tx.Statement.Quote(order.Column), tx.Statement.Quote(order.IDColumn)
+
+	// 根据游标设置边界
+	var backward bool
+	if request.Cursor != "" {
+		var cursor *utils.Cursor
+		if cursor, err = utils.ParseCursor(request.Cursor); err != nil {
+			return
+		}
+		key := reflect.New(field.FieldType)
+		if err = json.Unmarshal(cursor.Key, key.Interface()); err != nil {
+			return response, utils.BadRequest("invalid cursor")
+		}
+		backward = cursor.Backward
+
+		// 向前翻页时比较方向相反
+		operator := ">"
+		if order.Desc != backward {
+			operator = "<"
+		}
+		tx = tx.Where(
+			fmt.Sprintf("(%[1]s %[3]s @key OR (%[1]s = @key AND %[2]s %[3]s @id))", column, idColumn, operator),
+			sql.Named("key", key.Elem().Interface()),
+			sql.Named("id", cursor.ID),
+		)
+	}
+
+	// 设置排序，向前翻页时排序方向相反，查询后再反转
+	direction := " asc"
+	if order.Desc != backward {
+		direction = " desc"
+	}
+	if err = tx.Order(column + direction).Order(idColumn + direction).
+		Limit(request.PageSize + 1).Find(models).Error; err != nil {
 		return
 	}
 
+	// 多查询一条数据用于判断是否还有数据
+	response.HasMore = len(*models) > request.PageSize
+	if response.HasMore {
+		*models = (*models)[:request.PageSize]
+	}
+	if backward {
+		for i, j := 0, len(*models)-1; i < j; i, j = i+1, j-1 {
+			(*models)[i], (*models)[j] = (*models)[j], (*models)[i]
+		}
+	}
+	if len(*models) == 0 {
+		return
+	}
+
+	newCursor := func(model *T, backward bool) string {
+		value, _ := field.ValueOf(context.Background(), reflect.ValueOf(model))
+		key, _ := json.Marshal(value)
+		return utils.Cursor{Key: key, ID: (*model).GetID(), Backward: backward}.Encode()
+	}
+	first, last := &(*models)[0], &(*models)[len(*models)-1]
+	if backward {
+		if response.HasMore {
+			response.PrevCursor = newCursor(first, true)
+		}
+		response.NextCursor = newCursor(last, false)
+	} else {
+		if response.HasMore {
+			response.NextCursor = newCursor(last, false)
+		}
+		if request.Cursor != "" {
+			response.PrevCursor = newCursor(first, true)
+		}
+	}
+
 	return
 }
 
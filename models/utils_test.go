@@ -0,0 +1,184 @@
+package models
+
+import (
+	"chatdan_backend/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type pageLoadModel struct {
+	ID   int `json:"id" gorm:"primaryKey"`
+	Name string
+}
+
+func (pageLoadModel) TableName() string {
+	return "page_load_model"
+}
+
+func (m pageLoadModel) GetID() int {
+	return m.ID
+}
+
+// newTestDB 创建迁移完成的内存数据库，同名数据库在同一进程中共享
+func newTestDB(tb testing.TB, name string) *gorm.DB {
+	utils.InitCache()
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{
+		NamingStrategy: gormConfig.NamingStrategy,
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = setupJoinTables(db); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err = MigrateUp(db); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// newPageLoadDB 创建包含 size 条数据的内存数据库
+func newPageLoadDB(tb testing.TB, name string, size int) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = db.AutoMigrate(&pageLoadModel{}); err != nil {
+		tb.Fatal(err)
+	}
+
+	// 同名数据库在同一进程中共享，已经创建过的数据不再重复插入
+	var count int64
+	if err = db.Model(&pageLoadModel{}).Count(&count).Error; err != nil {
+		tb.Fatal(err)
+	}
+
+	models := make([]pageLoadModel, 0, 10000)
+	for i := int(count) + 1; i <= size; i++ {
+		models = append(models, pageLoadModel{ID: i, Name: strconv.Itoa(i)})
+		if len(models) == cap(models) || i == size {
+			if err = db.CreateInBatches(models, 1000).Error; err != nil {
+				tb.Fatal(err)
+			}
+			models = models[:0]
+		}
+	}
+	return db
+}
+
+func TestPageLoad(t *testing.T) {
+	utils.InitCache()
+	db := newPageLoadDB(t, "test_page_load", 2500)
+	key := "test_page_load:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	var models []pageLoadModel
+	version, total, err := PageLoad(db.Model(&pageLoadModel{}).Order("id desc"), &models, key, utils.PageRequest{PageNum: 1, PageSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2500 || len(models) != 10 || models[0].ID != 2500 || models[9].ID != 2491 {
+		t.Fatalf("unexpected first page: total %d, models %v", total, models)
+	}
+
+	// 新数据不影响同一版本的分页
+	if err = db.Create(&pageLoadModel{ID: 2501}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 跨越分片的分页
+	models = nil
+	newVersion, total, err := PageLoad(db.Model(&pageLoadModel{}).Order("id desc"), &models, key, utils.PageRequest{PageNum: 10, PageSize: 110, Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion != version || total != 2500 || len(models) != 110 || models[0].ID != 1510 || models[109].ID != 1401 {
+		t.Fatalf("unexpected cross chunk page: version %d, total %d, models %v", newVersion, total, models)
+	}
+
+	// 最后一页
+	models = nil
+	_, _, err = PageLoad(db.Model(&pageLoadModel{}).Order("id desc"), &models, key, utils.PageRequest{PageNum: 25, PageSize: 100, Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 100 || models[99].ID != 1 {
+		t.Fatalf("unexpected last page: %v", models)
+	}
+
+	// 超出范围
+	models = nil
+	_, _, err = PageLoad(db.Model(&pageLoadModel{}).Order("id desc"), &models, key, utils.PageRequest{PageNum: 26, PageSize: 100, Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 0 {
+		t.Fatalf("unexpected page out of range: %v", models)
+	}
+
+	// 分片过期后重新生成快照
+	utils.Delete(PageSnapshot{Version: version}.chunkKey(key, 0))
+	models = nil
+	newVersion, total, err = PageLoad(db.Model(&pageLoadModel{}).Order("id desc"), &models, key, utils.PageRequest{PageNum: 1, PageSize: 10, Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newVersion == version || total != 2501 || models[0].ID != 2501 {
+		t.Fatalf("unexpected rebuilt snapshot: version %d, total %d, models %v", newVersion, total, models)
+	}
+}
+
+func TestEncodeIDArray(t *testing.T) {
+	idArray := []int{100, 99, 3, 1 << 40, 0, 7}
+	decoded, err := decodeIDArray(encodeIDArray(idArray))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(idArray) {
+		t.Fatalf("expected %v, got %v", idArray, decoded)
+	}
+	for i := range idArray {
+		if decoded[i] != idArray[i] {
+			t.Fatalf("expected %v, got %v", idArray, decoded)
+		}
+	}
+
+	if _, err = decodeIDArray([]byte{0x80}); err == nil {
+		t.Fatal("expected error for truncated chunk")
+	}
+}
+
+// BenchmarkPageLoad 在一百万条数据中随机翻页
+func BenchmarkPageLoad(b *testing.B) {
+	const size = 1_000_000
+	utils.InitCache()
+	db := newPageLoadDB(b, "bench_page_load", size)
+	key := "bench_page_load:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	request := utils.PageRequest{PageSize: 20}
+
+	// 预先生成快照，只测试翻页的耗时
+	snapshot, err := SetLatestVersion(db.Model(&pageLoadModel{}).Order("id desc"), key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	request.Version = snapshot.Version
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var models []pageLoadModel
+		request.PageNum = i*7919%(size/request.PageSize) + 1
+		_, total, err := PageLoad(db.Model(&pageLoadModel{}).Order("id desc"), &models, key, request)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if total != size || len(models) != request.PageSize {
+			b.Fatalf("unexpected page: total %d, models %d", total, len(models))
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"chatdan_backend/config"
+	"chatdan_backend/utils"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"sync"
+	"time"
+)
+
+const topicViewShardCount = 16
+
+type topicViewKey struct {
+	TopicID int
+	UserID  int
+}
+
+// topicViewShard 浏览数缓冲区的一个分片，按照话题 ID 分片以减少锁竞争
+type topicViewShard struct {
+	sync.Mutex
+	counts map[topicViewKey]int
+}
+
+var (
+	topicViewShards    [topicViewShardCount]topicViewShard
+	topicViewFlushStop chan struct{}
+	topicViewFlushDone chan struct{}
+)
+
+func init() {
+	for i := range topicViewShards {
+		topicViewShards[i].counts = make(map[topicViewKey]int)
+	}
+}
+
+// RecordTopicView 记录一次话题浏览，浏览数先累加在内存中，由 FlushTopicViews 定期写入数据库
+// 同一用户在 TOPIC_VIEW_DEDUPE_WINDOW 时间内多次浏览同一话题只计一次，不使用 redis 时窗口最长 10 分钟
+func RecordTopicView(topicID, userID int) (err error) {
+	dedupeKey := fmt.Sprintf("topic_view:%d:%d", topicID, userID)
+	first, err := utils.SetNX(dedupeKey, true, config.Config.TopicViewDedupeWindow)
+	if err != nil || !first {
+		return err
+	}
+
+	addTopicViews(map[topicViewKey]int{{TopicID: topicID, UserID: userID}: 1})
+	return nil
+}
+
+func addTopicViews(counts map[topicViewKey]int) {
+	for key, count := range counts {
+		shard := &topicViewShards[key.TopicID%topicViewShardCount]
+		shard.Lock()
+		shard.counts[key] += count
+		shard.Unlock()
+	}
+}
+
+// FlushTopicViews 将缓冲的浏览数写入数据库，每个话题只更新一次 view_count
+// 写入失败时浏览数放回缓冲区，等待下次写入
+func FlushTopicViews() (err error) {
+	pending := make(map[topicViewKey]int)
+	for i := range topicViewShards {
+		shard := &topicViewShards[i]
+		shard.Lock()
+		for key, count := range shard.counts {
+			pending[key] += count
+		}
+		shard.counts = make(map[topicViewKey]int)
+		shard.Unlock()
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	topicCounts := make(map[int]int)
+	keys := make([]topicViewKey, 0, len(pending))
+	for key, count := range pending {
+		topicCounts[key.TopicID] += count
+		keys = append(keys, key)
+	}
+	topicIDs := make([]int, 0, len(topicCounts))
+	for topicID := range topicCounts {
+		topicIDs = append(topicIDs, topicID)
+	}
+	// 浏览记录和话题都按照 ID 顺序加锁，避免多个实例同时写入时死锁
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].TopicID != keys[j].TopicID {
+			return keys[i].TopicID < keys[j].TopicID
+		}
+		return keys[i].UserID < keys[j].UserID
+	})
+	sort.Ints(topicIDs)
+
+	now := time.Now()
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			count := pending[key]
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "user_id"}, {Name: "topic_id"}},
+				DoUpdates: clause.Assignments(Map{
+					"updated_at": now,
+					"count":      gorm.Expr("count + ?", count),
+				}),
+			}).Create(&TopicUserViews{
+				TopicID: key.TopicID,
+				UserID:  key.UserID,
+				Count:   count,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, topicID := range topicIDs {
+			err := tx.Model(&Topic{}).Where("id = ?", topicID).
+				UpdateColumn("view_count", gorm.Expr("view_count + ?", topicCounts[topicID])).Error
+			if err != nil {
+				return err
+			}
+		}
+		return UpdateTopicHotScore(tx, topicIDs...)
+	})
+	if err != nil {
+		addTopicViews(pending)
+	}
+	return err
+}
+
+// StartTopicViewFlusher 按照 TOPIC_VIEW_FLUSH_INTERVAL 定期写入浏览数
+func StartTopicViewFlusher() {
+	topicViewFlushStop = make(chan struct{})
+	topicViewFlushDone = make(chan struct{})
+
+	go func() {
+		defer close(topicViewFlushDone)
+		ticker := time.NewTicker(config.Config.TopicViewFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := FlushTopicViews(); err != nil {
+					utils.Logger.Error("flush topic views error", zap.Error(err))
+				}
+			case <-topicViewFlushStop:
+				return
+			}
+		}
+	}()
+}
+
+// StopTopicViewFlusher 停止定期写入，并写入剩余的浏览数，在关闭服务时调用
+func StopTopicViewFlusher() {
+	if topicViewFlushStop != nil {
+		close(topicViewFlushStop)
+		<-topicViewFlushDone
+		topicViewFlushStop = nil
+	}
+
+	if err := FlushTopicViews(); err != nil {
+		utils.Logger.Error("flush topic views error", zap.Error(err))
+	}
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"chatdan_backend/config"
+	"chatdan_backend/utils"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlushTopicViews(t *testing.T) {
+	db := newTestDB(t, "test_view")
+	var err error
+	oldDB, oldWindow := DB, config.Config.TopicViewDedupeWindow
+	defer func() {
+		DB, config.Config.TopicViewDedupeWindow = oldDB, oldWindow
+	}()
+	DB, config.Config.TopicViewDedupeWindow = db, time.Minute
+
+	topic := Topic{Title: "view", Content: "view", DivisionID: 1}
+	if err = db.Create(&topic).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一用户的重复浏览只计一次，并发浏览同样如此
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for _, userID := range []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2} {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			errs <- RecordTopicView(topic.ID, userID)
+		}(userID)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = FlushTopicViews(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.First(&topic, topic.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if topic.ViewCount != 2 {
+		t.Fatalf("expected view count 2, got %d", topic.ViewCount)
+	}
+
+	// 窗口过后再次计数，累加到已有的记录上
+	utils.Delete(fmt.Sprintf("topic_view:%d:1", topic.ID))
+	if err = RecordTopicView(topic.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	StopTopicViewFlusher()
+
+	var views TopicUserViews
+	if err = db.Where("topic_id = ? AND user_id = ?", topic.ID, 1).First(&views).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.First(&topic, topic.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if views.Count != 2 || topic.ViewCount != 3 {
+		t.Fatalf("expected user views 2 and view count 3, got %d and %d", views.Count, topic.ViewCount)
+	}
+}
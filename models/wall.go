@@ -1,8 +1,14 @@
 package models
 
 import (
+	"chatdan_backend/config"
+	"chatdan_backend/utils"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
 	"time"
+	_ "time/tzdata" // 部署环境可能没有时区数据
 )
 
 type Wall struct {
@@ -11,14 +17,406 @@ type Wall struct {
 	UpdatedAt   time.Time      `json:"updated_at" gorm:"index"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 	Content     string         `json:"content"`
-	Visibility  string         `json:"visibility"`
+	ContentHTML string         `json:"content_html"` // 渲染后的内容
+	Visibility  string         `json:"visibility"`   // public 所有人可见，private 只有发布者、表白的对象和管理员可见
 	IsAnonymous bool           `json:"is_anonymous"`
+	IsHidden    bool           `json:"is_hidden" gorm:"not null;default:false"` // 被管理员隐藏，只有发布者和管理员可见
+
+	// 发布
+	DigestID   *int `json:"digest_id" gorm:"index"` // 发布后所属的每日表白墙，未发布时为 null
+	Sequence   int  `json:"sequence"`               // 在当日表白墙中的序号，从 1 开始，只有公开的表白编号
+	IsExcluded bool `json:"is_excluded"`            // 管理员在发布前排除，不会发布
+
+	// 统计数据
+	ReactionCount int `json:"reaction_count" gorm:"not null;default:0"` // 表情回应数
+	CommentCount  int `json:"comment_count" gorm:"not null;default:0"`  // 回复数
+	Popularity    int `json:"popularity" gorm:"not null;default:0"`     // 热度，回应数加上两倍的回复数
 
 	// 关联数据
 	PosterID int   `json:"poster_id"`
 	Poster   *User `json:"-" gorm:"foreignKey:PosterID"`
+	TargetID *int  `json:"-" gorm:"index"` // 表白的对象，只有发布者和对象本人可以知道
+}
+
+func (Wall) TableName() string {
+	return "wall"
+}
+
+func (w Wall) GetID() int {
+	return w.ID
 }
 
 func (w *Wall) IsPublic() bool {
 	return w.Visibility == Public
 }
+
+// IsTarget 用户是否为表白的对象
+func (w *Wall) IsTarget(userID int) bool {
+	return userID != 0 && w.TargetID != nil && *w.TargetID == userID
+}
+
+// VisibleTo 用户是否可以查看表白，管理员可以查看所有表白，不公开的表白对象也可以查看
+func (w *Wall) VisibleTo(user *User) bool {
+	if user.IsAdmin || (user.ID != 0 && user.ID == w.PosterID) {
+		return true
+	}
+	return !w.IsHidden && (w.IsPublic() || w.IsTarget(user.ID))
+}
+
+// WallVisibleTo 查询用户可以查看的表白，与 Wall.VisibleTo 一致
+func WallVisibleTo(user *User) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if user.IsAdmin {
+			return tx
+		}
+		if user.ID == 0 {
+			return tx.Where("is_hidden = ? AND visibility = ?", false, Public)
+		}
+		return tx.Where("(is_hidden = ? AND (visibility = ? OR target_id = ?)) OR poster_id = ?", false, Public, user.ID, user.ID)
+	}
+}
+
+// WallMatch 互相表白，双方都有以对方为对象、已经发布到表白墙并且未被隐藏的表白
+type WallMatch struct {
+	UserID        int       `json:"user_id"`         // 对方
+	WallID        int       `json:"wall_id"`         // 自己发布的表白
+	MatchedWallID int       `json:"matched_wall_id"` // 对方发布的表白
+	MatchedAt     time.Time `json:"matched_at"`      // 后发布的表白的创建时间
+}
+
+// FindWallMatches 查询与 userID 互相表白的用户，userIDs 不为空时只查询这些用户，每个用户只返回最近的一对表白
+// 互相表白由现有的表白决定，删除或者隐藏表白之后不再互相表白
+// 未发布或者被排除的表白不算，否则可以在发布之前发一条表白试探对方，再在发布之前删除
+func FindWallMatches(tx *gorm.DB, userID int, userIDs ...int) (matches []WallMatch, err error) {
+	var rows []struct {
+		UserID           int
+		WallID           int
+		MatchedWallID    int
+		CreatedAt        time.Time
+		MatchedCreatedAt time.Time
+	}
+	query := tx.Table("wall AS w1").
+		Select("w2.poster_id AS user_id, w1.id AS wall_id, w2.id AS matched_wall_id, w1.created_at AS created_at, w2.created_at AS matched_created_at").
+		Joins("JOIN wall AS w2 ON w2.poster_id = w1.target_id AND w2.target_id = w1.poster_id").
+		Where("w1.poster_id = ? AND w1.deleted_at IS NULL AND w2.deleted_at IS NULL AND w1.is_hidden = ? AND w2.is_hidden = ?", userID, false, false).
+		Where("w1.digest_id IS NOT NULL AND w2.digest_id IS NOT NULL AND w1.is_excluded = ? AND w2.is_excluded = ?", false, false)
+	if len(userIDs) > 0 {
+		query = query.Where("w2.poster_id IN ?", userIDs)
+	}
+	if err = query.Scan(&rows).Error; err != nil {
+		return
+	}
+
+	indexes := make(map[int]int, len(rows))
+	for _, row := range rows {
+		match := WallMatch{UserID: row.UserID, WallID: row.WallID, MatchedWallID: row.MatchedWallID, MatchedAt: row.CreatedAt}
+		if row.MatchedCreatedAt.After(match.MatchedAt) {
+			match.MatchedAt = row.MatchedCreatedAt
+		}
+		if i, ok := indexes[row.UserID]; !ok {
+			indexes[row.UserID] = len(matches)
+			matches = append(matches, match)
+		} else if match.MatchedAt.After(matches[i].MatchedAt) {
+			matches[i] = match
+		}
+	}
+	return
+}
+
+// CheckWallTargetLimit 限制用户 24 小时内发布的指定对象的表白数量，避免通过大量表白试探谁喜欢自己
+// 删除的表白同样计数；需要在事务中调用，锁定用户防止并发创建超出限制
+func CheckWallTargetLimit(tx *gorm.DB, userID int) (err error) {
+	if err = tx.Clauses(LockClause).Select("id").Take(&User{}, userID).Error; err != nil {
+		return
+	}
+	var count int64
+	if err = tx.Unscoped().Model(&Wall{}).
+		Where("poster_id = ? AND target_id IS NOT NULL AND created_at > ?", userID, time.Now().Add(-24*time.Hour)).
+		Count(&count).Error; err != nil {
+		return
+	}
+	if count >= int64(config.Config.WallTargetDailyLimit) {
+		return utils.TooManyRequests("今天指定对象的表白太多，请明天再试")
+	}
+	return nil
+}
+
+// IsPublished 是否已经发布到表白墙
+func (w *Wall) IsPublished() bool {
+	return w.DigestID != nil
+}
+
+// WallDateLayout 表白墙发布日期的格式
+const WallDateLayout = "2006-01-02"
+
+// WallDigest 每日表白墙，在截止时间冻结该日的表白并编号
+// 发布日期为 D 的表白墙包含 D 日截止时间之前创建、尚未发布且未被排除的表白
+// 不公开的表白同样发布，但是不编号也不计数，否则序号的间隔和数量会暴露不公开的表白
+type WallDigest struct {
+	ID          int       `json:"id"`
+	Date        string    `json:"date" gorm:"size:10;uniqueIndex"` // 发布日期，WALL_TIMEZONE 下的 YYYY-MM-DD
+	PublishedAt time.Time `json:"published_at"`                    // 实际生成的时间
+	WindowEnd   time.Time `json:"window_end"`                      // 截止时间
+	WallCount   int       `json:"wall_count"`                      // 公开的表白数量
+}
+
+func (WallDigest) TableName() string {
+	return "wall_digest"
+}
+
+type wallSchedule struct {
+	location *time.Location
+	hour     int
+	minute   int
+}
+
+var (
+	wallScheduleOnce  sync.Once
+	wallScheduleValue wallSchedule
+)
+
+// getWallSchedule 解析 WALL_TIMEZONE 和 WALL_PUBLISH_TIME，配置错误时使用 Asia/Shanghai 和 00:00
+func getWallSchedule() wallSchedule {
+	wallScheduleOnce.Do(func() {
+		location, err := time.LoadLocation(config.Config.WallTimezone)
+		if err != nil {
+			utils.Logger.Error("invalid wall timezone", zap.String("timezone", config.Config.WallTimezone), zap.Error(err))
+			location, _ = time.LoadLocation("Asia/Shanghai")
+		}
+		wallScheduleValue.location = location
+
+		publishTime, err := time.Parse("15:04", config.Config.WallPublishTime)
+		if err != nil {
+			utils.Logger.Error("invalid wall publish time", zap.String("publish_time", config.Config.WallPublishTime), zap.Error(err))
+			return
+		}
+		wallScheduleValue.hour, wallScheduleValue.minute = publishTime.Hour(), publishTime.Minute()
+	})
+	return wallScheduleValue
+}
+
+// WallLocation 表白墙使用的时区
+func WallLocation() *time.Location {
+	return getWallSchedule().location
+}
+
+// WallDate 时间 t 在表白墙时区下的日期，时间部分为 0
+func WallDate(t time.Time) time.Time {
+	t = t.In(WallLocation())
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// WallCutoff 发布日期 date 的截止时间
+func WallCutoff(date time.Time) time.Time {
+	s := getWallSchedule()
+	date = WallDate(date)
+	return time.Date(date.Year(), date.Month(), date.Day(), s.hour, s.minute, 0, 0, s.location)
+}
+
+// WallPublishDate 在 now 时应当已经发布的最新日期，即截止时间不晚于 now 的最后一天
+func WallPublishDate(now time.Time) time.Time {
+	date := WallDate(now)
+	if now.Before(WallCutoff(date)) {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date
+}
+
+// WallBatchDate 在 t 时创建的表白所属的发布日期，即截止时间晚于 t 的第一天
+func WallBatchDate(t time.Time) time.Time {
+	return WallPublishDate(t).AddDate(0, 0, 1)
+}
+
+// PublishWallDigests 生成截至 now 所有应当发布但尚未生成的每日表白墙，按日期顺序返回新生成的表白墙
+// 被隐藏的表白不发布，取消隐藏后在下一次发布
+// 每天在一个事务中生成，发布日期唯一，多个实例同时发布时只有一个生效
+func PublishWallDigests(db *gorm.DB, now time.Time) (digests []WallDigest, err error) {
+	end := WallPublishDate(now)
+
+	// 从最后一次发布的下一天开始，没有发布过时从最早的未发布表白开始
+	var start time.Time
+	var last WallDigest
+	err = db.Order("date DESC").Take(&last).Error
+	switch err {
+	case nil:
+		var lastDate time.Time
+		if lastDate, err = time.ParseInLocation(WallDateLayout, last.Date, WallLocation()); err != nil {
+			return
+		}
+		start = lastDate.AddDate(0, 0, 1)
+	case gorm.ErrRecordNotFound:
+		var first Wall
+		err = db.Where("digest_id IS NULL").Order("created_at").Take(&first).Error
+		if err == gorm.ErrRecordNotFound {
+			start = end
+		} else if err != nil {
+			return
+		} else {
+			start = WallBatchDate(first.CreatedAt)
+		}
+	default:
+		return
+	}
+	err = nil
+
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+		var digest *WallDigest
+		if digest, err = publishWallDigest(db, date, now); err != nil {
+			return
+		}
+		if digest != nil {
+			digests = append(digests, *digest)
+		}
+	}
+	return
+}
+
+// publishWallDigest 生成发布日期为 date 的表白墙，已经生成时返回 nil
+func publishWallDigest(db *gorm.DB, date, now time.Time) (digest *WallDigest, err error) {
+	digest = &WallDigest{Date: date.Format(WallDateLayout), PublishedAt: now, WindowEnd: WallCutoff(date)}
+	err = db.Transaction(func(tx *gorm.DB) (err error) {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(digest)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			digest = nil
+			return nil
+		}
+
+		var walls []Wall
+		if err = tx.Clauses(LockClause).
+			Where("digest_id IS NULL AND is_excluded = ? AND is_hidden = ? AND created_at < ?", false, false, digest.WindowEnd).
+			Order("created_at, id").Find(&walls).Error; err != nil {
+			return
+		}
+		sequence := 0
+		for i := range walls {
+			columns := map[string]any{"digest_id": digest.ID, "sequence": 0}
+			if walls[i].IsPublic() {
+				sequence++
+				columns["sequence"] = sequence
+			}
+			if err = tx.Model(&walls[i]).UpdateColumns(columns).Error; err != nil {
+				return
+			}
+		}
+
+		digest.WallCount = sequence
+		return tx.Model(digest).UpdateColumn("wall_count", digest.WallCount).Error
+	})
+	return
+}
+
+// StartWallPublisher 启动时补发缺失的表白墙，之后按照 WALL_PUBLISH_INTERVAL 检查是否到达截止时间，为 0 时不启动
+func StartWallPublisher() {
+	interval := config.Config.WallPublishInterval
+	if interval <= 0 {
+		return
+	}
+
+	publish := func() {
+		digests, err := PublishWallDigests(DB, time.Now())
+		if err != nil {
+			utils.Logger.Error("publish wall digests error", zap.Error(err))
+			return
+		}
+		for _, digest := range digests {
+			utils.Logger.Info("wall digest published", zap.String("date", digest.Date), zap.Int("wall_count", digest.WallCount))
+		}
+	}
+
+	go func() {
+		publish()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			publish()
+		}
+	}()
+}
+
+// WallReactionEmojis 表白可以使用的表情回应
+var WallReactionEmojis = []string{"❤️", "🥰", "🤗", "😂", "😭", "🍋"}
+
+func IsWallReactionEmoji(emoji string) bool {
+	for _, e := range WallReactionEmojis {
+		if e == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// WallReaction 用户对表白的表情回应，每个用户可以使用多个不同的表情
+type WallReaction struct {
+	WallID    int       `json:"wall_id" gorm:"primaryKey"`
+	UserID    int       `json:"user_id" gorm:"primaryKey"`
+	Emoji     string    `json:"emoji" gorm:"primaryKey;size:16"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (WallReaction) TableName() string {
+	return "wall_reaction"
+}
+
+// WallComment 表白的回复，默认匿名，匿名时在同一条表白中使用同一个匿名昵称
+type WallComment struct {
+	ID          int            `json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	Content     string         `json:"content"`
+	IsAnonymous bool           `json:"is_anonymous"`
+	Anonyname   *string        `json:"anonyname"` // 匿名时的昵称
+
+	// 关联数据
+	WallID   int   `json:"wall_id" gorm:"index"`
+	Wall     *Wall `json:"-" gorm:"foreignKey:WallID"`
+	PosterID int   `json:"poster_id"`
+	Poster   *User `json:"-" gorm:"foreignKey:PosterID"`
+}
+
+func (WallComment) TableName() string {
+	return "wall_comment"
+}
+
+func (c WallComment) GetID() int {
+	return c.ID
+}
+
+type WallAnonynameMapping struct {
+	WallID    int    `json:"wall_id" gorm:"primaryKey"`
+	UserID    int    `json:"user_id" gorm:"primaryKey"`
+	Anonyname string `json:"anonyname" gorm:"not null"`
+}
+
+func (WallAnonynameMapping) TableName() string {
+	return "wall_anonyname_mapping"
+}
+
+// FindOrGenerateWallAnonyname 用户在表白的回复中使用的匿名昵称
+func FindOrGenerateWallAnonyname(tx *gorm.DB, wallID, userID int) (string, error) {
+	return findOrGenerateAnonyname(tx, &WallAnonynameMapping{}, "wall_id", wallID, userID)
+}
+
+// UpdateWallCounters 在回应或者回复变化后重新计算表白的回应数、回复数和热度
+func UpdateWallCounters(tx *gorm.DB, wallIDs ...int) (err error) {
+	for _, wallID := range wallIDs {
+		var reactionCount, commentCount int64
+		if err = tx.Model(&WallReaction{}).Where("wall_id = ?", wallID).Count(&reactionCount).Error; err != nil {
+			return
+		}
+		if err = tx.Model(&WallComment{}).Where("wall_id = ?", wallID).Count(&commentCount).Error; err != nil {
+			return
+		}
+		if err = tx.Model(&Wall{}).Where("id = ?", wallID).UpdateColumns(map[string]any{
+			"reaction_count": reactionCount,
+			"comment_count":  commentCount,
+			"popularity":     reactionCount + 2*commentCount,
+		}).Error; err != nil {
+			return
+		}
+	}
+	return
+}
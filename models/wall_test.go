@@ -0,0 +1,85 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishWallDigests(t *testing.T) {
+	db := newTestDB(t, "test_wall")
+	var err error
+
+	// 第一天截止前创建四条，其中一条被排除、一条不公开，截止后创建一条
+	day := WallDate(time.Now()).AddDate(0, 0, -10)
+	cutoff := WallCutoff(day)
+	walls := []Wall{
+		{Content: "a", PosterID: 1, Visibility: Public, CreatedAt: cutoff.Add(-3 * time.Hour)},
+		{Content: "b", PosterID: 1, Visibility: Public, CreatedAt: cutoff.Add(-2 * time.Hour), IsExcluded: true},
+		{Content: "c", PosterID: 1, Visibility: Public, CreatedAt: cutoff.Add(-time.Hour)},
+		{Content: "d", PosterID: 1, Visibility: Public, CreatedAt: cutoff.Add(time.Hour)},
+		{Content: "e", PosterID: 1, Visibility: Private, CreatedAt: cutoff.Add(-90 * time.Minute)},
+	}
+	if err = db.Create(&walls).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一天截止前不发布
+	digests, err := PublishWallDigests(db, cutoff.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 0 {
+		t.Fatalf("published before cutoff: %v", digests)
+	}
+
+	// 到达第一天截止时间，只发布截止前创建且未被排除的表白，只有公开的表白编号和计数
+	digests, err = PublishWallDigests(db, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 1 || digests[0].Date != day.Format(WallDateLayout) || digests[0].WallCount != 2 {
+		t.Fatalf("unexpected digests: %v", digests)
+	}
+	expected := map[int]int{walls[0].ID: 1, walls[1].ID: 0, walls[2].ID: 2, walls[3].ID: 0}
+	checkWalls := func() {
+		var loaded []Wall
+		if err = db.Order("id").Find(&loaded).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, wall := range loaded {
+			if !wall.IsPublic() {
+				continue
+			}
+			if wall.Sequence != expected[wall.ID] || wall.IsPublished() != (expected[wall.ID] > 0) {
+				t.Errorf("wall %d: sequence %d, published %v, expected %d", wall.ID, wall.Sequence, wall.IsPublished(), expected[wall.ID])
+			}
+		}
+	}
+	checkWalls()
+	var private Wall
+	if err = db.First(&private, walls[4].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !private.IsPublished() || private.Sequence != 0 {
+		t.Errorf("private wall should be published without sequence, got %+v", private)
+	}
+
+	// 重复发布不会生成新的表白墙
+	if digests, err = PublishWallDigests(db, cutoff.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 0 {
+		t.Fatalf("published twice: %v", digests)
+	}
+
+	// 三天后补发缺失的两天，第二天包含第一天截止后创建的表白，第三天为空
+	if digests, err = PublishWallDigests(db, WallCutoff(day.AddDate(0, 0, 2)).Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 2 || digests[0].WallCount != 1 || digests[1].WallCount != 0 ||
+		digests[1].Date != day.AddDate(0, 0, 2).Format(WallDateLayout) {
+		t.Fatalf("unexpected digests: %v", digests)
+	}
+	expected[walls[3].ID] = 1
+	checkWalls()
+}
@@ -1,12 +1,26 @@
 package tests
 
-import "testing"
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	_ = os.RemoveAll(uploadDir)
+	os.Exit(code)
+}
 
 func TestAll(t *testing.T) {
 	t.Run("TestAccountRegister", testAccountRegister)
 	t.Run("TestAccountLogin", testAccountLogin)
 	t.Run("TestListBoxes", testListBoxes)
 	t.Run("TestCreateABox", testCreateABox)
+	t.Run("TestBoxSettings", testBoxSettings)
+	t.Run("TestPostAnswerLifecycle", testPostAnswerLifecycle)
+	t.Run("TestPostAnonyname", testPostAnonyname)
+	t.Run("TestShareABox", testShareABox)
+	t.Run("TestGuestPost", testGuestPost)
 
 	// chat
 	t.Run("TestCreateMessage", testCreateMessage)
@@ -33,6 +47,27 @@ func TestAll(t *testing.T) {
 	//comment
 	t.Run("TestCreateComment", testCreateComment)
 	t.Run("TestListComments", testListComments)
+	t.Run("TestListCommentThreads", testListCommentThreads)
+
+	t.Run("TestListTopicsByCursor", testListTopicsByCursor)
+	t.Run("TestListHotTopics", testListHotTopics)
+	t.Run("TestListTimeline", testListTimeline)
+	t.Run("TestSubscribeATag", testSubscribeATag)
+	t.Run("TestCreateATag", testCreateATag)
+	t.Run("TestListCommentsByFloor", testListCommentsByFloor)
+	t.Run("TestListTopicRevisions", testListTopicRevisions)
+	t.Run("TestRichContent", testRichContent)
+	t.Run("TestUploadAFile", testUploadAFile)
+
+	// wall
+	t.Run("TestWallPublication", testWallPublication)
+	t.Run("TestWallManagement", testWallManagement)
+	t.Run("TestWallTarget", testWallTarget)
+	t.Run("TestWallInteraction", testWallInteraction)
+
+	// anonymity
+	t.Run("TestAnonymityLeaks", testAnonymityLeaks)
+	t.Run("TestDeanonymize", testDeanonymize)
 }
 
 func BenchmarkAll(b *testing.B) {
@@ -0,0 +1,288 @@
+package tests
+
+import (
+	"chatdan_backend/apis"
+	. "chatdan_backend/models"
+	"chatdan_backend/utils"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// identityKeys 可以识别用户身份的字段
+var identityKeys = []string{"poster_id", "owner_id", "user_id", "editor_id"}
+
+// assertNoIdentity 递归检查响应中没有出现匿名发布者的身份
+func assertNoIdentity(t *testing.T, route string, value any, userID int, username string) {
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range identityKeys {
+			if id, ok := v[key].(float64); ok {
+				assert.NotEqualValues(t, userID, id, "%s: %s leaks the anonymous poster", route, key)
+			}
+		}
+		if name, ok := v["username"].(string); ok {
+			assert.NotEqual(t, username, name, "%s: username leaks the anonymous poster", route)
+		}
+		if v["is_anonymous"] == true {
+			assert.NotEqual(t, true, v["is_post_owner"], "%s: is_post_owner on anonymous content", route)
+			assert.NotEqual(t, true, v["is_box_owner"], "%s: is_box_owner on anonymous content", route)
+		}
+		for _, child := range v {
+			assertNoIdentity(t, route, child, userID, username)
+		}
+	case []any:
+		for _, child := range v {
+			assertNoIdentity(t, route, child, userID, username)
+		}
+	}
+}
+
+func testAnonymityLeaks(t *testing.T) {
+	loginAdmin(t)
+	stranger := otherTester[9]
+	viewers := []tester{stranger, userTester, adminTester}
+
+	// 一个只发布匿名内容的用户
+	const username = "anonymity_poster"
+	var login utils.Response[apis.LoginResponse]
+	defaultTester.testPost(t, "/api/user/register", 200, Map{"username": username, "password": "test123456"}, &login)
+	poster := tester{Token: login.Data.AccessToken, ID: login.Data.ID}
+
+	// 话题和评论
+	var topic utils.Response[apis.TopicCommonResponse]
+	poster.testPost(t, "/api/topic", 201, Map{
+		"title": "anonymity leak", "content": "anonymity leak content", "division_id": 1, "is_anonymous": true,
+		"tags": []Map{{"name": "anonymityTag"}},
+	}, &topic)
+	topicID := topic.Data.ID
+	tagID := topic.Data.Tags[0].ID
+	var comment utils.Response[apis.CommentCommonResponse]
+	poster.testPost(t, "/api/comment", 201, Map{"topic_id": topicID, "content": "anonymous comment", "is_anonymous": true}, &comment)
+	commentID := comment.Data.ID
+	poster.testPost(t, "/api/comment", 201, Map{"topic_id": topicID, "reply_to_id": commentID, "content": "anonymous reply", "is_anonymous": true}, nil)
+
+	// 提问和回复
+	var box utils.Response[apis.BoxCommonResponse]
+	userTester.testPost(t, "/api/messageBox", 201, Map{"title": "anonymity"}, &box)
+	boxID := box.Data.ID
+	var post utils.Response[apis.PostCommonResponse]
+	poster.testPost(t, "/api/post", 201, Map{"message_box_id": boxID, "content": "anonymous question", "is_anonymous": true}, &post)
+	postID := post.Data.ID
+	var channel utils.Response[apis.ChannelCommonResponse]
+	poster.testPost(t, "/api/channel", 201, Map{"post_id": postID, "content": "anonymous follow up"}, &channel)
+	channelID := channel.Data.ID
+	userTester.testPost(t, "/api/channel", 201, Map{"post_id": postID, "content": "answer"}, nil)
+
+	// 已发布的表白和回复
+	var wall utils.Response[apis.WallCommonResponse]
+	poster.testPost(t, "/api/wall", 201, Map{"content": "anonymous wall"}, &wall)
+	wallID := wall.Data.ID
+	var digest WallDigest
+	assert.Nil(t, DB.Order("date DESC").Take(&digest).Error)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", wallID).Updates(Map{"digest_id": digest.ID, "sequence": 200}).Error)
+	digestDate, err := time.ParseInLocation(WallDateLayout, digest.Date, WallLocation())
+	assert.Nil(t, err)
+	poster.testPost(t, fmt.Sprintf("/api/wall/%d/comments", wallID), 201, Map{"content": "anonymous wall comment"}, nil)
+
+	// 修改匿名的内容，修改历史不为空
+	poster.testPut(t, fmt.Sprintf("/api/topic/%d", topicID), 200, Map{"content": "anonymity leak content edited"}, nil)
+	poster.testPut(t, fmt.Sprintf("/api/comment/%d", commentID), 200, Map{"content": "anonymous comment edited"}, nil)
+	poster.testPut(t, fmt.Sprintf("/api/post/%d", postID), 200, Map{"content": "anonymous question edited"}, nil)
+	poster.testPut(t, fmt.Sprintf("/api/channel/%d", channelID), 200, Map{"content": "anonymous follow up edited"}, nil)
+	revisionRoutes := map[string]string{
+		"/api/topic/:id/revisions":   fmt.Sprintf("/api/topic/%d/revisions", topicID),
+		"/api/comment/:id/revisions": fmt.Sprintf("/api/comment/%d/revisions", commentID),
+		"/api/post/:id/revisions":    fmt.Sprintf("/api/post/%d/revisions", postID),
+		"/api/channel/:id/revisions": fmt.Sprintf("/api/channel/%d/revisions", channelID),
+	}
+	for _, url := range revisionRoutes {
+		var revisions utils.Response[apis.RevisionListResponse]
+		stranger.testGet(t, url, 200, nil, &revisions)
+		assert.Len(t, revisions.Data.Revisions, 2, url)
+	}
+
+	// 匿名地提及、表白每个查看者，查看者收藏话题、订阅话题的标签
+	for _, viewer := range viewers {
+		var user User
+		assert.Nil(t, DB.Select("username").Take(&user, viewer.ID).Error)
+		poster.testPost(t, "/api/comment", 201, Map{"topic_id": topicID, "content": "hi @" + user.Username, "is_anonymous": true}, nil)
+		poster.testPost(t, "/api/wall", 201, Map{"content": "anonymous target wall", "target_id": viewer.ID}, nil)
+		viewer.testPut(t, fmt.Sprintf("/api/topic/%d/_favor", topicID), 200, nil, nil)
+		viewer.testPost(t, fmt.Sprintf("/api/tag/%d/_subscribe", tagID), 200, nil, nil)
+	}
+
+	// 分享链接
+	assert.NotEmpty(t, box.Data.ShareSlug)
+	assert.NotEmpty(t, post.Data.ShareSlug)
+
+	// 所有可以查询到这些内容的接口，键为注册的路由
+	type request struct {
+		url   string
+		query Map
+	}
+	routes := map[string]request{
+		"/api/topic/:id":            {fmt.Sprintf("/api/topic/%d", topicID), nil},
+		"/api/topics":               {"/api/topics", Map{"division_id": 1}},
+		"/api/topics/_tag/:tag_id":  {fmt.Sprintf("/api/topics/_tag/%d", tagID), nil},
+		"/api/topics/_user/:id":     {fmt.Sprintf("/api/topics/_user/%d", poster.ID), nil},
+		"/api/topics/_search":       {"/api/topics/_search", Map{"search": "anonymity leak", "page_num": 1, "page_size": 10}},
+		"/api/topics/_favor":        {"/api/topics/_favor", nil},
+		"/api/timeline":             {"/api/timeline", nil},
+		"/api/divisions":            {"/api/divisions", nil},
+		"/api/division/:id":         {"/api/division/1", nil},
+		"/api/comment/:id":          {fmt.Sprintf("/api/comment/%d", commentID), nil},
+		"/api/comments":             {"/api/comments", Map{"topic_id": topicID}},
+		"/api/comments/_thread":     {"/api/comments/_thread", Map{"topic_id": topicID}},
+		"/api/comment/:id/_replies": {fmt.Sprintf("/api/comment/%d/_replies", commentID), nil},
+		"/api/comments/_user/:id":   {fmt.Sprintf("/api/comments/_user/%d", poster.ID), nil},
+		"/api/comments/_search":     {"/api/comments/_search", Map{"search": "anonymous comment", "page_num": 1, "page_size": 10}},
+		"/api/mentions":             {"/api/mentions", nil},
+		"/api/tags":                 {"/api/tags", nil},
+		"/api/tag/:id":              {fmt.Sprintf("/api/tag/%d", tagID), nil},
+		"/api/tag/:id/_alias":       {fmt.Sprintf("/api/tag/%d/_alias", tagID), nil},
+		"/api/tags/_subscribed":     {"/api/tags/_subscribed", nil},
+		"/api/messageBoxes":         {"/api/messageBoxes", nil},
+		"/api/messageBox/:id":       {fmt.Sprintf("/api/messageBox/%d", boxID), nil},
+		"/api/post/:id":             {fmt.Sprintf("/api/post/%d", postID), nil},
+		"/api/posts":                {"/api/posts", Map{"message_box_id": boxID}},
+		"/api/channels":             {"/api/channels", Map{"post_id": postID}},
+		"/api/channel/:id":          {fmt.Sprintf("/api/channel/%d", channelID), nil},
+		"/api/share/box/:slug":      {"/api/share/box/" + box.Data.ShareSlug, nil},
+		"/api/share/post/:slug":     {"/api/share/post/" + post.Data.ShareSlug, nil},
+		"/api/wall/:id":             {fmt.Sprintf("/api/wall/%d", wallID), nil},
+		"/api/wall":                 {"/api/wall", Map{"date": digestDate.Format(time.RFC3339), "size": 100}},
+		"/api/wall/:id/comments":    {fmt.Sprintf("/api/wall/%d/comments", wallID), nil},
+		"/api/walls/_received":      {"/api/walls/_received", nil},
+		"/api/walls/_matches":       {"/api/walls/_matches", nil},
+	}
+	for route, url := range revisionRoutes {
+		routes[route] = request{url, nil}
+	}
+	// 不需要登录的分享页面和卡片
+	pages := map[string]string{
+		"/share/box/:slug":           "/share/box/" + box.Data.ShareSlug,
+		"/share/box/:slug/card.png":  "/share/box/" + box.Data.ShareSlug + "/card.png",
+		"/share/post/:slug":          "/share/post/" + post.Data.ShareSlug,
+		"/share/post/:slug/card.png": "/share/post/" + post.Data.ShareSlug + "/card.png",
+	}
+	// 不返回匿名内容的接口
+	exempted := map[string]string{
+		"/":                                 "index",
+		"/docs":                             "api docs",
+		"/docs/*":                           "api docs",
+		"/api/users":                        "user profiles",
+		"/api/user/me":                      "user profiles",
+		"/api/user/:id":                     "user profiles",
+		"/api/users/_search":                "user profiles",
+		"/api/users/:id/_followers":         "user profiles",
+		"/api/users/:id/_following":         "user profiles",
+		"/api/chats":                        "chats are not anonymous",
+		"/api/messages":                     "chats are not anonymous",
+		"/api/messageBox/:id/_challenge":    "guest challenge",
+		"/api/messageBox/:id/_guest_blocks": "guest fingerprints, owner only",
+		"/api/walls/_pending":               "unpublished walls, admin only",
+		"/api/_deanonymize/logs":            "deanonymization audit, admin only",
+	}
+
+	// 新增的 GET 接口必须检查或者说明不需要检查的原因
+	for _, route := range App.GetRoutes(true) {
+		if route.Method != fiber.MethodGet {
+			continue
+		}
+		_, isRoute := routes[route.Path]
+		_, isPage := pages[route.Path]
+		_, isExempted := exempted[route.Path]
+		assert.True(t, isRoute || isPage || isExempted, "GET %s is not checked for anonymity leaks", route.Path)
+	}
+
+	for _, viewer := range viewers {
+		for route, request := range routes {
+			var response utils.Response[any]
+			viewer.testGet(t, request.url, 200, request.query, &response)
+			if assert.NotNil(t, response.Data, route) {
+				assertNoIdentity(t, route, *response.Data, poster.ID, username)
+			}
+		}
+	}
+	for route, url := range pages {
+		res, body := testShareRequest(t, url)
+		assert.EqualValues(t, 200, res.StatusCode, route)
+		assert.NotContains(t, string(body), username, route)
+	}
+
+	// 其他用户查询发布者的内容时不包括匿名的内容
+	var topics utils.Response[apis.TopicListResponse]
+	stranger.testGet(t, fmt.Sprintf("/api/topics/_user/%d", poster.ID), 200, nil, &topics)
+	assert.Empty(t, topics.Data.Topics)
+	var comments utils.Response[apis.CommentListResponse]
+	stranger.testGet(t, fmt.Sprintf("/api/comments/_user/%d", poster.ID), 200, nil, &comments)
+	assert.Empty(t, comments.Data.Comments)
+	comments = utils.Response[apis.CommentListResponse]{}
+	poster.testGet(t, fmt.Sprintf("/api/comments/_user/%d", poster.ID), 200, nil, &comments)
+	assert.Len(t, comments.Data.Comments, 2+len(viewers))
+
+	// 不公开的提问的内容只返回给提问者和提问箱的主人
+	poster.testPost(t, "/api/post", 201, Map{"message_box_id": boxID, "content": "private question", "visibility": "private"}, nil)
+	var boxGet utils.Response[apis.BoxGetResponse]
+	stranger.testGet(t, fmt.Sprintf("/api/messageBox/%d", boxID), 200, nil, &boxGet)
+	assert.EqualValues(t, []string{"anonymous question edited"}, boxGet.Data.PostsContent)
+	boxGet = utils.Response[apis.BoxGetResponse]{}
+	userTester.testGet(t, fmt.Sprintf("/api/messageBox/%d", boxID), 200, nil, &boxGet)
+	assert.Len(t, boxGet.Data.PostsContent, 2)
+}
+
+func testDeanonymize(t *testing.T) {
+	loginAdmin(t)
+	poster, stranger := otherTester[8], otherTester[9]
+
+	var topic utils.Response[apis.TopicCommonResponse]
+	poster.testPost(t, "/api/topic", 201, Map{
+		"title": "deanonymize", "content": "deanonymize content", "division_id": 1, "is_anonymous": true,
+		"tags": []Map{{"name": "deanonymizeTag"}},
+	}, &topic)
+	topicID := topic.Data.ID
+	assert.Zero(t, topic.Data.PosterID)
+
+	// 只有管理员可以查看，需要理由
+	body := Map{"target_type": "topic", "target_id": topicID, "reason": "harassment report"}
+	stranger.testPost(t, "/api/_deanonymize", 403, body, nil)
+	stranger.testGet(t, "/api/_deanonymize/logs", 403, nil, nil)
+	adminTester.testPost(t, "/api/_deanonymize", 400, Map{"target_type": "topic", "target_id": topicID}, nil)
+	adminTester.testPost(t, "/api/_deanonymize", 400, Map{"target_type": "message", "target_id": topicID, "reason": "x"}, nil)
+	adminTester.testPost(t, "/api/_deanonymize", 404, Map{"target_type": "topic", "target_id": 1 << 30, "reason": "x"}, nil)
+
+	// 实名的内容不需要查看
+	var named utils.Response[apis.TopicCommonResponse]
+	poster.testPost(t, "/api/topic", 201, Map{
+		"title": "named", "content": "named content", "division_id": 1,
+		"tags": []Map{{"name": "deanonymizeTag"}},
+	}, &named)
+	adminTester.testPost(t, "/api/_deanonymize", 400, Map{"target_type": "topic", "target_id": named.Data.ID, "reason": "x"}, nil)
+
+	var response utils.Response[apis.DeanonymizeResponse]
+	adminTester.testPost(t, "/api/_deanonymize", 200, body, &response)
+	assert.EqualValues(t, poster.ID, response.Data.PosterID)
+	if assert.NotNil(t, response.Data.Poster) {
+		assert.EqualValues(t, poster.ID, response.Data.Poster.ID)
+	}
+
+	// 每次查看都记录审计日志
+	var logs utils.Response[apis.DeanonymizeLogListResponse]
+	adminTester.testGet(t, "/api/_deanonymize/logs", 200, Map{"target_type": "topic", "target_id": topicID}, &logs)
+	if assert.Len(t, logs.Data.Logs, 1) {
+		log := logs.Data.Logs[0]
+		assert.EqualValues(t, response.Data.LogID, log.ID)
+		assert.EqualValues(t, adminTester.ID, log.AdminID)
+		assert.EqualValues(t, poster.ID, log.PosterID)
+		assert.EqualValues(t, "harassment report", log.Reason)
+	}
+
+	// 其他接口对管理员同样匿名
+	topic = utils.Response[apis.TopicCommonResponse]{}
+	adminTester.testGet(t, fmt.Sprintf("/api/topic/%d", topicID), 200, nil, &topic)
+	assert.Zero(t, topic.Data.PosterID)
+	assert.Nil(t, topic.Data.Poster)
+}
@@ -1,11 +1,19 @@
 package tests
 
 import (
+	"bytes"
 	"chatdan_backend/apis"
+	"chatdan_backend/config"
 	. "chatdan_backend/models"
 	"chatdan_backend/utils"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func testListBoxes(t *testing.T) {
@@ -13,13 +21,19 @@ func testListBoxes(t *testing.T) {
 
 	var response utils.Response[apis.BoxListResponse]
 	defaultTester.testGet(t, url, 401, nil, &response) // 401 Unauthorized
-	userTester.testGet(t, url, 400, nil, &response)    // 没有分页
+	userTester.testGet(t, url, 200, nil, &response)    // 不填页码使用游标分页
 
 	data := Map{
 		"page_num":  1,
 		"page_size": 10,
 	}
 	userTester.testGet(t, url, 200, data, &response)
+
+	data = Map{
+		"page_num":  0,
+		"page_size": 101,
+	}
+	userTester.testGet(t, url, 400, data, &response) // 每页最多 100 条
 }
 
 func testCreateABox(t *testing.T) {
@@ -34,3 +48,450 @@ func testCreateABox(t *testing.T) {
 	userTester.testPost(t, url, 201, data, &response)
 	assert.EqualValues(t, "123", response.Data.Title)
 }
+
+func testBoxSettings(t *testing.T) {
+	other := otherTester[5]
+
+	var boxResponse utils.Response[apis.BoxCommonResponse]
+	userTester.testPost(t, "/api/messageBox", 201, Map{
+		"title":             "settings",
+		"description":       "ask me anything",
+		"question_template": "你想问什么？",
+		"anonymous_policy":  "required",
+	}, &boxResponse)
+	box := boxResponse.Data
+	assert.EqualValues(t, "ask me anything", box.Description)
+	assert.EqualValues(t, "你想问什么？", box.QuestionTemplate)
+	assert.EqualValues(t, BoxOpen, box.Status)
+	assert.EqualValues(t, AnonymousRequired, box.AnonymousPolicy)
+	assert.False(t, box.AllowGuest)
+	assert.True(t, box.IsOpen)
+	url := fmt.Sprintf("/api/messageBox/%d", box.ID)
+
+	// 只接受匿名提问，不填时默认匿名
+	other.testPost(t, "/api/post", 400, Map{"message_box_id": box.ID, "content": "named", "is_anonymous": false}, nil)
+	var postResponse utils.Response[apis.PostCommonResponse]
+	other.testPost(t, "/api/post", 201, Map{"message_box_id": box.ID, "content": "anonymous"}, &postResponse)
+	assert.True(t, postResponse.Data.IsAnonymous)
+	assert.Zero(t, postResponse.Data.PosterID)
+
+	// 只接受实名提问，不填时默认实名
+	userTester.testPut(t, url, 200, Map{"anonymous_policy": "forbidden"}, &boxResponse)
+	other.testPost(t, "/api/post", 400, Map{"message_box_id": box.ID, "content": "anonymous", "is_anonymous": true}, nil)
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	other.testPost(t, "/api/post", 201, Map{"message_box_id": box.ID, "content": "named"}, &postResponse)
+	assert.False(t, postResponse.Data.IsAnonymous)
+	assert.EqualValues(t, other.ID, postResponse.Data.PosterID)
+
+	// 只有主人可以修改设置，定时关闭的时间需要晚于当前时间
+	other.testPut(t, url, 403, Map{"status": "closed"}, nil)
+	userTester.testPut(t, url, 400, Map{"closes_at": time.Now().Add(-time.Hour)}, nil)
+	userTester.testPut(t, url, 400, Map{"status": "paused"}, nil)
+
+	// 关闭后不接受提问
+	boxResponse = utils.Response[apis.BoxCommonResponse]{}
+	userTester.testPut(t, url, 200, Map{"status": "closed", "description": ""}, &boxResponse)
+	assert.False(t, boxResponse.Data.IsOpen)
+	assert.Empty(t, boxResponse.Data.Description)
+	other.testPost(t, "/api/post", 403, Map{"message_box_id": box.ID, "content": "closed"}, nil)
+
+	// 定时关闭，到达时间前接受提问
+	closesAt := time.Now().Add(time.Hour)
+	boxResponse = utils.Response[apis.BoxCommonResponse]{}
+	userTester.testPut(t, url, 200, Map{"status": "open", "closes_at": closesAt}, &boxResponse)
+	assert.True(t, boxResponse.Data.IsOpen)
+	if assert.NotNil(t, boxResponse.Data.ClosesAt) {
+		assert.WithinDuration(t, closesAt, *boxResponse.Data.ClosesAt, time.Second)
+	}
+	other.testPost(t, "/api/post", 201, Map{"message_box_id": box.ID, "content": "scheduled"}, nil)
+
+	// 重新打开时取消定时关闭
+	var getResponse utils.Response[apis.BoxGetResponse]
+	userTester.testPut(t, url, 200, Map{"status": "open"}, nil)
+	other.testGet(t, url, 200, nil, &getResponse)
+	assert.Nil(t, getResponse.Data.ClosesAt)
+	assert.True(t, getResponse.Data.IsOpen)
+	assert.EqualValues(t, AnonymousForbidden, getResponse.Data.AnonymousPolicy)
+}
+
+func testPostAnswerLifecycle(t *testing.T) {
+	asker, stranger := otherTester[6], otherTester[7]
+
+	var boxResponse utils.Response[apis.BoxCommonResponse]
+	userTester.testPost(t, "/api/messageBox", 201, Map{"title": "answers"}, &boxResponse)
+	boxID := boxResponse.Data.ID
+
+	// 不公开的提问只有提问者和提问箱的主人可以查看
+	var postResponse utils.Response[apis.PostCommonResponse]
+	asker.testPost(t, "/api/post", 201, Map{"message_box_id": boxID, "content": "private question", "visibility": "private", "is_anonymous": false}, &postResponse)
+	post := postResponse.Data
+	assert.EqualValues(t, AnswerPending, post.AnswerStatus)
+	postURL := fmt.Sprintf("/api/post/%d", post.ID)
+	stranger.testGet(t, postURL, 403, nil, nil)
+	stranger.testGet(t, "/api/channels", 403, Map{"post_id": post.ID}, nil)
+
+	var listResponse utils.Response[apis.PostListResponse]
+	userTester.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID, "answer_status": "pending"}, &listResponse)
+	if assert.Len(t, listResponse.Data.Posts, 1) {
+		assert.EqualValues(t, post.ID, listResponse.Data.Posts[0].ID)
+	}
+	assert.Equal(t, 1, listResponse.Data.Total)
+
+	// 提问箱的主人回复时标记为已回答
+	var channelResponse utils.Response[apis.ChannelCommonResponse]
+	userTester.testPost(t, "/api/channel", 201, Map{"post_id": post.ID, "content": "owner answer"}, &channelResponse)
+	answerChannelID := channelResponse.Data.ID
+	asker.testPost(t, "/api/channel", 201, Map{"post_id": post.ID, "content": "follow up"}, &channelResponse)
+	followUpChannelID := channelResponse.Data.ID
+
+	var getResponse utils.Response[apis.PostGetResponse]
+	asker.testGet(t, postURL, 200, nil, &getResponse)
+	assert.EqualValues(t, AnswerAnswered, getResponse.Data.AnswerStatus)
+	assert.NotNil(t, getResponse.Data.AnsweredAt)
+	assert.Len(t, getResponse.Data.Channels, 2)
+
+	// 只有提问箱的主人可以设置回答状态
+	asker.testPut(t, postURL+"/_answer", 403, Map{"is_answer_public": true}, nil)
+	userTester.testPut(t, postURL+"/_answer", 400, Map{"answer_status": "done"}, nil)
+
+	// 公开回答后，其他用户只能查看提问箱主人的回答，提问的内容和提问者不可见
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	userTester.testPut(t, postURL+"/_answer", 200, Map{"is_answer_public": true}, &postResponse)
+	assert.True(t, postResponse.Data.IsAnswerPublic)
+
+	getResponse = utils.Response[apis.PostGetResponse]{}
+	stranger.testGet(t, postURL, 200, nil, &getResponse)
+	assert.True(t, getResponse.Data.IsQuestionHidden)
+	assert.Empty(t, getResponse.Data.Content)
+	assert.Empty(t, getResponse.Data.ContentHTML)
+	assert.Zero(t, getResponse.Data.PosterID)
+	assert.Nil(t, getResponse.Data.Poster)
+	assert.EqualValues(t, []string{"owner answer"}, getResponse.Data.Channels)
+
+	var channelListResponse utils.Response[apis.ChannelListResponse]
+	stranger.testGet(t, "/api/channels", 200, Map{"post_id": post.ID}, &channelListResponse)
+	if assert.Len(t, channelListResponse.Data.Channels, 1) {
+		assert.EqualValues(t, answerChannelID, channelListResponse.Data.Channels[0].ID)
+	}
+	assert.Equal(t, 1, channelListResponse.Data.Total)
+	stranger.testGet(t, fmt.Sprintf("/api/channel/%d", answerChannelID), 200, nil, nil)
+	stranger.testGet(t, fmt.Sprintf("/api/channel/%d", followUpChannelID), 403, nil, nil)
+	stranger.testGet(t, fmt.Sprintf("/api/channel/%d/revisions", followUpChannelID), 403, nil, nil)
+	stranger.testGet(t, postURL+"/revisions", 403, nil, nil)
+
+	listResponse = utils.Response[apis.PostListResponse]{}
+	stranger.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID}, &listResponse)
+	if assert.Len(t, listResponse.Data.Posts, 1) {
+		assert.True(t, listResponse.Data.Posts[0].IsQuestionHidden)
+		assert.Empty(t, listResponse.Data.Posts[0].Content)
+	}
+
+	// 忽略后不再公开回答
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	userTester.testPut(t, postURL+"/_answer", 200, Map{"answer_status": "ignored"}, &postResponse)
+	assert.Nil(t, postResponse.Data.AnsweredAt)
+	stranger.testGet(t, postURL, 403, nil, nil)
+	userTester.testPut(t, postURL+"/_answer", 200, Map{"answer_status": "answered"}, nil)
+
+	// 未回答的提问排在前面
+	asker.testPost(t, "/api/post", 201, Map{"message_box_id": boxID, "content": "another question"}, &postResponse)
+	pendingID := postResponse.Data.ID
+	listResponse = utils.Response[apis.PostListResponse]{}
+	userTester.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID, "order_by": "unanswered_first"}, &listResponse)
+	if assert.Len(t, listResponse.Data.Posts, 2) {
+		assert.EqualValues(t, pendingID, listResponse.Data.Posts[0].ID)
+	}
+
+	// 已废弃的 total 只在第一页返回
+	listResponse = utils.Response[apis.PostListResponse]{}
+	userTester.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID, "page_size": 1}, &listResponse)
+	assert.Equal(t, 2, listResponse.Data.Total)
+	if assert.NotEmpty(t, listResponse.Data.NextCursor) {
+		cursor := listResponse.Data.NextCursor
+		listResponse = utils.Response[apis.PostListResponse]{}
+		userTester.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID, "page_size": 1, "cursor": cursor}, &listResponse)
+		assert.Len(t, listResponse.Data.Posts, 1)
+		assert.Zero(t, listResponse.Data.Total)
+	}
+
+	// 置顶
+	asker.testPut(t, postURL+"/_pin", 403, nil, nil)
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	userTester.testPut(t, postURL+"/_pin", 200, nil, &postResponse)
+	assert.True(t, postResponse.Data.IsPinned)
+	listResponse = utils.Response[apis.PostListResponse]{}
+	stranger.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID, "order_by": "pinned_at"}, &listResponse)
+	if assert.Len(t, listResponse.Data.Posts, 1) {
+		assert.EqualValues(t, post.ID, listResponse.Data.Posts[0].ID)
+		assert.True(t, listResponse.Data.Posts[0].IsPinned)
+	}
+	userTester.testDelete(t, postURL+"/_pin", 200, nil, nil)
+	listResponse = utils.Response[apis.PostListResponse]{}
+	userTester.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID, "pinned": true}, &listResponse)
+	assert.Empty(t, listResponse.Data.Posts)
+}
+
+func testPostAnonyname(t *testing.T) {
+	asker, stranger := otherTester[6], otherTester[7]
+
+	var boxResponse utils.Response[apis.BoxCommonResponse]
+	userTester.testPost(t, "/api/messageBox", 201, Map{"title": "anonyname"}, &boxResponse)
+	boxID := boxResponse.Data.ID
+
+	// 匿名提问有昵称，实名提问没有
+	var postResponse utils.Response[apis.PostCommonResponse]
+	asker.testPost(t, "/api/post", 201, Map{"message_box_id": boxID, "content": "anonymous question", "visibility": "private", "is_anonymous": true}, &postResponse)
+	post := *postResponse.Data
+	assert.NotEmpty(t, post.Anonyname)
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	asker.testPost(t, "/api/post", 201, Map{"message_box_id": boxID, "content": "public question", "is_anonymous": false}, &postResponse)
+	assert.Empty(t, postResponse.Data.Anonyname)
+
+	// 提问者的回复默认匿名，昵称与提问相同，不标记为提问者
+	var channelResponse utils.Response[apis.ChannelCommonResponse]
+	asker.testPost(t, "/api/channel", 201, Map{"post_id": post.ID, "content": "anonymous follow up"}, &channelResponse)
+	assert.True(t, channelResponse.Data.IsAnonymous)
+	if assert.NotNil(t, channelResponse.Data.Anonyname) {
+		assert.EqualValues(t, post.Anonyname, *channelResponse.Data.Anonyname)
+	}
+	assert.False(t, channelResponse.Data.IsPostOwner)
+	assert.True(t, channelResponse.Data.IsOwner)
+
+	// 提问箱的主人默认实名回复
+	channelResponse = utils.Response[apis.ChannelCommonResponse]{}
+	userTester.testPost(t, "/api/channel", 201, Map{"post_id": post.ID, "content": "owner answer"}, &channelResponse)
+	assert.False(t, channelResponse.Data.IsAnonymous)
+	assert.Nil(t, channelResponse.Data.Anonyname)
+	assert.True(t, channelResponse.Data.IsBoxOwner)
+
+	// 提问箱的主人也可以匿名回复，昵称与提问者不同
+	channelResponse = utils.Response[apis.ChannelCommonResponse]{}
+	userTester.testPost(t, "/api/channel", 201, Map{"post_id": post.ID, "content": "anonymous answer", "is_anonymous": true}, &channelResponse)
+	assert.False(t, channelResponse.Data.IsBoxOwner)
+	if assert.NotNil(t, channelResponse.Data.Anonyname) {
+		assert.NotEqualValues(t, post.Anonyname, *channelResponse.Data.Anonyname)
+	}
+
+	// 同一个提问中昵称保持不变
+	var channelListResponse utils.Response[apis.ChannelListResponse]
+	asker.testGet(t, "/api/channels", 200, Map{"post_id": post.ID}, &channelListResponse)
+	if assert.Len(t, channelListResponse.Data.Channels, 3) {
+		for _, channel := range channelListResponse.Data.Channels {
+			if channel.IsOwner {
+				assert.EqualValues(t, post.Anonyname, *channel.Anonyname)
+			}
+		}
+	}
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	asker.testGet(t, fmt.Sprintf("/api/post/%d", post.ID), 200, nil, &postResponse)
+	assert.EqualValues(t, post.Anonyname, postResponse.Data.Anonyname)
+
+	// 问题隐藏时昵称也不可见
+	userTester.testPut(t, fmt.Sprintf("/api/post/%d/_answer", post.ID), 200, Map{"is_answer_public": true}, nil)
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	stranger.testGet(t, fmt.Sprintf("/api/post/%d", post.ID), 200, nil, &postResponse)
+	assert.Empty(t, postResponse.Data.Anonyname)
+}
+
+func testShareABox(t *testing.T) {
+	asker := otherTester[8]
+
+	var boxResponse utils.Response[apis.BoxCommonResponse]
+	userTester.testPost(t, "/api/messageBox", 201, Map{"title": "share", "description": "ask me"}, &boxResponse)
+	box := boxResponse.Data
+	assert.Len(t, box.ShareSlug, ShareSlugLength)
+
+	// 分享链接只返回给提问箱的主人
+	var boxGet utils.Response[apis.BoxGetResponse]
+	asker.testGet(t, fmt.Sprintf("/api/messageBox/%d", box.ID), 200, nil, &boxGet)
+	assert.Empty(t, boxGet.Data.ShareSlug)
+	boxGet = utils.Response[apis.BoxGetResponse]{}
+	userTester.testGet(t, fmt.Sprintf("/api/messageBox/%d", box.ID), 200, nil, &boxGet)
+	assert.EqualValues(t, box.ShareSlug, boxGet.Data.ShareSlug)
+	var boxList utils.Response[apis.BoxListResponse]
+	asker.testGet(t, "/api/messageBoxes", 200, Map{"owner": userTester.ID, "page_size": 100}, &boxList)
+	if assert.NotEmpty(t, boxList.Data.MessageBoxes) {
+		for _, listed := range boxList.Data.MessageBoxes {
+			assert.Empty(t, listed.ShareSlug)
+		}
+	}
+
+	// 公开的提问可以分享，不公开的提问不返回分享链接
+	var postResponse utils.Response[apis.PostCommonResponse]
+	asker.testPost(t, "/api/post", 201, Map{"message_box_id": box.ID, "content": "public question", "is_anonymous": false}, &postResponse)
+	publicPost := postResponse.Data
+	assert.NotEmpty(t, publicPost.ShareSlug)
+	postResponse = utils.Response[apis.PostCommonResponse]{}
+	asker.testPost(t, "/api/post", 201, Map{"message_box_id": box.ID, "content": "private question", "visibility": "private"}, &postResponse)
+	privatePost := postResponse.Data
+	assert.Empty(t, privatePost.ShareSlug)
+
+	userTester.testPost(t, "/api/channel", 201, Map{"post_id": publicPost.ID, "content": "public answer"}, nil)
+	asker.testPost(t, "/api/channel", 201, Map{"post_id": publicPost.ID, "content": "follow up"}, nil)
+	userTester.testPost(t, "/api/channel", 201, Map{"post_id": privatePost.ID, "content": "private answer"}, nil)
+
+	// 未登录用户只能看到公开的问答和提问箱主人的回复，不返回提问者
+	var shareResponse utils.Response[apis.ShareBoxResponse]
+	defaultTester.testGet(t, "/api/share/box/"+box.ShareSlug, 200, nil, &shareResponse)
+	assert.EqualValues(t, "share", shareResponse.Data.Title)
+	if assert.Len(t, shareResponse.Data.Posts, 1) {
+		assert.EqualValues(t, "public question", shareResponse.Data.Posts[0].Content)
+		assert.EqualValues(t, []string{"public answer"}, shareResponse.Data.Posts[0].Answers)
+	}
+	defaultTester.testGet(t, "/api/share/box/notexist", 404, nil, nil)
+	defaultTester.testGet(t, fmt.Sprintf("/api/share/post/%d", privatePost.ID), 404, nil, nil)
+
+	// 公开回答后，不公开的提问只分享回答
+	userTester.testPut(t, fmt.Sprintf("/api/post/%d/_answer", privatePost.ID), 200, Map{"is_answer_public": true}, &postResponse)
+	privateSlug := postResponse.Data.ShareSlug
+	assert.NotEmpty(t, privateSlug)
+	var sharePostResponse utils.Response[apis.SharePostResponse]
+	defaultTester.testGet(t, "/api/share/post/"+privateSlug, 200, nil, &sharePostResponse)
+	assert.True(t, sharePostResponse.Data.IsQuestionHidden)
+	assert.Empty(t, sharePostResponse.Data.Content)
+	assert.EqualValues(t, []string{"private answer"}, sharePostResponse.Data.Answers)
+	assert.EqualValues(t, box.ShareSlug, sharePostResponse.Data.BoxShareSlug)
+
+	// 分享页面包含 OpenGraph 信息，不包含未公开的提问
+	res, body := testShareRequest(t, "/share/box/"+box.ShareSlug)
+	assert.EqualValues(t, 200, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Type"), "text/html")
+	assert.Contains(t, string(body), `<meta property="og:title" content="share">`)
+	assert.Contains(t, string(body), "/share/box/"+box.ShareSlug+"/card.png")
+	assert.Contains(t, string(body), "public question")
+	assert.Contains(t, string(body), "private answer")
+	assert.NotContains(t, string(body), "private question")
+	assert.NotContains(t, string(body), "follow up")
+
+	// 分享卡片
+	for _, url := range []string{"/share/box/" + box.ShareSlug + "/card.png", "/share/post/" + publicPost.ShareSlug + "/card.png"} {
+		res, body = testShareRequest(t, url)
+		assert.EqualValues(t, 200, res.StatusCode)
+		assert.EqualValues(t, "image/png", res.Header.Get("Content-Type"))
+		config, err := png.DecodeConfig(bytes.NewReader(body))
+		if assert.Nil(t, err) {
+			assert.EqualValues(t, utils.ShareCardWidth, config.Width)
+			assert.EqualValues(t, utils.ShareCardHeight, config.Height)
+		}
+	}
+
+	// 重新生成分享链接后，原来的链接失效
+	asker.testPut(t, fmt.Sprintf("/api/messageBox/%d/_share", box.ID), 403, nil, nil)
+	boxResponse = utils.Response[apis.BoxCommonResponse]{}
+	userTester.testPut(t, fmt.Sprintf("/api/messageBox/%d/_share", box.ID), 200, nil, &boxResponse)
+	assert.NotEqual(t, box.ShareSlug, boxResponse.Data.ShareSlug)
+	defaultTester.testGet(t, "/api/share/box/"+box.ShareSlug, 404, nil, nil)
+	defaultTester.testGet(t, "/api/share/box/"+boxResponse.Data.ShareSlug, 200, nil, nil)
+}
+
+func testShareRequest(t *testing.T, url string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.Nil(t, err)
+	res, err := App.Test(req, -1)
+	assert.Nil(t, err)
+	body, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	return res, body
+}
+
+// solveChallenge 获取并完成未登录用户提问的工作量证明
+func solveChallenge(t *testing.T, boxID int) Map {
+	var challengeResponse utils.Response[apis.GuestChallengeResponse]
+	defaultTester.testGet(t, fmt.Sprintf("/api/messageBox/%d/_challenge", boxID), 200, nil, &challengeResponse)
+	challenge := challengeResponse.Data.Challenge
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if utils.CheckProofOfWork(challenge.Challenge, nonce, challenge.Difficulty) {
+			return Map{"challenge": challenge.Challenge, "nonce": nonce}
+		}
+	}
+}
+
+func testGuestPost(t *testing.T) {
+	other := otherTester[9]
+
+	var boxResponse utils.Response[apis.BoxCommonResponse]
+	userTester.testPost(t, "/api/messageBox", 201, Map{"title": "guest", "allow_guest": true}, &boxResponse)
+	boxID := boxResponse.Data.ID
+	userTester.testPost(t, "/api/messageBox", 201, Map{"title": "no guest"}, &boxResponse)
+	defaultTester.testGet(t, fmt.Sprintf("/api/messageBox/%d/_challenge", boxResponse.Data.ID), 403, nil, nil)
+	defaultTester.testPost(t, "/api/post", 403, Map{"message_box_id": boxResponse.Data.ID, "content": "guest"}, nil)
+
+	guest := tester{IP: "10.0.0.1"}
+	guestPost := func(statusCode int, fingerprint string, data Map, model any) {
+		body := solveChallenge(t, boxID)
+		body["message_box_id"] = boxID
+		body["content"] = "guest question"
+		body["fingerprint"] = fingerprint
+		for key, value := range data {
+			body[key] = value
+		}
+		guest.testPost(t, "/api/post", statusCode, body, model)
+	}
+
+	// 未登录用户完成工作量证明后匿名提问
+	var postResponse utils.Response[apis.PostCommonResponse]
+	challenge := solveChallenge(t, boxID)
+	guest.testPost(t, "/api/post", 201, Map{
+		"message_box_id": boxID, "content": "guest question", "fingerprint": "device-a",
+		"challenge": challenge["challenge"], "nonce": challenge["nonce"],
+	}, &postResponse)
+	guestPostID := postResponse.Data.ID
+	assert.True(t, postResponse.Data.IsGuest)
+	assert.True(t, postResponse.Data.IsAnonymous)
+	assert.False(t, postResponse.Data.IsOwner)
+	assert.Zero(t, postResponse.Data.PosterID)
+
+	// 工作量证明只能使用一次，需要正确的 nonce
+	defaultTester.testPost(t, "/api/post", 400, Map{
+		"message_box_id": boxID, "content": "guest question",
+		"challenge": challenge["challenge"], "nonce": challenge["nonce"],
+	}, nil)
+	defaultTester.testPost(t, "/api/post", 400, Map{"message_box_id": boxID, "content": "guest question"}, nil)
+	guestPost(400, "device-a", Map{"nonce": "not a nonce"}, nil)
+	guestPost(400, "device-a", Map{"is_anonymous": false}, nil)
+	guestPost(400, "device-a", Map{"attachment_ids": []int{1}}, nil)
+
+	var listResponse utils.Response[apis.PostListResponse]
+	userTester.testGet(t, "/api/posts", 200, Map{"message_box_id": boxID}, &listResponse)
+	if assert.Len(t, listResponse.Data.Posts, 1) {
+		assert.True(t, listResponse.Data.Posts[0].IsGuest)
+		assert.Zero(t, listResponse.Data.Posts[0].PosterID)
+	}
+
+	// 提问箱的主人按照指纹和 IP 屏蔽未登录的提问者
+	blockURL := fmt.Sprintf("/api/post/%d/_block_guest", guestPostID)
+	other.testPost(t, blockURL, 403, nil, nil)
+	var blockResponse utils.Response[apis.BoxGuestBlockResponse]
+	userTester.testPost(t, blockURL, 201, nil, &blockResponse)
+	blockID := blockResponse.Data.ID
+	assert.EqualValues(t, guestPostID, blockResponse.Data.PostID)
+	userTester.testPost(t, blockURL, 201, nil, &blockResponse)
+	assert.EqualValues(t, blockID, blockResponse.Data.ID)
+
+	other.testPost(t, "/api/post", 201, Map{"message_box_id": boxID, "content": "named question"}, &postResponse)
+	userTester.testPost(t, fmt.Sprintf("/api/post/%d/_block_guest", postResponse.Data.ID), 400, nil, nil)
+
+	// 只更换指纹或者只更换 IP 都不能绕过屏蔽
+	guestPost(403, "device-a", nil, nil)
+	guestPost(403, "device-b", nil, nil)
+	guest.IP = "10.0.0.2"
+	guestPost(403, "device-a", nil, nil)
+	guestPost(201, "device-b", nil, nil)
+
+	var blockListResponse utils.Response[apis.BoxGuestBlockListResponse]
+	other.testGet(t, fmt.Sprintf("/api/messageBox/%d/_guest_blocks", boxID), 403, nil, nil)
+	userTester.testGet(t, fmt.Sprintf("/api/messageBox/%d/_guest_blocks", boxID), 200, nil, &blockListResponse)
+	assert.Len(t, blockListResponse.Data.Blocks, 1)
+	userTester.testDelete(t, fmt.Sprintf("/api/messageBox/%d/_guest_blocks/%d", boxID, blockID), 200, nil, nil)
+	userTester.testDelete(t, fmt.Sprintf("/api/messageBox/%d/_guest_blocks/%d", boxID, blockID), 404, nil, nil)
+	guest.IP = "10.0.0.1"
+	guestPost(201, "device-a", nil, nil)
+
+	// 同一 IP 在时间窗口内的提问数量有限制
+	guest.IP = "10.0.0.3"
+	for i := 0; i < config.Config.GuestIPRateLimit; i++ {
+		guestPost(201, "device-c", nil, nil)
+	}
+	guestPost(429, "device-c", nil, nil)
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"chatdan_backend/apis"
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testRichContent(t *testing.T) {
+	mentioned, anonymous := otherTester[1], otherTester[3]
+
+	// 渲染 Markdown，提及用户名时输出用户 ID，代码中的提及不解析
+	var topicResponse Response[apis.TopicCommonResponse]
+	userTester.testPost(t, "/api/topic", 201, Map{
+		"title":       "RichTitle",
+		"content":     "hello @user1 **bold** `@user2` <script>alert(1)</script>",
+		"division_id": 1,
+		"tags":        []Map{{"name": "rich"}},
+	}, &topicResponse)
+	topicID := topicResponse.Data.ID
+	assert.Contains(t, topicResponse.Data.ContentHTML, fmt.Sprintf(`<span class="mention" data-user-id="%d">@user1</span>`, mentioned.ID))
+	assert.Contains(t, topicResponse.Data.ContentHTML, "<strong>bold</strong>")
+	assert.Contains(t, topicResponse.Data.ContentHTML, "<code>@user2</code>")
+	assert.NotContains(t, topicResponse.Data.ContentHTML, "<script>")
+
+	var mentionResponse Response[apis.MentionListResponse]
+	mentioned.testGet(t, "/api/mentions", 200, nil, &mentionResponse)
+	if assert.NotEmpty(t, mentionResponse.Data.Mentions) {
+		assert.EqualValues(t, ContentTypeTopic, mentionResponse.Data.Mentions[0].TargetType)
+		assert.EqualValues(t, topicID, mentionResponse.Data.Mentions[0].TargetID)
+	}
+	mentionResponse = Response[apis.MentionListResponse]{}
+	other := otherTester[2]
+	other.testGet(t, "/api/mentions", 200, nil, &mentionResponse)
+	assert.Empty(t, mentionResponse.Data.Mentions)
+
+	// 提及本话题中的匿名昵称时不输出用户 ID
+	var commentResponse Response[apis.CommentCommonResponse]
+	anonymous.testPost(t, "/api/comment", 201, Map{"topic_id": topicID, "content": "anonymous", "is_anonymous": true}, &commentResponse)
+	anonyname := *commentResponse.Data.Anonyname
+	commentResponse = Response[apis.CommentCommonResponse]{}
+	userTester.testPost(t, "/api/comment", 201, Map{"topic_id": topicID, "content": "@" + anonyname + " hi #rich"}, &commentResponse)
+	assert.Contains(t, commentResponse.Data.ContentHTML, `<span class="mention mention-anonymous">@`+anonyname+`</span>`)
+	assert.NotContains(t, commentResponse.Data.ContentHTML, "data-user-id")
+	assert.Contains(t, commentResponse.Data.ContentHTML, `<span class="tag" data-tag-id=`)
+
+	mentionResponse = Response[apis.MentionListResponse]{}
+	anonymous.testGet(t, "/api/mentions", 200, nil, &mentionResponse)
+	if assert.NotEmpty(t, mentionResponse.Data.Mentions) {
+		assert.EqualValues(t, ContentTypeComment, mentionResponse.Data.Mentions[0].TargetType)
+		assert.EqualValues(t, commentResponse.Data.ID, mentionResponse.Data.Mentions[0].TargetID)
+	}
+}
@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"chatdan_backend/apis"
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func testSubscribeATag(t *testing.T) {
+	tag, err := FindTagByName(DB, "ＴＥＳＴＴＡＧ１")
+	assert.Nil(t, err)
+	url := fmt.Sprintf("/api/tag/%d/_subscribe", tag.ID)
+
+	var response Response[EmptyStruct]
+	defaultTester.testPost(t, url, 401, nil, &response)
+	userTester.testPost(t, url, 200, nil, &response)
+	userTester.testPost(t, url, 400, nil, &response)
+
+	var listResponse Response[apis.TagListResponse]
+	userTester.testGet(t, "/api/tags/_subscribed", 200, nil, &listResponse)
+	assert.EqualValues(t, 1, len(listResponse.Data.Tags))
+	assert.EqualValues(t, tag.ID, listResponse.Data.Tags[0].ID)
+
+	// 订阅标签下的话题出现在时间线中
+	var timelineResponse Response[apis.TopicListResponse]
+	userTester.testGet(t, "/api/timeline", 200, nil, &timelineResponse)
+	assert.NotEmpty(t, timelineResponse.Data.Topics)
+
+	userTester.testDelete(t, url, 200, nil, &response)
+	userTester.testDelete(t, url, 400, nil, &response)
+}
+
+func testCreateATag(t *testing.T) {
+	// 大小写和全角半角不同的同名标签并发创建，都返回同一个标签
+	names := []string{"ConcurrentTag", "concurrenttag", "ＣＯＮＣＵＲＲＥＮＴＴＡＧ", "CONCURRENTTAG"}
+	responses := make([]Response[apis.TagCommonResponse], len(names))
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			userTester.testPost(t, "/api/tag", 201, Map{"name": names[i]}, &responses[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, response := range responses {
+		assert.EqualValues(t, responses[0].Data.ID, response.Data.ID)
+	}
+
+	var count int64
+	assert.Nil(t, DB.Model(&Tag{}).Where("normalized_name = ?", NormalizeTagName("ConcurrentTag")).Count(&count).Error)
+	assert.EqualValues(t, 1, count)
+}
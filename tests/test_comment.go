@@ -4,6 +4,8 @@ import (
 	"chatdan_backend/apis"
 	. "chatdan_backend/models"
 	. "chatdan_backend/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
@@ -52,3 +54,90 @@ func testCreateComment(t *testing.T) {
 	userTester.testPost(t, url, 201, body2, &response)
 
 }
+
+func testListCommentThreads(t *testing.T) {
+	const url = "/api/comment"
+	var response Response[apis.CommentCommonResponse]
+
+	// 楼中楼：root <- reply1 <- reply2，root <- reply3
+	userTester.testPost(t, url, 201, Map{"topic_id": 2, "content": "root"}, &response)
+	rootID := response.Data.ID
+	userTester.testPost(t, url, 201, Map{"topic_id": 2, "content": "reply1", "reply_to_id": rootID}, &response)
+	reply1ID := response.Data.ID
+	userTester.testPost(t, url, 201, Map{"topic_id": 2, "content": "reply2", "reply_to_id": reply1ID}, &response)
+	userTester.testPost(t, url, 201, Map{"topic_id": 2, "content": "reply3", "reply_to_id": rootID}, &response)
+
+	// 回复的评论必须属于同一个帖子
+	userTester.testPost(t, url, 400, Map{"topic_id": 3, "content": "wrong", "reply_to_id": rootID}, &response)
+	userTester.testPost(t, url, 400, Map{"topic_id": 2, "content": "missing", "reply_to_id": 100000}, &response)
+
+	var threadResponse Response[apis.CommentTreeListResponse]
+	userTester.testGet(t, "/api/comments/_thread", 200, Map{"topic_id": 2, "page_size": 100, "reply_page_size": 1}, &threadResponse)
+	var root *apis.CommentTreeResponse
+	for i := range threadResponse.Data.Comments {
+		assert.Nil(t, threadResponse.Data.Comments[i].ReplyToID)
+		if threadResponse.Data.Comments[i].ID == rootID {
+			root = &threadResponse.Data.Comments[i]
+		}
+	}
+	if !assert.NotNil(t, root) {
+		return
+	}
+	assert.EqualValues(t, 2, root.ReplyCount)
+	assert.EqualValues(t, 1, len(root.Replies))
+	assert.EqualValues(t, reply1ID, root.Replies[0].ID)
+	assert.EqualValues(t, 1, len(root.Replies[0].Replies))
+	assert.NotEmpty(t, root.ReplyCursor)
+
+	// 加载更多回复
+	userTester.testGet(t, fmt.Sprintf("/api/comment/%d/_replies", rootID), 200, Map{"cursor": root.ReplyCursor}, &threadResponse)
+	assert.EqualValues(t, 1, len(threadResponse.Data.Comments))
+	assert.EqualValues(t, "reply3", threadResponse.Data.Comments[0].Content)
+	assert.False(t, threadResponse.Data.HasMore)
+}
+
+func testListCommentsByFloor(t *testing.T) {
+	const url = "/api/comments"
+	var topicResponse Response[apis.TopicCommonResponse]
+	userTester.testPost(t, "/api/topic", 201, Map{
+		"title":       "FloorTitle",
+		"content":     "FloorContent",
+		"division_id": 1,
+		"tags":        []Map{{"name": "floor"}},
+	}, &topicResponse)
+	topicID := topicResponse.Data.ID
+
+	var commentIDs []int
+	for i := 0; i < 12; i++ {
+		var response Response[apis.CommentCommonResponse]
+		userTester.testPost(t, "/api/comment", 201, Map{"topic_id": topicID, "content": fmt.Sprintf("floor %d", i+1)}, &response)
+		assert.EqualValues(t, i+1, response.Data.Ranking)
+		commentIDs = append(commentIDs, response.Data.ID)
+	}
+
+	// 删除的楼层保留占位
+	var emptyResponse Response[EmptyStruct]
+	userTester.testDelete(t, fmt.Sprintf("/api/comment/%d", commentIDs[5]), 200, nil, &emptyResponse)
+
+	// 跳转到第 6 层，返回以第 6 层为中心的一页
+	var response Response[apis.CommentListResponse]
+	userTester.testGet(t, url, 200, Map{"topic_id": topicID, "floor": 6, "page_size": 4}, &response)
+	var floors []int
+	for _, comment := range response.Data.Comments {
+		floors = append(floors, comment.Ranking)
+	}
+	assert.EqualValues(t, []int{4, 5, 6, 7}, floors)
+	assert.True(t, response.Data.Comments[2].IsDeleted)
+	assert.Empty(t, response.Data.Comments[2].Content)
+	assert.NotEmpty(t, response.Data.PrevCursor)
+	assert.NotEmpty(t, response.Data.NextCursor)
+
+	// 向前翻页回到第一页
+	prevCursor := response.Data.PrevCursor
+	response = Response[apis.CommentListResponse]{}
+	userTester.testGet(t, url, 200, Map{"topic_id": topicID, "cursor": prevCursor, "page_size": 4}, &response)
+	assert.EqualValues(t, 1, response.Data.Comments[0].Ranking)
+
+	userTester.testGet(t, url, 404, Map{"topic_id": topicID, "floor": 13}, &response)
+	userTester.testGet(t, url, 400, Map{"topic_id": topicID, "floor": 1, "order_by": "like"}, &response)
+}
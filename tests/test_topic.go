@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"log"
+	"math"
 	"testing"
 )
 
@@ -79,6 +80,78 @@ func testDeleteTopic(t *testing.T) {
 
 }
 
+func testListTopicsByCursor(t *testing.T) {
+	const url = "/api/topics"
+	for i := 0; i < 3; i++ {
+		testCreatATopic(t)
+	}
+
+	// 向后翻页，数据不重复
+	var (
+		response Response[apis.TopicListResponse]
+		pages    [][]int
+		seen     = map[int]bool{}
+		query    = Map{"page_size": 2}
+	)
+	for {
+		response = Response[apis.TopicListResponse]{}
+		userTester.testGet(t, url, 200, query, &response)
+		var page []int
+		for _, topic := range response.Data.Topics {
+			assert.Falsef(t, seen[topic.ID], "topic %d returned twice", topic.ID)
+			seen[topic.ID] = true
+			page = append(page, topic.ID)
+		}
+		pages = append(pages, page)
+		if !response.Data.HasMore {
+			assert.Empty(t, response.Data.NextCursor)
+			break
+		}
+		query["cursor"] = response.Data.NextCursor
+	}
+	assert.EqualValues(t, 5, len(seen))
+	assert.EqualValues(t, 3, len(pages))
+
+	// 从第二页向前翻页回到第一页
+	userTester.testGet(t, url, 200, Map{"page_size": 2}, &response)
+	userTester.testGet(t, url, 200, Map{"page_size": 2, "cursor": response.Data.NextCursor}, &response)
+	assert.NotEmpty(t, response.Data.PrevCursor)
+	prevCursor := response.Data.PrevCursor
+	response = Response[apis.TopicListResponse]{}
+	userTester.testGet(t, url, 200, Map{"page_size": 2, "cursor": prevCursor}, &response)
+	assert.EqualValues(t, pages[0], []int{response.Data.Topics[0].ID, response.Data.Topics[1].ID})
+	assert.Empty(t, response.Data.PrevCursor)
+
+	userTester.testGet(t, url, 400, Map{"cursor": "invalid"}, &response)
+}
+
+func testListHotTopics(t *testing.T) {
+	const url = "/api/topics"
+
+	// 按照热度翻页，数据不重复且热度递减
+	var (
+		response Response[apis.TopicListResponse]
+		seen     = map[int]bool{}
+		lastHot  = math.Inf(1)
+		query    = Map{"page_size": 2, "order_by": "hot"}
+	)
+	for {
+		response = Response[apis.TopicListResponse]{}
+		userTester.testGet(t, url, 200, query, &response)
+		for _, topic := range response.Data.Topics {
+			assert.Falsef(t, seen[topic.ID], "topic %d returned twice", topic.ID)
+			seen[topic.ID] = true
+			assert.LessOrEqual(t, topic.HotScore, lastHot)
+			lastHot = topic.HotScore
+		}
+		if !response.Data.HasMore {
+			break
+		}
+		query["cursor"] = response.Data.NextCursor
+	}
+	assert.NotEmpty(t, seen)
+}
+
 func testLikeOrDislikeATopic(t *testing.T) {
 	const url = "/api/topic/2/_like/1"
 	const url2 = "/api/topic/3/_like/-1"
@@ -89,3 +162,104 @@ func testLikeOrDislikeATopic(t *testing.T) {
 	userTester.testPut(t, url2, 200, nil, &response)
 	log.Printf("%+v", response.Data)
 }
+
+func testListTimeline(t *testing.T) {
+	const url = "/api/timeline"
+	var response Response[apis.TopicListResponse]
+
+	defaultTester.testGet(t, url, 401, nil, &response)
+	userTester.testGet(t, url, 200, Map{"page_size": 5}, &response)
+	userTester.testGet(t, url, 400, Map{"cursor": "invalid"}, &response)
+
+	followed, stranger := otherTester[4], otherTester[5]
+	var login Response[apis.LoginResponse]
+	defaultTester.testPost(t, "/api/user/register", 200, Map{"username": "timeline_reader", "password": "test123456"}, &login)
+	reader := tester{Token: login.Data.AccessToken, ID: login.Data.ID}
+
+	createTopic := func(poster tester, title, tag string, isAnonymous bool) apis.TopicCommonResponse {
+		var topic Response[apis.TopicCommonResponse]
+		poster.testPost(t, "/api/topic", 201, Map{
+			"title": title, "content": "timeline content", "division_id": 1, "is_anonymous": isAnonymous,
+			"tags": []Map{{"name": tag}},
+		}, &topic)
+		return *topic.Data
+	}
+	followedTopic := createTopic(followed, "timeline followed", "timelineFollowed", false).ID
+	anonymousTopic := createTopic(followed, "timeline anonymous", "timelineFollowed", true).ID
+	hiddenTopic := createTopic(followed, "timeline hidden", "timelineFollowed", false).ID
+	assert.Nil(t, DB.Model(&Topic{}).Where("id = ?", hiddenTopic).Update("is_hidden", true).Error)
+	tagged := createTopic(stranger, "timeline tagged", "timelineSubscribed", false)
+	strangerTopic := createTopic(stranger, "timeline stranger", "timelineStranger", false).ID
+
+	reader.testPost(t, fmt.Sprintf("/api/user/%d/_follow", followed.ID), 200, nil, nil)
+	reader.testPost(t, fmt.Sprintf("/api/tag/%d/_subscribe", tagged.Tags[0].ID), 200, nil, nil)
+
+	// 包含关注用户和订阅标签的话题，不包含匿名、隐藏和无关的话题
+	response = Response[apis.TopicListResponse]{}
+	reader.testGet(t, url, 200, Map{"page_size": 100}, &response)
+	ids := make(map[int]bool)
+	for _, topic := range response.Data.Topics {
+		ids[topic.ID] = true
+	}
+	assert.True(t, ids[followedTopic], "topic of followed user")
+	assert.True(t, ids[tagged.ID], "topic of subscribed tag")
+	assert.False(t, ids[anonymousTopic], "anonymous topic of followed user")
+	assert.False(t, ids[hiddenTopic], "hidden topic of followed user")
+	assert.False(t, ids[strangerTopic], "topic of stranger")
+}
+
+func testListTopicRevisions(t *testing.T) {
+	var topicResponse Response[apis.TopicCommonResponse]
+	userTester.testPost(t, "/api/topic", 201, Map{
+		"title":       "RevisionTitle",
+		"content":     "line 1\nline 2",
+		"division_id": 1,
+		"tags":        []Map{{"name": "revision"}},
+	}, &topicResponse)
+	assert.False(t, topicResponse.Data.IsEdited)
+	url := fmt.Sprintf("/api/topic/%d/revisions", topicResponse.Data.ID)
+
+	// 未修改过的话题没有历史版本
+	var response Response[apis.RevisionListResponse]
+	userTester.testGet(t, url, 200, nil, &response)
+	assert.Empty(t, response.Data.Revisions)
+
+	// 只修改标签不记录版本
+	userTester.testPut(t, fmt.Sprintf("/api/topic/%d", topicResponse.Data.ID), 200, Map{
+		"tags": []Map{{"name": "revision2"}},
+	}, &topicResponse)
+	assert.False(t, topicResponse.Data.IsEdited)
+
+	userTester.testPut(t, fmt.Sprintf("/api/topic/%d", topicResponse.Data.ID), 200, Map{
+		"content": "line 1\nline 2 edited",
+	}, &topicResponse)
+	userTester.testPut(t, fmt.Sprintf("/api/topic/%d", topicResponse.Data.ID), 200, Map{
+		"title": "RevisionTitle2",
+	}, &topicResponse)
+	assert.True(t, topicResponse.Data.IsEdited)
+	assert.NotNil(t, topicResponse.Data.EditedAt)
+
+	response = Response[apis.RevisionListResponse]{}
+	userTester.testGet(t, url, 200, nil, &response)
+	revisions := response.Data.Revisions
+	assert.Len(t, revisions, 3)
+	if len(revisions) != 3 {
+		return
+	}
+	assert.EqualValues(t, "line 1\nline 2", revisions[0].Content)
+	assert.Empty(t, revisions[0].ContentDiff)
+	assert.EqualValues(t, []DiffLine{
+		{Op: DiffEqual, Text: "line 1"},
+		{Op: DiffDelete, Text: "line 2"},
+		{Op: DiffInsert, Text: "line 2 edited"},
+	}, revisions[1].ContentDiff)
+	assert.Empty(t, revisions[1].TitleDiff)
+	assert.EqualValues(t, "RevisionTitle2", *revisions[2].Title)
+	assert.NotEmpty(t, revisions[2].TitleDiff)
+	assert.Empty(t, revisions[2].ContentDiff)
+
+	// 隐藏的话题只有管理员可以查看修改历史
+	DB.Model(&Topic{}).Where("id = ?", topicResponse.Data.ID).Update("is_hidden", true)
+	userTester.testGet(t, url, 403, nil, &response)
+	userTester.testGet(t, "/api/topic/0/revisions", 404, nil, &response)
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"bytes"
+	"chatdan_backend/apis"
+	. "chatdan_backend/models"
+	. "chatdan_backend/utils"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (tester *tester) testUpload(t *testing.T, filename string, content []byte, statusCode int, model any) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	assert.Nilf(t, err, "create form file")
+	_, err = part.Write(content)
+	assert.Nilf(t, err, "write form file")
+	assert.Nilf(t, writer.Close(), "close multipart writer")
+
+	req, err := http.NewRequest(http.MethodPost, "/api/upload", &body)
+	assert.Nilf(t, err, "constructs http request")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if tester.Token != "" {
+		req.Header.Add("Authorization", "Bearer "+tester.Token)
+	}
+
+	res, err := App.Test(req, -1)
+	assert.Nilf(t, err, "perform request")
+	assert.Equalf(t, statusCode, res.StatusCode, "status code")
+
+	responseBody, err := io.ReadAll(res.Body)
+	assert.Nilf(t, err, "decode response")
+	if res.StatusCode < 400 && model != nil {
+		assert.Nilf(t, json.Unmarshal(responseBody, model), "decode response")
+	}
+}
+
+func newTestPNG(t *testing.T, width, height int, shade uint8) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: shade, G: uint8(x), B: uint8(y), A: 0xFF})
+		}
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func testUploadAFile(t *testing.T) {
+	other := otherTester[4]
+
+	// 上传图片，较大的图片生成缩略图
+	var uploadResponse Response[apis.AttachmentResponse]
+	large := newTestPNG(t, 400, 200, 1)
+	userTester.testUpload(t, "large.png", large, 201, &uploadResponse)
+	largeAttachment := uploadResponse.Data
+	assert.NotZero(t, largeAttachment.ID)
+	assert.EqualValues(t, "image/png", largeAttachment.MimeType)
+	assert.EqualValues(t, 400, largeAttachment.Width)
+	assert.EqualValues(t, 200, largeAttachment.Height)
+	assert.NotEmpty(t, largeAttachment.ThumbnailURL)
+
+	// 上传的文件可以访问
+	req, err := http.NewRequest(http.MethodGet, largeAttachment.URL, nil)
+	assert.Nil(t, err)
+	res, err := App.Test(req, -1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 200, res.StatusCode)
+
+	// 相同的文件返回已有的附件，状态码与新上传的文件相同
+	uploadResponse = Response[apis.AttachmentResponse]{}
+	other.testUpload(t, "same.png", large, 201, &uploadResponse)
+	assert.EqualValues(t, largeAttachment.ID, uploadResponse.Data.ID)
+
+	uploadResponse = Response[apis.AttachmentResponse]{}
+	userTester.testUpload(t, "small.png", newTestPNG(t, 16, 16, 2), 201, &uploadResponse)
+	smallAttachment := uploadResponse.Data
+	assert.Empty(t, smallAttachment.ThumbnailURL)
+
+	// 不支持的文件类型，类型按照内容判断
+	userTester.testUpload(t, "image.png", []byte("#!/bin/sh\necho hello\n"), 400, nil)
+
+	// 只有上传文件的接口允许超过 fiber 默认大小的请求体
+	userTester.testUpload(t, "padded.png", append(newTestPNG(t, 16, 16, 3), make([]byte, fiber.DefaultBodyLimit)...), 201, nil)
+	userTester.testPost(t, "/api/topic", 413, Map{
+		"title":       "too large",
+		"content":     strings.Repeat("a", fiber.DefaultBodyLimit),
+		"division_id": 1,
+	}, nil)
+
+	// 发布话题时引用附件
+	var topicResponse Response[apis.TopicCommonResponse]
+	userTester.testPost(t, "/api/topic", 201, Map{
+		"title":          "UploadTitle",
+		"content":        "upload content",
+		"division_id":    1,
+		"tags":           []Map{{"name": "upload"}},
+		"attachment_ids": []int{smallAttachment.ID, largeAttachment.ID},
+	}, &topicResponse)
+	if assert.Len(t, topicResponse.Data.Attachments, 2) {
+		assert.EqualValues(t, smallAttachment.ID, topicResponse.Data.Attachments[0].ID)
+		assert.EqualValues(t, largeAttachment.ID, topicResponse.Data.Attachments[1].ID)
+	}
+	topicID := topicResponse.Data.ID
+
+	topicResponse = Response[apis.TopicCommonResponse]{}
+	other.testGet(t, fmt.Sprintf("/api/topic/%d", topicID), 200, nil, &topicResponse)
+	assert.Len(t, topicResponse.Data.Attachments, 2)
+
+	// 不能引用其他用户上传的附件
+	other.testPost(t, "/api/comment", 400, Map{
+		"topic_id":       topicID,
+		"content":        "upload comment",
+		"attachment_ids": []int{smallAttachment.ID},
+	}, nil)
+
+	var commentResponse Response[apis.CommentCommonResponse]
+	other.testPost(t, "/api/comment", 201, Map{
+		"topic_id":       topicID,
+		"content":        "upload comment",
+		"attachment_ids": []int{largeAttachment.ID},
+	}, &commentResponse)
+	assert.Len(t, commentResponse.Data.Attachments, 1)
+
+	// 修改时替换附件
+	topicResponse = Response[apis.TopicCommonResponse]{}
+	userTester.testPut(t, fmt.Sprintf("/api/topic/%d", topicID), 200, Map{
+		"attachment_ids": []int{largeAttachment.ID},
+	}, &topicResponse)
+	if assert.Len(t, topicResponse.Data.Attachments, 1) {
+		assert.EqualValues(t, largeAttachment.ID, topicResponse.Data.Attachments[0].ID)
+	}
+
+	// 私信中引用附件
+	var messageResponse Response[apis.MessageCommonResponse]
+	userTester.testPost(t, "/api/messages", 201, Map{
+		"content":        "upload message",
+		"to_user_id":     other.ID,
+		"attachment_ids": []int{smallAttachment.ID},
+	}, &messageResponse)
+	assert.Len(t, messageResponse.Data.Attachments, 1)
+
+	var messageListResponse Response[apis.MessageListResponse]
+	other.testGet(t, "/api/messages", 200, Map{"to_user_id": userTester.ID}, &messageListResponse)
+	if assert.NotEmpty(t, messageListResponse.Data.Messages) {
+		assert.Len(t, messageListResponse.Data.Messages[0].Attachments, 1)
+	}
+}
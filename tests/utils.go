@@ -6,17 +6,35 @@ import (
 	. "chatdan_backend/models"
 	"chatdan_backend/utils"
 	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
 	"github.com/hetiansu5/urlquery"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
+	"os"
 )
 
-var App = bootstrap.InitFiberApp()
+var App = initApp()
+
+// uploadDir 测试上传的文件保存在临时目录中，测试结束后删除
+var uploadDir string
+
+// initApp 测试请求的连接地址是 0.0.0.0，把它作为可信代理，通过 X-Real-IP 模拟不同的客户端
+func initApp() *fiber.App {
+	_ = os.Setenv("PROXY_HEADER", "X-Real-IP")
+	_ = os.Setenv("TRUSTED_PROXIES", "0.0.0.0")
+	var err error
+	if uploadDir, err = os.MkdirTemp("", "chatdan_uploads"); err != nil {
+		panic(err)
+	}
+	_ = os.Setenv("STORAGE_LOCAL_DIR", uploadDir)
+	return bootstrap.InitFiberApp()
+}
 
 type tester struct {
 	Token string
 	ID    int
+	IP    string // 客户端 IP，为空时使用连接地址
 }
 
 var (
@@ -50,6 +68,9 @@ func (tester *tester) testCommon(t assert.TestingT, method string, route string,
 	if tester.Token != "" {
 		req.Header.Add("Authorization", "Bearer "+tester.Token)
 	}
+	if tester.IP != "" {
+		req.Header.Add("X-Real-IP", tester.IP)
+	}
 
 	res, err := App.Test(req, -1)
 	assert.Nilf(t, err, "perform request")
@@ -0,0 +1,370 @@
+package tests
+
+import (
+	"chatdan_backend/apis"
+	"chatdan_backend/config"
+	. "chatdan_backend/models"
+	"chatdan_backend/utils"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// loginAdmin 注册一个管理员账号并登录，设置 adminTester
+func loginAdmin(t *testing.T) {
+	if adminTester.Token != "" {
+		return
+	}
+	data := Map{"username": "admin", "password": "test123456"}
+	var response utils.Response[apis.LoginResponse]
+	defaultTester.testPost(t, "/api/user/register", 200, data, &response)
+	assert.Nil(t, DB.Model(&User{}).Where("id = ?", response.Data.ID).Update("is_admin", true).Error)
+	defaultTester.testPost(t, "/api/user/login", 200, data, &response)
+	adminTester = tester{Token: response.Data.AccessToken, ID: response.Data.ID}
+}
+
+func testWallPublication(t *testing.T) {
+	loginAdmin(t)
+
+	// 新建的表白在下一次截止时间发布
+	var wall utils.Response[apis.WallCommonResponse]
+	userTester.testPost(t, "/api/wall", 201, Map{"content": "wall publication"}, &wall)
+	assert.False(t, wall.Data.IsShown)
+	assert.Zero(t, wall.Data.Sequence)
+	if assert.NotNil(t, wall.Data.PublishAt) {
+		assert.True(t, wall.Data.PublishAt.After(time.Now()))
+	}
+	wallURL := "/api/wall/" + strconv.Itoa(wall.Data.ID)
+
+	// 只有管理员可以查看未发布的表白
+	userTester.testGet(t, "/api/walls/_pending", 403, nil, nil)
+	var pending utils.Response[apis.WallPendingListResponse]
+	adminTester.testGet(t, "/api/walls/_pending", 200, Map{"size": 50}, &pending)
+	found := false
+	for _, post := range pending.Data.Posts {
+		if post.ID == wall.Data.ID {
+			found = true
+			assert.Zero(t, post.PosterID) // 匿名的表白对管理员同样不显示发布者
+		}
+	}
+	assert.True(t, found)
+
+	// 发布前排除和取消排除
+	userTester.testPut(t, wallURL+"/_exclude", 403, nil, nil)
+	wall = utils.Response[apis.WallCommonResponse]{}
+	adminTester.testPut(t, wallURL+"/_exclude", 200, nil, &wall)
+	assert.True(t, wall.Data.IsExcluded)
+	assert.Nil(t, wall.Data.PublishAt)
+	wall = utils.Response[apis.WallCommonResponse]{}
+	userTester.testGet(t, wallURL, 200, nil, &wall)
+	assert.True(t, wall.Data.IsExcluded)
+	adminTester.testDelete(t, wallURL+"/_exclude", 200, nil, &wall)
+	assert.False(t, wall.Data.IsExcluded)
+	assert.NotNil(t, wall.Data.PublishAt)
+
+	// 手动发布不会提前发布未到截止时间的表白
+	userTester.testPost(t, "/api/walls/_publish", 403, nil, nil)
+	var publish utils.Response[apis.WallPublishResponse]
+	adminTester.testPost(t, "/api/walls/_publish", 200, nil, &publish)
+	userTester.testGet(t, wallURL, 200, nil, &wall)
+	assert.False(t, wall.Data.IsShown)
+
+	// 最近一次发布的表白墙，不包含未发布的表白
+	var list utils.Response[apis.WallListResponse]
+	defaultTester.testGet(t, "/api/wall", 200, nil, &list)
+	assert.NotNil(t, list.Data.PublishedAt)
+	assert.Equal(t, len(list.Data.Posts), list.Data.Total) // 已废弃的 total 与当前用户可见的数量相同
+	for _, post := range list.Data.Posts {
+		assert.NotEqual(t, wall.Data.ID, post.ID)
+		assert.True(t, post.IsShown)
+	}
+	defaultTester.testGet(t, "/api/wall", 400, Map{"date": time.Now().AddDate(0, 0, 2).Format(time.RFC3339)}, nil)
+
+	// 已经发布的表白不能排除
+	var digest WallDigest
+	assert.Nil(t, DB.Order("date DESC").Take(&digest).Error)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", wall.Data.ID).Updates(Map{"digest_id": digest.ID, "sequence": 1}).Error)
+	adminTester.testPut(t, wallURL+"/_exclude", 400, nil, nil)
+	wall = utils.Response[apis.WallCommonResponse]{}
+	userTester.testGet(t, wallURL, 200, nil, &wall)
+	assert.True(t, wall.Data.IsShown)
+	assert.Equal(t, 1, wall.Data.Sequence)
+	assert.Nil(t, wall.Data.PublishAt)
+}
+
+func testWallManagement(t *testing.T) {
+	loginAdmin(t)
+	poster, other := otherTester[5], otherTester[6]
+
+	// 不公开的表白只有发布者和管理员可见
+	var wall utils.Response[apis.WallCommonResponse]
+	poster.testPost(t, "/api/wall", 201, Map{"content": "private wall", "visibility": "private"}, &wall)
+	assert.Equal(t, "private", wall.Data.Visibility)
+	assert.True(t, wall.Data.IsOwner)
+	wallURL := "/api/wall/" + strconv.Itoa(wall.Data.ID)
+	defaultTester.testGet(t, wallURL, 404, nil, nil)
+	other.testGet(t, wallURL, 404, nil, nil)
+	other.testPut(t, wallURL, 404, Map{"visibility": "public"}, nil)
+	adminTester.testGet(t, wallURL, 200, nil, &wall)
+	assert.False(t, wall.Data.IsOwner)
+
+	// 发布者在发布之前修改
+	poster.testPut(t, wallURL, 400, Map{}, nil)
+	poster.testPut(t, wallURL, 200, Map{"content": "public wall", "visibility": "public", "is_anonymous": false}, &wall)
+	assert.Equal(t, "public wall", wall.Data.Content)
+	assert.Equal(t, "public", wall.Data.Visibility)
+	assert.Equal(t, poster.ID, wall.Data.PosterID)
+	other.testGet(t, wallURL, 200, nil, &wall)
+	other.testPut(t, wallURL, 403, Map{"content": "modified"}, nil)
+	other.testPut(t, wallURL, 403, Map{"visibility": "private"}, nil)
+	adminTester.testPut(t, wallURL, 403, Map{"content": "modified"}, nil)
+
+	// 只有管理员可以隐藏
+	poster.testPut(t, wallURL, 403, Map{"is_hidden": true}, nil)
+	adminTester.testPut(t, wallURL, 200, Map{"is_hidden": true}, &wall)
+	assert.True(t, wall.Data.IsHidden)
+	assert.False(t, wall.Data.IsShown)
+	other.testGet(t, wallURL, 404, nil, nil)
+	wall = utils.Response[apis.WallCommonResponse]{}
+	poster.testGet(t, wallURL, 200, nil, &wall)
+	assert.True(t, wall.Data.IsHidden)
+	adminTester.testPut(t, wallURL, 200, Map{"is_hidden": false}, &wall)
+	assert.False(t, wall.Data.IsHidden)
+
+	// 发布之后发布者不能修改，管理员可以修改可见性
+	var digest WallDigest
+	assert.Nil(t, DB.Order("date DESC").Take(&digest).Error)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", wall.Data.ID).Updates(Map{"digest_id": digest.ID, "sequence": 2}).Error)
+	poster.testPut(t, wallURL, 400, Map{"content": "modified"}, nil)
+	poster.testPut(t, wallURL, 400, Map{"visibility": "private"}, nil)
+	adminTester.testPut(t, wallURL, 200, Map{"visibility": "private"}, &wall)
+	assert.Equal(t, "private", wall.Data.Visibility)
+
+	listContains := func(tester tester) bool {
+		var list utils.Response[apis.WallListResponse]
+		tester.testGet(t, "/api/wall", 200, Map{"size": 50}, &list)
+		for _, post := range list.Data.Posts {
+			if post.ID == wall.Data.ID {
+				return true
+			}
+		}
+		return false
+	}
+	assert.False(t, listContains(defaultTester))
+	assert.False(t, listContains(other))
+	assert.True(t, listContains(poster))
+	adminTester.testPut(t, wallURL, 200, Map{"visibility": "public"}, &wall)
+	assert.True(t, listContains(other))
+
+	// 发布者或者管理员可以删除
+	other.testDelete(t, wallURL, 403, nil, nil)
+	poster.testDelete(t, wallURL, 200, nil, nil)
+	poster.testGet(t, wallURL, 404, nil, nil)
+	assert.False(t, listContains(poster))
+}
+
+func testWallTarget(t *testing.T) {
+	loginAdmin(t)
+	alice, bob, other := otherTester[7], otherTester[8], otherTester[9]
+
+	alice.testPost(t, "/api/wall", 400, Map{"content": "myself", "target_id": alice.ID}, nil)
+	alice.testPost(t, "/api/wall", 400, Map{"content": "nobody", "target_id": 1 << 30}, nil)
+
+	// 匿名表白，对象只对发布者可见
+	var wall utils.Response[apis.WallCommonResponse]
+	alice.testPost(t, "/api/wall", 201, Map{"content": "to bob", "target_id": bob.ID}, &wall)
+	if assert.NotNil(t, wall.Data.TargetUser) {
+		assert.Equal(t, bob.ID, wall.Data.TargetUser.ID)
+	}
+	assert.False(t, wall.Data.IsMatched)
+	aliceURL := "/api/wall/" + strconv.Itoa(wall.Data.ID)
+
+	// 其他人和管理员都看不到表白的对象
+	noTarget := func(tester tester, url string) {
+		var raw utils.Response[Map]
+		tester.testGet(t, url, 200, nil, &raw)
+		if assert.NotNil(t, raw.Data) {
+			for _, key := range []string{"target_id", "target_user", "is_target", "is_matched"} {
+				assert.NotContains(t, *raw.Data, key)
+			}
+			assert.EqualValues(t, 0, (*raw.Data)["poster_id"])
+		}
+	}
+	noTarget(other, aliceURL)
+	noTarget(adminTester, aliceURL)
+	noTarget(defaultTester, aliceURL)
+
+	// 对象本人知道自己是对象，但是看不到匿名的发布者
+	wall = utils.Response[apis.WallCommonResponse]{}
+	bob.testGet(t, aliceURL, 200, nil, &wall)
+	assert.True(t, wall.Data.IsTarget)
+	assert.Nil(t, wall.Data.TargetUser)
+	assert.Zero(t, wall.Data.PosterID)
+	var received utils.Response[apis.WallReceivedListResponse]
+	bob.testGet(t, "/api/walls/_received", 200, nil, &received)
+	if assert.NotEmpty(t, received.Data.Posts) {
+		assert.Equal(t, wall.Data.ID, received.Data.Posts[0].ID)
+		assert.Zero(t, received.Data.Posts[0].PosterID)
+	}
+	received = utils.Response[apis.WallReceivedListResponse]{}
+	other.testGet(t, "/api/walls/_received", 200, nil, &received)
+	assert.Empty(t, received.Data.Posts)
+
+	// 互相表白并且双方的表白都发布之后，双方都能看到对方的匿名表白的发布者
+	var bobWall utils.Response[apis.WallCommonResponse]
+	bob.testPost(t, "/api/wall", 201, Map{"content": "to alice", "target_id": alice.ID, "visibility": "private"}, &bobWall)
+	assert.False(t, bobWall.Data.IsMatched)
+	bobURL := "/api/wall/" + strconv.Itoa(bobWall.Data.ID)
+	var digest WallDigest
+	assert.Nil(t, DB.Order("date DESC").Take(&digest).Error)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", bobWall.Data.ID).Update("digest_id", digest.ID).Error)
+	bobWall = utils.Response[apis.WallCommonResponse]{}
+	alice.testGet(t, bobURL, 200, nil, &bobWall)
+	assert.False(t, bobWall.Data.IsMatched)
+	assert.Zero(t, bobWall.Data.PosterID)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", wall.Data.ID).Update("digest_id", digest.ID).Error)
+	bobWall = utils.Response[apis.WallCommonResponse]{}
+	alice.testGet(t, bobURL, 200, nil, &bobWall)
+	assert.True(t, bobWall.Data.IsTarget)
+	assert.True(t, bobWall.Data.IsMatched)
+	assert.Equal(t, bob.ID, bobWall.Data.PosterID)
+	wall = utils.Response[apis.WallCommonResponse]{}
+	bob.testGet(t, aliceURL, 200, nil, &wall)
+	assert.True(t, wall.Data.IsMatched)
+	assert.Equal(t, alice.ID, wall.Data.PosterID)
+	if assert.NotNil(t, wall.Data.Poster) {
+		assert.Equal(t, alice.ID, wall.Data.Poster.ID)
+	}
+	other.testGet(t, bobURL, 404, nil, nil)
+	noTarget(other, aliceURL)
+	noTarget(adminTester, aliceURL)
+
+	var matches utils.Response[apis.WallMatchListResponse]
+	alice.testGet(t, "/api/walls/_matches", 200, nil, &matches)
+	if assert.Len(t, matches.Data.Matches, 1) {
+		assert.Equal(t, bob.ID, matches.Data.Matches[0].User.ID)
+		assert.Equal(t, bobWall.Data.ID, matches.Data.Matches[0].MatchedWallID)
+	}
+	matches = utils.Response[apis.WallMatchListResponse]{}
+	other.testGet(t, "/api/walls/_matches", 200, nil, &matches)
+	assert.Empty(t, matches.Data.Matches)
+
+	// 被排除的表白不算
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", wall.Data.ID).Update("is_excluded", true).Error)
+	matches = utils.Response[apis.WallMatchListResponse]{}
+	bob.testGet(t, "/api/walls/_matches", 200, nil, &matches)
+	assert.Empty(t, matches.Data.Matches)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", wall.Data.ID).Update("is_excluded", false).Error)
+
+	// 删除表白之后不再互相表白
+	bob.testDelete(t, bobURL, 200, nil, nil)
+	matches = utils.Response[apis.WallMatchListResponse]{}
+	alice.testGet(t, "/api/walls/_matches", 200, nil, &matches)
+	assert.Empty(t, matches.Data.Matches)
+	wall = utils.Response[apis.WallCommonResponse]{}
+	bob.testGet(t, aliceURL, 200, nil, &wall)
+	assert.False(t, wall.Data.IsMatched)
+	assert.Zero(t, wall.Data.PosterID)
+
+	// 每个用户 24 小时内指定对象的表白数量有限制，删除的表白同样计数
+	for i := 1; i < config.Config.WallTargetDailyLimit; i++ {
+		bob.testPost(t, "/api/wall", 201, Map{"content": "to other", "target_id": other.ID}, nil)
+	}
+	bob.testPost(t, "/api/wall", 429, Map{"content": "to other", "target_id": other.ID}, nil)
+	bob.testPost(t, "/api/wall", 201, Map{"content": "no target"}, nil)
+}
+
+func testWallInteraction(t *testing.T) {
+	poster, alice, bob := otherTester[5], otherTester[6], otherTester[7]
+
+	var wall utils.Response[apis.WallCommonResponse]
+	poster.testPost(t, "/api/wall", 201, Map{"content": "popular wall"}, &wall)
+	wallURL := "/api/wall/" + strconv.Itoa(wall.Data.ID)
+	var quiet utils.Response[apis.WallCommonResponse]
+	poster.testPost(t, "/api/wall", 201, Map{"content": "quiet wall"}, &quiet)
+
+	// 发布之前不能回应和回复
+	alice.testPut(t, wallURL+"/_reaction", 400, Map{"emoji": "❤️"}, nil)
+	alice.testPost(t, wallURL+"/comments", 400, Map{"content": "hi"}, nil)
+
+	var digest WallDigest
+	assert.Nil(t, DB.Order("date DESC").Take(&digest).Error)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", quiet.Data.ID).Updates(Map{"digest_id": digest.ID, "sequence": 100}).Error)
+	assert.Nil(t, DB.Model(&Wall{}).Where("id = ?", wall.Data.ID).Updates(Map{"digest_id": digest.ID, "sequence": 101}).Error)
+
+	// 表情回应
+	alice.testPut(t, wallURL+"/_reaction", 400, Map{"emoji": "👎"}, nil)
+	alice.testPut(t, wallURL+"/_reaction", 200, Map{"emoji": "❤️"}, &wall)
+	alice.testPut(t, wallURL+"/_reaction", 200, Map{"emoji": "❤️"}, &wall)
+	alice.testPut(t, wallURL+"/_reaction", 200, Map{"emoji": "🍋"}, &wall)
+	bob.testPut(t, wallURL+"/_reaction", 200, Map{"emoji": "❤️"}, &wall)
+	assert.Equal(t, 3, wall.Data.ReactionCount)
+	if assert.Len(t, wall.Data.Reactions, len(WallReactionEmojis)) {
+		assert.Equal(t, "❤️", wall.Data.Reactions[0].Emoji)
+		assert.Equal(t, 2, wall.Data.Reactions[0].Count)
+		assert.True(t, wall.Data.Reactions[0].Reacted)
+		assert.False(t, wall.Data.Reactions[len(WallReactionEmojis)-1].Reacted)
+	}
+	bob.testDelete(t, wallURL+"/_reaction", 200, Map{"emoji": "❤️"}, &wall)
+	assert.Equal(t, 2, wall.Data.ReactionCount)
+	assert.False(t, wall.Data.Reactions[0].Reacted)
+
+	// 匿名回复，同一个用户在同一条表白中昵称相同
+	var comment utils.Response[apis.WallCommentResponse]
+	alice.testPost(t, wallURL+"/comments", 201, Map{"content": "first"}, &comment)
+	assert.True(t, comment.Data.IsAnonymous)
+	assert.Zero(t, comment.Data.PosterID)
+	assert.True(t, comment.Data.IsOwner)
+	if assert.NotNil(t, comment.Data.Anonyname) {
+		aliceName := *comment.Data.Anonyname
+		alice.testPost(t, wallURL+"/comments", 201, Map{"content": "second"}, &comment)
+		assert.Equal(t, aliceName, *comment.Data.Anonyname)
+		poster.testPost(t, wallURL+"/comments", 201, Map{"content": "thanks"}, &comment)
+		assert.NotEqual(t, aliceName, *comment.Data.Anonyname)
+		assert.True(t, comment.Data.IsWallPoster)
+	}
+	comment = utils.Response[apis.WallCommentResponse]{}
+	bob.testPost(t, wallURL+"/comments", 201, Map{"content": "real name", "is_anonymous": false}, &comment)
+	assert.Equal(t, bob.ID, comment.Data.PosterID)
+	assert.Nil(t, comment.Data.Anonyname)
+	bobCommentURL := "/api/wallComment/" + strconv.Itoa(comment.Data.ID)
+
+	var comments utils.Response[apis.WallCommentListResponse]
+	defaultTester.testGet(t, wallURL+"/comments", 200, nil, &comments)
+	if assert.Len(t, comments.Data.Comments, 4) {
+		assert.Equal(t, "first", comments.Data.Comments[0].Content)
+		assert.Zero(t, comments.Data.Comments[0].PosterID)
+		assert.False(t, comments.Data.Comments[0].IsOwner)
+	}
+
+	alice.testDelete(t, bobCommentURL, 403, nil, nil)
+	bob.testDelete(t, bobCommentURL, 200, nil, nil)
+	wall = utils.Response[apis.WallCommonResponse]{}
+	alice.testGet(t, wallURL, 200, nil, &wall)
+	assert.Equal(t, 3, wall.Data.CommentCount)
+
+	// 按照热度排序
+	var list utils.Response[apis.WallListResponse]
+	defaultTester.testGet(t, "/api/wall", 200, Map{"order_by": "popularity", "size": 50}, &list)
+	popular, quietIndex := -1, -1
+	for i, post := range list.Data.Posts {
+		switch post.ID {
+		case wall.Data.ID:
+			popular = i
+		case quiet.Data.ID:
+			quietIndex = i
+		}
+	}
+	assert.True(t, popular >= 0 && quietIndex > popular)
+	defaultTester.testGet(t, "/api/wall", 400, Map{"order_by": "likes"}, nil)
+
+	// 计数器对账
+	var report utils.Response[CounterReconcileReport]
+	adminTester.testPost(t, "/api/counters/_reconcile?dry_run=true", 200, nil, &report)
+	for _, counter := range report.Data.Counters {
+		if counter.Counter == "wall.reaction_count" || counter.Counter == "wall.comment_count" {
+			assert.Zero(t, counter.Drifted, counter.Counter)
+		}
+	}
+}
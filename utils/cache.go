@@ -3,11 +3,13 @@ package utils
 import (
 	"chatdan_backend/config"
 	"context"
+	"encoding/binary"
 	"github.com/allegro/bigcache/v3"
 	"github.com/goccy/go-json"
 	"github.com/juju/errors"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+	"sync"
 	"time"
 )
 
@@ -74,6 +76,61 @@ func Set(key string, model any, expiration time.Duration) (err error) {
 	}
 }
 
+var setNXMutex sync.Mutex
+
+// SetNX 键不存在时设置并返回 true，已经存在时不修改并返回 false
+// 使用 bigcache 时在进程内加锁保证原子性，值的前 8 个字节保存过期时间，过期的值视为不存在
+// bigcache 的条目最多保存 10 分钟，更长的过期时间同样在 10 分钟后失效；设置的值只能由 SetNX 读取
+func SetNX(key string, model any, expiration time.Duration) (ok bool, err error) {
+	var value []byte
+	if value, err = json.Marshal(model); err != nil {
+		return false, errors.Trace(err)
+	}
+
+	if usingRedis {
+		ok, err = RedisClient.SetNX(context.Background(), key, value, expiration).Result()
+		return ok, errors.Trace(err)
+	}
+
+	setNXMutex.Lock()
+	defer setNXMutex.Unlock()
+	now := time.Now()
+	var old []byte
+	if old, err = BigCacheClient.Get(key); err == nil {
+		if len(old) >= 8 && now.UnixNano() < int64(binary.BigEndian.Uint64(old)) {
+			return false, nil
+		}
+	} else if err != bigcache.ErrEntryNotFound {
+		return false, errors.Trace(err)
+	}
+	entry := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(value)), uint64(now.Add(expiration).UnixNano()))
+	return true, errors.Trace(BigCacheClient.Set(key, append(entry, value...)))
+}
+
+var getDelMutex sync.Mutex
+
+// GetDel 读取并删除缓存，并发调用时只有一个调用能读到值，其他调用返回 ErrCacheMiss
+func GetDel(key string, model any) (err error) {
+	var value []byte
+	if usingRedis {
+		value, err = RedisClient.GetDel(context.Background(), key).Bytes()
+	} else {
+		getDelMutex.Lock()
+		value, err = BigCacheClient.Get(key)
+		if err == nil {
+			err = BigCacheClient.Delete(key)
+		}
+		getDelMutex.Unlock()
+	}
+	if err != nil {
+		if err == redis.Nil || err == bigcache.ErrEntryNotFound {
+			return ErrCacheMiss
+		}
+		return errors.Trace(err)
+	}
+	return json.Unmarshal(value, model)
+}
+
 func Delete(key string) {
 	if usingRedis {
 		_ = RedisClient.Del(context.Background(), key)
@@ -97,3 +154,47 @@ func DeleteInBatch(keys ...string) {
 		}
 	}
 }
+
+type rateLimitCounter struct {
+	Count   int       `json:"count"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+var rateLimitMutex sync.Mutex
+
+// rateLimitScript 计数和设置过期时间在一个脚本中执行，避免计数后进程退出导致键永不过期
+var rateLimitScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// RateLimit 固定窗口限流，窗口内超过 limit 次时返回 false
+// 使用 bigcache 时过期时间由全局配置决定，窗口的重置时间保存在值中
+func RateLimit(key string, limit int, window time.Duration) (allowed bool, err error) {
+	if usingRedis {
+		count, err := rateLimitScript.Run(context.Background(), RedisClient, []string{key}, window.Milliseconds()).Int64()
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		return count <= int64(limit), nil
+	}
+
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+	var counter rateLimitCounter
+	if err = Get(key, &counter); err != nil && err != ErrCacheMiss {
+		return false, err
+	}
+	now := time.Now()
+	if !now.Before(counter.ResetAt) {
+		counter = rateLimitCounter{ResetAt: now.Add(window)}
+	}
+	counter.Count++
+	if err = Set(key, counter, window); err != nil {
+		return false, err
+	}
+	return counter.Count <= limit, nil
+}
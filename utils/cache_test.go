@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 使用 bigcache 时 SetNX 同样按照设置的时间过期
+func TestSetNXExpiration(t *testing.T) {
+	InitCache()
+	key := "test_setnx:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	for i, expected := range []bool{true, false} {
+		ok, err := SetNX(key, true, 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != expected {
+			t.Fatalf("SetNX #%d = %v, expected %v", i, ok, expected)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	ok, err := SetNX(key, true, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected expired key to be set again")
+	}
+	if ok, _ = SetNX(key, true, time.Minute); ok {
+		t.Fatal("expected key to exist")
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"github.com/thanhpk/randstr"
+	"math/bits"
+	"time"
+)
+
+// ChallengeTTL 工作量证明的有效期
+const ChallengeTTL = 5 * time.Minute
+
+// Challenge 工作量证明，客户端需要找到 nonce 使 sha256(challenge + nonce) 的前导零比特数不少于 difficulty
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type challengeRecord struct {
+	Scope      string    `json:"scope"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func challengeKey(challenge string) string {
+	return "challenge:" + challenge
+}
+
+// NewChallenge 生成工作量证明并保存在缓存中，scope 限定使用的范围，如某个提问箱
+func NewChallenge(scope string, difficulty int) (*Challenge, error) {
+	challenge := &Challenge{
+		Challenge:  randstr.Base62(32),
+		Difficulty: difficulty,
+		ExpiresAt:  time.Now().Add(ChallengeTTL),
+	}
+	record := challengeRecord{Scope: scope, Difficulty: difficulty, ExpiresAt: challenge.ExpiresAt}
+	if err := Set(challengeKey(challenge.Challenge), record, ChallengeTTL); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// VerifyChallenge 验证工作量证明，每个 challenge 只能使用一次
+func VerifyChallenge(scope, challenge, nonce string) error {
+	if challenge == "" || nonce == "" {
+		return BadRequest("需要完成验证")
+	}
+
+	// 读取的同时删除，并发提交同一个 challenge 时只有一个请求能通过
+	var record challengeRecord
+	if err := GetDel(challengeKey(challenge), &record); err != nil {
+		if err == ErrCacheMiss {
+			return BadRequest("验证已过期，请重新获取")
+		}
+		return err
+	}
+
+	if record.Scope != scope || time.Now().After(record.ExpiresAt) {
+		return BadRequest("验证已过期，请重新获取")
+	}
+	if !CheckProofOfWork(challenge, nonce, record.Difficulty) {
+		return BadRequest("验证失败")
+	}
+	return nil
+}
+
+// CheckProofOfWork sha256(challenge + nonce) 的前导零比特数是否不少于 difficulty
+func CheckProofOfWork(challenge, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(challenge + nonce))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros >= difficulty
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"encoding/base64"
+	"github.com/goccy/go-json"
+)
+
+// Cursor 分页游标，记录边界数据的排序值和 ID
+// 对客户端不透明，编码为 base64 字符串
+type Cursor struct {
+	Key      json.RawMessage `json:"k"`           // 边界数据的排序值
+	ID       int             `json:"i"`           // 边界数据的 ID，排序值相同时用于确定顺序
+	Backward bool            `json:"b,omitempty"` // true 表示向前翻页
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseCursor(value string) (cursor *Cursor, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, BadRequest("invalid cursor")
+	}
+	cursor = new(Cursor)
+	if err = json.Unmarshal(data, cursor); err != nil || cursor.Key == nil {
+		return nil, BadRequest("invalid cursor")
+	}
+	return cursor, nil
+}
@@ -50,6 +50,17 @@ func NotFound(messages ...string) error {
 	}
 }
 
+func TooManyRequests(messages ...string) error {
+	message := "Too Many Requests"
+	if len(messages) > 0 {
+		message = messages[0]
+	}
+	return &Response[any]{
+		Code:     429,
+		ErrorMsg: message,
+	}
+}
+
 func InternalServerError(messages ...string) error {
 	message := "Unknown Error"
 	if len(messages) > 0 {
@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	ThumbnailMaxSize = 320        // 缩略图最长边的像素数
+	imageMaxPixels   = 40_000_000 // 超过该像素数的图片不解码，避免解压炸弹
+)
+
+// UploadContentTypes 允许上传的文件类型 -> 文件扩展名
+var UploadContentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+var ErrImageTooLarge = errors.New("image too large")
+
+// DetectContentType 根据文件内容判断类型，不信任客户端提供的类型
+func DetectContentType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// StripImageMetadata 删除 JPEG 的 APP1（EXIF、XMP）段、PNG 的 eXIf、文本块和 WebP 的 EXIF、XMP 块，避免泄露拍摄位置等信息
+// 无法解析的文件原样返回
+func StripImageMetadata(data []byte, contentType string) []byte {
+	switch contentType {
+	case "image/jpeg":
+		if stripped, ok := stripJPEGMetadata(data); ok {
+			return stripped
+		}
+	case "image/png":
+		if stripped, ok := stripPNGMetadata(data); ok {
+			return stripped
+		}
+	case "image/webp":
+		if stripped, ok := stripWebPMetadata(data); ok {
+			return stripped
+		}
+	}
+	return data
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, false
+	}
+	result := make([]byte, 0, len(data))
+	result = append(result, 0xFF, 0xD8)
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, false
+		}
+		marker := data[i+1]
+		// 填充字节
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		// SOS 之后是压缩数据，直接复制
+		if marker == 0xDA {
+			return append(result, data[i:]...), true
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, false
+		}
+		if marker != 0xE1 {
+			result = append(result, data[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+	return nil, false
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func stripPNGMetadata(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, false
+	}
+	result := make([]byte, 0, len(data))
+	result = append(result, pngSignature...)
+	i := len(pngSignature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, false
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "iTXt", "zTXt":
+		default:
+			result = append(result, data[i:end]...)
+		}
+		if string(data[i+4:i+8]) == "IEND" {
+			return result, true
+		}
+		i = end
+	}
+	return nil, false
+}
+
+const (
+	webPFlagXMP  = 0x04
+	webPFlagEXIF = 0x08
+)
+
+// stripWebPMetadata 删除 RIFF 容器中的 EXIF 和 XMP 块，同时清除 VP8X 块中对应的标志位
+func stripWebPMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false
+	}
+	result := make([]byte, 0, len(data))
+	result = append(result, data[:12]...)
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, false
+		}
+		length := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		// 块的长度为奇数时有一个填充字节
+		end := i + 8 + length + length&1
+		if length < 0 || end > len(data) {
+			return nil, false
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			if length > 0 {
+				chunk[8] &^= webPFlagEXIF | webPFlagXMP
+			}
+			result = append(result, chunk...)
+		default:
+			result = append(result, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
+	return result, true
+}
+
+// ImageConfig 返回图片的宽高，非图片或者不支持解析的格式返回 0
+func ImageConfig(data []byte, contentType string) (width, height int, err error) {
+	var config image.Config
+	switch contentType {
+	case "image/jpeg":
+		config, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	case "image/png":
+		config, err = png.DecodeConfig(bytes.NewReader(data))
+	case "image/gif":
+		config, err = gif.DecodeConfig(bytes.NewReader(data))
+	default:
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if config.Width*config.Height > imageMaxPixels {
+		return 0, 0, ErrImageTooLarge
+	}
+	return config.Width, config.Height, nil
+}
+
+// GenerateThumbnail 生成最长边不超过 ThumbnailMaxSize 的 JPEG 缩略图，透明部分填充白色
+// 图片本身足够小或者格式不支持时返回 nil
+func GenerateThumbnail(data []byte, contentType string) ([]byte, error) {
+	width, height, err := ImageConfig(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if width <= ThumbnailMaxSize && height <= ThumbnailMaxSize {
+		return nil, nil
+	}
+
+	var src image.Image
+	switch contentType {
+	case "image/jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		src, err = png.Decode(bytes.NewReader(data))
+	case "image/gif":
+		src, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnailWidth, thumbnailHeight := ThumbnailMaxSize, ThumbnailMaxSize
+	if width > height {
+		thumbnailHeight = height * ThumbnailMaxSize / width
+	} else {
+		thumbnailWidth = width * ThumbnailMaxSize / height
+	}
+	if thumbnailWidth < 1 {
+		thumbnailWidth = 1
+	}
+	if thumbnailHeight < 1 {
+		thumbnailHeight = 1
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, resizeBox(src, thumbnailWidth, thumbnailHeight), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resizeBox 使用区域平均缩小图片
+func resizeBox(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := bounds.Min.Y + (y+1)*srcHeight/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := bounds.Min.X + (x+1)*srcWidth/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					// 预乘 alpha 的颜色叠加在白色背景上
+					white := 0xFFFF - uint64(ca)
+					r += uint64(cr) + white
+					g += uint64(cg) + white
+					b += uint64(cb) + white
+					count++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / count >> 8),
+				G: uint8(g / count >> 8),
+				B: uint8(b / count >> 8),
+				A: 0xFF,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"net/url"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	markdownMaxReferenceLength = 64 // 提及的用户名和引用的标签名的最大长度
+	markdownMaxLinks           = 5  // 每条内容最多生成链接预览的链接数
+)
+
+// 默认不输出原始 HTML，并过滤 javascript: 等危险的链接
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, referenceExtension{}),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// MarkdownReferences 内容中提及的用户、引用的标签和链接，按照出现顺序去重，代码中的内容不解析
+type MarkdownReferences struct {
+	Mentions []string // @ 后的用户名或匿名昵称
+	Tags     []string // # 后的标签名
+	Links    []string // http 和 https 链接，最多 markdownMaxLinks 个
+}
+
+// MentionTarget 提及解析的结果，匿名提及不输出用户 ID
+type MentionTarget struct {
+	UserID    int
+	Anonymous bool
+}
+
+// MarkdownResolver 渲染时提及和标签引用的解析结果，没有解析的按照普通文本输出
+type MarkdownResolver struct {
+	Mentions map[string]MentionTarget // 名称 -> 提及的用户
+	Tags     map[string]int           // 标签名 -> 标签 ID
+}
+
+// MarkdownDocument 解析后的 Markdown 文档
+type MarkdownDocument struct {
+	source []byte
+	root   ast.Node
+}
+
+// ParseMarkdown 解析 Markdown 内容
+func ParseMarkdown(content string) *MarkdownDocument {
+	source := []byte(content)
+	return &MarkdownDocument{source: source, root: markdown.Parser().Parse(text.NewReader(source))}
+}
+
+// References 返回内容中提及的用户、引用的标签和链接
+func (d *MarkdownDocument) References() (references MarkdownReferences) {
+	seen := make(map[string]bool)
+	appendOnce := func(list []string, kind, value string) []string {
+		if seen[kind+value] {
+			return list
+		}
+		seen[kind+value] = true
+		return append(list, value)
+	}
+	appendLink := func(link string) {
+		if len(references.Links) >= markdownMaxLinks {
+			return
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return
+		}
+		references.Links = appendOnce(references.Links, "link:", u.String())
+	}
+
+	_ = ast.Walk(d.root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *mentionNode:
+			references.Mentions = appendOnce(references.Mentions, "@", node.Name)
+		case *tagReferenceNode:
+			references.Tags = appendOnce(references.Tags, "#", node.Name)
+		case *ast.Link:
+			appendLink(string(node.Destination))
+		case *ast.AutoLink:
+			if node.AutoLinkType == ast.AutoLinkURL {
+				appendLink(string(node.URL(d.source)))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return
+}
+
+// Render 按照解析结果渲染为 HTML
+func (d *MarkdownDocument) Render(resolver MarkdownResolver) (string, error) {
+	_ = ast.Walk(d.root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *mentionNode:
+			node.Target, node.Resolved = resolver.Mentions[node.Name]
+		case *tagReferenceNode:
+			node.TagID = resolver.Tags[node.Name]
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var buf bytes.Buffer
+	if err := markdown.Renderer().Render(&buf, d.source, d.root); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+/* 提及和标签引用的语法扩展 */
+
+var (
+	kindMention      = ast.NewNodeKind("Mention")
+	kindTagReference = ast.NewNodeKind("TagReference")
+)
+
+type mentionNode struct {
+	ast.BaseInline
+	Name     string
+	Target   MentionTarget
+	Resolved bool
+}
+
+func (n *mentionNode) Kind() ast.NodeKind {
+	return kindMention
+}
+
+func (n *mentionNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": n.Name}, nil)
+}
+
+type tagReferenceNode struct {
+	ast.BaseInline
+	Name  string
+	TagID int
+}
+
+func (n *tagReferenceNode) Kind() ast.NodeKind {
+	return kindTagReference
+}
+
+func (n *tagReferenceNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": n.Name}, nil)
+}
+
+func isReferenceRune(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// referenceParser 解析 @用户名 和 #标签名，前面是字母或数字时不解析，避免匹配邮箱地址
+type referenceParser struct {
+	trigger byte
+}
+
+func (p referenceParser) Trigger() []byte {
+	return []byte{p.trigger}
+}
+
+func (p referenceParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast.Node {
+	if isReferenceRune(block.PrecendingCharacter()) {
+		return nil
+	}
+	line, _ := block.PeekLine()
+	length, count, onlyDigits := 1, 0, true
+	for length < len(line) && count < markdownMaxReferenceLength {
+		r, size := utf8.DecodeRune(line[length:])
+		if !isReferenceRune(r) {
+			break
+		}
+		onlyDigits = onlyDigits && unicode.IsDigit(r)
+		length += size
+		count++
+	}
+	// #123 一般是编号而不是标签
+	if count == 0 || (p.trigger == '#' && onlyDigits) {
+		return nil
+	}
+	name := string(line[1:length])
+	block.Advance(length)
+
+	if p.trigger == '@' {
+		return &mentionNode{Name: name}
+	}
+	return &tagReferenceNode{Name: name}
+}
+
+type referenceRenderer struct{}
+
+func (referenceRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMention, renderMention)
+	reg.Register(kindTagReference, renderTagReference)
+}
+
+func renderMention(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	node := n.(*mentionNode)
+	name := util.EscapeHTML([]byte("@" + node.Name))
+	switch {
+	case !node.Resolved:
+		_, _ = w.Write(name)
+	case node.Target.Anonymous:
+		_, _ = w.WriteString(`<span class="mention mention-anonymous">`)
+		_, _ = w.Write(name)
+		_, _ = w.WriteString(`</span>`)
+	default:
+		_, _ = w.WriteString(`<span class="mention" data-user-id="` + strconv.Itoa(node.Target.UserID) + `">`)
+		_, _ = w.Write(name)
+		_, _ = w.WriteString(`</span>`)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func renderTagReference(w util.BufWriter, _ []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	node := n.(*tagReferenceNode)
+	name := util.EscapeHTML([]byte("#" + node.Name))
+	if node.TagID == 0 {
+		_, _ = w.Write(name)
+	} else {
+		_, _ = w.WriteString(`<span class="tag" data-tag-id="` + strconv.Itoa(node.TagID) + `">`)
+		_, _ = w.Write(name)
+		_, _ = w.WriteString(`</span>`)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+type referenceExtension struct{}
+
+func (referenceExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(referenceParser{trigger: '@'}, 500),
+		util.Prioritized(referenceParser{trigger: '#'}, 500),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(referenceRenderer{}, 500),
+	))
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"golang.org/x/net/html"
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const linkPreviewMaxFieldLength = 256
+
+// LinkPreviewMeta 网页的预览信息，来自 Open Graph 标签、title 和 description
+type LinkPreviewMeta struct {
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+}
+
+// ParseLinkPreview 从网页的 head 中解析预览信息，Open Graph 标签优先，图片的相对地址按照 base 转换为绝对地址
+func ParseLinkPreview(r io.Reader, base *url.URL) (meta LinkPreviewMeta) {
+	var title, description string
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return finishLinkPreview(meta, title, description, base)
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return finishLinkPreview(meta, title, description, base)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "body":
+				return finishLinkPreview(meta, title, description, base)
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = tokenizer.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = string(v)
+					}
+				}
+				switch key {
+				case "og:title":
+					meta.Title = content
+				case "og:description":
+					meta.Description = content
+				case "og:image":
+					meta.Image = content
+				case "og:site_name":
+					meta.SiteName = content
+				case "description":
+					description = content
+				}
+			}
+		}
+	}
+}
+
+func finishLinkPreview(meta LinkPreviewMeta, title, description string, base *url.URL) LinkPreviewMeta {
+	if meta.Title == "" {
+		meta.Title = title
+	}
+	if meta.Description == "" {
+		meta.Description = description
+	}
+	if meta.Image != "" && base != nil {
+		if image, err := base.Parse(meta.Image); err == nil && (image.Scheme == "http" || image.Scheme == "https") {
+			meta.Image = image.String()
+		} else {
+			meta.Image = ""
+		}
+	}
+	meta.Title = truncateLinkPreviewField(meta.Title)
+	meta.Description = truncateLinkPreviewField(meta.Description)
+	meta.SiteName = truncateLinkPreviewField(meta.SiteName)
+	if len(meta.Image) > 2048 {
+		meta.Image = ""
+	}
+	return meta
+}
+
+func truncateLinkPreviewField(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= linkPreviewMaxFieldLength {
+		return s
+	}
+	return string([]rune(s)[:linkPreviewMaxFieldLength])
+}
@@ -16,10 +16,29 @@ func (q PageRequest) QuerySet(tx *gorm.DB) *gorm.DB {
 	return tx.Offset((q.PageNum - 1) * q.PageSize).Limit(q.PageSize)
 }
 
+// CursorRequest 游标分页请求
+type CursorRequest struct {
+	Cursor   string `json:"cursor" query:"cursor"`                                                       // 分页游标，使用上一次返回的 next_cursor 或 prev_cursor，不填从第一页开始
+	PageSize int    `json:"page_size" query:"page_size" validate:"omitempty,min=1,max=100" default:"10"` // 每页数量
+}
+
+// CursorResponse 游标分页响应
+type CursorResponse struct {
+	NextCursor string `json:"next_cursor,omitempty"` // 下一页游标，没有下一页时为空
+	PrevCursor string `json:"prev_cursor,omitempty"` // 上一页游标，第一页时为空
+	HasMore    bool   `json:"has_more"`              // 当前翻页方向上是否还有数据
+}
+
 type CanPostprocess interface {
 	Postprocess(c *fiber.Ctx) error
 }
 
+// CanRedact 可能包含匿名内容的响应
+// Redact 清除可以识别匿名发布者身份的字段，在 Postprocess 之后对响应中的每一层调用，需要可以重复调用
+type CanRedact interface {
+	Redact()
+}
+
 type Response[T any] struct {
 	Code     int    `json:"code"`
 	ErrorMsg string `json:"error_msg"`
@@ -41,6 +60,7 @@ func Success[T any](c *fiber.Ctx, data *T) error {
 	if err != nil {
 		return err
 	}
+	Redact(data)
 	return c.Status(200).JSON(Response[T]{
 		Code: 200,
 		Data: data,
@@ -52,6 +72,7 @@ func Created[T any](c *fiber.Ctx, data *T) error {
 	if err != nil {
 		return err
 	}
+	Redact(data)
 	return c.Status(201).JSON(Response[T]{
 		Code: 201,
 		Data: data,
@@ -76,3 +97,43 @@ func postprocess[T any](c *fiber.Ctx, data *T) error {
 
 	return returns[0].Interface().(error)
 }
+
+// Redact 递归遍历响应，对其中所有实现了 CanRedact 的值调用 Redact
+// 匿名字段的清除集中在这里，列表、嵌套的最后一条评论、评论树等都不需要单独处理
+func Redact(data any) {
+	redact(reflect.ValueOf(data))
+}
+
+func redact(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !value.IsNil() {
+			redact(value.Elem())
+		}
+	case reflect.Struct:
+		if value.CanAddr() {
+			if redactor, ok := value.Addr().Interface().(CanRedact); ok {
+				redactor.Redact()
+			}
+		}
+		valueType := value.Type()
+		for i := 0; i < value.NumField(); i++ {
+			if valueType.Field(i).IsExported() {
+				redact(value.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			redact(value.Index(i))
+		}
+	case reflect.Map:
+		// map 的值不可寻址，复制一份处理后写回
+		iter := value.MapRange()
+		for iter.Next() {
+			element := reflect.New(iter.Value().Type()).Elem()
+			element.Set(iter.Value())
+			redact(element)
+			value.SetMapIndex(iter.Key(), element)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"bytes"
+	"chatdan_backend/config"
+	"chatdan_backend/data"
+	"go.uber.org/zap"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	ShareCardWidth  = 1200 // OpenGraph 推荐的图片尺寸
+	ShareCardHeight = 630
+)
+
+// ShareCard 分享卡片的内容，Question 为空时只绘制标题和简介
+type ShareCard struct {
+	Title       string
+	Description string
+	Question    string // 精选的提问，提问不公开时为空
+	Answer      string
+	Footer      string
+}
+
+var (
+	shareCardColorBackground = color.RGBA{R: 0xF4, G: 0xF2, B: 0xFB, A: 0xFF}
+	shareCardColorAccent     = color.RGBA{R: 0x6D, G: 0x4A, B: 0xE0, A: 0xFF}
+	shareCardColorText       = color.RGBA{R: 0x1F, G: 0x1F, B: 0x2E, A: 0xFF}
+	shareCardColorSecondary  = color.RGBA{R: 0x6B, G: 0x6B, B: 0x80, A: 0xFF}
+)
+
+var (
+	shareCardFontsOnce sync.Once
+	shareCardRegular   []*opentype.Font
+	shareCardBold      []*opentype.Font
+)
+
+// loadShareCardFonts 加载内置的 Go 字体和中文字体，配置了 SHARE_CARD_FONT 时优先使用配置的字体
+// 内置的中文字体只包含 GB2312 中的字符，需要覆盖更多字符时应配置完整的字体
+func loadShareCardFonts() {
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		panic(err)
+	}
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		panic(err)
+	}
+	cjk, err := opentype.Parse(data.CJKFontFile)
+	if err != nil {
+		panic(err)
+	}
+	shareCardRegular = []*opentype.Font{regular, cjk}
+	shareCardBold = []*opentype.Font{bold, cjk}
+
+	if path := config.Config.ShareCardFont; path != "" {
+		custom, err := parseFontFile(path)
+		if err != nil {
+			Logger.Error("load share card font error", zap.String("path", path), zap.Error(err))
+			return
+		}
+		shareCardRegular = []*opentype.Font{custom, regular, cjk}
+		shareCardBold = []*opentype.Font{custom, bold, cjk}
+	}
+}
+
+// parseFontFile 解析 ttf、otf 字体文件，字体集合 ttc 使用其中的第一个字体
+func parseFontFile(path string) (*opentype.Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if f, err := opentype.Parse(data); err == nil {
+		return f, nil
+	}
+	collection, err := opentype.ParseCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	return collection.Font(0)
+}
+
+// textFace 按顺序在多个字体中查找字形，都没有时使用第一个字体的缺字符号
+type textFace []font.Face
+
+func newTextFace(fonts []*opentype.Font, size float64) (textFace, error) {
+	face := make(textFace, 0, len(fonts))
+	for _, f := range fonts {
+		fontFace, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		face = append(face, fontFace)
+	}
+	return face, nil
+}
+
+func (f textFace) pick(r rune) (font.Face, fixed.Int26_6) {
+	for _, face := range f {
+		if advance, ok := face.GlyphAdvance(r); ok {
+			return face, advance
+		}
+	}
+	advance, _ := f[0].GlyphAdvance(r)
+	return f[0], advance
+}
+
+func (f textFace) measure(s string) (width fixed.Int26_6) {
+	for _, r := range s {
+		_, advance := f.pick(r)
+		width += advance
+	}
+	return
+}
+
+// draw 以 (x, y) 为基线起点绘制一行文字
+func (f textFace) draw(dst draw.Image, s string, x, y int, c color.Color) {
+	dot := fixed.P(x, y)
+	src := image.NewUniform(c)
+	for _, r := range s {
+		face, advance := f.pick(r)
+		if dr, mask, maskp, _, ok := face.Glyph(dot, r); ok {
+			draw.DrawMask(dst, dr, src, image.Point{}, mask, maskp, draw.Over)
+		}
+		dot.X += advance
+	}
+}
+
+// wrap 按宽度折行，最多 maxLines 行，超出时最后一行以省略号结尾
+// 连续的空白字符合并为一个空格，英文尽量在空格处折行，中文在任意字符处折行
+func (f textFace) wrap(s string, maxWidth fixed.Int26_6, maxLines int) (lines []string) {
+	runes := []rune(strings.Join(strings.Fields(s), " "))
+	for len(runes) > 0 && len(lines) < maxLines {
+		end, lastSpace := 0, -1
+		var width fixed.Int26_6
+		for ; end < len(runes); end++ {
+			_, advance := f.pick(runes[end])
+			if width+advance > maxWidth && end > 0 {
+				break
+			}
+			width += advance
+			if runes[end] == ' ' {
+				lastSpace = end
+			}
+		}
+		if end < len(runes) && runes[end] != ' ' && lastSpace > 0 {
+			end = lastSpace
+		}
+		lines = append(lines, strings.TrimSpace(string(runes[:end])))
+		runes = []rune(strings.TrimLeft(string(runes[end:]), " "))
+	}
+	if len(runes) > 0 && len(lines) > 0 {
+		lines[len(lines)-1] = f.ellipsis(lines[len(lines)-1], maxWidth)
+	}
+	return lines
+}
+
+func (f textFace) ellipsis(line string, maxWidth fixed.Int26_6) string {
+	runes := []rune(line)
+	for len(runes) > 0 && f.measure(string(runes)+"…") > maxWidth {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "…"
+}
+
+// RenderShareCard 绘制 PNG 格式的分享卡片，包括提问箱的标题、简介和一条精选的问答
+func RenderShareCard(card ShareCard) ([]byte, error) {
+	shareCardFontsOnce.Do(loadShareCardFonts)
+	titleFace, err := newTextFace(shareCardBold, 56)
+	if err != nil {
+		return nil, err
+	}
+	labelFace, err := newTextFace(shareCardBold, 32)
+	if err != nil {
+		return nil, err
+	}
+	bodyFace, err := newTextFace(shareCardRegular, 32)
+	if err != nil {
+		return nil, err
+	}
+	smallFace, err := newTextFace(shareCardRegular, 24)
+	if err != nil {
+		return nil, err
+	}
+
+	const margin = 80
+	img := image.NewRGBA(image.Rect(0, 0, ShareCardWidth, ShareCardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(shareCardColorBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 16, ShareCardHeight), image.NewUniform(shareCardColorAccent), image.Point{}, draw.Src)
+	maxWidth := fixed.I(ShareCardWidth - 2*margin)
+
+	y := 130
+	for _, line := range titleFace.wrap(card.Title, maxWidth, 1) {
+		titleFace.draw(img, line, margin, y, shareCardColorText)
+		y += 56
+	}
+	for _, line := range bodyFace.wrap(card.Description, maxWidth, 1) {
+		bodyFace.draw(img, line, margin, y, shareCardColorSecondary)
+		y += 44
+	}
+
+	// 精选的问答
+	if card.Question != "" || card.Answer != "" {
+		y += 10
+		draw.Draw(img, image.Rect(margin-24, y, ShareCardWidth-margin+24, ShareCardHeight-90), image.White, image.Point{}, draw.Src)
+		const indent = 56
+		y += 60
+		for _, part := range []struct {
+			label    string
+			content  string
+			maxLines int
+		}{{"Q", card.Question, 2}, {"A", card.Answer, 3}} {
+			if part.content == "" {
+				continue
+			}
+			labelFace.draw(img, part.label, margin, y, shareCardColorAccent)
+			for _, line := range bodyFace.wrap(part.content, maxWidth-fixed.I(indent), part.maxLines) {
+				bodyFace.draw(img, line, margin+indent, y, shareCardColorText)
+				y += 44
+			}
+			y += 16
+		}
+	}
+
+	smallFace.draw(img, card.Footer, margin, ShareCardHeight-40, shareCardColorSecondary)
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"bytes"
+	"golang.org/x/image/font/opentype"
+	"image"
+	"image/draw"
+	"testing"
+)
+
+// 中文应当使用内置的中文字体绘制出字形，而不是缺字符号
+func TestShareCardChineseGlyphs(t *testing.T) {
+	shareCardFontsOnce.Do(loadShareCardFonts)
+	const text = "向我提问吧"
+
+	for _, fonts := range [][]*opentype.Font{shareCardRegular, shareCardBold} {
+		face, err := newTextFace(fonts, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range text {
+			if f, _ := face.pick(r); f == face[0] {
+				t.Errorf("glyph %q not found in bundled fonts", r)
+			}
+		}
+
+		render := func(s string) []byte {
+			img := image.NewRGBA(image.Rect(0, 0, 400, 60))
+			draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+			face.draw(img, s, 10, 45, shareCardColorText)
+			return img.Pix
+		}
+		chinese := render(text)
+		if bytes.Equal(chinese, render("")) {
+			t.Error("expected chinese text to be drawn")
+		}
+		if bytes.Equal(chinese, render("")) {
+			t.Error("expected chinese text not to be drawn as missing glyphs")
+		}
+	}
+}